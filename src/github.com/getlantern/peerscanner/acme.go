@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/getlantern/peerscanner/cfl"
+	"golang.org/x/crypto/acme"
+)
+
+const (
+	// acmeChallengeTtl is the ttl of DNS-01 challenge records, the lowest
+	// CloudFlare allows, so that resolvers don't hold on to stale ones
+	acmeChallengeTtl = 120
+
+	// acmeRenewBefore is how long before it expires we renew the certificate
+	acmeRenewBefore = 30 * 24 * time.Hour
+
+	// acmeRenewCheckInterval is how often we check whether the certificate
+	// needs renewing
+	acmeRenewCheckInterval = 12 * time.Hour
+
+	// acmeTimeout is how long getting a certificate may take, on top of
+	// waiting for challenge records to propagate
+	acmeTimeout = 5 * time.Minute
+
+	acmeAccountKeyFile = "acme_account.key"
+)
+
+var (
+	acmeDNS01              = flag.Bool("acme-dns01", false, "Get the certificate for -tlsdomain from an ACME CA with DNS-01 challenges in the -cfldomain CloudFlare zone, which wildcard certificates need, instead of using a self-signed one, defaults to false")
+	tlsDomain              = flag.String("tlsdomain", "", "Domain to get a certificate for with -acme-dns01, e.g. *.getiantem.org, defaults to none")
+	acmeDirectory          = flag.String("acme-directory", acme.LetsEncryptURL, "Directory URL of the ACME CA to use with -acme-dns01, defaults to Let's Encrypt")
+	acmeDNSPropagationWait = flag.Duration("acme-dns-propagation-wait", 60*time.Second, "How long to wait after creating a DNS-01 challenge record before letting the CA check it, defaults to 60s")
+	autocertDir            = flag.String("autocertdir", "certs", "Directory to store the ACME account key and certificates in, defaults to certs")
+)
+
+// cflDNS01Solver solves ACME DNS-01 challenges by publishing their TXT
+// records in our CloudFlare zone.
+type cflDNS01Solver struct {
+	util            *cfl.Util
+	zone            string
+	propagationWait time.Duration
+}
+
+// present creates the _acme-challenge TXT record with the given value for
+// domain and waits for it to propagate.
+func (s *cflDNS01Solver) present(domain string, value string) error {
+	name, err := acmeChallengeName(s.zone, domain)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Creating DNS-01 challenge record %v", name)
+	if err := s.util.CreateTXTRecord(name, value, acmeChallengeTtl); err != nil {
+		return fmt.Errorf("Unable to create DNS-01 challenge record for %v: %v", domain, err)
+	}
+	time.Sleep(s.propagationWait)
+	return nil
+}
+
+// cleanUp removes the TXT record that present created, leaving the records of
+// other challenges for the same domain alone.
+func (s *cflDNS01Solver) cleanUp(domain string, value string) error {
+	name, err := acmeChallengeName(s.zone, domain)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Removing DNS-01 challenge record %v", name)
+	return s.util.DestroyTXTRecordByContent(name, value)
+}
+
+// acmeChallengeName returns the name, relative to zone, of the DNS-01
+// challenge record for domain. A wildcard domain has the same one as the
+// domain it's a wildcard of.
+func acmeChallengeName(zone string, domain string) (string, error) {
+	domain = strings.TrimPrefix(strings.TrimSuffix(domain, "."), "*.")
+	if domain == zone {
+		return "_acme-challenge", nil
+	}
+	if !isHostname(domain) || !strings.HasSuffix(domain, "."+zone) {
+		return "", fmt.Errorf("%v is not a domain in zone %v", domain, zone)
+	}
+	return "_acme-challenge." + strings.TrimSuffix(domain, "."+zone), nil
+}
+
+// acmeCertManager gets the certificate for domain from an ACME CA, stores it
+// in dir and renews it before it expires. Its getCertificate serves the
+// current one.
+type acmeCertManager struct {
+	client *acme.Client
+	solver *cflDNS01Solver
+	domain string
+	dir    string
+	cert   atomic.Pointer[tls.Certificate]
+}
+
+// newAcmeCertManager creates an acmeCertManager, with the account key from
+// dir, which it generates if there's none yet.
+func newAcmeCertManager(directoryURL string, solver *cflDNS01Solver, domain string, dir string) (*acmeCertManager, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("Unable to create %v: %v", dir, err)
+	}
+	key, err := loadOrCreateKey(filepath.Join(dir, acmeAccountKeyFile))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load ACME account key: %v", err)
+	}
+	return &acmeCertManager{
+		client: &acme.Client{Key: key, DirectoryURL: directoryURL},
+		solver: solver,
+		domain: domain,
+		dir:    dir,
+	}, nil
+}
+
+// certFile is where the key and certificate chain for domain are stored, as
+// PEM blocks in a single file
+func (m *acmeCertManager) certFile() string {
+	return filepath.Join(m.dir, strings.Replace(m.domain, "*", "_wildcard", 1)+".pem")
+}
+
+func (m *acmeCertManager) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert := m.cert.Load()
+	if cert == nil {
+		return nil, fmt.Errorf("No certificate for %v yet", m.domain)
+	}
+	return cert, nil
+}
+
+// ensureCertificate makes sure that we have a certificate that isn't about to
+// expire, using the stored one if it's still good and getting a new one
+// otherwise.
+func (m *acmeCertManager) ensureCertificate() error {
+	cert := m.cert.Load()
+	if cert == nil {
+		stored, err := loadCertificate(m.certFile())
+		if err != nil && !os.IsNotExist(err) {
+			log.Errorf("Unable to load stored certificate for %v, getting a new one: %v", m.domain, err)
+		}
+		cert = stored
+	}
+	if cert != nil && time.Until(cert.Leaf.NotAfter) > acmeRenewBefore {
+		m.cert.Store(cert)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), acmeTimeout+m.solver.propagationWait)
+	defer cancel()
+	log.Debugf("Getting certificate for %v from %v", m.domain, m.client.DirectoryURL)
+	pemBytes, err := m.obtain(ctx)
+	if err != nil {
+		return fmt.Errorf("Unable to get certificate for %v: %v", m.domain, err)
+	}
+	cert, err = parseCertificate(pemBytes)
+	if err != nil {
+		return fmt.Errorf("CA returned an unusable certificate for %v: %v", m.domain, err)
+	}
+	if err := os.WriteFile(m.certFile(), pemBytes, 0600); err != nil {
+		// We still have it in memory
+		log.Errorf("Unable to store certificate for %v: %v", m.domain, err)
+	}
+	m.cert.Store(cert)
+	log.Debugf("Got certificate for %v, valid until %v", m.domain, cert.Leaf.NotAfter)
+	return nil
+}
+
+// keepRenewed periodically renews the certificate once it's about to expire.
+func (m *acmeCertManager) keepRenewed() {
+	for {
+		time.Sleep(acmeRenewCheckInterval)
+		if err := m.ensureCertificate(); err != nil {
+			log.Errorf("Unable to renew certificate: %v", err)
+		}
+	}
+}
+
+// obtain orders a certificate for m.domain, solving its DNS-01 challenges,
+// and returns its key and chain as PEM.
+func (m *acmeCertManager) obtain(ctx context.Context) ([]byte, error) {
+	if _, err := m.client.Register(ctx, &acme.Account{}, acme.AcceptTOS); err != nil && err != acme.ErrAccountAlreadyExists {
+		return nil, fmt.Errorf("Unable to register ACME account: %v", err)
+	}
+	order, err := m.client.AuthorizeOrder(ctx, acme.DomainIDs(m.domain))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to order certificate: %v", err)
+	}
+	for _, u := range order.AuthzURLs {
+		z, err := m.client.GetAuthorization(ctx, u)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to get authorization: %v", err)
+		}
+		if z.Status == acme.StatusValid {
+			continue
+		}
+		if err := m.authorize(ctx, z); err != nil {
+			return nil, err
+		}
+	}
+	order, err = m.client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return nil, fmt.Errorf("Order didn't become ready: %v", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to generate certificate key: %v", err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: []string{m.domain}}, key)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create certificate request: %v", err)
+	}
+	chain, _, err := m.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to finalize order: %v", err)
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})
+	for _, der := range chain {
+		pemBytes = append(pemBytes, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	return pemBytes, nil
+}
+
+// authorize solves the DNS-01 challenge of z, always cleaning up its record
+// afterwards.
+func (m *acmeCertManager) authorize(ctx context.Context, z *acme.Authorization) error {
+	var chal *acme.Challenge
+	for _, c := range z.Challenges {
+		if c.Type == "dns-01" {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("CA offered no DNS-01 challenge for %v", z.Identifier.Value)
+	}
+	value, err := m.client.DNS01ChallengeRecord(chal.Token)
+	if err != nil {
+		return err
+	}
+	domain := z.Identifier.Value
+	if err := m.solver.present(domain, value); err != nil {
+		return err
+	}
+	defer func() {
+		if err := m.solver.cleanUp(domain, value); err != nil {
+			log.Errorf("Unable to clean up DNS-01 challenge record for %v: %v", domain, err)
+		}
+	}()
+	if _, err := m.client.Accept(ctx, chal); err != nil {
+		return fmt.Errorf("Unable to accept DNS-01 challenge for %v: %v", domain, err)
+	}
+	if _, err := m.client.WaitAuthorization(ctx, z.URI); err != nil {
+		return fmt.Errorf("DNS-01 challenge for %v failed: %v", domain, err)
+	}
+	return nil
+}
+
+// loadOrCreateKey loads the EC private key at path, generating and storing
+// one if there's no such file.
+func loadOrCreateKey(path string) (*ecdsa.PrivateKey, error) {
+	pemBytes, err := os.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(pemBytes)
+		if block == nil {
+			return nil, fmt.Errorf("No PEM data in %v", path)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}), 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// loadCertificate loads a key and certificate chain stored by
+// ensureCertificate.
+func loadCertificate(path string) (*tls.Certificate, error) {
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseCertificate(pemBytes)
+}
+
+func parseCertificate(pemBytes []byte) (*tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(pemBytes, pemBytes)
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, err
+		}
+	}
+	return &cert, nil
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getlantern/testify/assert"
+)
+
+// fakeAcme is an RFC 8555 ACME CA that issues certificates for a single
+// DNS-01 challenge. It doesn't check signatures. When the client accepts the
+// challenge, it calls check, which tells whether to validate it.
+type fakeAcme struct {
+	*httptest.Server
+	check func() bool
+
+	accepted bool
+	valid    bool
+	certPEM  []byte
+	mutex    sync.Mutex
+}
+
+const fakeAcmeToken = "fake-token"
+
+func newFakeAcme(check func() bool) *fakeAcme {
+	a := &fakeAcme{check: check}
+	a.Server = httptest.NewServer(a)
+	return a
+}
+
+func (a *fakeAcme) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	resp.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", time.Now().UnixNano()))
+	resp.Header().Set("Content-Type", "application/json")
+	var jws struct {
+		Payload string `json:"payload"`
+	}
+	json.NewDecoder(req.Body).Decode(&jws)
+	payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+
+	order := func(status string) map[string]interface{} {
+		return map[string]interface{}{
+			"status":         status,
+			"identifiers":    []map[string]string{{"type": "dns", "value": "*.getiantem.org"}},
+			"authorizations": []string{a.URL + "/authz"},
+			"finalize":       a.URL + "/finalize",
+			"certificate":    a.URL + "/cert",
+		}
+	}
+	var body interface{}
+	switch req.URL.Path {
+	case "/directory":
+		body = map[string]string{
+			"newNonce":   a.URL + "/nonce",
+			"newAccount": a.URL + "/account",
+			"newOrder":   a.URL + "/order",
+			"revokeCert": a.URL + "/revoke",
+			"keyChange":  a.URL + "/keychange",
+		}
+	case "/nonce":
+		resp.WriteHeader(http.StatusOK)
+		return
+	case "/account":
+		resp.Header().Set("Location", a.URL+"/account/1")
+		resp.WriteHeader(http.StatusCreated)
+		body = map[string]string{"status": "valid"}
+	case "/order":
+		resp.Header().Set("Location", a.URL+"/order/1")
+		resp.WriteHeader(http.StatusCreated)
+		body = order("pending")
+	case "/order/1":
+		if a.valid {
+			body = order("ready")
+		} else {
+			body = order("pending")
+		}
+	case "/authz":
+		status := "pending"
+		if a.valid {
+			status = "valid"
+		} else if a.accepted {
+			status = "invalid"
+		}
+		body = map[string]interface{}{
+			"status":     status,
+			"identifier": map[string]string{"type": "dns", "value": "getiantem.org"},
+			"wildcard":   true,
+			"challenges": []map[string]string{
+				{"type": "http-01", "url": a.URL + "/chal/http", "token": "http-token", "status": "pending"},
+				{"type": "dns-01", "url": a.URL + "/chal/dns", "token": fakeAcmeToken, "status": "pending"},
+			},
+		}
+	case "/chal/dns":
+		a.accepted = true
+		a.valid = a.check()
+		body = map[string]string{"type": "dns-01", "url": a.URL + "/chal/dns", "token": fakeAcmeToken, "status": "processing"}
+	case "/finalize":
+		var finalize struct {
+			CSR string `json:"csr"`
+		}
+		json.Unmarshal(payload, &finalize)
+		der, _ := base64.RawURLEncoding.DecodeString(finalize.CSR)
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil || !a.valid {
+			resp.WriteHeader(http.StatusForbidden)
+			body = map[string]string{"type": "urn:ietf:params:acme:error:unauthorized", "detail": "Not authorized"}
+			break
+		}
+		a.certPEM = issueTestCertificate(csr.DNSNames, csr.PublicKey, 90*24*time.Hour)
+		resp.Header().Set("Location", a.URL+"/order/1")
+		body = order("valid")
+	case "/cert":
+		resp.Header().Set("Content-Type", "application/pem-certificate-chain")
+		resp.Write(a.certPEM)
+		return
+	default:
+		resp.WriteHeader(http.StatusNotFound)
+		body = map[string]string{"type": "urn:ietf:params:acme:error:malformed", "detail": "No such resource"}
+	}
+	json.NewEncoder(resp).Encode(body)
+	io.Copy(io.Discard, req.Body)
+}
+
+func TestAcmeCertManagerObtainsCertificate(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+
+	var certs *acmeCertManager
+	a := newFakeAcme(func() bool {
+		value, err := certs.client.DNS01ChallengeRecord(fakeAcmeToken)
+		return err == nil && len(m.FindRecords("_acme-challenge", value)) == 1
+	})
+	defer a.Close()
+
+	dir := t.TempDir()
+	solver := &cflDNS01Solver{util: cflutil, zone: "getiantem.org"}
+	var err error
+	certs, err = newAcmeCertManager(a.URL+"/directory", solver, "*.getiantem.org", dir)
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.NoError(t, certs.ensureCertificate()) {
+		return
+	}
+	assert.True(t, a.valid, "CA should have found the challenge record")
+	assert.Len(t, m.FindRecords("_acme-challenge", ""), 0, "Challenge record should have been cleaned up")
+	cert, err := certs.getCertificate(nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"*.getiantem.org"}, cert.Leaf.DNSNames)
+	}
+	stored, err := loadCertificate(certs.certFile())
+	if assert.NoError(t, err, "Certificate should have been stored") {
+		assert.Equal(t, cert.Certificate, stored.Certificate)
+	}
+}
+
+func TestAcmeCertManagerCleansUpFailedChallenge(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	a := newFakeAcme(func() bool { return false })
+	defer a.Close()
+
+	solver := &cflDNS01Solver{util: cflutil, zone: "getiantem.org"}
+	certs, err := newAcmeCertManager(a.URL+"/directory", solver, "*.getiantem.org", t.TempDir())
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Error(t, certs.ensureCertificate(), "Failed challenge should fail getting the certificate")
+	assert.True(t, a.accepted, "Challenge should have been accepted")
+	assert.Len(t, m.FindRecords("_acme-challenge", ""), 0, "Challenge record should have been cleaned up")
+	_, err = os.Stat(certs.certFile())
+	assert.True(t, os.IsNotExist(err), "Nothing should have been stored")
+}
+
+func TestCflDNS01Solver(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+
+	s := &cflDNS01Solver{util: cflutil, zone: "getiantem.org", propagationWait: 50 * time.Millisecond}
+	start := time.Now()
+	if !assert.NoError(t, s.present("*.getiantem.org", "wildcard-value")) {
+		return
+	}
+	assert.True(t, time.Since(start) >= s.propagationWait, "Should have waited for the record to propagate")
+	recs := m.FindRecords("_acme-challenge", "")
+	if assert.Len(t, recs, 1, "Challenge record should have been created") {
+		assert.Equal(t, "TXT", recs[0].Type)
+		assert.Equal(t, "wildcard-value", recs[0].Value)
+		assert.Equal(t, "120", recs[0].Ttl)
+	}
+
+	// A certificate for both getiantem.org and *.getiantem.org has two
+	// challenges with the same record name
+	assert.NoError(t, s.present("getiantem.org", "apex-value"))
+	assert.Len(t, m.FindRecords("_acme-challenge", ""), 2)
+	assert.NoError(t, s.cleanUp("*.getiantem.org", "wildcard-value"))
+	recs = m.FindRecords("_acme-challenge", "")
+	if assert.Len(t, recs, 1, "Only the cleaned up challenge's record should have been removed") {
+		assert.Equal(t, "apex-value", recs[0].Value)
+	}
+	assert.NoError(t, s.cleanUp("getiantem.org", "apex-value"))
+	assert.Len(t, m.FindRecords("_acme-challenge", ""), 0)
+
+	if assert.NoError(t, s.present("sub.getiantem.org", "sub-value")) {
+		assert.Len(t, m.FindRecords("_acme-challenge.sub", "sub-value"), 1, "Challenge for subdomain should be relative to zone")
+	}
+	assert.Error(t, s.present("example.com", "value"), "Domains outside of zone should be rejected")
+}
+
+func TestAcmeChallengeName(t *testing.T) {
+	for domain, expected := range map[string]string{
+		"getiantem.org":      "_acme-challenge",
+		"*.getiantem.org":    "_acme-challenge",
+		"fl.getiantem.org.":  "_acme-challenge.fl",
+		"*.fl.getiantem.org": "_acme-challenge.fl",
+		"a.b.getiantem.org":  "_acme-challenge.a.b",
+		"notgetiantem.org":   "",
+		"getiantem.org.evil": "",
+		"":                   "",
+		"*.*.getiantem.org":  "",
+		"fl 1.getiantem.org": "",
+	} {
+		name, err := acmeChallengeName("getiantem.org", domain)
+		if expected == "" {
+			assert.Error(t, err, "%q should be rejected", domain)
+		} else if assert.NoError(t, err, "%q should be accepted", domain) {
+			assert.Equal(t, expected, name)
+		}
+	}
+}
+
+func TestAcmeCertManagerUsesStoredCertificate(t *testing.T) {
+	dir := t.TempDir()
+	// Nothing listens there, so getting a certificate fails
+	directory := "http://127.0.0.1:1/directory"
+	solver := &cflDNS01Solver{zone: "getiantem.org"}
+	m, err := newAcmeCertManager(directory, solver, "*.getiantem.org", dir)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, filepath.Join(dir, "_wildcard.getiantem.org.pem"), m.certFile())
+	assert.Error(t, m.ensureCertificate(), "Without a stored certificate, we should have tried the CA")
+	_, err = m.getCertificate(nil)
+	assert.Error(t, err, "There should be no certificate to serve")
+
+	writeTestCertificate(t, m.certFile(), 90*24*time.Hour)
+	if assert.NoError(t, m.ensureCertificate(), "Stored certificate should be used without asking the CA") {
+		cert, err := m.getCertificate(nil)
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{"*.getiantem.org"}, cert.Leaf.DNSNames)
+		}
+	}
+
+	// A restart keeps the account key
+	m2, err := newAcmeCertManager(directory, solver, "*.getiantem.org", dir)
+	if assert.NoError(t, err) {
+		assert.Equal(t, m.client.Key, m2.client.Key)
+	}
+
+	writeTestCertificate(t, m2.certFile(), 10*24*time.Hour)
+	assert.Error(t, m2.ensureCertificate(), "Stored certificate that's about to expire should be renewed")
+}
+
+// issueTestCertificate issues a self-signed certificate for pub and dnsNames
+// that expires in validFor and returns it as PEM.
+func issueTestCertificate(dnsNames []string, pub interface{}, validFor time.Duration) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(validFor),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pub, key)
+	if err != nil {
+		panic(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// writeTestCertificate stores a certificate for *.getiantem.org that expires
+// in validFor the way acmeCertManager stores them.
+func writeTestCertificate(t *testing.T, path string, validFor time.Duration) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})
+	pemBytes = append(pemBytes, issueTestCertificate([]string{"*.getiantem.org"}, &key.PublicKey, validFor)...)
+	if !assert.NoError(t, os.WriteFile(path, pemBytes, 0600)) {
+		t.FailNow()
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+var (
+	// adminKey is the key that callers of admin endpoints need to present in
+	// the X-Admin-Key header. If it's not set, admin endpoints are disabled.
+	adminKey = os.Getenv("PEERSCANNER_ADMIN_KEY")
+)
+
+// requireAdmin wraps the given handler so that it only serves requests
+// presenting the admin key, or a client certificate signed by
+// -admin-client-ca, which supersedes the key.
+func requireAdmin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		if hasVerifiedClientCert(req) {
+			handler(resp, req)
+			return
+		}
+		if adminKey == "" {
+			resp.WriteHeader(http.StatusForbidden)
+			fmt.Fprintln(resp, "Admin endpoints are disabled")
+			return
+		}
+		key := req.Header.Get("X-Admin-Key")
+		if subtle.ConstantTimeCompare([]byte(key), []byte(adminKey)) != 1 {
+			resp.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintln(resp, "Invalid admin key")
+			return
+		}
+		handler(resp, req)
+	}
+}
+
+type responseTimes struct {
+	P50 int64 `json:"p50"`
+	P95 int64 `json:"p95"`
+	P99 int64 `json:"p99"`
+}
+
+type fallbackHealthReport struct {
+	Name                string        `json:"name"`
+	Ip                  string        `json:"ip"`
+	State               string        `json:"state"`
+	ConsecutiveFailures int           `json:"consecutiveFailures"`
+	LastCheck           time.Time     `json:"lastCheck"`
+	CfRecordExists      bool          `json:"cfRecordExists"`
+	CfRecordId          string        `json:"cfRecordID"`
+	ResponseTimeMs      responseTimes `json:"responseTimeMs"`
+}
+
+// fallbacksHealth is the admin endpoint that reports on the health of all
+// fallbacks, for the ops dashboard.
+func (p *HostPool) fallbacksHealth(resp http.ResponseWriter, req *http.Request) {
+	infos := p.Snapshot()
+	sort.Sort(byName(infos))
+	reports := make([]fallbackHealthReport, 0, len(infos))
+	for _, info := range infos {
+		if !isFallback(info.name) {
+			continue
+		}
+		ps := info.checkDurations.percentiles(50, 95, 99)
+		reports = append(reports, fallbackHealthReport{
+			Name:                info.name,
+			Ip:                  info.ip,
+			State:               info.state,
+			ConsecutiveFailures: info.consecutiveFailures,
+			LastCheck:           info.lastTest,
+			CfRecordExists:      info.cflRecordId != "",
+			CfRecordId:          info.cflRecordId,
+			ResponseTimeMs: responseTimes{
+				P50: int64(ps[0] / time.Millisecond),
+				P95: int64(ps[1] / time.Millisecond),
+				P99: int64(ps[2] / time.Millisecond),
+			},
+		})
+	}
+	writeJSON(resp, reports)
+}
+
+// writeJSON writes the given value as a JSON response
+func writeJSON(resp http.ResponseWriter, v interface{}) {
+	resp.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(resp).Encode(v)
+	if err != nil {
+		log.Errorf("Unable to write JSON response: %v", err)
+	}
+}
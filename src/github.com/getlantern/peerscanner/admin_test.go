@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestRequireAdmin(t *testing.T) {
+	defer withAdminKey("secret")()
+	handler := requireAdmin(func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(200)
+	})
+
+	for key, expected := range map[string]int{"": 401, "wrong": 401, "secret": 200} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/v1/admin/whatever", nil)
+		req.Header.Set("X-Admin-Key", key)
+		handler(rec, req)
+		assert.Equal(t, expected, rec.Code, "Unexpected status for key '%v'", key)
+	}
+
+	adminKey = ""
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("GET", "/v1/admin/whatever", nil))
+	assert.Equal(t, 403, rec.Code, "Admin endpoints should be disabled without a key")
+}
+
+func TestFallbacksHealth(t *testing.T) {
+	h := onlineHost("fl-us-health", "45.63.2.1", "443", true)
+	h.consecutiveFailures = 0
+	h.cflRecordId = "1234"
+	// Seed with 1ms - 150ms, of which only the last 100 should be kept
+	for i := 1; i <= 150; i++ {
+		h.checkDurations.add(time.Duration(i) * time.Millisecond)
+	}
+	h.publishInfo()
+	pool := newTestPool(h)
+
+	rec := httptest.NewRecorder()
+	pool.fallbacksHealth(rec, httptest.NewRequest("GET", "/v1/admin/fallbacks/health", nil))
+	assert.Equal(t, 200, rec.Code)
+
+	var reports []map[string]interface{}
+	if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &reports)) && assert.Len(t, reports, 1) {
+		r := reports[0]
+		assert.Equal(t, "fl-us-health", r["name"])
+		assert.Equal(t, "45.63.2.1", r["ip"])
+		assert.Equal(t, "online", r["state"])
+		assert.Equal(t, float64(0), r["consecutiveFailures"])
+		assert.Equal(t, true, r["cfRecordExists"])
+		assert.Equal(t, "1234", r["cfRecordID"])
+		_, hasLastCheck := r["lastCheck"]
+		assert.True(t, hasLastCheck, "Report should include lastCheck")
+		assert.Equal(t, map[string]interface{}{"p50": float64(100), "p95": float64(145), "p99": float64(149)}, r["responseTimeMs"])
+	}
+}
+
+func TestCircularBufferPercentiles(t *testing.T) {
+	b := newCircularBuffer(10)
+	assert.Equal(t, []time.Duration{0, 0}, b.percentiles(50, 99), "Empty buffer should report zeros")
+
+	for i := 10; i >= 1; i-- {
+		b.add(time.Duration(i))
+	}
+	assert.Equal(t, []time.Duration{1, 5, 10, 10}, b.percentiles(0, 50, 95, 100))
+
+	b.add(11)
+	assert.Equal(t, []time.Duration{9, 8, 7, 6, 5, 4, 3, 2, 1, 11}, b.snapshot(), "Oldest value should have been overwritten")
+}
+
+// withAdminKey sets the admin key, returning a function that restores the
+// original one.
+func withAdminKey(key string) func() {
+	orig := adminKey
+	adminKey = key
+	return func() {
+		adminKey = orig
+	}
+}
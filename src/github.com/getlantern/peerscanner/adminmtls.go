@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+var (
+	adminClientCA = flag.String("admin-client-ca", "", "PEM file with the CA certificate that signs admin client certificates. Admin requests with a client certificate signed by it don't need an X-Admin-Key.")
+)
+
+// loadAdminClientCAs loads the CA certificates in -admin-client-ca.
+func loadAdminClientCAs(filename string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No PEM certificates in %v", filename)
+	}
+	return pool, nil
+}
+
+// configureAdminClientAuth has tlsConfig verify client certificates against
+// -admin-client-ca, if it's set. The same server handles peers registering,
+// which don't have client certificates, so a certificate is only verified if
+// the client presents one. A client whose certificate doesn't verify fails the
+// handshake.
+func configureAdminClientAuth(tlsConfig *tls.Config) error {
+	if *adminClientCA == "" {
+		return nil
+	}
+	pool, err := loadAdminClientCAs(*adminClientCA)
+	if err != nil {
+		return err
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	return nil
+}
+
+// hasVerifiedClientCert indicates whether req came with a client certificate
+// that verified against -admin-client-ca.
+func hasVerifiedClientCert(req *http.Request) bool {
+	return req.TLS != nil && len(req.TLS.VerifiedChains) > 0
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestAdminClientCertSupersedesKey(t *testing.T) {
+	defer withAdminKey("secret")()
+	ca, caKey := newTestCA(t, "Admin CA")
+	other, otherKey := newTestCA(t, "Other CA")
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if !assert.NoError(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0644)) {
+		return
+	}
+	orig := *adminClientCA
+	*adminClientCA = caFile
+	defer func() { *adminClientCA = orig }()
+
+	server := httptest.NewUnstartedServer(requireAdmin(func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(200)
+	}))
+	server.TLS = &tls.Config{}
+	if !assert.NoError(t, configureAdminClientAuth(server.TLS)) {
+		return
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	get := func(cert *tls.Certificate, key string) (int, error) {
+		// A new transport every time, so that connections aren't reused
+		tlsConfig := server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+		if cert != nil {
+			// Send it even if it's not signed by one of the CAs that the
+			// server asks for
+			tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				return cert, nil
+			}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		req, _ := http.NewRequest("GET", server.URL+"/v1/admin/whatever", nil)
+		if key != "" {
+			req.Header.Set("X-Admin-Key", key)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	trusted := newTestClientCert(t, ca, caKey)
+	status, err := get(&trusted, "")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 200, status, "Client certificate signed by the CA should do without a key")
+	}
+	status, err = get(&trusted, "wrong")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 200, status, "Client certificate should supersede the key")
+	}
+
+	status, err = get(nil, "secret")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 200, status, "Key should still do without a client certificate")
+	}
+	status, err = get(nil, "")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 401, status, "Neither certificate nor key should be unauthorized")
+	}
+
+	untrusted := newTestClientCert(t, other, otherKey)
+	_, err = get(&untrusted, "secret")
+	assert.Error(t, err, "Client certificate from another CA should fail the handshake")
+
+	adminKey = ""
+	status, err = get(&trusted, "")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 200, status, "Client certificate should do even with key authentication disabled")
+	}
+}
+
+func TestLoadAdminClientCAs(t *testing.T) {
+	dir := t.TempDir()
+	_, err := loadAdminClientCAs(filepath.Join(dir, "missing.pem"))
+	assert.Error(t, err)
+	notPem := filepath.Join(dir, "ca.pem")
+	assert.NoError(t, ioutil.WriteFile(notPem, []byte("not a certificate"), 0644))
+	_, err = loadAdminClientCAs(notPem)
+	assert.Error(t, err, "File without certificates should be rejected")
+
+	orig := *adminClientCA
+	*adminClientCA = notPem
+	defer func() { *adminClientCA = orig }()
+	assert.Contains(t, validateConfig(), "Invalid -admin-client-ca: No PEM certificates in "+notPem)
+}
+
+func newTestCA(t *testing.T, name string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-1 * time.Hour),
+		NotAfter:              time.Now().Add(1 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func newTestClientCert(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "admin"},
+		NotBefore:    time.Now().Add(-1 * time.Hour),
+		NotAfter:     time.Now().Add(1 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
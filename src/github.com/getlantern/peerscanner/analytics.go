@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/getlantern/peerscanner/cfl"
+)
+
+const (
+	// analyticsMaxAge is how long we use the zone's analytics from CloudFlare
+	// before fetching them again
+	analyticsMaxAge = 10 * time.Minute
+)
+
+var (
+	zoneAnalytics = &analyticsCache{analytics: make(map[string]cachedAnalytics)}
+)
+
+// analyticsReport is a cfl.ZoneAnalytics along with when we got it from
+// CloudFlare.
+type analyticsReport struct {
+	Period string `json:"period"`
+	*cfl.ZoneAnalytics
+	LastUpdated time.Time `json:"last_updated"`
+}
+
+// getAnalytics is the admin endpoint that reports how much traffic the zone
+// served over the last ?period, 24h by default.
+func getAnalytics(resp http.ResponseWriter, req *http.Request) {
+	period := req.URL.Query().Get("period")
+	if period == "" {
+		period = "24h"
+	}
+	if !cfl.IsValidAnalyticsPeriod(period) {
+		resp.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(resp, "Unsupported period %v, expected 1h, 24h or 7d\n", period)
+		return
+	}
+	analytics, fetched, err := zoneAnalytics.get(period)
+	if err != nil {
+		resp.WriteHeader(http.StatusBadGateway)
+		fmt.Fprintln(resp, err.Error())
+		return
+	}
+	writeJSON(resp, analyticsReport{Period: period, ZoneAnalytics: analytics, LastUpdated: fetched})
+}
+
+// analyticsCache caches the zone's analytics for each period.
+type analyticsCache struct {
+	analytics map[string]cachedAnalytics
+	mutex     sync.Mutex
+}
+
+type cachedAnalytics struct {
+	analytics *cfl.ZoneAnalytics
+	fetched   time.Time
+}
+
+// get returns the analytics for period and when we got them from CloudFlare,
+// fetching them again if they're older than analyticsMaxAge.
+func (c *analyticsCache) get(period string) (*cfl.ZoneAnalytics, time.Time, error) {
+	c.mutex.Lock()
+	cached, found := c.analytics[period]
+	c.mutex.Unlock()
+	if found && time.Since(cached.fetched) < analyticsMaxAge {
+		return cached.analytics, cached.fetched, nil
+	}
+
+	analytics, err := cflutil.GetAnalytics(period)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	cached = cachedAnalytics{analytics, time.Now()}
+	c.mutex.Lock()
+	c.analytics[period] = cached
+	c.mutex.Unlock()
+	return cached.analytics, cached.fetched, nil
+}
+
+// purge empties the cache.
+func (c *analyticsCache) purge() {
+	c.mutex.Lock()
+	c.analytics = make(map[string]cachedAnalytics)
+	c.mutex.Unlock()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/getlantern/peerscanner/cfl"
+	"github.com/getlantern/peerscanner/cfl/cfltest"
+	"github.com/getlantern/testify/assert"
+)
+
+func TestGetAnalytics(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	zoneAnalytics.purge()
+	defer zoneAnalytics.purge()
+	m.SetAnalytics(cfl.ZoneAnalytics{QueryCount: 1000, UniqueIPs: 100, TopCountryCodes: []string{"IR", "CN"}})
+
+	rec := httptest.NewRecorder()
+	getAnalytics(rec, httptest.NewRequest("GET", "/v1/admin/analytics?period=7d", nil))
+	assert.Equal(t, 200, rec.Code)
+	var report map[string]interface{}
+	if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report)) {
+		assert.Equal(t, "7d", report["period"])
+		assert.Equal(t, float64(1000), report["query_count"])
+		assert.Equal(t, float64(100), report["unique_ips"])
+		assert.Equal(t, []interface{}{"IR", "CN"}, report["top_country_codes"])
+		assert.NotEmpty(t, report["last_updated"])
+	}
+
+	countDashboardRequests := func() int {
+		n := 0
+		for _, req := range m.V4Requests() {
+			if req.Path == "/zones/"+cfltest.ZoneId+"/analytics/dashboard" {
+				n++
+			}
+		}
+		return n
+	}
+	m.SetAnalytics(cfl.ZoneAnalytics{QueryCount: 2000})
+	rec = httptest.NewRecorder()
+	getAnalytics(rec, httptest.NewRequest("GET", "/v1/admin/analytics?period=7d", nil))
+	assert.Contains(t, rec.Body.String(), `"query_count":1000`, "Analytics should be cached")
+	assert.Equal(t, 1, countDashboardRequests())
+
+	rec = httptest.NewRecorder()
+	getAnalytics(rec, httptest.NewRequest("GET", "/v1/admin/analytics", nil))
+	assert.Contains(t, rec.Body.String(), `"period":"24h"`, "Period should default to 24h")
+	assert.Contains(t, rec.Body.String(), `"query_count":2000`, "Periods should be cached separately")
+	assert.Equal(t, 2, countDashboardRequests())
+}
+
+func TestGetAnalyticsFails(t *testing.T) {
+	rec := httptest.NewRecorder()
+	getAnalytics(rec, httptest.NewRequest("GET", "/v1/admin/analytics?period=30d", nil))
+	assert.Equal(t, 400, rec.Code, "Unsupported period should be rejected")
+
+	m := newMockCfl()
+	defer m.close()
+	zoneAnalytics.purge()
+	m.Close()
+	rec = httptest.NewRecorder()
+	getAnalytics(rec, httptest.NewRequest("GET", "/v1/admin/analytics?period=1h", nil))
+	assert.Equal(t, 502, rec.Code, "Failing to get analytics should be reported")
+}
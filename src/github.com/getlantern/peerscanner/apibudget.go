@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/getlantern/peerscanner/cfl"
+)
+
+var (
+	cfApiRate      = flag.Float64("cf-api-rate", 4, "CloudFlare API calls per second that peerscanner may make, 0 for no limit, defaults to 4 (CloudFlare allows 1200 per 5 minutes)")
+	cfApiBurst     = flag.Int("cf-api-burst", 50, "CloudFlare API calls that peerscanner may make in a burst, defaults to 50")
+	cfApiWait      = flag.Duration("cf-api-wait", 5*time.Second, "How long a CloudFlare API call waits for the budget before it fails, e.g. leaving a removal until the next reconciliation, defaults to 5 seconds")
+	registerCfWait = flag.Duration("register-cf-wait", 1*time.Second, "How soon there needs to be CloudFlare API budget for a registration of a new host, which is rejected with a 503 otherwise, defaults to 1 second")
+
+	// cfBudget is what every call to CloudFlare takes a token from, nil if
+	// -cf-api-rate is 0
+	cfBudget *cfl.Budget
+
+	// errAPIBudgetExhausted is what Register returns for new hosts when
+	// there's no CloudFlare API budget left for creating their records
+	errAPIBudgetExhausted = fmt.Errorf("CloudFlare API budget exhausted")
+)
+
+// skippedForBudget indicates whether err is from a call that reconciliation
+// made without there being budget for it, logging that it's skipped.
+func skippedForBudget(err error, what string) bool {
+	if !cfl.IsBudgetExhausted(err) {
+		return false
+	}
+	log.Debugf("CloudFlare API budget exhausted, not %v until the next reconciliation", what)
+	return true
+}
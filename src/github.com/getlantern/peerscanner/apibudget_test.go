@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getlantern/peerscanner/cfl"
+	"github.com/getlantern/testify/assert"
+)
+
+// exhaustCfBudget replaces cfBudget with one that's used up, returning a
+// function that restores it.
+func exhaustCfBudget() func() {
+	orig, origRegisterWait, origApiWait := cfBudget, *registerCfWait, *cfApiWait
+	cfBudget = cfl.NewBudget(0.01, 1)
+	cfBudget.Wait(0)
+	*registerCfWait, *cfApiWait = 10*time.Millisecond, 10*time.Millisecond
+	return func() {
+		cfBudget, *registerCfWait, *cfApiWait = orig, origRegisterWait, origApiWait
+	}
+}
+
+func TestRegisterRejectedWithoutCfBudget(t *testing.T) {
+	defer exhaustCfBudget()()
+	pool := NewHostPool()
+	rec := httptest.NewRecorder()
+	webFor(pool).register(rec, newRegisterRequest("fl-us-budget", "45.63.8.1", "443"))
+	assert.Equal(t, 503, rec.Code, "Registration should be rejected without budget")
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+	assert.Contains(t, rec.Body.String(), "cf_api_budget_exhausted")
+	assert.Nil(t, pool.Get("45.63.8.1"), "Host shouldn't have been created")
+}
+
+func TestReconcileSkipsRemovalWithoutCfBudget(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	r := m.AddRecord("A", string(RoundRobin), "45.63.8.2")
+	restore := exhaustCfBudget()
+	var err error
+	cflutil, err = m.NewUtil(cfl.WithBudget(cfBudget, *cfApiWait))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	removeCflRecord(&wg, RoundRobin, &r)
+	wg.Wait()
+	assert.Len(t, m.FindRecords(string(RoundRobin), "45.63.8.2"), 1, "Record shouldn't have been removed without budget")
+	assert.Len(t, m.V4Requests(), 0, "Nothing should have been sent to CloudFlare without budget")
+
+	restore()
+	cflutil, err = m.NewUtil()
+	if !assert.NoError(t, err) {
+		return
+	}
+	wg.Add(1)
+	removeCflRecord(&wg, RoundRobin, &r)
+	wg.Wait()
+	assert.Len(t, m.FindRecords(string(RoundRobin), "45.63.8.2"), 0, "Record should have been removed with budget")
+}
+
+func TestHostCallsTakeCfBudget(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	var err error
+	cflutil, err = m.NewUtil(cfl.WithBudget(cfl.NewBudget(0.01, 2), 10*time.Millisecond))
+	if !assert.NoError(t, err) {
+		return
+	}
+	name, ip := "fl-us-hostbudget", "45.63.8.3"
+	f := newFakeFallback(name)
+	defer f.close()
+	h, _ := newTestHost(name, ip, f)
+
+	// Registering takes more than the 2 calls that the budget allows for
+	h.check()
+	assert.Len(t, m.GetRequests(), 2, "Calls beyond the budget shouldn't have been sent")
+	assert.Contains(t, h.getInfo().lastCfSyncErr, "budget exhausted", "Running out of budget should have been recorded")
+}
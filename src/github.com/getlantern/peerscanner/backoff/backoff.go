@@ -0,0 +1,66 @@
+// Package backoff computes how long to wait between attempts at something that
+// may fail, like a call to CloudFlare or a check of a host.
+//
+// A retry loop asks its Policy for the wait after every failed attempt and
+// gives up once the Policy says Stop:
+//
+//	for attempt := 1; ; attempt++ {
+//		err := try()
+//		if err == nil {
+//			return nil
+//		}
+//		wait := policy.Next(attempt)
+//		if wait == backoff.Stop {
+//			return err
+//		}
+//		time.Sleep(wait)
+//	}
+package backoff
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Stop is what Policy.Next returns once no more attempts should be made.
+const Stop time.Duration = -1
+
+// Policy describes how long to wait between attempts. The wait grows
+// exponentially from Base by Multiplier up to Max, randomized by +/- Jitter (a
+// fraction of the wait).
+type Policy struct {
+	Base        time.Duration
+	Max         time.Duration
+	Multiplier  float64
+	Jitter      float64
+	MaxAttempts int
+}
+
+var (
+	// DefaultCFPolicy is for retrying calls to the CloudFlare API
+	DefaultCFPolicy = Policy{Base: 1 * time.Second, Max: 30 * time.Second, Multiplier: 2, Jitter: 0.2, MaxAttempts: 5}
+
+	// DefaultCheckPolicy is for retrying test requests through a host before
+	// considering it down
+	DefaultCheckPolicy = Policy{Base: 500 * time.Millisecond, Max: 2 * time.Second, Multiplier: 2, Jitter: 0.2, MaxAttempts: 1}
+)
+
+// Next returns how long to wait after the given attempt (starting at 1) before
+// making the next one, or Stop if MaxAttempts have been made already.
+func (p Policy) Next(attempt int) time.Duration {
+	if attempt >= p.MaxAttempts {
+		return Stop
+	}
+	if attempt < 1 {
+		attempt = 1
+	}
+	wait := float64(p.Base) * math.Pow(p.Multiplier, float64(attempt-1))
+	if wait > float64(p.Max) {
+		wait = float64(p.Max)
+	}
+	if p.Jitter > 0 {
+		wait = wait * (1 + p.Jitter*(2*rand.Float64()-1))
+	}
+	return time.Duration(wait)
+}
@@ -0,0 +1,48 @@
+package backoff
+
+import (
+	"testing"
+	"time"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestBackoffJitterRange(t *testing.T) {
+	p := Policy{Base: 100 * time.Millisecond, Max: 1 * time.Second, Multiplier: 2, Jitter: 0.25, MaxAttempts: 10}
+	for i := 0; i < 1000; i++ {
+		for attempt, expected := range map[int]time.Duration{
+			1: 100 * time.Millisecond,
+			2: 200 * time.Millisecond,
+			3: 400 * time.Millisecond,
+			5: 1 * time.Second,
+			9: 1 * time.Second,
+		} {
+			wait := p.Next(attempt)
+			assert.True(t, wait >= expected*3/4 && wait <= expected*5/4, "Wait %v after attempt %d should be within 25%% of %v", wait, attempt, expected)
+		}
+	}
+
+	p.Jitter = 0
+	assert.Equal(t, 400*time.Millisecond, p.Next(3), "Without jitter, wait should be exact")
+}
+
+func TestBackoffMaxAttempts(t *testing.T) {
+	p := Policy{Base: time.Millisecond, Max: time.Second, Multiplier: 2, MaxAttempts: 3}
+	assert.NotEqual(t, Stop, p.Next(1))
+	assert.NotEqual(t, Stop, p.Next(2))
+	assert.Equal(t, Stop, p.Next(3), "Should stop after MaxAttempts")
+	assert.Equal(t, Stop, p.Next(4), "Should stop after MaxAttempts")
+
+	attempts := 0
+	for attempt := 1; ; attempt++ {
+		attempts++
+		if p.Next(attempt) == Stop {
+			break
+		}
+	}
+	assert.Equal(t, p.MaxAttempts, attempts, "Retry loop should make exactly MaxAttempts attempts")
+
+	for _, p := range []Policy{DefaultCFPolicy, DefaultCheckPolicy} {
+		assert.True(t, p.MaxAttempts >= 1, "Default policies should allow at least one attempt")
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"time"
+)
+
+const (
+	backupLockKey  = "peerscanner:backup"
+	backupInterval = 1 * time.Hour
+)
+
+var (
+	backupCfdomain = flag.String("backup-cfdomain", "", "(optional) Domain of a backup zone in the same CloudFlare account that our A records are copied to every hour, so that we can switch to it quickly if -cfldomain is lost")
+)
+
+// startZoneBackup copies our records to -backup-cfdomain now and every
+// backupInterval from now on, in the background. Only one replica does this
+// at a time.
+func startZoneBackup() {
+	if *backupCfdomain == "" {
+		return
+	}
+	go func() {
+		backupZone()
+		for range time.Tick(backupInterval) {
+			backupZone()
+		}
+	}()
+}
+
+func backupZone() {
+	acquired, err := withLock(reconcileLock, backupLockKey, reconcileLockTtl, func() {
+		created, err := cflutil.CloneZone(*backupCfdomain)
+		if err != nil {
+			log.Errorf("Unable to back up zone to %v, created %d records: %v", *backupCfdomain, created, err)
+			return
+		}
+		log.Debugf("Backed up zone to %v, created %d records", *backupCfdomain, created)
+	})
+	if err != nil {
+		log.Errorf("Unable to acquire lock %v, not backing up zone: %v", backupLockKey, err)
+	} else if !acquired {
+		log.Debugf("Another replica holds %v, not backing up zone", backupLockKey)
+	}
+}
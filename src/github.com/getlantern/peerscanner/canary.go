@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	canaryInterval = 1 * time.Minute
+
+	// canaryFailThreshold is how many canary probes of a peer have to fail in
+	// a row before we warn about it
+	canaryFailThreshold = 3
+)
+
+var (
+	canary            = flag.Bool("canary", false, "Run as a canary alongside another peerscanner: instead of serving registrations and changing CloudFlare records, regularly probe a sample of the peers it lists from this location, defaults to false")
+	canarySourceUrl   = flag.String("canary-source-url", "", "The /v1/peers URL of the peerscanner whose peers -canary probes, e.g. https://localhost:62443/v1/peers")
+	canaryMetricsAddr = flag.String("canary-metrics-addr", ":9091", "Address at which -canary serves its metrics at /debug/vars, defaults to :9091")
+)
+
+// Canary probes peers and fallbacks from wherever it runs, to find the ones
+// that peerscanner's own checks consider online but that users in other
+// locations can't reach. Every canaryInterval, it dials a random sample of
+// the ones listed by a running peerscanner's /v1/peers. It is not safe for
+// concurrent use.
+type Canary struct {
+	sourceUrl string
+	client    *http.Client
+	dialer    Dialer
+	// fraction is the fraction of listed peers probed every time
+	fraction float64
+	// failures counts the consecutive failed probes per address
+	failures map[string]int
+}
+
+func NewCanary(sourceUrl string, client *http.Client, dialer Dialer) *Canary {
+	return &Canary{sourceUrl: sourceUrl, client: client, dialer: dialer, fraction: 0.1, failures: make(map[string]int)}
+}
+
+// runCanary runs peerscanner in canary mode, which only serves its metrics.
+func runCanary() {
+	c := NewCanary(*canarySourceUrl, canaryClient(), defaultDialer)
+	go func() {
+		log.Debugf("Serving canary metrics at %v", *canaryMetricsAddr)
+		if err := http.ListenAndServe(*canaryMetricsAddr, nil); err != nil {
+			log.Fatalf("Unable to serve canary metrics: %v", err)
+		}
+	}()
+	log.Debugf("Probing peers listed at %v every %v", c.sourceUrl, canaryInterval)
+	for {
+		if err := c.probe(); err != nil {
+			log.Errorf("Unable to probe peers: %v", err)
+		}
+		time.Sleep(canaryInterval)
+	}
+}
+
+// canaryClient returns the client used to fetch peers. peerscanner serves
+// /v1/peers with a self-signed certificate, so the canary also trusts the
+// certificate in CertFile if it's there, as it is when running alongside.
+func canaryClient() *http.Client {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if pem, err := ioutil.ReadFile(CertFile); err == nil {
+		pool.AppendCertsFromPEM(pem)
+	}
+	return &http.Client{
+		Timeout:   requestTimeout,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+	}
+}
+
+// probe fetches the current peers, dials a sample of them and records the
+// results in canary_probe_duration_seconds.
+func (c *Canary) probe() error {
+	addrs, err := c.fetchPeers()
+	if err != nil {
+		return err
+	}
+	sample := c.sample(addrs)
+
+	failed := make([]bool, len(sample))
+	var wg sync.WaitGroup
+	for i, addr := range sample {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			start := time.Now()
+			err := c.dial(addr)
+			result := "success"
+			if err != nil {
+				log.Debugf("Canary probe of %v failed: %v", addr, err)
+				result = "failure"
+				failed[i] = true
+			}
+			canaryProbeDuration.observe(time.Since(start).Seconds(), result)
+		}(i, addr)
+	}
+	wg.Wait()
+
+	// Forget about peers that are no longer listed
+	listed := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		listed[addr] = true
+	}
+	for addr := range c.failures {
+		if !listed[addr] {
+			delete(c.failures, addr)
+		}
+	}
+	for i, addr := range sample {
+		if !failed[i] {
+			delete(c.failures, addr)
+			continue
+		}
+		c.failures[addr]++
+		if c.failures[addr] >= canaryFailThreshold {
+			canaryWarnings.Add(1)
+			log.Errorf("WARNING: %v failed %d canary probes in a row", addr, c.failures[addr])
+		}
+	}
+	log.Debugf("Probed %d of %d peers, %d failed", len(sample), len(addrs), countTrue(failed))
+	return nil
+}
+
+// fetchPeers returns the addresses of the peers and fallbacks currently
+// listed at c.sourceUrl.
+func (c *Canary) fetchPeers() ([]string, error) {
+	resp, err := c.client.Get(c.sourceUrl)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to fetch peers from %v: %v", c.sourceUrl, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected response status fetching peers from %v: %v", c.sourceUrl, resp.Status)
+	}
+	var peers peersResponse
+	if err := json.NewDecoder(resp.Body).Decode(&peers); err != nil {
+		return nil, fmt.Errorf("Unable to decode peers from %v: %v", c.sourceUrl, err)
+	}
+	addrs := make([]string, 0, len(peers.Peers)+len(peers.Fallbacks))
+	for _, p := range append(peers.Peers, peers.Fallbacks...) {
+		addrs = append(addrs, net.JoinHostPort(p.Ip, strconv.Itoa(p.Port)))
+	}
+	return addrs, nil
+}
+
+// sample randomly picks c.fraction of the given addresses, but at least one.
+func (c *Canary) sample(addrs []string) []string {
+	n := int(math.Ceil(c.fraction * float64(len(addrs))))
+	sample := make([]string, 0, n)
+	for _, i := range rand.Perm(len(addrs))[:n] {
+		sample = append(sample, addrs[i])
+	}
+	return sample
+}
+
+func (c *Canary) dial(addr string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	conn, err := c.dialer.Dial(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func countTrue(bs []bool) int {
+	n := 0
+	for _, b := range bs {
+		if b {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestCanary(t *testing.T) {
+	listed := peersResponse{
+		Peers:     []peerInfo{{Name: "peer-1", Ip: "1.2.3.4", Port: 443}},
+		Fallbacks: []peerInfo{{Name: "fl-us-1", Ip: "5.6.7.8", Port: 443}, {Name: "fl-us-2", Ip: "5.6.7.9", Port: 80}},
+	}
+	source := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		writeJSON(resp, listed)
+	}))
+	defer source.Close()
+
+	var dialed []string
+	var mutex sync.Mutex
+	unreachable := map[string]bool{"5.6.7.8:443": true}
+	dialer := dialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		dialed = append(dialed, address)
+		if unreachable[address] {
+			return nil, fmt.Errorf("connection timed out")
+		}
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	})
+	c := NewCanary(source.URL, http.DefaultClient, dialer)
+	c.fraction = 1
+
+	before := canaryWarnings.Value()
+	for i := 1; i < canaryFailThreshold; i++ {
+		assert.NoError(t, c.probe())
+		assert.Equal(t, i, c.failures["5.6.7.8:443"])
+	}
+	assert.Equal(t, before, canaryWarnings.Value(), "Shouldn't warn before %d failures", canaryFailThreshold)
+	assert.Len(t, c.failures, 1, "Only the unreachable fallback should have failures")
+	assert.NoError(t, c.probe())
+	assert.Equal(t, before+1, canaryWarnings.Value(), "Should warn after %d failures in a row", canaryFailThreshold)
+	assert.Len(t, dialed, 3*canaryFailThreshold, "Should have probed all peers every time")
+
+	// Success or no longer being listed resets the failures
+	delete(unreachable, "5.6.7.8:443")
+	assert.NoError(t, c.probe())
+	assert.Len(t, c.failures, 0)
+	unreachable["5.6.7.9:80"] = true
+	assert.NoError(t, c.probe())
+	listed.Fallbacks = listed.Fallbacks[:1]
+	assert.NoError(t, c.probe())
+	assert.Len(t, c.failures, 0, "Peers that are no longer listed should be forgotten")
+}
+
+func TestCanarySample(t *testing.T) {
+	c := NewCanary("", nil, nil)
+	addrs := make([]string, 95)
+	for i := range addrs {
+		addrs[i] = fmt.Sprintf("1.2.3.%d:443", i)
+	}
+	sample := c.sample(addrs)
+	assert.Len(t, sample, 10, "Should probe 10%% of peers, rounded up")
+	seen := make(map[string]bool)
+	for _, addr := range sample {
+		assert.False(t, seen[addr], "%v sampled twice", addr)
+		seen[addr] = true
+	}
+	assert.Len(t, c.sample(addrs[:3]), 1, "Should probe at least one peer")
+	assert.Len(t, c.sample(nil), 0)
+}
+
+func TestValidateCanaryConfig(t *testing.T) {
+	origCanary, origUrl, origId := *canary, *canarySourceUrl, cflid
+	defer func() {
+		*canary, *canarySourceUrl, cflid = origCanary, origUrl, origId
+	}()
+	*canary = true
+	cflid = ""
+	*canarySourceUrl = "https://localhost:62443/v1/peers"
+	assert.Len(t, validateConfig(), 0, "Canaries shouldn't need CloudFlare credentials")
+	*canarySourceUrl = ""
+	assert.Len(t, validateConfig(), 1, "Canaries need -canary-source-url")
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	cfProbeInterval = 30 * time.Second
+	cfSloWindow     = 5 * time.Minute
+	cfSloTarget     = 0.999
+)
+
+// CloudFlareHealthCheck periodically probes CloudFlare's API, recording how
+// long the probes take in cf_api_probe_duration_seconds and warning when the
+// success rate over the last cfSloWindow drops below cfSloTarget.
+type CloudFlareHealthCheck struct {
+	probe    func() error
+	interval time.Duration
+	window   *sloWindow
+	breached bool
+}
+
+// NewCloudFlareHealthCheck creates a CloudFlareHealthCheck that calls probe
+// every interval.
+func NewCloudFlareHealthCheck(probe func() error, interval time.Duration) *CloudFlareHealthCheck {
+	return &CloudFlareHealthCheck{
+		probe:    probe,
+		interval: interval,
+		window:   newSloWindow(cfSloWindow),
+	}
+}
+
+// startCloudFlareHealthCheck starts monitoring CloudFlare's API.
+func startCloudFlareHealthCheck() {
+	go NewCloudFlareHealthCheck(cflutil.Probe, cfProbeInterval).run()
+}
+
+func (c *CloudFlareHealthCheck) run() {
+	for {
+		c.check(time.Now())
+		time.Sleep(c.interval)
+	}
+}
+
+// check probes CloudFlare once and updates the SLO.
+func (c *CloudFlareHealthCheck) check(now time.Time) {
+	start := time.Now()
+	err := c.probe()
+	result := "success"
+	if err != nil {
+		log.Debugf("CloudFlare API probe failed: %v", err)
+		result = "failure"
+	}
+	cfProbeDuration.observe(time.Since(start).Seconds(), result)
+	c.window.record(now, err == nil)
+
+	rate := c.window.successRate(now)
+	if rate < cfSloTarget {
+		// Only count the breach once, not every probe while it lasts
+		if !c.breached {
+			cfSloBreaches.Add(1)
+		}
+		c.breached = true
+		log.Errorf("WARNING: CloudFlare API success rate over the last %v is %.2f%%, below our SLO of %.1f%%", cfSloWindow, rate*100, cfSloTarget*100)
+	} else if c.breached {
+		log.Debugf("CloudFlare API success rate recovered to %.2f%%", rate*100)
+		c.breached = false
+	}
+}
+
+// sloWindow counts successes and failures over a sliding window of time. It
+// is safe for concurrent use.
+type sloWindow struct {
+	length  time.Duration
+	results []sloResult
+	mutex   sync.Mutex
+}
+
+type sloResult struct {
+	t       time.Time
+	success bool
+}
+
+func newSloWindow(length time.Duration) *sloWindow {
+	return &sloWindow{length: length}
+}
+
+// record records a success or failure that happened at t.
+func (w *sloWindow) record(t time.Time, success bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.results = append(w.results, sloResult{t, success})
+	w.expire(t)
+}
+
+// successRate returns the fraction of results within the window ending at now
+// that were successes, or 1 if there were none.
+func (w *sloWindow) successRate(now time.Time) float64 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.expire(now)
+	if len(w.results) == 0 {
+		return 1
+	}
+	successes := 0
+	for _, r := range w.results {
+		if r.success {
+			successes++
+		}
+	}
+	return float64(successes) / float64(len(w.results))
+}
+
+// expire drops results that are older than the window ending at now.
+func (w *sloWindow) expire(now time.Time) {
+	cutoff := now.Add(-w.length)
+	i := 0
+	for i < len(w.results) && !w.results[i].t.After(cutoff) {
+		i++
+	}
+	w.results = w.results[i:]
+}
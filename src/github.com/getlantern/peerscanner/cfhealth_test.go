@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestSloWindow(t *testing.T) {
+	w := newSloWindow(5 * time.Minute)
+	start := time.Now()
+	assert.Equal(t, 1.0, w.successRate(start), "Empty window should meet any SLO")
+
+	for i := 0; i < 9; i++ {
+		w.record(start.Add(time.Duration(i)*30*time.Second), true)
+	}
+	w.record(start.Add(270*time.Second), false)
+	assert.InDelta(t, 0.9, w.successRate(start.Add(270*time.Second)), 0.0001)
+
+	// Once the first 2 successes slide out of the window, 1 of 8 failed
+	assert.InDelta(t, 0.875, w.successRate(start.Add(5*time.Minute+30*time.Second)), 0.0001)
+
+	// And once the failure slides out, everything succeeded again
+	assert.Equal(t, 1.0, w.successRate(start.Add(10*time.Minute)))
+}
+
+func TestCloudFlareHealthCheckCountsBreaches(t *testing.T) {
+	var probeErr error
+	c := NewCloudFlareHealthCheck(func() error { return probeErr }, cfProbeInterval)
+	before := cfSloBreaches.Value()
+	probesBefore := cfProbeDuration.count("failure") + cfProbeDuration.count("success")
+
+	now := time.Now()
+	c.check(now)
+	assert.Equal(t, before, cfSloBreaches.Value(), "Successful probe shouldn't breach the SLO")
+
+	probeErr = fmt.Errorf("API down")
+	c.check(now.Add(30 * time.Second))
+	c.check(now.Add(60 * time.Second))
+	assert.Equal(t, before+1, cfSloBreaches.Value(), "Ongoing breach should only be counted once")
+
+	// Recover once the failures have left the window, then breach again
+	probeErr = nil
+	c.check(now.Add(10 * time.Minute))
+	assert.False(t, c.breached, "SLO should have recovered")
+	probeErr = fmt.Errorf("API down again")
+	c.check(now.Add(10*time.Minute + 30*time.Second))
+	assert.Equal(t, before+2, cfSloBreaches.Value(), "New breach should be counted")
+
+	assert.Equal(t, probesBefore+5, cfProbeDuration.count("failure")+cfProbeDuration.count("success"), "Every probe should be timed")
+}
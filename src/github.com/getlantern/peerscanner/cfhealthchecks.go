@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+)
+
+var (
+	cfHealthChecks = flag.Bool("cf-health-checks", false, "Also have CloudFlare health check every host that's registered, which needs a Pro or higher plan, defaults to false")
+)
+
+// registerHealthCheck creates this host's CloudFlare health check if
+// -cf-health-checks is set and we haven't yet.
+func (h *host) registerHealthCheck() error {
+	if !*cfHealthChecks || h.healthCheckRegistered {
+		return nil
+	}
+	port, err := strconv.Atoi(h.port)
+	if err != nil {
+		log.Tracef("Port of %v not known yet, not registering health check", h)
+		return nil
+	}
+	if err := cflutil.CreateHealthCheck(h.name, h.ip, port); err != nil {
+		return err
+	}
+	h.healthCheckRegistered = true
+	return nil
+}
+
+// deregisterHealthCheck deletes this host's CloudFlare health check, if we
+// created it.
+func (h *host) deregisterHealthCheck() error {
+	if !h.healthCheckRegistered {
+		return nil
+	}
+	h.healthCheckRegistered = false
+	checks, err := cflutil.ListHealthChecks()
+	if err != nil {
+		return err
+	}
+	for _, hc := range checks {
+		if hc.Name == h.name && hc.Address == h.ip {
+			if err := cflutil.DeleteHealthCheck(hc.Id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
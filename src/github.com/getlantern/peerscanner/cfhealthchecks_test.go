@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/getlantern/peerscanner/cfl"
+	"github.com/getlantern/testify/assert"
+)
+
+func TestCheckRegistersHealthCheck(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	orig := *cfHealthChecks
+	*cfHealthChecks = true
+	defer func() { *cfHealthChecks = orig }()
+
+	name, ip := "fl-us-healthcheck", "45.63.14.1"
+	f := newFakeFallback(name)
+	defer f.close()
+	h, _ := newTestHost(name, ip, f)
+
+	h.check()
+	h.check()
+	checks := m.HealthChecks()
+	if assert.Len(t, checks, 1, "Online host should have exactly one health check") {
+		assert.Equal(t, cfl.HealthCheck{Id: checks[0].Id, Name: name, Address: ip, Port: 80, Status: "unknown"}, checks[0])
+	}
+
+	assert.NoError(t, h.doDeregisterCflHost())
+	assert.Len(t, m.HealthChecks(), 0, "Deregistered host's health check should have been deleted")
+}
+
+func TestCheckSkipsHealthCheckByDefault(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+
+	name, ip := "fl-us-nohealthcheck", "45.63.14.2"
+	f := newFakeFallback(name)
+	defer f.close()
+	h, _ := newTestHost(name, ip, f)
+
+	h.check()
+	assert.Len(t, m.HealthChecks(), 0, "Health check shouldn't be created without -cf-health-checks")
+}
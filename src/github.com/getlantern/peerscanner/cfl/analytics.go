@@ -0,0 +1,82 @@
+package cfl
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	// analyticsTopCountries is how many countries ZoneAnalytics lists
+	analyticsTopCountries = 5
+)
+
+// analyticsPeriods are the periods that GetAnalytics supports, in the minutes
+// that the dashboard API wants them in.
+var analyticsPeriods = map[string]int{
+	"1h":  60,
+	"24h": 1440,
+	"7d":  10080,
+}
+
+// ZoneAnalytics summarizes the traffic that our zone served over a period.
+// TopCountryCodes are the ISO codes of the countries with the most requests,
+// most first.
+type ZoneAnalytics struct {
+	QueryCount      int64    `json:"query_count"`
+	UniqueIPs       int64    `json:"unique_ips"`
+	TopCountryCodes []string `json:"top_country_codes"`
+}
+
+// IsValidAnalyticsPeriod indicates whether GetAnalytics supports period.
+func IsValidAnalyticsPeriod(period string) bool {
+	_, ok := analyticsPeriods[period]
+	return ok
+}
+
+// GetAnalytics gets the totals of our zone's dashboard analytics for the last
+// period, one of 1h, 24h and 7d.
+func (util *Util) GetAnalytics(period string) (*ZoneAnalytics, error) {
+	minutes, ok := analyticsPeriods[period]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported analytics period %v, expected 1h, 24h or 7d", period)
+	}
+	zone, err := util.zoneId()
+	if err != nil {
+		return nil, err
+	}
+	var dashboard struct {
+		Totals struct {
+			Requests struct {
+				All     int64            `json:"all"`
+				Country map[string]int64 `json:"country"`
+			} `json:"requests"`
+			Uniques struct {
+				All int64 `json:"all"`
+			} `json:"uniques"`
+		} `json:"totals"`
+	}
+	err = util.v4Request("GET", fmt.Sprintf("/zones/%v/analytics/dashboard?since=-%d&continuous=true", zone, minutes), nil, &dashboard)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get analytics for the last %v: %v", period, err)
+	}
+
+	countries := dashboard.Totals.Requests.Country
+	codes := make([]string, 0, len(countries))
+	for code := range countries {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		if countries[codes[i]] != countries[codes[j]] {
+			return countries[codes[i]] > countries[codes[j]]
+		}
+		return codes[i] < codes[j]
+	})
+	if len(codes) > analyticsTopCountries {
+		codes = codes[:analyticsTopCountries]
+	}
+	return &ZoneAnalytics{
+		QueryCount:      dashboard.Totals.Requests.All,
+		UniqueIPs:       dashboard.Totals.Uniques.All,
+		TopCountryCodes: codes,
+	}, nil
+}
@@ -0,0 +1,56 @@
+package cfl
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+// dashboard is an abbreviated response from GET /zones/{id}/analytics/dashboard
+const dashboard = `{
+	"totals": {
+		"since": "2026-10-13T00:00:00Z",
+		"until": "2026-10-14T00:00:00Z",
+		"requests": {
+			"all": 1234567,
+			"cached": 1000,
+			"uncached": 1233567,
+			"country": {"US": 400000, "IR": 500000, "CN": 200000, "RU": 100000, "DE": 20000, "FR": 20000, "GB": 5}
+		},
+		"uniques": {"all": 45678}
+	},
+	"timeseries": []
+}`
+
+func TestGetAnalytics(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+	path := "/zones/" + fakeZoneId + "/analytics/dashboard"
+	f.handle("GET", path, func(body []byte) (int, interface{}) {
+		return 200, json.RawMessage(dashboard)
+	})
+
+	analytics, err := f.util.GetAnalytics("24h")
+	if assert.NoError(t, err) {
+		assert.Equal(t, ZoneAnalytics{QueryCount: 1234567, UniqueIPs: 45678, TopCountryCodes: []string{"IR", "US", "CN", "RU", "DE"}}, *analytics)
+	}
+	assert.Equal(t, "-1440", f.query("GET", path).Get("since"), "24h should be asked for in minutes")
+
+	_, err = f.util.GetAnalytics("7d")
+	assert.NoError(t, err)
+	assert.Equal(t, "-10080", f.query("GET", path).Get("since"))
+
+	_, err = f.util.GetAnalytics("2h")
+	assert.Error(t, err, "Unsupported period should be rejected")
+}
+
+func TestGetAnalyticsFails(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+	f.handle("GET", "/zones/"+fakeZoneId+"/analytics/dashboard", func(body []byte) (int, interface{}) {
+		return 403, nil
+	})
+	_, err := f.util.GetAnalytics("1h")
+	assert.Error(t, err)
+}
@@ -0,0 +1,109 @@
+package cfl
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/getlantern/peerscanner/backoff"
+)
+
+const (
+	// batchCreateConcurrency is how many creates BatchCreateRecords makes at
+	// the same time
+	batchCreateConcurrency = 10
+	// batchCreateMaxFailures is the fraction of creates that may fail before
+	// BatchCreateRecords rolls back the others
+	batchCreateMaxFailures = 0.2
+)
+
+// BatchResult is the outcome of BatchCreateRecords. Created and RolledBack
+// hold record ids.
+type BatchResult struct {
+	Created    []string
+	Failed     []RecordSpec
+	RolledBack []string
+}
+
+// BatchCreateRecords creates all of the given records, retrying the ones that
+// fail as retryPolicy allows, after the Retry-After that CloudFlare sent if it
+// rate limited us. If more than 20% of them still fail,
+// it makes a best-effort attempt at deleting the ones it created, so that
+// e.g. a rotation isn't left half populated, and returns an error along with
+// the result.
+func (util *Util) BatchCreateRecords(specs []RecordSpec) (*BatchResult, error) {
+	result := &BatchResult{}
+	if len(specs) == 0 {
+		return result, nil
+	}
+	zone, err := util.zoneId()
+	if err != nil {
+		return nil, err
+	}
+
+	pending := specs
+	for attempt := 1; ; attempt++ {
+		ids, errs := util.createRecords(zone, pending)
+		var failed []RecordSpec
+		var failedErrs []error
+		for i, s := range pending {
+			if errs[i] != nil {
+				log.Debugf("Unable to create %v record %v (%v) on attempt %d: %v", s.Type, s.Name, s.Value, attempt, errs[i])
+				failed = append(failed, s)
+				failedErrs = append(failedErrs, errs[i])
+			} else {
+				result.Created = append(result.Created, ids[i])
+			}
+		}
+		pending = failed
+		if len(pending) == 0 {
+			break
+		}
+		wait := util.retryWait(retryPolicy, attempt, failedErrs...)
+		if wait == backoff.Stop {
+			break
+		}
+		sleep(wait)
+	}
+	result.Failed = pending
+
+	if float64(len(result.Failed)) <= batchCreateMaxFailures*float64(len(specs)) {
+		return result, nil
+	}
+	log.Errorf("%d of %d creates failed, rolling back the %d created records", len(result.Failed), len(specs), len(result.Created))
+	for _, id := range result.Created {
+		err := util.v4Request("DELETE", fmt.Sprintf("/zones/%v/dns_records/%v", zone, id), nil, nil)
+		if err != nil && !isNotFound(err) {
+			log.Errorf("Unable to roll back record %v: %v", id, err)
+			continue
+		}
+		result.RolledBack = append(result.RolledBack, id)
+	}
+	return result, fmt.Errorf("Unable to create %d of %d records", len(result.Failed), len(specs))
+}
+
+// createRecords creates the given records, batchCreateConcurrency at a time,
+// returning the id of each created record or the error creating it.
+func (util *Util) createRecords(zone string, specs []RecordSpec) ([]string, []error) {
+	ids := make([]string, len(specs))
+	errs := make([]error, len(specs))
+	sem := make(chan bool, batchCreateConcurrency)
+	var wg sync.WaitGroup
+	for i, s := range specs {
+		wg.Add(1)
+		sem <- true
+		go func(i int, s RecordSpec) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			ttl := s.Ttl
+			if ttl == 0 {
+				ttl = AutoTtl
+			}
+			rec := batchRecord{Type: s.Type, Name: util.fullName(s.Name), Content: s.Value, Ttl: ttl, Comment: util.createdComment()}
+			var created dnsRecord
+			errs[i] = util.v4Request("POST", fmt.Sprintf("/zones/%v/dns_records", zone), &rec, &created)
+			ids[i] = created.Id
+		}(i, s)
+	}
+	wg.Wait()
+	return ids, errs
+}
@@ -0,0 +1,116 @@
+package cfl
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getlantern/testify/assert"
+)
+
+// flakyCreates is a fake v4 handler for creating records that fails creates
+// of the given ips the given number of times, with a 500 unless status says
+// otherwise.
+type flakyCreates struct {
+	failures map[string]int
+	attempts map[string]int
+	status   int
+	mutex    sync.Mutex
+}
+
+func (c *flakyCreates) handle(body []byte) (int, interface{}) {
+	var rec batchRecord
+	json.Unmarshal(body, &rec)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.attempts[rec.Content]++
+	if c.failures[rec.Content] < 0 || c.attempts[rec.Content] <= c.failures[rec.Content] {
+		if c.status != 0 {
+			return c.status, nil
+		}
+		return 500, nil
+	}
+	return 200, dnsRecord{Id: "rec-" + rec.Content, Type: rec.Type, Name: rec.Name, Content: rec.Content}
+}
+
+func groupSpecs(n int) []RecordSpec {
+	specs := make([]RecordSpec, n)
+	for i := range specs {
+		specs[i] = RecordSpec{Type: "A", Name: "roundrobin", Value: fmt.Sprintf("10.0.0.%d", i+1)}
+	}
+	return specs
+}
+
+func TestBatchCreateRecordsRetries(t *testing.T) {
+	defer withoutRetryDelay()()
+	f := newFakeV4("example.com")
+	defer f.Close()
+	// One fails once, one fails for good, which is within the 20% allowed
+	creates := &flakyCreates{failures: map[string]int{"10.0.0.1": 1, "10.0.0.2": -1}, attempts: make(map[string]int)}
+	f.handle("POST", "/zones/"+fakeZoneId+"/dns_records", creates.handle)
+
+	result, err := f.util.BatchCreateRecords(groupSpecs(5))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, result.Created, 4)
+	assert.Contains(t, result.Created, "rec-10.0.0.1", "Create that failed once should have been retried")
+	assert.Equal(t, []RecordSpec{{Type: "A", Name: "roundrobin", Value: "10.0.0.2"}}, result.Failed)
+	assert.Len(t, result.RolledBack, 0)
+	assert.Equal(t, retryPolicy.MaxAttempts, creates.attempts["10.0.0.2"], "Failing create should have been attempted %d times", retryPolicy.MaxAttempts)
+	assert.Equal(t, 1, creates.attempts["10.0.0.3"], "Successful create shouldn't be repeated")
+}
+
+func TestBatchCreateRecordsHonorsRetryAfter(t *testing.T) {
+	var slept []time.Duration
+	defer withSleep(func(d time.Duration) { slept = append(slept, d) })()
+	f := newFakeV4("example.com")
+	defer f.Close()
+	f.Config.Handler = http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("Retry-After", "10")
+		f.serve(resp, req)
+	})
+	creates := &flakyCreates{failures: map[string]int{"10.0.0.1": 1}, attempts: make(map[string]int), status: http.StatusTooManyRequests}
+	f.handle("POST", "/zones/"+fakeZoneId+"/dns_records", creates.handle)
+
+	result, err := f.util.BatchCreateRecords(groupSpecs(2))
+	if assert.NoError(t, err) {
+		assert.Len(t, result.Created, 2, "Rate limited create should have been retried")
+	}
+	if assert.Len(t, slept, 1) {
+		assert.InDelta(t, float64(10*time.Second), float64(slept[0]), float64(500*time.Millisecond), "Retry should happen after Retry-After, give or take 5%%")
+	}
+}
+
+func TestBatchCreateRecordsRollsBack(t *testing.T) {
+	defer withoutRetryDelay()()
+	f := newFakeV4("example.com")
+	defer f.Close()
+	creates := &flakyCreates{failures: map[string]int{"10.0.0.1": -1, "10.0.0.2": -1}, attempts: make(map[string]int)}
+	f.handle("POST", "/zones/"+fakeZoneId+"/dns_records", creates.handle)
+	for _, ip := range []string{"10.0.0.3", "10.0.0.4"} {
+		f.handle("DELETE", "/zones/"+fakeZoneId+"/dns_records/rec-"+ip, func(body []byte) (int, interface{}) {
+			return 200, nil
+		})
+	}
+	f.handle("DELETE", "/zones/"+fakeZoneId+"/dns_records/rec-10.0.0.5", func(body []byte) (int, interface{}) {
+		return 400, nil
+	})
+
+	result, err := f.util.BatchCreateRecords(groupSpecs(5))
+	assert.Error(t, err, "More than 20%% of creates failing should be an error")
+	if !assert.NotNil(t, result) {
+		return
+	}
+	assert.Len(t, result.Created, 3)
+	assert.Len(t, result.Failed, 2)
+	sort.Strings(result.RolledBack)
+	assert.Equal(t, []string{"rec-10.0.0.3", "rec-10.0.0.4"}, result.RolledBack, "Failed rollback shouldn't be reported as rolled back")
+	for _, ip := range []string{"10.0.0.3", "10.0.0.4", "10.0.0.5"} {
+		assert.True(t, f.requested("DELETE", "/zones/"+fakeZoneId+"/dns_records/rec-"+ip), "Rollback of %v should have been attempted", ip)
+	}
+}
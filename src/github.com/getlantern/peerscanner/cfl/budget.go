@@ -0,0 +1,108 @@
+package cfl
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrBudgetExhausted is what requests fail with when there's no Budget
+	// left for them (see WithBudget)
+	ErrBudgetExhausted = fmt.Errorf("CloudFlare API budget exhausted")
+)
+
+// Budget is a token bucket for calls to the CloudFlare API, so that everything
+// sharing it together doesn't get us rate limited by CloudFlare. A nil Budget
+// is unlimited. It is safe for concurrent use.
+type Budget struct {
+	rate  float64
+	burst float64
+	// tokens goes negative while calls are waiting for tokens that they have
+	// reserved
+	tokens float64
+	last   time.Time
+	mutex  sync.Mutex
+}
+
+// NewBudget creates a Budget that allows rate calls per second and burst
+// calls at once.
+func NewBudget(rate float64, burst int) *Budget {
+	return &Budget{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Wait takes a token for a single call, waiting up to timeout for one to
+// become available. It returns false without taking one or waiting at all if
+// there won't be one within timeout.
+func (b *Budget) Wait(timeout time.Duration) bool {
+	if b == nil {
+		return true
+	}
+	b.mutex.Lock()
+	wait := b.waitLocked()
+	if wait > timeout {
+		b.mutex.Unlock()
+		return false
+	}
+	b.tokens--
+	b.mutex.Unlock()
+	time.Sleep(wait)
+	return true
+}
+
+// Available indicates whether a token will be available within timeout,
+// without taking it.
+func (b *Budget) Available(timeout time.Duration) bool {
+	if b == nil {
+		return true
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.waitLocked() <= timeout
+}
+
+// waitLocked refills the bucket and returns how long it takes until there's a
+// token.
+func (b *Budget) waitLocked() time.Duration {
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// IsBudgetExhausted indicates whether err is from a request that failed
+// because there was no Budget left for it.
+func IsBudgetExhausted(err error) bool {
+	return err != nil && strings.Contains(err.Error(), ErrBudgetExhausted.Error())
+}
+
+// budgetTransport takes a token from budget for every request, waiting up to
+// wait for one. Requests that don't get one fail with ErrBudgetExhausted
+// without being sent.
+type budgetTransport struct {
+	rt     http.RoundTripper
+	budget *Budget
+	wait   time.Duration
+}
+
+func newBudgetTransport(rt http.RoundTripper, budget *Budget, wait time.Duration) *budgetTransport {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &budgetTransport{rt: rt, budget: budget, wait: wait}
+}
+
+func (t *budgetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.budget.Wait(t.wait) {
+		return nil, ErrBudgetExhausted
+	}
+	return t.rt.RoundTrip(req)
+}
@@ -0,0 +1,41 @@
+package cfl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestBudget(t *testing.T) {
+	b := NewBudget(20, 2)
+	assert.True(t, b.Wait(0), "Burst should be available right away")
+	assert.True(t, b.Available(0), "Burst should be available right away")
+	assert.True(t, b.Wait(0), "Burst should be available right away")
+	start := time.Now()
+	assert.False(t, b.Available(10*time.Millisecond), "Exhausted budget shouldn't have a token within 10ms")
+	assert.False(t, b.Wait(10*time.Millisecond), "Exhausted budget shouldn't have a token within 10ms")
+	assert.True(t, time.Since(start) < 10*time.Millisecond, "Shouldn't wait for a token that won't come in time")
+	assert.True(t, b.Available(1*time.Second), "Should have a token within a second")
+	assert.True(t, b.Wait(1*time.Second), "Should get a token by waiting for it")
+	assert.True(t, time.Since(start) >= 40*time.Millisecond, "Should have waited for the token")
+
+	var nilBudget *Budget
+	assert.True(t, nilBudget.Wait(0), "Nil budget should be unlimited")
+	assert.True(t, nilBudget.Available(0), "Nil budget should be unlimited")
+}
+
+func TestWithBudget(t *testing.T) {
+	r := newHeaderRecorder()
+	defer r.Close()
+	b := NewBudget(0.01, 2)
+	u := newRecordedUtil(t, r, WithAPIKey("user@example.com", "key"), WithBudget(b, 10*time.Millisecond))
+	other := newRecordedUtil(t, r, WithAPIKey("user@example.com", "key"), WithBudget(b, 10*time.Millisecond))
+	assert.NoError(t, u.v4Request("GET", "/user", nil, nil))
+	assert.NoError(t, other.v4Request("GET", "/user", nil, nil))
+
+	err := u.v4Request("GET", "/user", nil, nil)
+	assert.True(t, IsBudgetExhausted(err), "Request beyond the shared budget should fail with ErrBudgetExhausted, not %v", err)
+	assert.Equal(t, 2, r.count(), "Request without budget shouldn't have been sent")
+	assert.False(t, IsBudgetExhausted(nil))
+}
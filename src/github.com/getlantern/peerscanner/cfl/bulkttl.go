@@ -0,0 +1,74 @@
+package cfl
+
+import (
+	"fmt"
+	"net/url"
+)
+
+const (
+	// bulkUpdateBatchSize is how many records BulkUpdateTTL updates with
+	// a single request
+	bulkUpdateBatchSize = 50
+)
+
+// recordPatch is an update of a single record in a batch request
+type recordPatch struct {
+	Id  string `json:"id"`
+	Ttl int    `json:"ttl"`
+}
+
+// recordBatch is the body of a batch request
+type recordBatch struct {
+	Patches []recordPatch `json:"patches"`
+}
+
+// BulkUpdateTTL sets the TTL of all records with the given names (relative to
+// our zone) to ttl. Rather than updating records one at a time, it updates
+// them bulkUpdateBatchSize at a time with CloudFlare's batch endpoint, and
+// skips records that already have the ttl. Batches that were sent before one
+// failed stay applied.
+func (util *Util) BulkUpdateTTL(names []string, ttl int) error {
+	if !IsValidTtl(ttl) {
+		return fmt.Errorf("Unsupported ttl %d", ttl)
+	}
+	zone, err := util.zoneId()
+	if err != nil {
+		return err
+	}
+
+	var patches []recordPatch
+	seen := make(map[string]bool)
+	for _, name := range names {
+		recs, err := util.listDnsRecords(url.Values{"name": {util.fullName(name)}})
+		if err != nil {
+			return fmt.Errorf("Unable to look up records for %v: %v", name, err)
+		}
+		for _, r := range recs {
+			if r.Ttl == ttl || seen[r.Id] {
+				continue
+			}
+			seen[r.Id] = true
+			patches = append(patches, recordPatch{Id: r.Id, Ttl: ttl})
+		}
+	}
+	if util.DryRun {
+		log.Debugf("Dry run, not updating ttl of %d records to %d", len(patches), ttl)
+		return nil
+	}
+
+	updated := 0
+	for len(patches) > 0 {
+		n := len(patches)
+		if n > bulkUpdateBatchSize {
+			n = bulkUpdateBatchSize
+		}
+		err := util.v4Request("POST", fmt.Sprintf("/zones/%v/dns_records/batch", zone), &recordBatch{Patches: patches[:n]}, nil)
+		if err != nil {
+			return fmt.Errorf("Unable to update ttl of records to %d after updating %d: %v", ttl, updated, err)
+		}
+		updated += n
+		patches = patches[n:]
+	}
+	log.Debugf("Updated ttl of %d records with %d names to %d", updated, len(names), ttl)
+	return nil
+}
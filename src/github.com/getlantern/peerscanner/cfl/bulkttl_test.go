@@ -0,0 +1,73 @@
+package cfl
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestBulkUpdateTTL(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+	path := "/zones/" + fakeZoneId + "/dns_records"
+	f.handle("GET", path, func(body []byte) (int, interface{}) {
+		recs := []dnsRecord{{Id: "current", Type: "A", Name: "roundrobin.example.com", Content: "10.0.1.0", Ttl: 300}}
+		for i := 0; i < 150; i++ {
+			recs = append(recs, dnsRecord{Id: fmt.Sprintf("rec%d", i), Type: "A", Name: "roundrobin.example.com", Content: fmt.Sprintf("10.0.0.%d", i), Ttl: 3600})
+		}
+		return 200, recs
+	})
+	var batches []recordBatch
+	f.handle("POST", path+"/batch", func(body []byte) (int, interface{}) {
+		var batch recordBatch
+		json.Unmarshal(body, &batch)
+		batches = append(batches, batch)
+		return 200, map[string]interface{}{}
+	})
+
+	assert.Error(t, f.util.BulkUpdateTTL([]string{"roundrobin"}, 301), "Unsupported ttl should be rejected")
+	if !assert.NoError(t, f.util.BulkUpdateTTL([]string{"roundrobin"}, 300)) {
+		return
+	}
+	assert.Equal(t, "roundrobin.example.com", f.query("GET", path).Get("name"), "Records should be looked up by name")
+	if assert.Len(t, batches, 3, "150 records should be updated in 3 batches") {
+		total := 0
+		for _, batch := range batches {
+			assert.Len(t, batch.Patches, bulkUpdateBatchSize)
+			for _, p := range batch.Patches {
+				assert.Equal(t, 300, p.Ttl)
+				assert.NotEqual(t, "current", p.Id, "Record that already has the ttl shouldn't be updated")
+			}
+			total += len(batch.Patches)
+		}
+		assert.Equal(t, 150, total)
+	}
+}
+
+func TestBulkUpdateTTLFails(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+	path := "/zones/" + fakeZoneId + "/dns_records"
+	f.handle("GET", path, func(body []byte) (int, interface{}) {
+		recs := make([]dnsRecord, 0, 60)
+		for i := 0; i < 60; i++ {
+			recs = append(recs, dnsRecord{Id: fmt.Sprintf("rec%d", i), Type: "A", Name: "roundrobin.example.com", Ttl: 3600})
+		}
+		return 200, recs
+	})
+	batches := 0
+	f.handle("POST", path+"/batch", func(body []byte) (int, interface{}) {
+		batches++
+		if batches > 1 {
+			return 500, nil
+		}
+		return 200, map[string]interface{}{}
+	})
+
+	err := f.util.BulkUpdateTTL([]string{"roundrobin"}, 300)
+	if assert.Error(t, err, "Failing batch should fail the update") {
+		assert.Contains(t, err.Error(), "after updating 50")
+	}
+}
@@ -6,22 +6,104 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/getlantern/cloudflare"
 	"github.com/getlantern/golog"
 )
 
+const (
+	// AutoTtl tells CloudFlare to pick the TTL automatically
+	AutoTtl = 1
+
+	// defaultMaxRetryAfter is the longest we wait when CloudFlare's
+	// Retry-After asks us to, unless configured otherwise
+	defaultMaxRetryAfter = 60 * time.Second
+)
+
 var (
 	log = golog.LoggerFor("cfl")
+
+	// allowedTtls are the TTLs that CloudFlare accepts, besides AutoTtl
+	allowedTtls = []int{120, 300, 600, 900, 1800, 3600, 7200, 18000, 43200, 86400}
 )
 
 type Util struct {
 	Client *cloudflare.Client
+	// V4URL is the base URL of CloudFlare's v4 API
+	V4URL string
+	// Tags, if set, are attached to every record we create (see
+	// FilterTagged)
+	Tags map[string]string
+	// RecordComment, if set, is included in the comment of every record we
+	// create, e.g. to tell which instance created it
+	RecordComment string
+	// DryRun, if set, makes us log changes instead of making them
+	DryRun bool
+	// Lock is held while SyncGroup changes records, so that instances sharing
+	// our zone don't step on each other. It defaults to a no-op lock.
+	Lock   Locker
 	domain string
+
+	apiToken       string
+	hasCredentials bool
+	budget         *Budget
+	budgetWait     time.Duration
+	tracerProvider TracerProvider
+	maxRetryAfter  time.Duration
+	withTelemetry  bool
+	telemetry      *telemetryTransport
+	credentials    CredentialSource
+
+	cachedZoneId string
+	zoneIdMutex  sync.Mutex
+}
+
+// New creates a Util for the given domain, configured with opts. It needs
+// either WithAPIKey or WithAPIToken.
+func New(domain string, opts ...Option) (*Util, error) {
+	util := newUtil(domain, "", "")
+	for _, opt := range opts {
+		if err := opt(util); err != nil {
+			return nil, err
+		}
+	}
+	if !util.hasCredentials {
+		return nil, fmt.Errorf("No CloudFlare credentials, use WithAPIKey or WithAPIToken")
+	}
+	if util.budget != nil || util.tracerProvider != nil || util.withTelemetry || util.credentials != nil {
+		// Copy the client so that we don't affect other users of a client
+		// passed to WithHTTPClient
+		client := *util.Client.Http
+		if util.credentials != nil {
+			// Innermost, so that the credentials aren't traced or recorded
+			client.Transport = newCredentialTransport(client.Transport, util.credentials)
+		}
+		if util.tracerProvider != nil {
+			client.Transport = NewTracingTransport(client.Transport, util.tracerProvider)
+		}
+		if util.withTelemetry {
+			// Inside of the budget, so that calls don't seem slow for
+			// waiting their turn
+			util.telemetry = newTelemetryTransport(client.Transport)
+			client.Transport = util.telemetry
+		}
+		if util.budget != nil {
+			client.Transport = newBudgetTransport(client.Transport, util.budget, util.budgetWait)
+		}
+		util.Client.Http = &client
+	}
+	return util, nil
 }
 
-func New(domain string, username string, apiKey string) *Util {
+// NewLegacy creates a Util that authenticates with username and apiKey, like
+// New used to before it took options.
+func NewLegacy(domain string, username string, apiKey string) *Util {
+	return newUtil(domain, username, apiKey)
+}
+
+func newUtil(domain string, username string, apiKey string) *Util {
 	client := cloudflare.NewClient(username, apiKey)
 	// Set a longish timeout on the HTTP client just in case
 	client.Http = &http.Client{
@@ -32,26 +114,17 @@ func New(domain string, username string, apiKey string) *Util {
 			},
 		},
 	}
-	return &Util{client, domain}
+	return &Util{Client: client, V4URL: defaultV4URL, Lock: noopLock{}, domain: domain, maxRetryAfter: defaultMaxRetryAfter}
 }
 
+// GetAllRecords lists all records in our zone. Like all of cfl, it uses the
+// v4 API, so the records' ids are v4 ids.
 func (util *Util) GetAllRecords() ([]cloudflare.Record, error) {
-	resp, err := util.Client.LoadAll(util.domain)
+	recs, err := util.listRecords(nil)
 	if err != nil {
 		return nil, fmt.Errorf("Error retrieving Cloudflare records: %v", err)
 	}
-
-	allRecords := resp.Response.Recs.Records
-	for resp.Response.Recs.HasMore {
-		ix := len(allRecords)
-		resp, err = util.Client.LoadAllAtIndex(util.domain, ix)
-		if err != nil {
-			return nil, fmt.Errorf("Error retrieving records at index %d: %v", ix, err)
-		}
-		allRecords = append(allRecords, resp.Response.Recs.Records...)
-	}
-
-	return allRecords, nil
+	return recs, nil
 }
 
 // Register ensures that a record with the given name and ip is registered and
@@ -59,44 +132,56 @@ func (util *Util) GetAllRecords() ([]cloudflare.Record, error) {
 // in, in which case the record is assumed to be registered and we enable the
 // orange cloud for the existing record.
 // EnsureRegistered returns:
-//  - the record if registration was successful
-//  - true of it was able to turn on proxying
-//  - any error encountered
+//   - the record if registration was successful
+//   - true of it was able to turn on proxying
+//   - any error encountered
 func (util *Util) EnsureRegistered(name string, ip string, rec *cloudflare.Record) (*cloudflare.Record, bool, error) {
-	if rec == nil {
-		// Register record
-		var err error
-		cr := cloudflare.CreateRecord{Type: "A", Name: name, Content: ip}
-		rec, err = util.Client.CreateRecord(util.domain, &cr)
+	return util.EnsureRegisteredWithTtl(name, ip, rec, 0)
+}
 
+// EnsureRegisteredWithTtl is like EnsureRegistered but creates the record with
+// the given ttl, which must be one of CloudFlare's allowed TTLs (see
+// IsValidTtl). A ttl of 0 leaves it up to CloudFlare.
+func (util *Util) EnsureRegisteredWithTtl(name string, ip string, rec *cloudflare.Record, ttl int) (*cloudflare.Record, bool, error) {
+	if util.DryRun {
+		log.Debugf("Dry run, not registering %v (%v)", name, ip)
+		if rec == nil {
+			rec = &cloudflare.Record{Type: "A", Name: name, Value: ip}
+		}
+		return rec, true, nil
+	}
+	if rec == nil {
+		// Register record, commenting it so that we can tell who created it
+		zone, err := util.zoneId()
 		if err != nil {
-			if !isDuplicateRecord(err) {
-				return nil, false, err
-			}
-			log.Debugf("%v (%v) already registered, looking up existing record", name, ip)
-			// Note - this is pretty heavyweight since it fetches all
-			// records, but this condition should rarely be hit anyway
-			all, err := util.GetAllRecords()
-			if err != nil {
-				return nil, false, err
-			}
-			for _, r := range all {
-				if r.Name == name && r.Value == ip {
-					rec = &r
-					break
-				}
-			}
-			if rec == nil {
-				return nil, false, fmt.Errorf("Unable to find existing record for %v (%v)!?", name, ip)
-			}
+			return nil, false, err
+		}
+		cr := batchRecord{Type: "A", Name: util.fullName(name), Content: ip, Ttl: AutoTtl, Proxied: true, Comment: util.createdComment()}
+		if ttl != 0 {
+			cr.Ttl = ttl
+		}
+		var created dnsRecord
+		err = util.v4Request("POST", fmt.Sprintf("/zones/%v/dns_records", zone), &cr, &created)
+		if err == nil {
+			r := util.toRecord(created)
+			return &r, true, nil
+		}
+		if !isDuplicateRecord(err) {
+			return nil, false, err
+		}
+		log.Debugf("%v (%v) already registered, looking up existing record", name, ip)
+		rec, err = util.FindRecord(name, ip)
+		if err != nil {
+			return nil, false, err
 		}
 	}
 
-	// Update the record to set the ServiceMode to 1 (orange cloud). For
-	// whatever reason we can't do this on create.
-	// Note for some reason CloudFlare seems to ignore the TTL here.
-	ur := cloudflare.UpdateRecord{Type: "A", Name: name, Content: ip, Ttl: "360", ServiceMode: "1"}
-	err := util.Client.UpdateRecord(util.domain, rec.Id, &ur)
+	// Make sure the existing record is proxying (orange cloud)
+	fields := map[string]interface{}{"proxied": true}
+	if ttl != 0 {
+		fields["ttl"] = ttl
+	}
+	err := util.patchDnsRecord(rec.Id, fields)
 	if err != nil {
 		log.Debugf("Error updating record %v, destroying", rec)
 		err2 := util.DestroyRecord(rec)
@@ -106,14 +191,96 @@ func (util *Util) EnsureRegistered(name string, ip string, rec *cloudflare.Recor
 		}
 		return nil, false, err
 	}
+	setProxied(rec.Id, true)
 
 	return rec, true, nil
 }
 
+// IsProxied indicates whether r is proxied by CloudFlare (orange cloud), in
+// which case it resolves to CloudFlare's edge rather than to r.Value. It only
+// knows about records that came from cfl, others aren't proxied as far as it
+// can tell.
+func IsProxied(r *cloudflare.Record) bool {
+	proxied, _ := proxiedOf(r)
+	return proxied
+}
+
+// IsValidTtl indicates whether CloudFlare accepts ttl for records
+func IsValidTtl(ttl int) bool {
+	if ttl == AutoTtl {
+		return true
+	}
+	for _, allowed := range allowedTtls {
+		if ttl == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// NearestValidTtl returns the TTL that CloudFlare accepts, other than
+// AutoTtl, that's closest to ttl.
+func NearestValidTtl(ttl int) int {
+	nearest := allowedTtls[0]
+	for _, allowed := range allowedTtls {
+		if abs(allowed-ttl) < abs(nearest-ttl) {
+			nearest = allowed
+		}
+	}
+	return nearest
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
+
+// SetRecordTtl changes the TTL of the record with the given id.
+func (util *Util) SetRecordTtl(id string, ttl int) error {
+	if !IsValidTtl(ttl) {
+		return fmt.Errorf("Unsupported ttl %d", ttl)
+	}
+	err := util.patchDnsRecord(id, map[string]interface{}{"ttl": ttl})
+	if err != nil {
+		return fmt.Errorf("Unable to set ttl of record %v to %d: %v", id, ttl, err)
+	}
+	return nil
+}
+
+// FindRecord looks up the existing record with the given name and ip.
+func (util *Util) FindRecord(name string, ip string) (*cloudflare.Record, error) {
+	r, err := util.findDnsRecord(name, ip)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to find existing record for %v (%v): %v", name, ip, err)
+	}
+	rec := util.toRecord(*r)
+	return &rec, nil
+}
+
 func (util *Util) DestroyRecord(r *cloudflare.Record) error {
-	return util.Client.DestroyRecord(util.domain, r.Id)
+	return util.DestroyRecordById(r.Id)
+}
+
+// DestroyRecordById destroys the record with the given id without needing to
+// look it up first.
+func (util *Util) DestroyRecordById(id string) error {
+	if util.DryRun {
+		log.Debugf("Dry run, not destroying record %v", id)
+		return nil
+	}
+	zone, err := util.zoneId()
+	if err != nil {
+		return err
+	}
+	err = util.v4Request("DELETE", fmt.Sprintf("/zones/%v/dns_records/%v", zone, id), nil, nil)
+	if err == nil {
+		forgetProxied(id)
+	}
+	return err
 }
 
 func isDuplicateRecord(err error) bool {
-	return strings.Contains(err.Error(), "The record already exists.")
+	return strings.Contains(err.Error(), "An identical record already exists.")
 }
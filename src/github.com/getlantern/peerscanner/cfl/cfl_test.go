@@ -39,7 +39,13 @@ func TestEnsureRegistered(t *testing.T) {
 	rec, proxying, err := u.EnsureRegistered(name, ip, nil)
 	if assert.NoError(t, err, "Should be able to register with no record") {
 		assert.NotNil(t, rec, "A new record should have been returned")
+		assert.NotEmpty(t, rec.Id, "The new record should have an id")
 		assert.True(t, proxying, "Proxying (orange cloud) should be on")
+
+		found, err := u.FindRecord(name, ip)
+		if assert.NoError(t, err, "Should be able to find registered record") {
+			assert.Equal(t, rec.Id, found.Id, "Found record should have the registered record's id")
+		}
 	}
 
 	// Test with existing record, but not passing it in
@@ -75,9 +81,13 @@ func getUtil() *Util {
 	if cflid == "" || cflkey == "" {
 		log.Fatalf("You need to set CFL_ID and CFL_KEY environment variables (e.g. `source <too-few-secrets>/envvars.bash`)")
 	}
-	u := New("getiantem.org", cflid, cflkey)
-	u.Client.Http.Transport = &http.Transport{
-		DisableKeepAlives: true,
+	u, err := New("getiantem.org", WithAPIKey(cflid, cflkey), WithHTTPClient(&http.Client{
+		Transport: &http.Transport{
+			DisableKeepAlives: true,
+		},
+	}))
+	if err != nil {
+		log.Fatalf("Unable to create util: %v", err)
 	}
 	return u
 }
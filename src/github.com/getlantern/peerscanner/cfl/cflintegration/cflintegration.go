@@ -0,0 +1,120 @@
+// cflintegration exercises the cfl package against a real CloudFlare zone. It
+// creates a test record, checks that it shows up in GetAllRecords, updates
+// its TTL, checks the update and finally deletes it again.
+//
+// This is deliberately not a _test.go file so that it never runs as part of
+// the regular unit tests. Run it by hand against a test zone, e.g.:
+//
+//   CFL_ID=<username> CFL_KEY=<api key> CFL_TEST_DOMAIN=flashlightproxy.com go run cflintegration.go
+//
+// It exits with status 0 on success and 1 on failure.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/getlantern/cloudflare"
+	"github.com/getlantern/golog"
+	"github.com/getlantern/peerscanner/cfl"
+)
+
+const (
+	ip         = "127.0.0.1"
+	updatedTtl = 300
+)
+
+var (
+	log = golog.LoggerFor("cflintegration")
+)
+
+func main() {
+	cflid := os.Getenv("CFL_ID")
+	cflkey := os.Getenv("CFL_KEY")
+	domain := os.Getenv("CFL_TEST_DOMAIN")
+	if cflid == "" || cflkey == "" || domain == "" {
+		log.Fatalf("You need to set CFL_ID, CFL_KEY and CFL_TEST_DOMAIN environment variables (e.g. `source <too-few-secrets>/envvars.bash`)")
+	}
+
+	u, err := cfl.New(domain, cfl.WithAPIKey(cflid, cflkey))
+	if err != nil {
+		log.Fatalf("Unable to create CloudFlare util: %v", err)
+	}
+	name := fmt.Sprintf("cfl-integration-%d", time.Now().UnixNano())
+
+	log.Debugf("Creating %v (%v) in %v", name, ip, domain)
+	rec, _, err := u.EnsureRegistered(name, ip, nil)
+	if err != nil {
+		log.Fatalf("Unable to create record %v: %v", name, err)
+	}
+
+	err = run(u, name, rec)
+	if err != nil {
+		cleanup(u, rec)
+		log.Fatal(err)
+	}
+	log.Debug("cflintegration done.")
+}
+
+func run(u *cfl.Util, name string, rec *cloudflare.Record) error {
+	found, err := findById(u, rec.Id)
+	if err != nil {
+		return err
+	}
+	if found == nil {
+		return fmt.Errorf("Created record %v not returned by GetAllRecords", rec.Id)
+	}
+
+	log.Debugf("Updating ttl of %v to %d", name, updatedTtl)
+	err = u.SetRecordTtl(rec.Id, updatedTtl)
+	if err != nil {
+		return fmt.Errorf("Unable to update record %v: %v", rec.Id, err)
+	}
+	found, err = findById(u, rec.Id)
+	if err != nil {
+		return err
+	}
+	if found == nil {
+		return fmt.Errorf("Updated record %v not returned by GetAllRecords", rec.Id)
+	}
+	if found.Ttl != strconv.Itoa(updatedTtl) {
+		return fmt.Errorf("Updated record %v has ttl %v, expected %d", rec.Id, found.Ttl, updatedTtl)
+	}
+
+	log.Debugf("Deleting %v", name)
+	err = u.DestroyRecordById(rec.Id)
+	if err != nil {
+		return fmt.Errorf("Unable to delete record %v: %v", rec.Id, err)
+	}
+	found, err = findById(u, rec.Id)
+	if err != nil {
+		return err
+	}
+	if found != nil {
+		return fmt.Errorf("Deleted record %v still returned by GetAllRecords", rec.Id)
+	}
+	return nil
+}
+
+func findById(u *cfl.Util, id string) (*cloudflare.Record, error) {
+	recs, err := u.GetAllRecords()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get all records: %v", err)
+	}
+	for _, r := range recs {
+		if r.Id == id {
+			return &r, nil
+		}
+	}
+	return nil, nil
+}
+
+// cleanup makes a best effort to remove the test record after a failure.
+func cleanup(u *cfl.Util, rec *cloudflare.Record) {
+	if err := u.DestroyRecordById(rec.Id); err != nil {
+		log.Errorf("Unable to clean up test record %v: %v", rec.Id, err)
+	}
+}
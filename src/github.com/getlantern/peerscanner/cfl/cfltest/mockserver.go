@@ -0,0 +1,663 @@
+// Package cfltest provides a fake CloudFlare API for testing code that uses
+// cfl, without real credentials:
+//
+//   s := cfltest.NewMockServer("example.com")
+//   s.Start()
+//   defer s.Close()
+//   util, err := s.NewUtil()
+//
+// Records that the code under test creates can then be inspected with
+// FindRecords, and the requests it made with GetRequests.
+package cfltest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/getlantern/cloudflare"
+	"github.com/getlantern/golog"
+	"github.com/getlantern/peerscanner/cfl"
+)
+
+const (
+	// ZoneId is the v4 API's id of the MockServer's zone
+	ZoneId = "mockzone"
+)
+
+var (
+	log = golog.LoggerFor("cfltest")
+)
+
+// MockServer is a fake of CloudFlare's client API that keeps the records of a
+// single zone in memory. It also fakes the parts of the v4 API that cfl uses
+// for the same records. Like in CloudFlare, records have different ids in the
+// client API and in the v4 API, so that mixing them up fails. The
+// MockServer's own methods take and return v4 ids. It is safe for concurrent
+// use.
+type MockServer struct {
+	sync.Mutex
+	domain   string
+	server   *httptest.Server
+	records  map[string]cloudflare.Record
+	comments map[string]string
+	// proxied tells by v4 id which records are proxied (orange cloud)
+	proxied map[string]bool
+	// createdOn is when records were created, if set
+	createdOn map[string]time.Time
+	// dnssec is the status of the zone's DNSSEC
+	dnssec string
+	// pageRules are the zone's page rules
+	pageRules []cfl.PageRule
+	// analytics is what the zone's dashboard analytics add up to
+	analytics cfl.ZoneAnalytics
+	// healthChecks are the zone's health checks by id
+	healthChecks map[string]cfl.HealthCheck
+	// clientIds are the client API's ids of records by v4 id, and v4Ids the
+	// other way around
+	clientIds map[string]string
+	v4Ids     map[string]string
+	nextId    int
+	requests  []recordedRequest
+	// fail, if set, is consulted for every client API request and makes it
+	// fail if it returns true.
+	fail func(params url.Values) bool
+	// v4Fail, if set, is consulted for every v4 API request and makes it fail
+	// if it returns true.
+	v4Fail func(req V4Request) bool
+}
+
+type recordedRequest struct {
+	req  http.Request
+	body []byte
+}
+
+// V4Request is a request that a MockServer received through the v4 API, with
+// its JSON body decoded.
+type V4Request struct {
+	Method string
+	Path   string
+	Body   map[string]interface{}
+}
+
+// NewMockServer creates a MockServer for the zone of the given domain. Call
+// Start to start serving.
+func NewMockServer(domain string) *MockServer {
+	return &MockServer{
+		domain:    domain,
+		records:   make(map[string]cloudflare.Record),
+		comments:  make(map[string]string),
+		proxied:   make(map[string]bool),
+		createdOn: make(map[string]time.Time),
+		clientIds: make(map[string]string),
+		v4Ids:     make(map[string]string),
+		dnssec:    cfl.DNSSECDisabled,
+		nextId:    1,
+	}
+}
+
+// Start starts serving the fake API at the returned server's URL.
+func (m *MockServer) Start() *httptest.Server {
+	m.server = httptest.NewServer(m)
+	return m.server
+}
+
+// Close stops serving.
+func (m *MockServer) Close() {
+	m.server.Close()
+}
+
+// NewUtil creates a cfl.Util for the MockServer's zone that talks to it. It
+// authenticates with a fake API key unless opts specify otherwise. The
+// MockServer needs to have been started.
+func (m *MockServer) NewUtil(opts ...cfl.Option) (*cfl.Util, error) {
+	util, err := cfl.New(m.domain, append([]cfl.Option{cfl.WithAPIKey("testid", "testkey")}, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	util.Client.URL = m.server.URL
+	util.V4URL = m.server.URL + "/client/v4"
+	return util, nil
+}
+
+// AddRecord adds a record directly, without going through the API.
+func (m *MockServer) AddRecord(typ string, name string, value string) cloudflare.Record {
+	m.Lock()
+	defer m.Unlock()
+	r := cloudflare.Record{
+		Id:       m.newRecordIdLocked(),
+		Domain:   m.domain,
+		Name:     name,
+		FullName: name + "." + m.domain,
+		Value:    value,
+		Type:     typ,
+		Ttl:      "1",
+	}
+	m.records[r.Id] = r
+	return r
+}
+
+// newRecordIdLocked allocates the ids of a new record, returning its v4 id.
+// Like CloudFlare's, v4 ids are 32 hex digits and client API ids are numbers.
+func (m *MockServer) newRecordIdLocked() string {
+	id := fmt.Sprintf("%032x", m.nextId)
+	clientId := strconv.Itoa(m.nextId + 1000)
+	m.nextId++
+	m.clientIds[id] = clientId
+	m.v4Ids[clientId] = id
+	return id
+}
+
+// removeRecordLocked removes the record with the given v4 id.
+func (m *MockServer) removeRecordLocked(id string) {
+	delete(m.records, id)
+	delete(m.proxied, id)
+	delete(m.v4Ids, m.clientIds[id])
+	delete(m.clientIds, id)
+}
+
+// clientRecord represents r the way the client API does
+func (m *MockServer) clientRecord(r cloudflare.Record) cloudflare.Record {
+	r.Id = m.clientIds[r.Id]
+	return r
+}
+
+// SetProxied makes the record with the given id proxied (orange cloud) or
+// not.
+func (m *MockServer) SetProxied(id string, proxied bool) {
+	m.Lock()
+	m.proxied[id] = proxied
+	m.Unlock()
+}
+
+// IsProxied indicates whether the record with the given id is proxied.
+func (m *MockServer) IsProxied(id string) bool {
+	m.Lock()
+	defer m.Unlock()
+	return m.proxied[id]
+}
+
+// RemoveRecord removes the record with the given id directly, without going
+// through the API.
+func (m *MockServer) RemoveRecord(id string) {
+	m.Lock()
+	m.removeRecordLocked(id)
+	m.Unlock()
+}
+
+// FindRecords returns all records with the given name (relative to the zone)
+// and, if specified, value.
+func (m *MockServer) FindRecords(name string, value string) []cloudflare.Record {
+	m.Lock()
+	defer m.Unlock()
+	var found []cloudflare.Record
+	for _, r := range m.records {
+		if r.Name == name && (value == "" || r.Value == value) {
+			found = append(found, r)
+		}
+	}
+	return found
+}
+
+// Comment returns the comment of the record with the given id.
+func (m *MockServer) Comment(id string) string {
+	m.Lock()
+	defer m.Unlock()
+	return m.comments[id]
+}
+
+// SetComment sets the comment of the record with the given id.
+func (m *MockServer) SetComment(id string, comment string) {
+	m.Lock()
+	m.comments[id] = comment
+	m.Unlock()
+}
+
+// SetCreatedOn sets when the record with the given id was created, which the
+// v4 API reports as its created_on.
+func (m *MockServer) SetCreatedOn(id string, created time.Time) {
+	m.Lock()
+	m.createdOn[id] = created
+	m.Unlock()
+}
+
+// DNSSECStatus returns the status of the zone's DNSSEC, like
+// cfl.DNSSECDisabled.
+func (m *MockServer) DNSSECStatus() string {
+	m.Lock()
+	defer m.Unlock()
+	return m.dnssec
+}
+
+// SetPageRules replaces the zone's page rules with the given ones.
+func (m *MockServer) SetPageRules(rules ...cfl.PageRule) {
+	m.Lock()
+	m.pageRules = rules
+	m.Unlock()
+}
+
+// SetAnalytics sets what the zone's dashboard analytics add up to, for any
+// period.
+func (m *MockServer) SetAnalytics(analytics cfl.ZoneAnalytics) {
+	m.Lock()
+	m.analytics = analytics
+	m.Unlock()
+}
+
+// HealthChecks returns the zone's health checks.
+func (m *MockServer) HealthChecks() []cfl.HealthCheck {
+	m.Lock()
+	defer m.Unlock()
+	checks := make([]cfl.HealthCheck, 0, len(m.healthChecks))
+	for _, hc := range m.healthChecks {
+		checks = append(checks, hc)
+	}
+	return checks
+}
+
+// SetFail makes client API requests for which fail returns true fail.
+func (m *MockServer) SetFail(fail func(params url.Values) bool) {
+	m.Lock()
+	m.fail = fail
+	m.Unlock()
+}
+
+// SetV4Fail makes v4 API requests for which fail returns true fail with a
+// 500. The request's Path is relative to /client/v4, like in V4Requests.
+func (m *MockServer) SetV4Fail(fail func(req V4Request) bool) {
+	m.Lock()
+	m.v4Fail = fail
+	m.Unlock()
+}
+
+// GetRequests returns copies of all requests received so far, oldest first.
+// Their bodies can be read again.
+func (m *MockServer) GetRequests() []http.Request {
+	m.Lock()
+	defer m.Unlock()
+	reqs := make([]http.Request, 0, len(m.requests))
+	for _, r := range m.requests {
+		req := r.req
+		req.Body = ioutil.NopCloser(bytes.NewReader(r.body))
+		reqs = append(reqs, req)
+	}
+	return reqs
+}
+
+// CountRequests counts the client API requests made with the given action
+// (e.g. "rec_new").
+func (m *MockServer) CountRequests(action string) int {
+	n := 0
+	for _, req := range m.GetRequests() {
+		if !strings.HasPrefix(req.URL.Path, "/client/v4/") && req.URL.Query().Get("a") == action {
+			n++
+		}
+	}
+	return n
+}
+
+// CountRecordRequests counts the v4 API requests made with the given method
+// (e.g. "POST" for creations) for the zone's DNS records.
+func (m *MockServer) CountRecordRequests(method string) int {
+	n := 0
+	for _, req := range m.V4Requests() {
+		if req.Method == method && strings.HasPrefix(req.Path, "/zones/"+ZoneId+"/dns_records") {
+			n++
+		}
+	}
+	return n
+}
+
+// V4Requests returns all requests received through the v4 API, oldest first.
+func (m *MockServer) V4Requests() []V4Request {
+	var v4reqs []V4Request
+	for _, req := range m.GetRequests() {
+		if !strings.HasPrefix(req.URL.Path, "/client/v4/") {
+			continue
+		}
+		var body map[string]interface{}
+		json.NewDecoder(req.Body).Decode(&body)
+		v4reqs = append(v4reqs, V4Request{req.Method, strings.TrimPrefix(req.URL.Path, "/client/v4"), body})
+	}
+	return v4reqs
+}
+
+func (m *MockServer) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	m.Lock()
+	m.requests = append(m.requests, recordedRequest{*req, body})
+	fail := m.fail
+	m.Unlock()
+	if strings.HasPrefix(req.URL.Path, "/client/v4/") {
+		m.serveV4(resp, req, body)
+		return
+	}
+	params := req.URL.Query()
+	if fail != nil && fail(params) {
+		m.respond(resp, map[string]interface{}{"result": "error", "msg": "Simulated failure"})
+		return
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	switch params.Get("a") {
+	case "rec_load_all":
+		recs := make([]cloudflare.Record, 0, len(m.records))
+		for _, r := range m.records {
+			recs = append(recs, m.clientRecord(r))
+		}
+		m.respond(resp, map[string]interface{}{
+			"result": "success",
+			"response": map[string]interface{}{
+				"recs": map[string]interface{}{"has_more": false, "count": len(recs), "objs": recs},
+			},
+		})
+	case "rec_new":
+		for _, r := range m.records {
+			if r.Name == params.Get("name") && r.Value == params.Get("content") && r.Type == params.Get("type") {
+				m.respond(resp, map[string]interface{}{"result": "error", "msg": "The record already exists."})
+				return
+			}
+		}
+		r := cloudflare.Record{
+			Id:       m.newRecordIdLocked(),
+			Domain:   params.Get("z"),
+			Name:     params.Get("name"),
+			FullName: params.Get("name") + "." + params.Get("z"),
+			Value:    params.Get("content"),
+			Type:     params.Get("type"),
+			Ttl:      params.Get("ttl"),
+		}
+		m.records[r.Id] = r
+		m.respondRecord(resp, m.clientRecord(r))
+	case "rec_edit":
+		r, found := m.records[m.v4Ids[params.Get("id")]]
+		if !found {
+			m.respond(resp, map[string]interface{}{"result": "error", "msg": "Record not found"})
+			return
+		}
+		r.Value = params.Get("content")
+		if sm := params.Get("service_mode"); sm != "" {
+			m.proxied[r.Id] = sm == "1"
+		}
+		if ttl := params.Get("ttl"); ttl != "" {
+			r.Ttl = ttl
+		}
+		m.records[r.Id] = r
+		m.respondRecord(resp, m.clientRecord(r))
+	case "rec_delete":
+		r, found := m.records[m.v4Ids[params.Get("id")]]
+		if !found {
+			m.respond(resp, map[string]interface{}{"result": "error", "msg": "Record not found"})
+			return
+		}
+		rec := m.clientRecord(r)
+		m.removeRecordLocked(r.Id)
+		m.respondRecord(resp, rec)
+	default:
+		m.respond(resp, map[string]interface{}{"result": "error", "msg": "Unsupported action"})
+	}
+}
+
+func (m *MockServer) serveV4(resp http.ResponseWriter, req *http.Request, b []byte) {
+	path := strings.TrimPrefix(req.URL.Path, "/client/v4")
+	var body map[string]interface{}
+	json.Unmarshal(b, &body)
+	m.Lock()
+	defer m.Unlock()
+	if m.v4Fail != nil && m.v4Fail(V4Request{req.Method, path, body}) {
+		resp.WriteHeader(500)
+		m.respond(resp, map[string]interface{}{"success": false, "errors": []map[string]interface{}{{"code": 10000, "message": "Simulated failure"}}})
+		return
+	}
+
+	recordsPath := "/zones/" + ZoneId + "/dns_records"
+	switch {
+	case req.Method == "GET" && path == "/zones":
+		m.respondV4(resp, []map[string]string{{"id": ZoneId, "name": m.domain}})
+	case req.Method == "GET" && path == "/zones/"+ZoneId:
+		m.respondV4(resp, map[string]interface{}{"id": ZoneId, "name": m.domain, "plan": map[string]string{"legacy_id": "free"}})
+	case req.Method == "GET" && path == "/zones/"+ZoneId+"/dnssec":
+		m.respondV4(resp, m.v4DNSSEC())
+	case req.Method == "PATCH" && path == "/zones/"+ZoneId+"/dnssec":
+		m.dnssec, _ = body["status"].(string)
+		m.respondV4(resp, m.v4DNSSEC())
+	case req.Method == "GET" && path == "/zones/"+ZoneId+"/pagerules":
+		m.respondV4(resp, m.v4PageRules())
+	case req.Method == "GET" && path == "/zones/"+ZoneId+"/analytics/dashboard":
+		m.respondV4(resp, m.v4Analytics())
+	case req.Method == "GET" && path == "/zones/"+ZoneId+"/healthchecks":
+		checks := make([]map[string]interface{}, 0, len(m.healthChecks))
+		for _, hc := range m.healthChecks {
+			checks = append(checks, m.v4HealthCheck(hc))
+		}
+		m.respondV4(resp, checks)
+	case req.Method == "POST" && path == "/zones/"+ZoneId+"/healthchecks":
+		httpConfig, _ := body["http_config"].(map[string]interface{})
+		port, _ := httpConfig["port"].(float64)
+		hc := cfl.HealthCheck{Id: "hc" + strconv.Itoa(m.nextId), Port: int(port), Status: "unknown"}
+		hc.Name, _ = body["name"].(string)
+		hc.Address, _ = body["address"].(string)
+		m.nextId++
+		if m.healthChecks == nil {
+			m.healthChecks = make(map[string]cfl.HealthCheck)
+		}
+		m.healthChecks[hc.Id] = hc
+		m.respondV4(resp, m.v4HealthCheck(hc))
+	case req.Method == "DELETE" && strings.HasPrefix(path, "/zones/"+ZoneId+"/healthchecks/"):
+		id := strings.TrimPrefix(path, "/zones/"+ZoneId+"/healthchecks/")
+		delete(m.healthChecks, id)
+		m.respondV4(resp, map[string]string{"id": id})
+	case req.Method == "GET" && path == recordsPath:
+		q := req.URL.Query()
+		recs := make([]map[string]interface{}, 0)
+		for _, r := range m.records {
+			if (q.Get("type") == "" || q.Get("type") == r.Type) &&
+				(q.Get("name") == "" || q.Get("name") == r.FullName) &&
+				(q.Get("content") == "" || q.Get("content") == r.Value) {
+				recs = append(recs, m.v4Record(r))
+			}
+		}
+		resp.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(resp).Encode(map[string]interface{}{
+			"success":     true,
+			"result":      recs,
+			"result_info": map[string]interface{}{"page": 1, "total_pages": 1, "count": len(recs), "total_count": len(recs)},
+		})
+	case req.Method == "POST" && path == recordsPath:
+		typ, _ := body["type"].(string)
+		fullName, _ := body["name"].(string)
+		value, _ := body["content"].(string)
+		if typ == "CNAME" {
+			for _, r := range m.records {
+				if r.FullName == fullName {
+					// Like CloudFlare, don't allow CNAMEs next to other records
+					resp.WriteHeader(400)
+					m.respond(resp, map[string]interface{}{"success": false, "errors": []map[string]interface{}{{"code": 81053, "message": "An A, AAAA, or CNAME record with that host already exists."}}})
+					return
+				}
+			}
+		}
+		if typ == "SRV" {
+			data, _ := body["data"].(map[string]interface{})
+			value = fmt.Sprintf("%v %v %v %v", data["priority"], data["weight"], data["port"], data["target"])
+		}
+		for _, r := range m.records {
+			if r.FullName == fullName && r.Value == value && r.Type == typ {
+				resp.WriteHeader(400)
+				m.respond(resp, map[string]interface{}{"success": false, "errors": []map[string]interface{}{{"code": 81058, "message": "An identical record already exists."}}})
+				return
+			}
+		}
+		r := cloudflare.Record{
+			Id:       m.newRecordIdLocked(),
+			Domain:   m.domain,
+			Name:     strings.TrimSuffix(fullName, "."+m.domain),
+			FullName: fullName,
+			Value:    value,
+			Type:     typ,
+			Ttl:      "1",
+		}
+		if ttl, ok := body["ttl"].(float64); ok {
+			r.Ttl = strconv.Itoa(int(ttl))
+		}
+		if proxied, _ := body["proxied"].(bool); proxied {
+			m.proxied[r.Id] = true
+		}
+		if comment, ok := body["comment"].(string); ok {
+			m.comments[r.Id] = comment
+		}
+		m.records[r.Id] = r
+		m.respondV4(resp, m.v4Record(r))
+	case req.Method == "GET" && strings.HasPrefix(path, recordsPath+"/"):
+		r, found := m.records[strings.TrimPrefix(path, recordsPath+"/")]
+		if !found {
+			resp.WriteHeader(404)
+			m.respond(resp, map[string]interface{}{"success": false, "errors": []map[string]interface{}{{"code": 81044, "message": "Record not found"}}})
+			return
+		}
+		m.respondV4(resp, m.v4Record(r))
+	case req.Method == "DELETE" && strings.HasPrefix(path, recordsPath+"/"):
+		id := strings.TrimPrefix(path, recordsPath+"/")
+		if _, found := m.records[id]; !found {
+			resp.WriteHeader(404)
+			m.respond(resp, map[string]interface{}{"success": false, "errors": []map[string]interface{}{{"code": 81044, "message": "Record not found"}}})
+			return
+		}
+		m.removeRecordLocked(id)
+		m.respondV4(resp, map[string]string{"id": id})
+	case req.Method == "PATCH" && strings.HasPrefix(path, recordsPath+"/"):
+		id := strings.TrimPrefix(path, recordsPath+"/")
+		r, found := m.records[id]
+		if !found {
+			resp.WriteHeader(404)
+			m.respond(resp, map[string]interface{}{"success": false, "errors": []map[string]interface{}{{"code": 81044, "message": "Record not found"}}})
+			return
+		}
+		if comment, ok := body["comment"].(string); ok {
+			m.comments[id] = comment
+		}
+		if ttl, ok := body["ttl"].(float64); ok {
+			r.Ttl = strconv.Itoa(int(ttl))
+		}
+		if proxied, ok := body["proxied"].(bool); ok {
+			m.proxied[id] = proxied
+		}
+		m.records[id] = r
+		m.respondV4(resp, m.v4Record(r))
+	default:
+		resp.WriteHeader(404)
+		m.respond(resp, map[string]interface{}{"success": false, "errors": []map[string]interface{}{{"code": 7003, "message": "No route for " + req.Method + " " + path}}})
+	}
+}
+
+// v4Record represents r the way the v4 API does
+func (m *MockServer) v4Record(r cloudflare.Record) map[string]interface{} {
+	ttl, _ := strconv.Atoi(r.Ttl)
+	rec := map[string]interface{}{
+		"id":      r.Id,
+		"type":    r.Type,
+		"name":    r.FullName,
+		"content": r.Value,
+		"ttl":     ttl,
+		"proxied": m.proxied[r.Id],
+		"comment": m.comments[r.Id],
+	}
+	if created, found := m.createdOn[r.Id]; found {
+		rec["created_on"] = created.Format(time.RFC3339)
+	}
+	return rec
+}
+
+// v4DNSSEC represents the zone's DNSSEC the way the v4 API does
+func (m *MockServer) v4DNSSEC() map[string]interface{} {
+	if m.dnssec == cfl.DNSSECDisabled {
+		return map[string]interface{}{"status": m.dnssec, "flags": nil, "algorithm": nil, "key_tag": nil, "ds": nil}
+	}
+	return map[string]interface{}{
+		"status":      m.dnssec,
+		"flags":       257,
+		"algorithm":   "13",
+		"key_type":    "ECDSAP256SHA256",
+		"digest_type": "2",
+		"digest":      "48E939042E82C22542CB377B580DFDC52A361CEFDC72E7F9107E2B6BD9306A45",
+		"ds":          m.domain + ". 3600 IN DS 2371 13 2 48E939042E82C22542CB377B580DFDC52A361CEFDC72E7F9107E2B6BD9306A45",
+		"key_tag":     2371,
+		"public_key":  "mdsswUyr3DPW132mOi8V9xESWE8jTo0dxCjjnopKl+GqJxpVXckHAeF+KkxLbxILfDLUT0rAK9iUzy1L53eKGQ==",
+	}
+}
+
+// v4PageRules represents the zone's page rules the way the v4 API does
+func (m *MockServer) v4PageRules() []map[string]interface{} {
+	rules := make([]map[string]interface{}, 0, len(m.pageRules))
+	for _, r := range m.pageRules {
+		targets := make([]map[string]interface{}, 0, len(r.Targets))
+		for _, t := range r.Targets {
+			targets = append(targets, map[string]interface{}{
+				"target":     "url",
+				"constraint": map[string]string{"operator": "matches", "value": t},
+			})
+		}
+		rules = append(rules, map[string]interface{}{
+			"id":       r.Id,
+			"targets":  targets,
+			"actions":  r.Actions,
+			"priority": r.Priority,
+			"status":   r.Status,
+		})
+	}
+	return rules
+}
+
+// v4Analytics represents the zone's analytics the way the dashboard API does,
+// giving the top countries decreasing numbers of requests
+func (m *MockServer) v4Analytics() map[string]interface{} {
+	countries := make(map[string]int64, len(m.analytics.TopCountryCodes))
+	for i, code := range m.analytics.TopCountryCodes {
+		countries[code] = int64(len(m.analytics.TopCountryCodes) - i)
+	}
+	return map[string]interface{}{
+		"totals": map[string]interface{}{
+			"requests": map[string]interface{}{"all": m.analytics.QueryCount, "country": countries},
+			"uniques":  map[string]interface{}{"all": m.analytics.UniqueIPs},
+		},
+	}
+}
+
+// v4HealthCheck represents hc the way the v4 API does
+func (m *MockServer) v4HealthCheck(hc cfl.HealthCheck) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          hc.Id,
+		"name":        hc.Name,
+		"address":     hc.Address,
+		"type":        "HTTP",
+		"http_config": map[string]interface{}{"method": "GET", "path": "/", "port": hc.Port},
+		"status":      hc.Status,
+	}
+}
+
+func (m *MockServer) respondV4(resp http.ResponseWriter, result interface{}) {
+	m.respond(resp, map[string]interface{}{"success": true, "result": result})
+}
+
+func (m *MockServer) respondRecord(resp http.ResponseWriter, r cloudflare.Record) {
+	m.respond(resp, map[string]interface{}{
+		"result":   "success",
+		"response": map[string]interface{}{"rec": map[string]interface{}{"obj": r}},
+	})
+}
+
+func (m *MockServer) respond(resp http.ResponseWriter, body interface{}) {
+	resp.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(resp).Encode(body); err != nil {
+		log.Errorf("Unable to write mock response: %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package cfltest
+
+import (
+	"testing"
+
+	"github.com/getlantern/peerscanner/cfl"
+	"github.com/getlantern/testify/assert"
+)
+
+func TestMockServer(t *testing.T) {
+	s := NewMockServer("example.com")
+	s.Start()
+	defer s.Close()
+	util, err := s.NewUtil()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	r := s.AddRecord("A", "fl-us-mock", "45.63.9.1")
+	recs, err := util.GetAllRecords()
+	if assert.NoError(t, err) && assert.Len(t, recs, 1) {
+		assert.Equal(t, r.Id, recs[0].Id)
+	}
+
+	rec, _, err := util.EnsureRegistered("roundrobin", "45.63.9.1", nil)
+	if assert.NoError(t, err) {
+		assert.Len(t, s.FindRecords("roundrobin", "45.63.9.1"), 1, "Record should have been created")
+		assert.True(t, s.IsProxied(rec.Id), "Record should have been created proxied")
+		assert.True(t, cfl.IsProxied(rec), "Registered record should be known to be proxied")
+	}
+
+	s.SetProxied(r.Id, true)
+	recs, err = util.GetAllRecords()
+	if assert.NoError(t, err) {
+		for _, rec := range recs {
+			assert.True(t, cfl.IsProxied(&rec), "Listed record %v should be proxied", rec.Name)
+		}
+	}
+	assert.Equal(t, 1, s.CountRecordRequests("POST"))
+	assert.NotEmpty(t, s.GetRequests())
+
+	s.RemoveRecord(r.Id)
+	assert.Len(t, s.FindRecords("fl-us-mock", ""), 0, "Record should have been removed")
+}
+
+func TestMockServerSeparatesIds(t *testing.T) {
+	s := NewMockServer("example.com")
+	s.Start()
+	defer s.Close()
+	util, err := s.NewUtil()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	r := s.AddRecord("A", "fl-us-mock", "45.63.9.1")
+	resp, err := util.Client.LoadAll("example.com")
+	if !assert.NoError(t, err) || !assert.Len(t, resp.Response.Recs.Records, 1) {
+		return
+	}
+	clientId := resp.Response.Recs.Records[0].Id
+	assert.NotEqual(t, r.Id, clientId, "Client API and v4 API ids should differ")
+
+	assert.Error(t, util.Client.DestroyRecord("example.com", r.Id), "Client API shouldn't know v4 ids")
+	assert.Error(t, util.DestroyRecordById(clientId), "v4 API shouldn't know client API ids")
+	assert.Len(t, s.FindRecords("fl-us-mock", ""), 1, "Record shouldn't have been removed by the wrong id")
+	assert.NoError(t, util.Client.DestroyRecord("example.com", clientId))
+	assert.Len(t, s.FindRecords("fl-us-mock", ""), 0, "Record should have been removed by its client API id")
+}
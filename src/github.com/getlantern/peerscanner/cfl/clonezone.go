@@ -0,0 +1,80 @@
+package cfl
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// CloneZone copies the A records of our zone to the zone of targetDomain,
+// which needs to be in the same CloudFlare account, so that we can switch to
+// it quickly if our domain is lost. Other records, like NS and SOA, belong to
+// each zone and aren't copied. Records that the target zone already has are
+// left alone, so CloneZone can be called repeatedly to keep it up to date. It
+// returns how many records it created.
+func (util *Util) CloneZone(targetDomain string) (int, error) {
+	if targetDomain == util.domain {
+		return 0, fmt.Errorf("Can't clone %v to itself", util.domain)
+	}
+	target := util.forDomain(targetDomain)
+	targetZone, err := target.zoneId()
+	if err != nil {
+		return 0, fmt.Errorf("Unable to find target zone %v: %v", targetDomain, err)
+	}
+	recs, err := util.listDnsRecords(url.Values{"type": {"A"}})
+	if err != nil {
+		return 0, err
+	}
+	existingRecs, err := target.listDnsRecords(url.Values{"type": {"A"}})
+	if err != nil {
+		return 0, err
+	}
+	existing := make(map[string]bool, len(existingRecs))
+	for _, r := range existingRecs {
+		existing[target.relativeName(r.Name)+" "+r.Content] = true
+	}
+
+	created, failed := 0, 0
+	for _, r := range recs {
+		name := util.relativeName(r.Name)
+		if existing[name+" "+r.Content] {
+			continue
+		}
+		if util.DryRun {
+			log.Debugf("Dry run, not cloning %v (%v) to %v", name, r.Content, targetDomain)
+			continue
+		}
+		rec := batchRecord{Type: r.Type, Name: target.fullName(name), Content: r.Content, Ttl: r.Ttl, Proxied: r.Proxied, Comment: util.recordComment()}
+		err := target.v4Request("POST", fmt.Sprintf("/zones/%v/dns_records", targetZone), &rec, nil)
+		if err != nil {
+			log.Debugf("Unable to clone %v (%v) to %v: %v", name, r.Content, targetDomain, err)
+			failed++
+			continue
+		}
+		created++
+	}
+	if failed > 0 {
+		return created, fmt.Errorf("Unable to clone %d of %d records to %v", failed, failed+created, targetDomain)
+	}
+	log.Debugf("Cloned %d of %d records to %v", created, len(recs), targetDomain)
+	return created, nil
+}
+
+// forDomain returns a Util for the zone of the given domain that uses the same
+// credentials and settings as util.
+func (util *Util) forDomain(domain string) *Util {
+	return &Util{
+		Client:         util.Client,
+		V4URL:          util.V4URL,
+		Tags:           util.Tags,
+		RecordComment:  util.RecordComment,
+		DryRun:         util.DryRun,
+		Lock:           util.Lock,
+		domain:         domain,
+		apiToken:       util.apiToken,
+		hasCredentials: util.hasCredentials,
+		budget:         util.budget,
+		budgetWait:     util.budgetWait,
+		tracerProvider: util.tracerProvider,
+		maxRetryAfter:  util.maxRetryAfter,
+	}
+}
@@ -0,0 +1,86 @@
+package cfl
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+const (
+	backupZoneId = "backupzone"
+)
+
+func TestCloneZone(t *testing.T) {
+	f := newFakeV4("getiantem.org")
+	defer f.Close()
+	f.handle("GET", "/zones", func(body []byte) (int, interface{}) {
+		return 200, []map[string]string{{"id": fakeZoneId, "name": "getiantem.org"}, {"id": backupZoneId, "name": "backup.org"}}
+	})
+	f.handle("GET", "/zones/"+fakeZoneId+"/dns_records", func(body []byte) (int, interface{}) {
+		return 200, []dnsRecord{
+			{Id: "1", Type: "A", Name: "fl-us-1.getiantem.org", Content: "10.0.0.1", Ttl: 300},
+			{Id: "2", Type: "A", Name: "roundrobin.getiantem.org", Content: "10.0.0.1", Ttl: 120, Proxied: true},
+			{Id: "3", Type: "A", Name: "roundrobin.getiantem.org", Content: "10.0.0.2", Ttl: 120, Proxied: true},
+			{Id: "4", Type: "A", Name: "getiantem.org", Content: "10.0.0.9", Ttl: 1},
+		}
+	})
+	// The backup zone already has one of them
+	f.handle("GET", "/zones/"+backupZoneId+"/dns_records", func(body []byte) (int, interface{}) {
+		return 200, []dnsRecord{{Id: "b1", Type: "A", Name: "roundrobin.backup.org", Content: "10.0.0.2", Ttl: 120, Proxied: true}}
+	})
+	var created []batchRecord
+	f.handle("POST", "/zones/"+backupZoneId+"/dns_records", func(body []byte) (int, interface{}) {
+		var rec batchRecord
+		json.Unmarshal(body, &rec)
+		created = append(created, rec)
+		return 200, dnsRecord{Id: "new", Type: rec.Type, Name: rec.Name, Content: rec.Content}
+	})
+
+	n, err := f.util.CloneZone("backup.org")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 3, n)
+	assert.Equal(t, []batchRecord{
+		{Type: "A", Name: "fl-us-1.backup.org", Content: "10.0.0.1", Ttl: 300},
+		{Type: "A", Name: "roundrobin.backup.org", Content: "10.0.0.1", Ttl: 120, Proxied: true},
+		{Type: "A", Name: "backup.org", Content: "10.0.0.9", Ttl: 1},
+	}, created, "All records missing from the backup zone should have been copied")
+	assert.Equal(t, "A", f.query("GET", "/zones/"+fakeZoneId+"/dns_records").Get("type"), "Only A records should be cloned")
+	assert.False(t, f.requested("POST", "/zones/"+fakeZoneId+"/dns_records"), "Nothing should be created in the source zone")
+}
+
+func TestCloneZoneFailures(t *testing.T) {
+	f := newFakeV4("getiantem.org")
+	defer f.Close()
+
+	_, err := f.util.CloneZone("getiantem.org")
+	assert.Error(t, err, "Cloning a zone to itself should fail")
+	_, err = f.util.CloneZone("backup.org")
+	assert.Error(t, err, "Cloning to a zone that doesn't exist should fail")
+
+	f.handle("GET", "/zones", func(body []byte) (int, interface{}) {
+		return 200, []map[string]string{{"id": fakeZoneId, "name": "getiantem.org"}, {"id": backupZoneId, "name": "backup.org"}}
+	})
+	f.handle("GET", "/zones/"+fakeZoneId+"/dns_records", func(body []byte) (int, interface{}) {
+		return 200, []dnsRecord{
+			{Id: "1", Type: "A", Name: "fl-us-1.getiantem.org", Content: "10.0.0.1"},
+			{Id: "2", Type: "A", Name: "fl-us-2.getiantem.org", Content: "10.0.0.2"},
+		}
+	})
+	f.handle("GET", "/zones/"+backupZoneId+"/dns_records", func(body []byte) (int, interface{}) {
+		return 200, []dnsRecord{}
+	})
+	f.handle("POST", "/zones/"+backupZoneId+"/dns_records", func(body []byte) (int, interface{}) {
+		var rec batchRecord
+		json.Unmarshal(body, &rec)
+		if rec.Content == "10.0.0.2" {
+			return 500, nil
+		}
+		return 200, dnsRecord{Id: "new"}
+	})
+	n, err := f.util.CloneZone("backup.org")
+	assert.Error(t, err, "Failed creates should be reported")
+	assert.Equal(t, 1, n, "Records that were cloned should still be counted")
+}
@@ -0,0 +1,61 @@
+package cfl
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+type cnameRecord struct {
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	Ttl     int    `json:"ttl"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// CreateCNAMERecord creates a CNAME record with the given name (relative to
+// our zone) that aliases target, a fully qualified name like
+// roundrobin.getiantem.org. It succeeds if the record already exists.
+func (util *Util) CreateCNAMERecord(name string, target string) error {
+	zone, err := util.zoneId()
+	if err != nil {
+		return err
+	}
+	rec := cnameRecord{
+		Type:    "CNAME",
+		Name:    util.fullName(name),
+		Content: target,
+		Ttl:     AutoTtl,
+	}
+	rec.Comment = util.recordComment()
+	err = util.v4Request("POST", fmt.Sprintf("/zones/%v/dns_records", zone), &rec, nil)
+	if err != nil {
+		if _, ok := err.(*APIError); ok && strings.Contains(strings.ToLower(err.Error()), "already exists") {
+			log.Debugf("CNAME record %v already exists", name)
+			return nil
+		}
+		return fmt.Errorf("Unable to create CNAME record %v: %v", name, err)
+	}
+	return nil
+}
+
+// DestroyCNAMERecord destroys the CNAME record with the given name (relative
+// to our zone), if any.
+func (util *Util) DestroyCNAMERecord(name string) error {
+	zone, err := util.zoneId()
+	if err != nil {
+		return err
+	}
+	recs, err := util.listDnsRecords(url.Values{"type": {"CNAME"}, "name": {util.fullName(name)}})
+	if err != nil {
+		return err
+	}
+	for _, r := range recs {
+		err := util.v4Request("DELETE", fmt.Sprintf("/zones/%v/dns_records/%v", zone, r.Id), nil, nil)
+		if err != nil && !isNotFound(err) {
+			return fmt.Errorf("Unable to destroy CNAME record %v: %v", name, err)
+		}
+	}
+	return nil
+}
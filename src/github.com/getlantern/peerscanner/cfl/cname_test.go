@@ -0,0 +1,50 @@
+package cfl
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestCreateCNAMERecord(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+
+	var payload map[string]interface{}
+	f.handle("POST", "/zones/"+fakeZoneId+"/dns_records", func(body []byte) (int, interface{}) {
+		json.Unmarshal(body, &payload)
+		return 200, map[string]interface{}{"id": "cname1"}
+	})
+
+	err := f.util.CreateCNAMERecord("roundrobin-alias", "roundrobin.example.com")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "CNAME", payload["type"])
+	assert.Equal(t, "roundrobin-alias.example.com", payload["name"])
+	assert.Equal(t, "roundrobin.example.com", payload["content"])
+	assert.Equal(t, float64(AutoTtl), payload["ttl"])
+
+	f.handle("POST", "/zones/"+fakeZoneId+"/dns_records", func(body []byte) (int, interface{}) {
+		return 400, nil
+	})
+	assert.Error(t, f.util.CreateCNAMERecord("roundrobin-alias", "roundrobin.example.com"), "Failed create should be reported")
+}
+
+func TestDestroyCNAMERecord(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+
+	f.handle("GET", "/zones/"+fakeZoneId+"/dns_records", func(body []byte) (int, interface{}) {
+		return 200, []dnsRecord{{Id: "cname1", Type: "CNAME", Name: "roundrobin-alias.example.com", Content: "roundrobin.example.com"}}
+	})
+	f.handle("DELETE", "/zones/"+fakeZoneId+"/dns_records/cname1", func(body []byte) (int, interface{}) {
+		return 200, map[string]interface{}{"id": "cname1"}
+	})
+	assert.NoError(t, f.util.DestroyCNAMERecord("roundrobin-alias"))
+	assert.True(t, f.requested("DELETE", "/zones/"+fakeZoneId+"/dns_records/cname1"), "CNAME record should have been deleted")
+	q := f.query("GET", "/zones/"+fakeZoneId+"/dns_records")
+	assert.Equal(t, "CNAME", q.Get("type"), "Only CNAME records should be looked up")
+	assert.Equal(t, "roundrobin-alias.example.com", q.Get("name"))
+}
@@ -0,0 +1,51 @@
+package cfl
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// maxCommentLength is the longest comment that CloudFlare takes on all
+	// plans
+	maxCommentLength = 100
+)
+
+// SetRecordComment sets the comment of the record with the given v4 id to the
+// given comment, e.g. what triggered a change to the record, followed by
+// util.recordComment() so that FilterTagged still finds the record's tags.
+// The given comment is cut short to fit the whole in 100 characters.
+func (util *Util) SetRecordComment(id string, comment string) error {
+	err := util.patchDnsRecord(id, map[string]interface{}{"comment": util.annotatedComment(comment)})
+	if err != nil {
+		return fmt.Errorf("Unable to set comment of record %v: %v", id, err)
+	}
+	return nil
+}
+
+// annotatedComment is annotation followed by util.recordComment(), with the
+// annotation cut short to fit in maxCommentLength. util.recordComment() is
+// never cut, since it holds the tags.
+func (util *Util) annotatedComment(annotation string) string {
+	suffix := util.recordComment()
+	if suffix == "" {
+		return truncate(annotation, maxCommentLength)
+	}
+	room := maxCommentLength - len(suffix) - len("; ")
+	if room <= 0 || annotation == "" {
+		return suffix
+	}
+	return truncate(annotation, room) + "; " + suffix
+}
+
+// createdComment is the comment for records that we create now.
+func (util *Util) createdComment() string {
+	return util.annotatedComment("peerscanner created at " + time.Now().UTC().Format(time.RFC3339))
+}
+
+func truncate(s string, length int) string {
+	if len(s) <= length {
+		return s
+	}
+	return s[:length]
+}
@@ -0,0 +1,46 @@
+package cfl
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestSetRecordComment(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+	f.util.RecordComment = "ps-1"
+	f.util.Tags = map[string]string{"env": "staging"}
+	var payload map[string]interface{}
+	f.handle("PATCH", "/zones/"+fakeZoneId+"/dns_records/rec1", func(body []byte) (int, interface{}) {
+		json.Unmarshal(body, &payload)
+		return 200, map[string]interface{}{"id": "rec1"}
+	})
+
+	if assert.NoError(t, f.util.SetRecordComment("rec1", "updated by health check at 2026-10-14T00:00:00Z (score: 0.50)")) {
+		assert.Equal(t, map[string]interface{}{
+			"comment": "updated by health check at 2026-10-14T00:00:00Z (score: 0.50); ps-1; tags: env=staging",
+		}, payload, "Only the comment should be patched, with our comment and tags after it")
+	}
+
+	assert.NoError(t, f.util.SetRecordComment("rec1", strings.Repeat("x", 200)))
+	comment := payload["comment"].(string)
+	assert.Len(t, comment, maxCommentLength, "Comment should be cut short")
+	assert.Equal(t, map[string]string{"env": "staging"}, tagsFromComment(comment), "Tags should survive the cut")
+
+	f.handle("PATCH", "/zones/"+fakeZoneId+"/dns_records/rec1", func(body []byte) (int, interface{}) {
+		return 500, nil
+	})
+	assert.Error(t, f.util.SetRecordComment("rec1", "whatever"))
+}
+
+func TestAnnotatedComment(t *testing.T) {
+	u := &Util{}
+	assert.Equal(t, "created", u.annotatedComment("created"))
+	assert.Len(t, u.annotatedComment(strings.Repeat("x", 200)), maxCommentLength)
+	u.RecordComment = strings.Repeat("y", maxCommentLength)
+	assert.Equal(t, u.RecordComment, u.annotatedComment("created"), "Record comment shouldn't be cut to make room")
+	assert.True(t, strings.HasPrefix((&Util{}).createdComment(), "peerscanner created at "))
+}
@@ -0,0 +1,46 @@
+package cfl
+
+import (
+	"net/http"
+)
+
+// CredentialSource returns the account email and global API key to
+// authenticate with, e.g. from a secret store that rotates them.
+type CredentialSource func() (user string, key string, err error)
+
+// credentialTransport fills in the credentials from source on every request,
+// in the query for the client API and in headers for the v4 API, so that they
+// can change while the Util is in use.
+type credentialTransport struct {
+	rt     http.RoundTripper
+	source CredentialSource
+}
+
+func newCredentialTransport(rt http.RoundTripper, source CredentialSource) *credentialTransport {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &credentialTransport{rt: rt, source: source}
+}
+
+func (t *credentialTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	user, key, err := t.source()
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	// RoundTrippers mustn't modify the request they're given
+	req = req.Clone(req.Context())
+	q := req.URL.Query()
+	if _, clientAPI := q["tkn"]; clientAPI {
+		q.Set("email", user)
+		q.Set("tkn", key)
+		req.URL.RawQuery = q.Encode()
+	} else {
+		req.Header.Set("X-Auth-Email", user)
+		req.Header.Set("X-Auth-Key", key)
+	}
+	return t.rt.RoundTrip(req)
+}
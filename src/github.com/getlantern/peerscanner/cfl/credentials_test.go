@@ -0,0 +1,64 @@
+package cfl
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestWithCredentialSource(t *testing.T) {
+	var mutex sync.Mutex
+	var queries []url.Values
+	var headers []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		queries = append(queries, req.URL.Query())
+		headers = append(headers, req.Header)
+		mutex.Unlock()
+		resp.Header().Set("Content-Type", "application/json")
+		resp.Write([]byte(`{"success": true, "result": {}}`))
+	}))
+	defer server.Close()
+
+	key := "key1"
+	u, err := New("example.com", WithCredentialSource(func() (string, string, error) {
+		return "user@example.com", key, nil
+	}), WithTelemetry())
+	if !assert.NoError(t, err) {
+		return
+	}
+	u.V4URL = server.URL
+	u.Client.URL = server.URL + "/api_json.html"
+
+	assert.NoError(t, u.v4Request("GET", "/zones", nil, nil))
+	key = "key2"
+	u.Client.DestroyRecord("example.com", "rec1")
+	if assert.Len(t, headers, 2) {
+		assert.Equal(t, "user@example.com", headers[0].Get("X-Auth-Email"))
+		assert.Equal(t, "key1", headers[0].Get("X-Auth-Key"), "v4 request should have the key from the source")
+		assert.Equal(t, "user@example.com", queries[1].Get("email"))
+		assert.Equal(t, "key2", queries[1].Get("tkn"), "Client API request should have the key the source has now")
+		assert.Equal(t, "", headers[1].Get("X-Auth-Key"), "Client API request shouldn't get v4 headers")
+	}
+	for _, e := range u.Telemetry() {
+		assert.NotContains(t, e.URL, "key", "Credentials shouldn't be recorded")
+	}
+
+	u, _ = New("example.com", WithCredentialSource(func() (string, string, error) {
+		return "", "", fmt.Errorf("vault is sealed")
+	}))
+	u.V4URL = server.URL
+	err = u.v4Request("GET", "/zones", nil, nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "vault is sealed")
+	}
+	assert.Len(t, headers, 2, "Request without credentials shouldn't be sent")
+
+	_, err = New("example.com", WithCredentialSource(nil))
+	assert.Error(t, err)
+}
@@ -0,0 +1,135 @@
+package cfl
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/getlantern/cloudflare"
+)
+
+const (
+	dnsRecordsPerPage = 1000
+)
+
+var (
+	// proxiedRecords tells, by id, whether the records we've seen through the
+	// v4 API are proxied by CloudFlare (orange cloud), which
+	// cloudflare.Record has no field for
+	proxiedRecords      = make(map[string]bool)
+	proxiedRecordsMutex sync.RWMutex
+)
+
+// dnsRecord is a DNS record as represented by the v4 API, which knows about
+// more fields than the client API (e.g. comments).
+type dnsRecord struct {
+	Id         string `json:"id"`
+	Type       string `json:"type"`
+	Name       string `json:"name"`
+	Content    string `json:"content"`
+	Ttl        int    `json:"ttl"`
+	Proxied    bool   `json:"proxied"`
+	Comment    string `json:"comment"`
+	CreatedOn  string `json:"created_on"`
+	ModifiedOn string `json:"modified_on"`
+}
+
+// toRecord converts r to the client API's representation.
+func (util *Util) toRecord(r dnsRecord) cloudflare.Record {
+	name := r.Name
+	if name != util.domain {
+		name = strings.TrimSuffix(name, "."+util.domain)
+	}
+	setProxied(r.Id, r.Proxied)
+	return cloudflare.Record{
+		Id:       r.Id,
+		Domain:   util.domain,
+		Type:     r.Type,
+		Name:     name,
+		FullName: r.Name,
+		Value:    r.Content,
+		Ttl:      strconv.Itoa(r.Ttl),
+	}
+}
+
+func setProxied(id string, proxied bool) {
+	if id == "" {
+		return
+	}
+	proxiedRecordsMutex.Lock()
+	proxiedRecords[id] = proxied
+	proxiedRecordsMutex.Unlock()
+}
+
+// forgetProxied forgets whether the record with the given id is proxied, once
+// it's gone.
+func forgetProxied(id string) {
+	proxiedRecordsMutex.Lock()
+	delete(proxiedRecords, id)
+	proxiedRecordsMutex.Unlock()
+}
+
+// proxiedOf tells whether r is proxied and whether we know that at all, which
+// we do for records we've listed, fetched or registered.
+func proxiedOf(r *cloudflare.Record) (proxied bool, known bool) {
+	proxiedRecordsMutex.RLock()
+	defer proxiedRecordsMutex.RUnlock()
+	proxied, known = proxiedRecords[r.Id]
+	return
+}
+
+// listDnsRecords lists all records in our zone matching the given query (e.g.
+// type=A), following pagination.
+func (util *Util) listDnsRecords(query url.Values) ([]dnsRecord, error) {
+	zone, err := util.zoneId()
+	if err != nil {
+		return nil, err
+	}
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	q.Set("per_page", strconv.Itoa(dnsRecordsPerPage))
+
+	var all []dnsRecord
+	for page := 1; ; page++ {
+		q.Set("page", strconv.Itoa(page))
+		var recs []dnsRecord
+		info, err := util.v4RequestWithInfo("GET", fmt.Sprintf("/zones/%v/dns_records?%v", zone, q.Encode()), nil, &recs)
+		if _, ok := err.(*APIError); ok {
+			// As is, so that callers can tell e.g. its Retry-After
+			return nil, err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Unable to list DNS records: %v", err)
+		}
+		all = append(all, recs...)
+		if info == nil || page >= info.TotalPages {
+			return all, nil
+		}
+	}
+}
+
+// findDnsRecord finds the A record with the given name (relative to our zone)
+// and ip.
+func (util *Util) findDnsRecord(name string, ip string) (*dnsRecord, error) {
+	recs, err := util.listDnsRecords(url.Values{"type": {"A"}, "name": {util.fullName(name)}, "content": {ip}})
+	if err != nil {
+		return nil, err
+	}
+	if len(recs) == 0 {
+		return nil, fmt.Errorf("No record found for %v (%v)", name, ip)
+	}
+	return &recs[0], nil
+}
+
+// patchDnsRecord updates the given fields of the record with the given v4 id.
+func (util *Util) patchDnsRecord(id string, fields map[string]interface{}) error {
+	zone, err := util.zoneId()
+	if err != nil {
+		return err
+	}
+	return util.v4Request("PATCH", fmt.Sprintf("/zones/%v/dns_records/%v", zone, id), fields, nil)
+}
@@ -0,0 +1,112 @@
+package cfl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// Some of the statuses that a zone's DNSSEC can have
+const (
+	DNSSECActive   = "active"
+	DNSSECPending  = "pending"
+	DNSSECDisabled = "disabled"
+)
+
+// DNSSECStatus describes the DNSSEC settings of our zone. Status is one of
+// active, pending, disabled, pending-disabled or error. The DS record (and the
+// fields it's made of) is what needs to be added at the registrar once DNSSEC
+// is enabled.
+type DNSSECStatus struct {
+	Status     string `json:"status"`
+	Flags      int    `json:"flags"`
+	Algorithm  int    `json:"algorithm"`
+	KeyTag     int    `json:"keyTag"`
+	DigestType int    `json:"digestType"`
+	Digest     string `json:"digest"`
+	DS         string `json:"ds"`
+	KeyType    string `json:"keyType"`
+	PublicKey  string `json:"publicKey"`
+}
+
+// dnssecResult is the v4 API's representation of a DNSSECStatus
+type dnssecResult struct {
+	Status     string     `json:"status"`
+	Flags      int        `json:"flags"`
+	Algorithm  numericInt `json:"algorithm"`
+	KeyTag     int        `json:"key_tag"`
+	DigestType numericInt `json:"digest_type"`
+	Digest     string     `json:"digest"`
+	DS         string     `json:"ds"`
+	KeyType    string     `json:"key_type"`
+	PublicKey  string     `json:"public_key"`
+}
+
+// numericInt is an int that the v4 API sends as a string, like "13", or as
+// null while DNSSEC is disabled.
+type numericInt int
+
+func (n *numericInt) UnmarshalJSON(b []byte) error {
+	s := string(bytes.Trim(b, `"`))
+	if s == "" || s == "null" {
+		*n = 0
+		return nil
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("Invalid number %v: %v", string(b), err)
+	}
+	*n = numericInt(i)
+	return nil
+}
+
+// GetDNSSECStatus looks up the DNSSEC settings of our zone.
+func (util *Util) GetDNSSECStatus() (*DNSSECStatus, error) {
+	zone, err := util.zoneId()
+	if err != nil {
+		return nil, err
+	}
+	var result dnssecResult
+	err = util.v4Request("GET", "/zones/"+zone+"/dnssec", nil, &result)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get DNSSEC status: %v", err)
+	}
+	return &DNSSECStatus{
+		Status:     result.Status,
+		Flags:      result.Flags,
+		Algorithm:  int(result.Algorithm),
+		KeyTag:     result.KeyTag,
+		DigestType: int(result.DigestType),
+		Digest:     result.Digest,
+		DS:         result.DS,
+		KeyType:    result.KeyType,
+		PublicKey:  result.PublicKey,
+	}, nil
+}
+
+// EnableDNSSEC turns on DNSSEC for our zone. CloudFlare reports it as pending
+// until the DS record has been added at the registrar.
+func (util *Util) EnableDNSSEC(ctx context.Context) error {
+	return util.setDNSSEC(ctx, DNSSECActive)
+}
+
+// DisableDNSSEC turns off DNSSEC for our zone. Remove the DS record at the
+// registrar first, or resolvers that validate DNSSEC will fail to resolve the
+// zone.
+func (util *Util) DisableDNSSEC(ctx context.Context) error {
+	return util.setDNSSEC(ctx, DNSSECDisabled)
+}
+
+func (util *Util) setDNSSEC(ctx context.Context, status string) error {
+	zone, err := util.zoneId()
+	if err != nil {
+		return err
+	}
+	_, err = util.v4RequestContext(ctx, "PATCH", "/zones/"+zone+"/dnssec", map[string]string{"status": status}, nil)
+	if err != nil {
+		return fmt.Errorf("Unable to set DNSSEC to %v: %v", status, err)
+	}
+	log.Debugf("Set DNSSEC of %v to %v", util.domain, status)
+	return nil
+}
@@ -0,0 +1,106 @@
+package cfl
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+// dnssecActive is an abbreviated response from GET /zones/{id}/dnssec once
+// DNSSEC is enabled
+const dnssecActive = `{
+	"status": "active",
+	"flags": 257,
+	"algorithm": "13",
+	"key_type": "ECDSAP256SHA256",
+	"digest_type": "2",
+	"digest_algorithm": "SHA256",
+	"digest": "48E939042E82C22542CB377B580DFDC52A361CEFDC72E7F9107E2B6BD9306A45",
+	"ds": "example.com. 3600 IN DS 2371 13 2 48E939042E82C22542CB377B580DFDC52A361CEFDC72E7F9107E2B6BD9306A45",
+	"key_tag": 2371,
+	"public_key": "mdsswUyr3DPW132mOi8V9xESWE8jTo0dxCjjnopKl+GqJxpVXckHAeF+KkxLbxILfDLUT0rAK9iUzy1L53eKGQ==",
+	"modified_on": "2026-01-01T05:20:00Z"
+}`
+
+// dnssecDisabled is the response while DNSSEC is disabled
+const dnssecDisabled = `{
+	"status": "disabled",
+	"flags": null,
+	"algorithm": null,
+	"key_type": null,
+	"digest_type": null,
+	"digest_algorithm": null,
+	"digest": null,
+	"ds": null,
+	"key_tag": null,
+	"public_key": null,
+	"modified_on": null
+}`
+
+func TestGetDNSSECStatusActive(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+	f.handle("GET", "/zones/"+fakeZoneId+"/dnssec", func(body []byte) (int, interface{}) {
+		return 200, json.RawMessage(dnssecActive)
+	})
+
+	status, err := f.util.GetDNSSECStatus()
+	if assert.NoError(t, err) {
+		assert.Equal(t, DNSSECActive, status.Status)
+		assert.Equal(t, 257, status.Flags)
+		assert.Equal(t, 13, status.Algorithm)
+		assert.Equal(t, 2371, status.KeyTag)
+		assert.Equal(t, 2, status.DigestType)
+		assert.Equal(t, "48E939042E82C22542CB377B580DFDC52A361CEFDC72E7F9107E2B6BD9306A45", status.Digest)
+		assert.Equal(t, "example.com. 3600 IN DS 2371 13 2 48E939042E82C22542CB377B580DFDC52A361CEFDC72E7F9107E2B6BD9306A45", status.DS)
+		assert.Equal(t, "ECDSAP256SHA256", status.KeyType)
+		assert.NotEmpty(t, status.PublicKey)
+	}
+}
+
+func TestGetDNSSECStatusDisabled(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+	f.handle("GET", "/zones/"+fakeZoneId+"/dnssec", func(body []byte) (int, interface{}) {
+		return 200, json.RawMessage(dnssecDisabled)
+	})
+
+	status, err := f.util.GetDNSSECStatus()
+	if assert.NoError(t, err) {
+		assert.Equal(t, &DNSSECStatus{Status: DNSSECDisabled}, status)
+	}
+}
+
+func TestEnableDisableDNSSEC(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+	var patched []string
+	f.handle("PATCH", "/zones/"+fakeZoneId+"/dnssec", func(body []byte) (int, interface{}) {
+		var req map[string]string
+		json.Unmarshal(body, &req)
+		patched = append(patched, req["status"])
+		if req["status"] == DNSSECActive {
+			return 200, json.RawMessage(`{"status": "pending", "flags": 257, "algorithm": "13", "key_tag": 2371}`)
+		}
+		return 200, json.RawMessage(dnssecDisabled)
+	})
+
+	assert.NoError(t, f.util.EnableDNSSEC(context.Background()))
+	assert.NoError(t, f.util.DisableDNSSEC(context.Background()))
+	assert.Equal(t, []string{DNSSECActive, DNSSECDisabled}, patched)
+}
+
+func TestEnableDNSSECError(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+	f.handle("PATCH", "/zones/"+fakeZoneId+"/dnssec", func(body []byte) (int, interface{}) {
+		return 400, nil
+	})
+
+	err := f.util.EnableDNSSEC(context.Background())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Unable to set DNSSEC to active")
+	}
+}
@@ -0,0 +1,35 @@
+package cfl
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// ExportRecordsToCSV writes all records in our zone to w as CSV, sorted by
+// name and then value, for analysis in a spreadsheet.
+func (util *Util) ExportRecordsToCSV(w io.Writer) error {
+	recs, err := util.listDnsRecords(nil)
+	if err != nil {
+		return err
+	}
+	sort.Slice(recs, func(i, j int) bool {
+		if recs[i].Name != recs[j].Name {
+			return recs[i].Name < recs[j].Name
+		}
+		return recs[i].Content < recs[j].Content
+	})
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "name", "type", "value", "proxied", "ttl", "created_on", "modified_on"})
+	for _, r := range recs {
+		cw.Write([]string{r.Id, r.Name, r.Type, r.Content, strconv.FormatBool(r.Proxied), strconv.Itoa(r.Ttl), r.CreatedOn, r.ModifiedOn})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("Unable to write CSV: %v", err)
+	}
+	return nil
+}
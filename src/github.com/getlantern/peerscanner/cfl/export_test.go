@@ -0,0 +1,30 @@
+package cfl
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestExportRecordsToCSV(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+	f.handle("GET", "/zones/"+fakeZoneId+"/dns_records", func(body []byte) (int, interface{}) {
+		return 200, []dnsRecord{
+			{Id: "rec3", Type: "A", Name: "roundrobin.example.com", Content: "5.6.7.8", Ttl: 1, CreatedOn: "2016-01-03T00:00:00Z", ModifiedOn: "2016-01-04T00:00:00Z"},
+			{Id: "rec2", Type: "A", Name: "roundrobin.example.com", Content: "1.2.3.4", Ttl: 1, Proxied: true, CreatedOn: "2016-01-02T00:00:00Z", ModifiedOn: "2016-01-02T00:00:00Z"},
+			{Id: "rec1", Type: "TXT", Name: "odd,name.example.com", Content: `say "hi"`, Ttl: 300, CreatedOn: "2016-01-01T00:00:00Z", ModifiedOn: "2016-01-01T00:00:00Z"},
+		}
+	})
+
+	var buf bytes.Buffer
+	if !assert.NoError(t, f.util.ExportRecordsToCSV(&buf)) {
+		return
+	}
+	assert.Equal(t, `id,name,type,value,proxied,ttl,created_on,modified_on
+rec1,"odd,name.example.com",TXT,"say ""hi""",false,300,2016-01-01T00:00:00Z,2016-01-01T00:00:00Z
+rec2,roundrobin.example.com,A,1.2.3.4,true,1,2016-01-02T00:00:00Z,2016-01-02T00:00:00Z
+rec3,roundrobin.example.com,A,5.6.7.8,false,1,2016-01-03T00:00:00Z,2016-01-04T00:00:00Z
+`, buf.String())
+}
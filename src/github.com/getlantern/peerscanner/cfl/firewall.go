@@ -0,0 +1,120 @@
+package cfl
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+)
+
+const (
+	firewallPhase = "http_request_firewall_custom"
+)
+
+var (
+	// firewallActions are the actions that a WAF custom rule can take on a
+	// request
+	firewallActions = map[string]bool{
+		"block":             true,
+		"challenge":         true,
+		"js_challenge":      true,
+		"managed_challenge": true,
+		"log":               true,
+	}
+
+	ipExpressionPattern = regexp.MustCompile(`^\(ip\.src eq ([0-9a-fA-F:.]+)\)$`)
+)
+
+// FirewallRule is a WAF custom rule of our zone. Ip is only set for rules that
+// match a single ip, like the ones that CreateFirewallRule creates.
+type FirewallRule struct {
+	Id         string
+	Ip         string
+	Expression string
+	Action     string
+	Notes      string
+}
+
+// CreateFirewallRule adds a WAF custom rule to our zone that takes the given
+// action (block, challenge, js_challenge, managed_challenge or log) on all
+// requests from ip at the CloudFlare edge. notes ends up in the rule's
+// description. If there's already a rule for ip, it is left alone.
+func (util *Util) CreateFirewallRule(ip string, action string, notes string) error {
+	if net.ParseIP(ip) == nil {
+		return fmt.Errorf("Invalid ip %v", ip)
+	}
+	if !firewallActions[action] {
+		return fmt.Errorf("Unknown firewall action %v", action)
+	}
+	zone, err := util.zoneId()
+	if err != nil {
+		return err
+	}
+	rs, err := util.firewallRuleset(zone)
+	if err != nil {
+		return err
+	}
+	r := rule{
+		Description: notes,
+		Expression:  fmt.Sprintf("(ip.src eq %v)", ip),
+		Action:      action,
+	}
+	if rs == nil {
+		log.Debugf("Creating firewall ruleset for %v", util.domain)
+		return util.v4Request("PUT", entrypointPath(zone, firewallPhase), &ruleset{Rules: []rule{r}}, nil)
+	}
+	for _, existing := range rs.Rules {
+		if existing.Expression == r.Expression {
+			log.Debugf("Firewall rule for %v already exists", ip)
+			return nil
+		}
+	}
+	return util.v4Request("POST", fmt.Sprintf("/zones/%v/rulesets/%v/rules", zone, rs.Id), &r, nil)
+}
+
+// DeleteFirewallRule removes the WAF custom rule with the given id from our
+// zone.
+func (util *Util) DeleteFirewallRule(id string) error {
+	zone, err := util.zoneId()
+	if err != nil {
+		return err
+	}
+	rs, err := util.firewallRuleset(zone)
+	if err != nil {
+		return err
+	}
+	if rs == nil {
+		return fmt.Errorf("No firewall rule %v", id)
+	}
+	return util.v4Request("DELETE", fmt.Sprintf("/zones/%v/rulesets/%v/rules/%v", zone, rs.Id, id), nil, nil)
+}
+
+// ListFirewallRules lists the WAF custom rules of our zone.
+func (util *Util) ListFirewallRules() ([]FirewallRule, error) {
+	zone, err := util.zoneId()
+	if err != nil {
+		return nil, err
+	}
+	rs, err := util.firewallRuleset(zone)
+	if err != nil || rs == nil {
+		return nil, err
+	}
+	rules := make([]FirewallRule, 0, len(rs.Rules))
+	for _, r := range rs.Rules {
+		fr := FirewallRule{Id: r.Id, Expression: r.Expression, Action: r.Action, Notes: r.Description}
+		if m := ipExpressionPattern.FindStringSubmatch(r.Expression); m != nil {
+			fr.Ip = m[1]
+		}
+		rules = append(rules, fr)
+	}
+	return rules, nil
+}
+
+// firewallRuleset gets the zone's WAF custom rules ruleset, or nil if it
+// doesn't have one yet.
+func (util *Util) firewallRuleset(zone string) (*ruleset, error) {
+	rs, err := util.phaseRuleset(zone, firewallPhase)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get firewall ruleset: %v", err)
+	}
+	return rs, nil
+}
@@ -0,0 +1,76 @@
+package cfl
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestFirewallRules(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+
+	entrypoint := "/zones/" + fakeZoneId + "/rulesets/phases/http_request_firewall_custom/entrypoint"
+	var rs *ruleset
+	nextId := 0
+	f.handle("GET", entrypoint, func(body []byte) (int, interface{}) {
+		if rs == nil {
+			return 404, nil
+		}
+		return 200, rs
+	})
+	addRule := func(body []byte) rule {
+		var r rule
+		json.Unmarshal(body, &r)
+		nextId++
+		r.Id = fmt.Sprintf("rule%d", nextId)
+		return r
+	}
+	f.handle("PUT", entrypoint, func(body []byte) (int, interface{}) {
+		var created ruleset
+		json.Unmarshal(body, &created)
+		rs = &ruleset{Id: "rs1"}
+		for _, r := range created.Rules {
+			b, _ := json.Marshal(r)
+			rs.Rules = append(rs.Rules, addRule(b))
+		}
+		return 200, rs
+	})
+	f.handle("POST", "/zones/"+fakeZoneId+"/rulesets/rs1/rules", func(body []byte) (int, interface{}) {
+		rs.Rules = append(rs.Rules, addRule(body))
+		return 200, rs
+	})
+	f.handle("DELETE", "/zones/"+fakeZoneId+"/rulesets/rs1/rules/rule1", func(body []byte) (int, interface{}) {
+		rs.Rules = rs.Rules[1:]
+		return 200, rs
+	})
+
+	rules, err := f.util.ListFirewallRules()
+	assert.NoError(t, err, "Listing without a ruleset should succeed")
+	assert.Len(t, rules, 0)
+
+	assert.Error(t, f.util.CreateFirewallRule("1.2.3", "block", ""), "Invalid ip should be rejected")
+	assert.Error(t, f.util.CreateFirewallRule("1.2.3.4", "allow", ""), "Unknown action should be rejected")
+	if !assert.NoError(t, f.util.CreateFirewallRule("1.2.3.4", "block", "abusive"), "Should be able to create rule") {
+		return
+	}
+	assert.NoError(t, f.util.CreateFirewallRule("2001:db8::1", "managed_challenge", "suspicious"))
+	assert.NoError(t, f.util.CreateFirewallRule("1.2.3.4", "block", "abusive"), "Creating existing rule should succeed")
+	assert.Len(t, rs.Rules, 2, "Existing rule shouldn't have been added again")
+	rs.Rules = append(rs.Rules, rule{Id: "other", Expression: `(http.host eq "example.com")`, Action: "log"})
+
+	rules, err = f.util.ListFirewallRules()
+	if assert.NoError(t, err) {
+		assert.Equal(t, []FirewallRule{
+			{Id: "rule1", Ip: "1.2.3.4", Expression: "(ip.src eq 1.2.3.4)", Action: "block", Notes: "abusive"},
+			{Id: "rule2", Ip: "2001:db8::1", Expression: "(ip.src eq 2001:db8::1)", Action: "managed_challenge", Notes: "suspicious"},
+			{Id: "other", Expression: `(http.host eq "example.com")`, Action: "log"},
+		}, rules)
+	}
+
+	assert.NoError(t, f.util.DeleteFirewallRule("rule1"), "Should be able to delete rule")
+	assert.Len(t, rs.Rules, 2, "Rule should have been deleted")
+	assert.Error(t, f.util.DeleteFirewallRule("unknown"), "Deleting unknown rule should fail")
+}
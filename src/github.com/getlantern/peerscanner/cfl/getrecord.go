@@ -0,0 +1,97 @@
+package cfl
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"time"
+
+	"github.com/getlantern/cloudflare"
+	"github.com/getlantern/peerscanner/backoff"
+)
+
+var (
+	// waitForRecordPolicy is how often WaitForRecord checks for the record,
+	// every 2 seconds until it runs out of time
+	waitForRecordPolicy = backoff.Policy{Base: 2 * time.Second, Max: 2 * time.Second, Multiplier: 1, MaxAttempts: math.MaxInt32}
+)
+
+// GetRecord looks up the A record with the given name (relative to our zone)
+// and ip. Unlike FindRecord, it returns nil if there's no such record.
+func (util *Util) GetRecord(name string, ip string) (*cloudflare.Record, error) {
+	fullName := util.fullName(name)
+	recs, err := util.listDnsRecords(url.Values{"type": {"A"}, "name": {fullName}, "content": {ip}})
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range recs {
+		if r.Name == fullName && r.Content == ip {
+			rec := util.toRecord(r)
+			return &rec, nil
+		}
+	}
+	return nil, nil
+}
+
+// IsRecordInGroup indicates whether ip is in the group (rotation) with the
+// given subdomain according to CloudFlare. Unlike GetRecord, it only ever
+// makes a single request, since there's at most one such record.
+func (util *Util) IsRecordInGroup(ip string, groupSubdomain string) (bool, error) {
+	zone, err := util.zoneId()
+	if err != nil {
+		return false, err
+	}
+	fullName := util.fullName(groupSubdomain)
+	q := url.Values{"type": {"A"}, "name": {fullName}, "content": {ip}, "per_page": {"5"}}
+	var recs []dnsRecord
+	err = util.v4Request("GET", fmt.Sprintf("/zones/%v/dns_records?%v", zone, q.Encode()), nil, &recs)
+	if err != nil {
+		return false, fmt.Errorf("Unable to look up %v in %v: %v", ip, groupSubdomain, err)
+	}
+	for _, r := range recs {
+		if r.Name == fullName && r.Content == ip {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// WaitForRecord waits for the A record with the given name and ip to show up,
+// which can take a while after creating it since CloudFlare's API is only
+// eventually consistent. If CloudFlare rate limits us, it waits for as long as
+// its Retry-After asks. It fails if the record doesn't show up within timeout.
+func (util *Util) WaitForRecord(name string, ip string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for attempt := 1; ; attempt++ {
+		rec, err := util.GetRecord(name, ip)
+		if err != nil {
+			log.Debugf("Unable to check for record %v (%v): %v", name, ip, err)
+		} else if rec != nil {
+			return nil
+		}
+		wait := util.retryWait(waitForRecordPolicy, attempt, err)
+		if wait == backoff.Stop || time.Now().Add(wait).After(deadline) {
+			return fmt.Errorf("Record %v (%v) didn't show up within %v", name, ip, timeout)
+		}
+		sleep(wait)
+	}
+}
+
+// GetRecordCreationTime looks up when the record with the given id was
+// created, which the client API doesn't tell us.
+func (util *Util) GetRecordCreationTime(id string) (time.Time, error) {
+	zone, err := util.zoneId()
+	if err != nil {
+		return time.Time{}, err
+	}
+	var r dnsRecord
+	err = util.v4Request("GET", fmt.Sprintf("/zones/%v/dns_records/%v", zone, id), nil, &r)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Unable to get record %v: %v", id, err)
+	}
+	created, err := time.Parse(time.RFC3339, r.CreatedOn)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Record %v has invalid created_on %q: %v", id, r.CreatedOn, err)
+	}
+	return created, nil
+}
@@ -0,0 +1,130 @@
+package cfl
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestGetRecord(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+	f.handle("GET", "/zones/"+fakeZoneId+"/dns_records", func(body []byte) (int, interface{}) {
+		return 200, []dnsRecord{{Id: "rec1", Type: "A", Name: "fl-us-1.example.com", Content: "1.2.3.4", Ttl: 120}}
+	})
+
+	rec, err := f.util.GetRecord("fl-us-1", "1.2.3.4")
+	if assert.NoError(t, err) && assert.NotNil(t, rec) {
+		assert.Equal(t, "rec1", rec.Id)
+		assert.Equal(t, "fl-us-1", rec.Name)
+		assert.Equal(t, "fl-us-1.example.com", rec.FullName)
+		assert.Equal(t, "120", rec.Ttl)
+	}
+	rec, err = f.util.GetRecord("fl-us-1", "1.2.3.5")
+	assert.NoError(t, err)
+	assert.Nil(t, rec, "Record with other ip shouldn't be returned")
+}
+
+func TestIsRecordInGroup(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+	path := "/zones/" + fakeZoneId + "/dns_records"
+	f.handle("GET", path, func(body []byte) (int, interface{}) {
+		return 200, []dnsRecord{{Id: "rec1", Type: "A", Name: "roundrobin.example.com", Content: "1.2.3.4"}}
+	})
+
+	member, err := f.util.IsRecordInGroup("1.2.3.4", "roundrobin")
+	if assert.NoError(t, err) {
+		assert.True(t, member, "Ip with a record in the group should be a member")
+	}
+	q := f.query("GET", path)
+	assert.Equal(t, "roundrobin.example.com", q.Get("name"), "Group should be filtered by CloudFlare")
+	assert.Equal(t, "1.2.3.4", q.Get("content"), "Ip should be filtered by CloudFlare")
+
+	member, err = f.util.IsRecordInGroup("1.2.3.5", "roundrobin")
+	if assert.NoError(t, err) {
+		assert.False(t, member, "Ip without a record in the group shouldn't be a member")
+	}
+	member, err = f.util.IsRecordInGroup("1.2.3.4", "fallbacks")
+	if assert.NoError(t, err) {
+		assert.False(t, member, "Ip shouldn't be a member of other groups")
+	}
+}
+
+func TestWaitForRecordWaitsForPropagation(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+	start := time.Now()
+	f.handle("GET", "/zones/"+fakeZoneId+"/dns_records", func(body []byte) (int, interface{}) {
+		if time.Since(start) < 6*time.Second {
+			return 200, []dnsRecord{}
+		}
+		return 200, []dnsRecord{{Id: "rec1", Type: "A", Name: "fl-us-1.example.com", Content: "1.2.3.4"}}
+	})
+
+	err := f.util.WaitForRecord("fl-us-1", "1.2.3.4", 10*time.Second)
+	assert.NoError(t, err, "Record should have shown up")
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= 6*time.Second && elapsed < 10*time.Second, "Should have returned once the record showed up, took %v", elapsed)
+}
+
+func TestWaitForRecordTimesOut(t *testing.T) {
+	orig := waitForRecordPolicy
+	waitForRecordPolicy.Base, waitForRecordPolicy.Max = 10*time.Millisecond, 10*time.Millisecond
+	defer func() {
+		waitForRecordPolicy = orig
+	}()
+	f := newFakeV4("example.com")
+	defer f.Close()
+	f.handle("GET", "/zones/"+fakeZoneId+"/dns_records", func(body []byte) (int, interface{}) {
+		return 200, []dnsRecord{}
+	})
+
+	assert.Error(t, f.util.WaitForRecord("fl-us-1", "1.2.3.4", 50*time.Millisecond), "Missing record should time out")
+}
+
+func TestWaitForRecordHonorsRetryAfter(t *testing.T) {
+	var slept []time.Duration
+	defer withSleep(func(d time.Duration) { slept = append(slept, d) })()
+	f := newFakeV4("example.com")
+	defer f.Close()
+	f.Config.Handler = http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("Retry-After", "5")
+		f.serve(resp, req)
+	})
+	checks := 0
+	f.handle("GET", "/zones/"+fakeZoneId+"/dns_records", func(body []byte) (int, interface{}) {
+		checks++
+		if checks == 1 {
+			return http.StatusTooManyRequests, nil
+		}
+		return 200, []dnsRecord{{Id: "rec1", Type: "A", Name: "fl-us-1.example.com", Content: "1.2.3.4"}}
+	})
+
+	assert.NoError(t, f.util.WaitForRecord("fl-us-1", "1.2.3.4", 10*time.Second), "Record should have shown up")
+	if assert.Len(t, slept, 1) {
+		assert.InDelta(t, float64(5*time.Second), float64(slept[0]), float64(250*time.Millisecond), "Check should be repeated after Retry-After, give or take 5%%")
+	}
+}
+
+func TestGetRecordCreationTime(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+	f.handle("GET", "/zones/"+fakeZoneId+"/dns_records/rec1", func(body []byte) (int, interface{}) {
+		return 200, dnsRecord{Id: "rec1", Type: "A", Name: "peer-1.example.com", Content: "1.2.3.4", CreatedOn: "2014-01-01T05:20:00.12345Z"}
+	})
+	f.handle("GET", "/zones/"+fakeZoneId+"/dns_records/rec2", func(body []byte) (int, interface{}) {
+		return 200, dnsRecord{Id: "rec2", Type: "A", Name: "peer-2.example.com", Content: "1.2.3.5"}
+	})
+
+	created, err := f.util.GetRecordCreationTime("rec1")
+	if assert.NoError(t, err) {
+		assert.Equal(t, time.Date(2014, 1, 1, 5, 20, 0, 123450000, time.UTC), created)
+	}
+	_, err = f.util.GetRecordCreationTime("rec2")
+	assert.Error(t, err, "Record without created_on should be an error")
+	_, err = f.util.GetRecordCreationTime("missing")
+	assert.Error(t, err)
+}
@@ -0,0 +1,105 @@
+package cfl
+
+import (
+	"fmt"
+)
+
+// HealthCheck is a CloudFlare health check of one of our hosts. Status is
+// healthy, unhealthy or unknown, as CloudFlare last saw the host.
+type HealthCheck struct {
+	Id      string `json:"id"`
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Port    int    `json:"port"`
+	Status  string `json:"status"`
+}
+
+// healthCheckHTTPConfig is the http_config of a health check in the v4 API
+type healthCheckHTTPConfig struct {
+	Method        string   `json:"method"`
+	Path          string   `json:"path"`
+	Port          int      `json:"port"`
+	ExpectedCodes []string `json:"expected_codes"`
+}
+
+// healthCheck is the v4 API's representation of a HealthCheck
+type healthCheck struct {
+	Id          string                `json:"id,omitempty"`
+	Name        string                `json:"name"`
+	Description string                `json:"description,omitempty"`
+	Address     string                `json:"address"`
+	Type        string                `json:"type"`
+	HTTPConfig  healthCheckHTTPConfig `json:"http_config"`
+	Status      string                `json:"status,omitempty"`
+}
+
+// CreateHealthCheck has CloudFlare check the host with the given name at
+// ip:port with an HTTP GET of /. Our hosts are proxies that don't serve a page
+// at /, so any response other than a server error counts as healthy. It
+// succeeds if there's a health check for the same host already. Health checks
+// need a Pro or higher plan.
+func (util *Util) CreateHealthCheck(name string, ip string, port int) error {
+	existing, err := util.ListHealthChecks()
+	if err != nil {
+		return err
+	}
+	for _, hc := range existing {
+		if hc.Name == name && hc.Address == ip && hc.Port == port {
+			log.Debugf("Health check for %v already exists", name)
+			return nil
+		}
+	}
+	zone, err := util.zoneId()
+	if err != nil {
+		return err
+	}
+	hc := healthCheck{
+		Name:        name,
+		Description: util.recordComment(),
+		Address:     ip,
+		Type:        "HTTP",
+		HTTPConfig: healthCheckHTTPConfig{
+			Method:        "GET",
+			Path:          "/",
+			Port:          port,
+			ExpectedCodes: []string{"2xx", "3xx", "4xx"},
+		},
+	}
+	err = util.v4Request("POST", fmt.Sprintf("/zones/%v/healthchecks", zone), &hc, nil)
+	if err != nil {
+		return fmt.Errorf("Unable to create health check for %v: %v", name, err)
+	}
+	return nil
+}
+
+// ListHealthChecks lists the health checks of our zone.
+func (util *Util) ListHealthChecks() ([]HealthCheck, error) {
+	zone, err := util.zoneId()
+	if err != nil {
+		return nil, err
+	}
+	var results []healthCheck
+	err = util.v4Request("GET", fmt.Sprintf("/zones/%v/healthchecks", zone), nil, &results)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list health checks: %v", err)
+	}
+	checks := make([]HealthCheck, 0, len(results))
+	for _, r := range results {
+		checks = append(checks, HealthCheck{Id: r.Id, Name: r.Name, Address: r.Address, Port: r.HTTPConfig.Port, Status: r.Status})
+	}
+	return checks, nil
+}
+
+// DeleteHealthCheck deletes the health check with the given id. It succeeds
+// if there's no such health check.
+func (util *Util) DeleteHealthCheck(id string) error {
+	zone, err := util.zoneId()
+	if err != nil {
+		return err
+	}
+	err = util.v4Request("DELETE", fmt.Sprintf("/zones/%v/healthchecks/%v", zone, id), nil, nil)
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("Unable to delete health check %v: %v", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package cfl
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+// healthChecks is an abbreviated response from GET /zones/{id}/healthchecks
+const healthChecks = `[
+	{
+		"id": "699d98642c564d2e855e9661899b7252",
+		"name": "fl-us-1",
+		"description": "",
+		"suspended": false,
+		"address": "1.2.3.4",
+		"type": "HTTP",
+		"interval": 60,
+		"retries": 2,
+		"timeout": 5,
+		"http_config": {"method": "GET", "port": 443, "path": "/", "expected_codes": ["2xx", "3xx", "4xx"]},
+		"status": "healthy",
+		"failure_reason": ""
+	}
+]`
+
+func TestCreateHealthCheck(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+	path := "/zones/" + fakeZoneId + "/healthchecks"
+	f.handle("GET", path, func(body []byte) (int, interface{}) {
+		return 200, json.RawMessage(healthChecks)
+	})
+	var payload map[string]interface{}
+	f.handle("POST", path, func(body []byte) (int, interface{}) {
+		json.Unmarshal(body, &payload)
+		return 200, map[string]interface{}{"id": "hc2"}
+	})
+
+	assert.NoError(t, f.util.CreateHealthCheck("fl-us-1", "1.2.3.4", 443))
+	assert.Nil(t, payload, "Existing health check shouldn't be created again")
+
+	if !assert.NoError(t, f.util.CreateHealthCheck("fl-us-2", "1.2.3.5", 80)) {
+		return
+	}
+	assert.Equal(t, "fl-us-2", payload["name"])
+	assert.Equal(t, "1.2.3.5", payload["address"])
+	assert.Equal(t, "HTTP", payload["type"])
+	assert.Equal(t, map[string]interface{}{
+		"method":         "GET",
+		"path":           "/",
+		"port":           float64(80),
+		"expected_codes": []interface{}{"2xx", "3xx", "4xx"},
+	}, payload["http_config"])
+
+	f.handle("POST", path, func(body []byte) (int, interface{}) {
+		return 403, nil
+	})
+	assert.Error(t, f.util.CreateHealthCheck("fl-us-3", "1.2.3.6", 80), "Failed create should be reported, e.g. on a free plan")
+}
+
+func TestListHealthChecks(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+	f.handle("GET", "/zones/"+fakeZoneId+"/healthchecks", func(body []byte) (int, interface{}) {
+		return 200, json.RawMessage(healthChecks)
+	})
+	checks, err := f.util.ListHealthChecks()
+	if assert.NoError(t, err) && assert.Len(t, checks, 1) {
+		assert.Equal(t, HealthCheck{Id: "699d98642c564d2e855e9661899b7252", Name: "fl-us-1", Address: "1.2.3.4", Port: 443, Status: "healthy"}, checks[0])
+	}
+}
+
+func TestDeleteHealthCheck(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+	path := "/zones/" + fakeZoneId + "/healthchecks/hc1"
+	f.handle("DELETE", path, func(body []byte) (int, interface{}) {
+		return 200, map[string]interface{}{"id": "hc1"}
+	})
+	assert.NoError(t, f.util.DeleteHealthCheck("hc1"))
+	assert.True(t, f.requested("DELETE", path))
+	assert.NoError(t, f.util.DeleteHealthCheck("missing"), "Missing health check should count as deleted")
+
+	f.handle("DELETE", path, func(body []byte) (int, interface{}) {
+		return 500, nil
+	})
+	assert.Error(t, f.util.DeleteHealthCheck("hc1"))
+}
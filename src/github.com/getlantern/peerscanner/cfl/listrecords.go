@@ -0,0 +1,99 @@
+package cfl
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/getlantern/cloudflare"
+)
+
+// ListRecordsByType lists the records of the given type (e.g. A) in our zone.
+// Unlike GetAllRecords, CloudFlare does the filtering, so we don't fetch the
+// records we don't manage.
+func (util *Util) ListRecordsByType(recordType string) ([]cloudflare.Record, error) {
+	return util.listRecords(url.Values{"type": {recordType}})
+}
+
+// ListRecordsByPrefix lists the records in our zone whose names (relative to
+// our zone) start with prefix, e.g. "fl-".
+func (util *Util) ListRecordsByPrefix(prefix string) ([]cloudflare.Record, error) {
+	// CloudFlare can only filter on the name containing prefix, so make sure
+	// it's actually at the start.
+	recs, err := util.listRecords(url.Values{"name.contains": {prefix}})
+	if err != nil {
+		return nil, err
+	}
+	filtered := make([]cloudflare.Record, 0, len(recs))
+	for _, r := range recs {
+		if strings.HasPrefix(r.Name, prefix) {
+			filtered = append(filtered, r)
+		}
+	}
+	return filtered, nil
+}
+
+// ListRecordsByName lists the records of any type with the given name
+// (relative to our zone), e.g. all the A records of a peer that registered
+// with different ips, without scanning the whole zone.
+func (util *Util) ListRecordsByName(name string) ([]cloudflare.Record, error) {
+	fullName := util.fullName(name)
+	recs, err := util.listRecords(url.Values{"name": {fullName}})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list records for %v: %v", name, err)
+	}
+	filtered := make([]cloudflare.Record, 0, len(recs))
+	for _, r := range recs {
+		if r.FullName == fullName {
+			filtered = append(filtered, r)
+		}
+	}
+	return filtered, nil
+}
+
+// CountRecords counts the A records with the given name (relative to our
+// zone), e.g. the members of a rotation, without fetching them all.
+func (util *Util) CountRecords(name string) (int, error) {
+	zone, err := util.zoneId()
+	if err != nil {
+		return 0, err
+	}
+	q := url.Values{"type": {"A"}, "name": {util.fullName(name)}, "per_page": {"1"}}
+	info, err := util.v4RequestWithInfo("GET", fmt.Sprintf("/zones/%v/dns_records?%v", zone, q.Encode()), nil, nil)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to count records for %v: %v", name, err)
+	}
+	if info == nil {
+		return 0, fmt.Errorf("No result_info in response to counting records for %v", name)
+	}
+	return info.TotalCount, nil
+}
+
+func (util *Util) listRecords(query url.Values) ([]cloudflare.Record, error) {
+	dnsRecs, err := util.listDnsRecords(query)
+	if err != nil {
+		return nil, err
+	}
+	recs := make([]cloudflare.Record, 0, len(dnsRecs))
+	for _, r := range dnsRecs {
+		recs = append(recs, util.toRecord(r))
+	}
+	return recs, nil
+}
+
+// FindOrphanedRecords returns the A records of hosts, which isHost tells by
+// their names (relative to our zone), whose ips aren't among the ones that
+// isActive knows about, e.g. ones left behind by an instance that crashed.
+func (util *Util) FindOrphanedRecords(isHost func(name string) bool, isActive func(ip string) bool) ([]cloudflare.Record, error) {
+	recs, err := util.ListRecordsByType("A")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list A records: %v", err)
+	}
+	orphans := make([]cloudflare.Record, 0)
+	for _, r := range recs {
+		if isHost(r.Name) && !isActive(r.Value) {
+			orphans = append(orphans, r)
+		}
+	}
+	return orphans, nil
+}
@@ -0,0 +1,191 @@
+package cfl
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/getlantern/cloudflare"
+	"github.com/getlantern/testify/assert"
+)
+
+func TestListRecordsByType(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+	path := "/zones/" + fakeZoneId + "/dns_records"
+	f.handle("GET", path, func(body []byte) (int, interface{}) {
+		return 200, []dnsRecord{
+			{Id: "rec1", Type: "A", Name: "fl-us-1.example.com", Content: "1.2.3.4", Ttl: 300},
+			{Id: "rec2", Type: "A", Name: "roundrobin.example.com", Content: "1.2.3.4", Ttl: 1, Proxied: true},
+		}
+	})
+
+	recs, err := f.util.ListRecordsByType("A")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "A", f.query("GET", path).Get("type"), "Type should be filtered by CloudFlare")
+	if assert.Equal(t, 2, len(recs)) {
+		assert.Equal(t, cloudflare.Record{
+			Id:       "rec1",
+			Domain:   "example.com",
+			Type:     "A",
+			Name:     "fl-us-1",
+			FullName: "fl-us-1.example.com",
+			Value:    "1.2.3.4",
+			Ttl:      "300",
+		}, recs[0])
+		assert.False(t, IsProxied(&recs[0]))
+		assert.True(t, IsProxied(&recs[1]), "Proxied flag should be kept from the v4 API")
+	}
+}
+
+func TestListRecordsByPrefix(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+	path := "/zones/" + fakeZoneId + "/dns_records"
+	f.handle("GET", path, func(body []byte) (int, interface{}) {
+		return 200, []dnsRecord{
+			{Id: "rec1", Type: "A", Name: "fl-us-1.example.com", Content: "1.2.3.4"},
+			{Id: "rec2", Type: "CNAME", Name: "fl-www.example.com", Content: "example.org"},
+			{Id: "rec3", Type: "A", Name: "notfl-1.example.com", Content: "5.6.7.8"},
+		}
+	})
+
+	recs, err := f.util.ListRecordsByPrefix("fl-")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "fl-", f.query("GET", path).Get("name.contains"))
+	if assert.Equal(t, 2, len(recs), "Records containing but not starting with the prefix should be skipped") {
+		assert.Equal(t, "fl-us-1", recs[0].Name)
+		assert.Equal(t, "fl-www", recs[1].Name)
+	}
+}
+
+func TestListRecordsByName(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+	path := "/zones/" + fakeZoneId + "/dns_records"
+	var result []dnsRecord
+	f.handle("GET", path, func(body []byte) (int, interface{}) {
+		return 200, result
+	})
+
+	recs, err := f.util.ListRecordsByName("peer-1")
+	if assert.NoError(t, err) {
+		assert.Len(t, recs, 0, "Name without records should have none")
+	}
+	assert.Equal(t, "peer-1.example.com", f.query("GET", path).Get("name"), "Name should be filtered on by CloudFlare")
+
+	result = []dnsRecord{{Id: "rec1", Type: "A", Name: "peer-1.example.com", Content: "1.2.3.4"}}
+	recs, err = f.util.ListRecordsByName("peer-1")
+	if assert.NoError(t, err) && assert.Len(t, recs, 1) {
+		assert.Equal(t, "rec1", recs[0].Id)
+		assert.Equal(t, "peer-1", recs[0].Name)
+		assert.Equal(t, "1.2.3.4", recs[0].Value)
+	}
+
+	result = []dnsRecord{
+		{Id: "rec1", Type: "A", Name: "peer-1.example.com", Content: "1.2.3.4"},
+		{Id: "rec2", Type: "A", Name: "peer-1.example.com", Content: "1.2.3.5"},
+		{Id: "rec3", Type: "A", Name: "peer-10.example.com", Content: "1.2.3.6"},
+	}
+	recs, err = f.util.ListRecordsByName("peer-1")
+	if assert.NoError(t, err) && assert.Len(t, recs, 2, "Every ip should be returned, but only for the exact name") {
+		assert.Equal(t, "1.2.3.4", recs[0].Value)
+		assert.Equal(t, "1.2.3.5", recs[1].Value)
+	}
+
+	f.handle("GET", path, func(body []byte) (int, interface{}) {
+		return 500, nil
+	})
+	_, err = f.util.ListRecordsByName("peer-1")
+	assert.Error(t, err)
+}
+
+func TestCountRecords(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+	path := "/zones/" + fakeZoneId + "/dns_records"
+	f.handle("GET", path, func(body []byte) (int, interface{}) {
+		return 200, v4Paged{
+			result: []dnsRecord{{Id: "rec1", Type: "A", Name: "roundrobin.example.com", Content: "1.2.3.4"}},
+			info:   v4ResultInfo{Page: 1, PerPage: 1, TotalPages: 42, Count: 1, TotalCount: 42},
+		}
+	})
+
+	count, err := f.util.CountRecords("roundrobin")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 42, count)
+	}
+	q := f.query("GET", path)
+	assert.Equal(t, "roundrobin.example.com", q.Get("name"))
+	assert.Equal(t, "A", q.Get("type"))
+}
+
+// benchmarkZone fakes a zone with 10,000 records, of which 500 are A records,
+// and returns all of the records and the v4 API's A records.
+func benchmarkZone() (*fakeV4, []cloudflare.Record, []dnsRecord) {
+	f := newFakeV4("example.com")
+	var all []cloudflare.Record
+	var aRecs []dnsRecord
+	for i := 0; i < 10000; i++ {
+		name := fmt.Sprintf("host-%d", i)
+		typ, value := "TXT", "v=spf1 include:example.org ~all"
+		if i%20 == 0 {
+			typ, value = "A", fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+			aRecs = append(aRecs, dnsRecord{Id: name, Type: typ, Name: name + ".example.com", Content: value, Ttl: 1})
+		}
+		all = append(all, cloudflare.Record{Id: name, Domain: "example.com", Name: name, FullName: name + ".example.com", Value: value, Type: typ, Ttl: "1"})
+	}
+	return f, all, aRecs
+}
+
+func BenchmarkGetAllRecords(b *testing.B) {
+	f, all, _ := benchmarkZone()
+	defer f.Close()
+	f.serveRecords(all)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := f.util.GetAllRecords(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkListRecordsByType(b *testing.B) {
+	f, _, aRecs := benchmarkZone()
+	defer f.Close()
+	f.handle("GET", "/zones/"+fakeZoneId+"/dns_records", func(body []byte) (int, interface{}) {
+		return 200, aRecs
+	})
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := f.util.ListRecordsByType("A"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestFindOrphanedRecords(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+	path := "/zones/" + fakeZoneId + "/dns_records"
+	f.handle("GET", path, func(body []byte) (int, interface{}) {
+		return 200, []dnsRecord{
+			{Id: "active", Type: "A", Name: "fl-us-1.example.com", Content: "1.2.3.4"},
+			{Id: "orphan", Type: "A", Name: "fl-us-2.example.com", Content: "1.2.3.5"},
+			{Id: "group", Type: "A", Name: "roundrobin.example.com", Content: "1.2.3.5"},
+			{Id: "other", Type: "A", Name: "www.example.com", Content: "5.6.7.8"},
+		}
+	})
+
+	isHost := func(name string) bool { return strings.HasPrefix(name, "fl-") }
+	isActive := func(ip string) bool { return ip == "1.2.3.4" }
+	orphans, err := f.util.FindOrphanedRecords(isHost, isActive)
+	if assert.NoError(t, err) && assert.Len(t, orphans, 1, "Only records of hosts that aren't active should be orphans") {
+		assert.Equal(t, "orphan", orphans[0].Id)
+	}
+	assert.Equal(t, "A", f.query("GET", path).Get("type"))
+}
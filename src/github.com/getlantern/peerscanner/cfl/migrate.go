@@ -0,0 +1,87 @@
+package cfl
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// MigrateRecords moves the records with oldName to newName (both relative to
+// our zone), e.g. when a host's name changes while it keeps its ip. So that
+// the host can be resolved throughout, the records for newName are created
+// first and the ones for oldName only destroyed once the new ones can be seen
+// in CloudFlare. Only A, AAAA, CNAME and TXT records are migrated; others are
+// left alone.
+func (util *Util) MigrateRecords(oldName string, newName string) error {
+	if oldName == newName {
+		return nil
+	}
+	oldRecs, err := util.listDnsRecords(url.Values{"name": {util.fullName(oldName)}})
+	if err != nil {
+		return fmt.Errorf("Unable to find records for %v: %v", oldName, err)
+	}
+	existing, err := util.listDnsRecords(url.Values{"name": {util.fullName(newName)}})
+	if err != nil {
+		return fmt.Errorf("Unable to find records for %v: %v", newName, err)
+	}
+	exists := make(map[string]bool, len(existing))
+	for _, r := range existing {
+		exists[r.Type+" "+r.Content] = true
+	}
+
+	var migrating []dnsRecord
+	for _, r := range oldRecs {
+		if !exportedTypes[r.Type] {
+			log.Debugf("Not migrating %v record %v", r.Type, r.Name)
+			continue
+		}
+		migrating = append(migrating, r)
+	}
+	if len(migrating) == 0 {
+		log.Debugf("No records to migrate from %v to %v", oldName, newName)
+		return nil
+	}
+	if util.DryRun {
+		log.Debugf("Dry run, not migrating %d records from %v to %v", len(migrating), oldName, newName)
+		return nil
+	}
+
+	zone, err := util.zoneId()
+	if err != nil {
+		return err
+	}
+	for _, r := range migrating {
+		if exists[r.Type+" "+r.Content] {
+			continue
+		}
+		rec := batchRecord{Type: r.Type, Name: util.fullName(newName), Content: r.Content, Ttl: r.Ttl, Proxied: r.Proxied, Comment: util.recordComment()}
+		err := util.v4Request("POST", fmt.Sprintf("/zones/%v/dns_records", zone), &rec, nil)
+		if err != nil {
+			return fmt.Errorf("Unable to create %v record %v (%v), keeping %v: %v", r.Type, newName, r.Content, oldName, err)
+		}
+	}
+
+	// Make sure that the new records are there before removing the old ones
+	created, err := util.listDnsRecords(url.Values{"name": {util.fullName(newName)}})
+	if err != nil {
+		return fmt.Errorf("Unable to verify records for %v, keeping %v: %v", newName, oldName, err)
+	}
+	found := make(map[string]bool, len(created))
+	for _, r := range created {
+		found[r.Type+" "+r.Content] = true
+	}
+	for _, r := range migrating {
+		if !found[r.Type+" "+r.Content] {
+			return fmt.Errorf("%v record %v (%v) missing after creating it, keeping %v", r.Type, newName, r.Content, oldName)
+		}
+	}
+
+	for _, r := range migrating {
+		err := util.v4Request("DELETE", fmt.Sprintf("/zones/%v/dns_records/%v", zone, r.Id), nil, nil)
+		if err != nil && !isNotFound(err) {
+			return fmt.Errorf("Unable to destroy %v record %v (%v) after migrating it to %v: %v", r.Type, oldName, r.Content, newName, err)
+		}
+		forgetProxied(r.Id)
+	}
+	log.Debugf("Migrated %d records from %v to %v", len(migrating), oldName, newName)
+	return nil
+}
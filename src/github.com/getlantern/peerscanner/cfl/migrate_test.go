@@ -0,0 +1,108 @@
+package cfl
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+// fakeZone is a fake zone's records, served through a fakeV4. It keeps track
+// of the order of the changes made to them.
+type fakeZone struct {
+	f       *fakeV4
+	records map[string]dnsRecord
+	changes []string
+	// dropCreates, if set, makes creates succeed without creating anything,
+	// as if CloudFlare lost them
+	dropCreates bool
+	nextId      int
+}
+
+func newFakeZone(f *fakeV4, recs ...dnsRecord) *fakeZone {
+	z := &fakeZone{f: f, records: make(map[string]dnsRecord)}
+	path := "/zones/" + fakeZoneId + "/dns_records"
+	f.handle("GET", path, func(body []byte) (int, interface{}) {
+		name := f.query("GET", path).Get("name")
+		result := make([]dnsRecord, 0)
+		for _, r := range z.records {
+			if r.Name == name {
+				result = append(result, r)
+			}
+		}
+		z.changes = append(z.changes, "list "+name)
+		return 200, result
+	})
+	f.handle("POST", path, func(body []byte) (int, interface{}) {
+		var rec batchRecord
+		json.Unmarshal(body, &rec)
+		z.changes = append(z.changes, "create "+rec.Name)
+		created := z.add(dnsRecord{Type: rec.Type, Name: rec.Name, Content: rec.Content, Ttl: rec.Ttl, Proxied: rec.Proxied})
+		if z.dropCreates {
+			delete(z.records, created.Id)
+		}
+		return 200, created
+	})
+	for _, r := range recs {
+		z.add(r)
+	}
+	return z
+}
+
+func (z *fakeZone) add(r dnsRecord) dnsRecord {
+	z.nextId++
+	r.Id = fmt.Sprintf("rec%d", z.nextId)
+	z.records[r.Id] = r
+	z.f.handle("DELETE", "/zones/"+fakeZoneId+"/dns_records/"+r.Id, func(body []byte) (int, interface{}) {
+		z.changes = append(z.changes, "delete "+z.records[r.Id].Name)
+		delete(z.records, r.Id)
+		return 200, map[string]string{"id": r.Id}
+	})
+	return r
+}
+
+func (z *fakeZone) find(name string) []dnsRecord {
+	var recs []dnsRecord
+	for _, r := range z.records {
+		if r.Name == name {
+			r.Id = ""
+			recs = append(recs, r)
+		}
+	}
+	return recs
+}
+
+func TestMigrateRecords(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+	z := newFakeZone(f,
+		dnsRecord{Type: "A", Name: "peer-old.example.com", Content: "10.0.0.1", Ttl: 120, Proxied: true},
+		dnsRecord{Type: "A", Name: "peer-other.example.com", Content: "10.0.0.2", Ttl: 120},
+	)
+
+	if !assert.NoError(t, f.util.MigrateRecords("peer-old", "peer-new")) {
+		return
+	}
+	assert.Len(t, z.find("peer-old.example.com"), 0, "Old record should have been destroyed")
+	assert.Equal(t, []dnsRecord{{Type: "A", Name: "peer-new.example.com", Content: "10.0.0.1", Ttl: 120, Proxied: true}}, z.find("peer-new.example.com"), "Identical record should have been created")
+	assert.Len(t, z.find("peer-other.example.com"), 1, "Other records should be left alone")
+	assert.Equal(t, []string{
+		"list peer-old.example.com",
+		"list peer-new.example.com",
+		"create peer-new.example.com",
+		"list peer-new.example.com",
+		"delete peer-old.example.com",
+	}, z.changes, "Old record should only be destroyed once the new one has been seen")
+}
+
+func TestMigrateRecordsKeepsOldRecordsIfNewOnesAreMissing(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+	z := newFakeZone(f, dnsRecord{Type: "A", Name: "peer-old.example.com", Content: "10.0.0.1"})
+	z.dropCreates = true
+
+	assert.Error(t, f.util.MigrateRecords("peer-old", "peer-new"))
+	assert.Len(t, z.find("peer-old.example.com"), 1, "Old record should be kept if the new one doesn't show up")
+	assert.False(t, f.requested("DELETE", "/zones/"+fakeZoneId+"/dns_records/rec1"))
+}
@@ -0,0 +1,149 @@
+package cfl
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+// Option configures a Util created with New
+type Option func(*Util) error
+
+// WithAPIKey authenticates with the account email user and its global API key.
+func WithAPIKey(user string, key string) Option {
+	return func(util *Util) error {
+		if user == "" || key == "" {
+			return fmt.Errorf("API key authentication needs both a user and a key")
+		}
+		util.Client.Email = user
+		util.Client.Token = key
+		util.hasCredentials = true
+		return nil
+	}
+}
+
+// WithCredentialSource authenticates with the account email and global API
+// key that source returns, asking it for every request so that they can
+// change while the Util is in use. source should cache them.
+func WithCredentialSource(source CredentialSource) Option {
+	return func(util *Util) error {
+		if source == nil {
+			return fmt.Errorf("Credential source is nil")
+		}
+		util.credentials = source
+		util.hasCredentials = true
+		return nil
+	}
+}
+
+// WithAPIToken authenticates v4 API requests with a scoped API token. Util
+// only uses the v4 API, but the client API doesn't support tokens, so using
+// Util.Client directly still needs WithAPIKey.
+func WithAPIToken(token string) Option {
+	return func(util *Util) error {
+		if token == "" {
+			return fmt.Errorf("API token is empty")
+		}
+		util.apiToken = token
+		util.hasCredentials = true
+		return nil
+	}
+}
+
+// WithHTTPClient makes all requests go through c instead of the default client.
+func WithHTTPClient(c *http.Client) Option {
+	return func(util *Util) error {
+		if c == nil {
+			return fmt.Errorf("HTTP client is nil")
+		}
+		util.Client.Http = c
+		return nil
+	}
+}
+
+// WithRateLimit limits requests to CloudFlare (through either API) to rps per
+// second, delaying requests that would exceed it. It's a WithBudget without
+// bursts whose requests wait as long as it takes.
+func WithRateLimit(rps float64) Option {
+	return func(util *Util) error {
+		if rps <= 0 {
+			return fmt.Errorf("Rate limit must be positive, not %v", rps)
+		}
+		return WithBudget(NewBudget(rps, 1), math.MaxInt64)(util)
+	}
+}
+
+// WithBudget makes every request to CloudFlare (through either API) take a
+// token from budget, which can be shared with other Utils. Requests wait up to
+// wait for a token and fail with ErrBudgetExhausted if there's none, see
+// IsBudgetExhausted.
+func WithBudget(budget *Budget, wait time.Duration) Option {
+	return func(util *Util) error {
+		if budget == nil {
+			return fmt.Errorf("Budget is nil")
+		}
+		util.budget = budget
+		util.budgetWait = wait
+		return nil
+	}
+}
+
+// WithDryRun, if dryRun is true, makes the Util log the changes it would make
+// instead of making them. Lookups still go to CloudFlare.
+func WithDryRun(dryRun bool) Option {
+	return func(util *Util) error {
+		util.DryRun = dryRun
+		return nil
+	}
+}
+
+// WithTags attaches tags to every record the Util creates (see FilterTagged).
+func WithTags(tags map[string]string) Option {
+	return func(util *Util) error {
+		util.Tags = tags
+		return nil
+	}
+}
+
+// WithTracing traces every request to CloudFlare in a span started by tp and
+// propagates it in the request's headers (see TracingTransport).
+func WithTracing(tp TracerProvider) Option {
+	return func(util *Util) error {
+		if tp == nil {
+			return fmt.Errorf("TracerProvider is nil")
+		}
+		util.tracerProvider = tp
+		return nil
+	}
+}
+
+// WithTelemetry records the last 50 calls to CloudFlare for debugging, which
+// Telemetry returns.
+func WithTelemetry() Option {
+	return func(util *Util) error {
+		util.withTelemetry = true
+		return nil
+	}
+}
+
+// WithRecordComment includes comment in the comment of every record the Util
+// creates.
+func WithRecordComment(comment string) Option {
+	return func(util *Util) error {
+		util.RecordComment = comment
+		return nil
+	}
+}
+
+// WithMaxRetryAfter caps how long the Util waits before retrying when
+// CloudFlare's Retry-After asks for longer, defaultMaxRetryAfter by default.
+func WithMaxRetryAfter(max time.Duration) Option {
+	return func(util *Util) error {
+		if max <= 0 {
+			return fmt.Errorf("Max Retry-After must be positive, not %v", max)
+		}
+		util.maxRetryAfter = max
+		return nil
+	}
+}
@@ -0,0 +1,160 @@
+package cfl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getlantern/testify/assert"
+)
+
+// headerRecorder is a v4 API that answers every request with an empty success
+// and remembers the requests' headers.
+type headerRecorder struct {
+	*httptest.Server
+	headers []http.Header
+	mutex   sync.Mutex
+}
+
+func newHeaderRecorder() *headerRecorder {
+	r := &headerRecorder{}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		r.mutex.Lock()
+		r.headers = append(r.headers, req.Header)
+		r.mutex.Unlock()
+		resp.Header().Set("Content-Type", "application/json")
+		resp.Write([]byte(`{"success": true, "result": {}}`))
+	}))
+	return r
+}
+
+func (r *headerRecorder) count() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.headers)
+}
+
+func newRecordedUtil(t *testing.T, r *headerRecorder, opts ...Option) *Util {
+	u, err := New("example.com", opts...)
+	if err != nil {
+		t.Fatalf("Unable to create util: %v", err)
+	}
+	u.V4URL = r.URL
+	return u
+}
+
+func TestNewRequiresCredentials(t *testing.T) {
+	_, err := New("example.com")
+	assert.Error(t, err, "Util without credentials should be rejected")
+	_, err = New("example.com", WithAPIKey("", "key"))
+	assert.Error(t, err, "API key without user should be rejected")
+	_, err = New("example.com", WithAPIToken(""))
+	assert.Error(t, err, "Empty token should be rejected")
+	_, err = New("example.com", WithAPIKey("user@example.com", "key"), WithRateLimit(0))
+	assert.Error(t, err, "Non-positive rate limit should be rejected")
+	_, err = New("example.com", WithAPIKey("user@example.com", "key"), WithBudget(nil, time.Second))
+	assert.Error(t, err, "Nil budget should be rejected")
+	_, err = New("example.com", WithAPIKey("user@example.com", "key"), WithHTTPClient(nil))
+	assert.Error(t, err, "Nil client should be rejected")
+}
+
+func TestWithAPIKey(t *testing.T) {
+	r := newHeaderRecorder()
+	defer r.Close()
+	u := newRecordedUtil(t, r, WithAPIKey("user@example.com", "key"))
+	if assert.NoError(t, u.v4Request("GET", "/user", nil, nil)) {
+		assert.Equal(t, "user@example.com", r.headers[0].Get("X-Auth-Email"))
+		assert.Equal(t, "key", r.headers[0].Get("X-Auth-Key"))
+		assert.Equal(t, "", r.headers[0].Get("Authorization"))
+	}
+}
+
+func TestWithAPIToken(t *testing.T) {
+	r := newHeaderRecorder()
+	defer r.Close()
+	u := newRecordedUtil(t, r, WithAPIToken("token"))
+	if assert.NoError(t, u.v4Request("GET", "/user", nil, nil)) {
+		assert.Equal(t, "Bearer token", r.headers[0].Get("Authorization"))
+		assert.Equal(t, "", r.headers[0].Get("X-Auth-Key"))
+	}
+}
+
+type countingTransport struct {
+	count int
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.count++
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestWithHTTPClient(t *testing.T) {
+	r := newHeaderRecorder()
+	defer r.Close()
+	rt := &countingTransport{}
+	c := &http.Client{Transport: rt}
+	u := newRecordedUtil(t, r, WithAPIKey("user@example.com", "key"), WithHTTPClient(c))
+	assert.NoError(t, u.v4Request("GET", "/user", nil, nil))
+	assert.Equal(t, 1, rt.count, "Request should have gone through the given client")
+}
+
+func TestWithRateLimit(t *testing.T) {
+	r := newHeaderRecorder()
+	defer r.Close()
+	c := &http.Client{}
+	u := newRecordedUtil(t, r, WithAPIKey("user@example.com", "key"), WithHTTPClient(c), WithRateLimit(50))
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, u.v4Request("GET", "/user", nil, nil))
+	}
+	assert.True(t, time.Since(start) >= 80*time.Millisecond, "5 requests at 50 per second should take at least 80ms, took %v", time.Since(start))
+	assert.Nil(t, c.Transport, "Client passed in shouldn't have been modified")
+}
+
+func TestWithDryRun(t *testing.T) {
+	r := newHeaderRecorder()
+	defer r.Close()
+	u := newRecordedUtil(t, r, WithAPIKey("user@example.com", "key"), WithDryRun(true))
+	u.cachedZoneId = "zone"
+
+	assert.NoError(t, u.patchDnsRecord("rec1", map[string]interface{}{"ttl": 120}))
+	assert.NoError(t, u.DestroyRecordById("rec1"))
+	rec, proxying, err := u.EnsureRegistered("fl-us-1", "1.2.3.4", nil)
+	if assert.NoError(t, err) {
+		assert.True(t, proxying)
+		assert.Equal(t, "1.2.3.4", rec.Value)
+	}
+	assert.Equal(t, 0, r.count(), "Dry run shouldn't make changes")
+
+	assert.NoError(t, u.v4Request("GET", "/user", nil, nil))
+	assert.Equal(t, 1, r.count(), "Dry run should still look things up")
+}
+
+func TestWithTags(t *testing.T) {
+	u, err := New("example.com", WithAPIKey("user@example.com", "key"), WithTags(map[string]string{"env": "staging"}))
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]string{"env": "staging"}, u.Tags)
+	}
+}
+
+func TestWithMaxRetryAfter(t *testing.T) {
+	u, err := New("example.com", WithAPIKey("user@example.com", "key"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, defaultMaxRetryAfter, u.maxRetryAfter)
+	}
+	u, err = New("example.com", WithAPIKey("user@example.com", "key"), WithMaxRetryAfter(5*time.Second))
+	if assert.NoError(t, err) {
+		assert.Equal(t, 5*time.Second, u.maxRetryAfter)
+	}
+	_, err = New("example.com", WithAPIKey("user@example.com", "key"), WithMaxRetryAfter(0))
+	assert.Error(t, err, "Non-positive max should be rejected")
+}
+
+func TestNewLegacy(t *testing.T) {
+	u := NewLegacy("example.com", "user@example.com", "key")
+	assert.Equal(t, "user@example.com", u.Client.Email)
+	assert.Equal(t, "key", u.Client.Token)
+	assert.Equal(t, defaultV4URL, u.V4URL)
+}
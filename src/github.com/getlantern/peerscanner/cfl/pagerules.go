@@ -0,0 +1,69 @@
+package cfl
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// PageRule is a page rule of our zone. Targets are the URL patterns that it
+// matches, like *.example.com/*, and Status is active or disabled. Rules with
+// a higher Priority take precedence.
+type PageRule struct {
+	Id       string           `json:"id"`
+	Targets  []string         `json:"targets"`
+	Actions  []PageRuleAction `json:"actions"`
+	Priority int              `json:"priority"`
+	Status   string           `json:"status"`
+}
+
+// PageRuleAction is a setting that a page rule applies, like forwarding_url or
+// cache_level. Value is as CloudFlare specifies it, which depends on the
+// action.
+type PageRuleAction struct {
+	Id    string          `json:"id"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// pageRuleResult is the v4 API's representation of a PageRule
+type pageRuleResult struct {
+	Id      string `json:"id"`
+	Targets []struct {
+		Target     string `json:"target"`
+		Constraint struct {
+			Operator string `json:"operator"`
+			Value    string `json:"value"`
+		} `json:"constraint"`
+	} `json:"targets"`
+	Actions  []PageRuleAction `json:"actions"`
+	Priority int              `json:"priority"`
+	Status   string           `json:"status"`
+}
+
+// GetPageRules lists the page rules of our zone, in the order that CloudFlare
+// applies them.
+func (util *Util) GetPageRules() ([]PageRule, error) {
+	zone, err := util.zoneId()
+	if err != nil {
+		return nil, err
+	}
+	var results []pageRuleResult
+	err = util.v4Request("GET", "/zones/"+zone+"/pagerules?order=priority&direction=desc", nil, &results)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get page rules: %v", err)
+	}
+	rules := make([]PageRule, 0, len(results))
+	for _, result := range results {
+		r := PageRule{
+			Id:       result.Id,
+			Targets:  make([]string, 0, len(result.Targets)),
+			Actions:  result.Actions,
+			Priority: result.Priority,
+			Status:   result.Status,
+		}
+		for _, t := range result.Targets {
+			r.Targets = append(r.Targets, t.Constraint.Value)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
@@ -0,0 +1,79 @@
+package cfl
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+// pageRules is an abbreviated response from GET /zones/{id}/pagerules
+const pageRules = `[
+	{
+		"id": "9a7806061c88ada191ed06f989cc3dac",
+		"targets": [{"target": "url", "constraint": {"operator": "matches", "value": "*.example.com/images/*"}}],
+		"actions": [{"id": "forwarding_url", "value": {"url": "https://example.com/", "status_code": 302}}],
+		"priority": 2,
+		"status": "active",
+		"created_on": "2026-01-01T05:20:00Z",
+		"modified_on": "2026-01-01T05:20:00Z"
+	},
+	{
+		"id": "2b8a7ef0b1dd8d2a2cd1f1ba0c3f9e11",
+		"targets": [{"target": "url", "constraint": {"operator": "matches", "value": "peer-abc.example.com/*"}}],
+		"actions": [{"id": "always_use_https"}, {"id": "cache_level", "value": "bypass"}],
+		"priority": 1,
+		"status": "disabled",
+		"created_on": "2026-01-01T05:20:00Z",
+		"modified_on": "2026-01-01T05:20:00Z"
+	}
+]`
+
+func TestGetPageRules(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+	f.handle("GET", "/zones/"+fakeZoneId+"/pagerules", func(body []byte) (int, interface{}) {
+		return 200, json.RawMessage(pageRules)
+	})
+
+	rules, err := f.util.GetPageRules()
+	if assert.NoError(t, err) && assert.Len(t, rules, 2) {
+		assert.Equal(t, "9a7806061c88ada191ed06f989cc3dac", rules[0].Id)
+		assert.Equal(t, []string{"*.example.com/images/*"}, rules[0].Targets)
+		if assert.Len(t, rules[0].Actions, 1) {
+			assert.Equal(t, "forwarding_url", rules[0].Actions[0].Id)
+			assert.Equal(t, `{"url":"https://example.com/","status_code":302}`, string(rules[0].Actions[0].Value))
+		}
+		assert.Equal(t, 2, rules[0].Priority)
+		assert.Equal(t, "active", rules[0].Status)
+
+		assert.Equal(t, []string{"peer-abc.example.com/*"}, rules[1].Targets)
+		assert.Equal(t, []PageRuleAction{
+			{Id: "always_use_https"},
+			{Id: "cache_level", Value: json.RawMessage(`"bypass"`)},
+		}, rules[1].Actions)
+		assert.Equal(t, "disabled", rules[1].Status)
+	}
+	assert.Equal(t, "priority", f.query("GET", "/zones/"+fakeZoneId+"/pagerules").Get("order"))
+}
+
+func TestGetPageRulesNone(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+	f.handle("GET", "/zones/"+fakeZoneId+"/pagerules", func(body []byte) (int, interface{}) {
+		return 200, []interface{}{}
+	})
+
+	rules, err := f.util.GetPageRules()
+	if assert.NoError(t, err) {
+		assert.Len(t, rules, 0)
+	}
+}
+
+func TestGetPageRulesFails(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+
+	_, err := f.util.GetPageRules()
+	assert.Error(t, err, "Getting page rules should fail without the endpoint")
+}
@@ -0,0 +1,15 @@
+package cfl
+
+import (
+	"fmt"
+)
+
+// Probe makes the cheapest request that exercises the DNS API (listing a
+// single record), for monitoring how well CloudFlare's API is doing.
+func (util *Util) Probe() error {
+	zone, err := util.zoneId()
+	if err != nil {
+		return err
+	}
+	return util.v4Request("GET", fmt.Sprintf("/zones/%v/dns_records?per_page=1", zone), nil, nil)
+}
@@ -0,0 +1,19 @@
+package cfl
+
+import (
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestProbe(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+	assert.Error(t, f.util.Probe(), "Probe should fail when the API does")
+
+	f.handle("GET", "/zones/"+fakeZoneId+"/dns_records", func(body []byte) (int, interface{}) {
+		return 200, []dnsRecord{}
+	})
+	assert.NoError(t, f.util.Probe())
+	assert.True(t, f.requested("GET", "/zones/"+fakeZoneId+"/dns_records"))
+}
@@ -0,0 +1,127 @@
+package cfl
+
+import (
+	"fmt"
+)
+
+const (
+	rateLimitPhase = "http_ratelimit"
+)
+
+type ruleset struct {
+	Id    string `json:"id"`
+	Rules []rule `json:"rules"`
+}
+
+type rule struct {
+	Id          string     `json:"id,omitempty"`
+	Description string     `json:"description"`
+	Expression  string     `json:"expression"`
+	Action      string     `json:"action"`
+	RateLimit   *rateLimit `json:"ratelimit,omitempty"`
+}
+
+type rateLimit struct {
+	Characteristics   []string `json:"characteristics"`
+	Period            int      `json:"period"`
+	RequestsPerPeriod int      `json:"requests_per_period"`
+	MitigationTimeout int      `json:"mitigation_timeout"`
+}
+
+// CreateRateLimitRule adds a rule to our zone's rate limiting ruleset that
+// blocks ip at the CloudFlare edge once it makes more than threshold requests
+// within period seconds.
+func (util *Util) CreateRateLimitRule(ip string, threshold int, period int) error {
+	zone, err := util.zoneId()
+	if err != nil {
+		return err
+	}
+	r := rule{
+		Description: rateLimitRuleDescription(ip),
+		Expression:  fmt.Sprintf("(ip.src eq %v)", ip),
+		Action:      "block",
+		RateLimit: &rateLimit{
+			Characteristics:   []string{"ip.src", "cf.colo.id"},
+			Period:            period,
+			RequestsPerPeriod: threshold,
+			MitigationTimeout: period,
+		},
+	}
+
+	rs, err := util.rateLimitRuleset(zone)
+	if err != nil {
+		return err
+	}
+	if rs == nil {
+		log.Debugf("Creating rate limiting ruleset for %v", util.domain)
+		return util.v4Request("PUT", entrypointPath(zone, rateLimitPhase), &ruleset{Rules: []rule{r}}, nil)
+	}
+	if findRule(rs, r.Description) != nil {
+		log.Debugf("Rate limiting rule for %v already exists", ip)
+		return nil
+	}
+	return util.v4Request("POST", fmt.Sprintf("/zones/%v/rulesets/%v/rules", zone, rs.Id), &r, nil)
+}
+
+// DeleteRateLimitRule removes the rule created for ip by CreateRateLimitRule,
+// if there is one.
+func (util *Util) DeleteRateLimitRule(ip string) error {
+	zone, err := util.zoneId()
+	if err != nil {
+		return err
+	}
+	rs, err := util.rateLimitRuleset(zone)
+	if err != nil {
+		return err
+	}
+	if rs == nil {
+		return nil
+	}
+	r := findRule(rs, rateLimitRuleDescription(ip))
+	if r == nil {
+		log.Debugf("No rate limiting rule for %v", ip)
+		return nil
+	}
+	return util.v4Request("DELETE", fmt.Sprintf("/zones/%v/rulesets/%v/rules/%v", zone, rs.Id, r.Id), nil, nil)
+}
+
+// rateLimitRuleset gets the zone's rate limiting ruleset, or nil if it
+// doesn't have one yet.
+func (util *Util) rateLimitRuleset(zone string) (*ruleset, error) {
+	rs, err := util.phaseRuleset(zone, rateLimitPhase)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get rate limiting ruleset: %v", err)
+	}
+	return rs, nil
+}
+
+// phaseRuleset gets the zone's entry point ruleset for the given phase, or
+// nil if it doesn't have one yet.
+func (util *Util) phaseRuleset(zone string, phase string) (*ruleset, error) {
+	rs := &ruleset{}
+	err := util.v4Request("GET", entrypointPath(zone, phase), nil, rs)
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+func findRule(rs *ruleset, description string) *rule {
+	for i, r := range rs.Rules {
+		if r.Description == description {
+			return &rs.Rules[i]
+		}
+	}
+	return nil
+}
+
+func entrypointPath(zone string, phase string) string {
+	return fmt.Sprintf("/zones/%v/rulesets/phases/%v/entrypoint", zone, phase)
+}
+
+func rateLimitRuleDescription(ip string) string {
+	return "peerscanner: block " + ip
+}
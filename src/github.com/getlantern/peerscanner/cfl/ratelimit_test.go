@@ -0,0 +1,54 @@
+package cfl
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestRateLimitRules(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+
+	entrypoint := "/zones/" + fakeZoneId + "/rulesets/phases/http_ratelimit/entrypoint"
+	var rs *ruleset
+	f.handle("GET", entrypoint, func(body []byte) (int, interface{}) {
+		if rs == nil {
+			return 404, nil
+		}
+		return 200, rs
+	})
+	f.handle("PUT", entrypoint, func(body []byte) (int, interface{}) {
+		rs = &ruleset{}
+		json.Unmarshal(body, rs)
+		rs.Id = "rs1"
+		for i := range rs.Rules {
+			rs.Rules[i].Id = "rule1"
+		}
+		return 200, rs
+	})
+	f.handle("DELETE", "/zones/"+fakeZoneId+"/rulesets/rs1/rules/rule1", func(body []byte) (int, interface{}) {
+		rs.Rules = nil
+		return 200, rs
+	})
+
+	if !assert.NoError(t, f.util.CreateRateLimitRule("1.2.3.4", 100, 60), "Should be able to create rule") {
+		return
+	}
+	if assert.NotNil(t, rs, "Ruleset should have been created") && assert.Len(t, rs.Rules, 1) {
+		r := rs.Rules[0]
+		assert.Equal(t, "(ip.src eq 1.2.3.4)", r.Expression)
+		assert.Equal(t, "block", r.Action)
+		assert.Equal(t, 100, r.RateLimit.RequestsPerPeriod)
+		assert.Equal(t, 60, r.RateLimit.Period)
+	}
+
+	assert.NoError(t, f.util.CreateRateLimitRule("1.2.3.4", 100, 60), "Creating existing rule should succeed")
+	assert.False(t, f.requested("POST", "/zones/"+fakeZoneId+"/rulesets/rs1/rules"), "Existing rule shouldn't have been added again")
+
+	assert.NoError(t, f.util.DeleteRateLimitRule("5.6.7.8"), "Deleting unknown rule should succeed")
+	assert.Len(t, rs.Rules, 1, "Unknown rule shouldn't have deleted anything")
+	assert.NoError(t, f.util.DeleteRateLimitRule("1.2.3.4"), "Should be able to delete rule")
+	assert.Len(t, rs.Rules, 0, "Rule should have been deleted")
+}
@@ -0,0 +1,236 @@
+package cfl
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/getlantern/cloudflare"
+)
+
+const (
+	// reconcileBatchSize is the maximum number of operations sent in a single
+	// batch request.
+	reconcileBatchSize = 50
+)
+
+// OpType is the kind of change BulkReconcile makes to a record
+type OpType string
+
+const (
+	OpCreate OpType = "create"
+	OpUpdate OpType = "update"
+	OpDelete OpType = "delete"
+)
+
+// RecordSpec describes a record that should exist. Name is relative to the
+// zone, like cloudflare.Record.Name. Id is only set for records that already
+// exist.
+type RecordSpec struct {
+	Id    string
+	Type  string
+	Name  string
+	Value string
+	// Ttl in seconds, 0 means don't care
+	Ttl int
+}
+
+func (s RecordSpec) key() string {
+	return s.Type + " " + s.Name + " " + s.Value
+}
+
+// Op is a change that BulkReconcile made
+type Op struct {
+	Type   OpType
+	Record RecordSpec
+}
+
+// BulkReconcile makes our zone's records match desired, creating, updating
+// and deleting records in batches rather than one call at a time. actual is
+// the current list of records, as returned by GetAllRecords. It returns the
+// operations that were executed successfully.
+func (util *Util) BulkReconcile(desired []RecordSpec, actual []cloudflare.Record) ([]Op, error) {
+	ops := diffRecords(desired, actual)
+	if len(ops) == 0 {
+		return ops, nil
+	}
+
+	zone, err := util.zoneId()
+	if err != nil {
+		return nil, err
+	}
+
+	var creates, updates, deletes []Op
+	for _, op := range ops {
+		switch op.Type {
+		case OpCreate:
+			creates = append(creates, op)
+		case OpUpdate:
+			updates = append(updates, op)
+		case OpDelete:
+			deletes = append(deletes, op)
+		}
+	}
+
+	done := make([]Op, 0, len(ops))
+	for _, group := range [][]Op{creates, updates, deletes} {
+		for len(group) > 0 {
+			n := reconcileBatchSize
+			if n > len(group) {
+				n = len(group)
+			}
+			batch := group[:n]
+			group = group[n:]
+			err := util.executeBatch(zone, batch)
+			if err != nil {
+				return done, fmt.Errorf("Unable to execute batch of %d %v operations: %v", len(batch), batch[0].Type, err)
+			}
+			done = append(done, batch...)
+		}
+	}
+	return done, nil
+}
+
+// diffRecords computes the operations needed to turn actual into desired,
+// sorted by name and value.
+func diffRecords(desired []RecordSpec, actual []cloudflare.Record) []Op {
+	existing := make(map[string]cloudflare.Record, len(actual))
+	for _, r := range actual {
+		existing[specFor(r).key()] = r
+	}
+
+	var ops []Op
+	wanted := make(map[string]bool, len(desired))
+	for _, spec := range desired {
+		key := spec.key()
+		if wanted[key] {
+			continue
+		}
+		wanted[key] = true
+		current, found := existing[key]
+		if !found {
+			ops = append(ops, Op{OpCreate, spec})
+		} else if RecordNeedsUpdate(current, recordFor(spec)) {
+			spec.Id = current.Id
+			ops = append(ops, Op{OpUpdate, spec})
+		}
+	}
+	for key, r := range existing {
+		if !wanted[key] {
+			ops = append(ops, Op{OpDelete, specFor(r)})
+		}
+	}
+
+	sort.Sort(byNameAndValue(ops))
+	return ops
+}
+
+func specFor(r cloudflare.Record) RecordSpec {
+	ttl, _ := strconv.Atoi(r.Ttl)
+	return RecordSpec{Id: r.Id, Type: r.Type, Name: r.Name, Value: r.Value, Ttl: ttl}
+}
+
+// recordFor is the opposite of specFor. A Ttl of 0 becomes an empty Ttl.
+func recordFor(s RecordSpec) cloudflare.Record {
+	r := cloudflare.Record{Id: s.Id, Type: s.Type, Name: s.Name, Value: s.Value}
+	if s.Ttl != 0 {
+		r.Ttl = strconv.Itoa(s.Ttl)
+	}
+	return r
+}
+
+// RecordsEqual checks whether a and b have the same name, type, value, ttl
+// and proxied flag. Ids and fields that CloudFlare derives, like FullName,
+// aren't compared.
+func RecordsEqual(a, b cloudflare.Record) bool {
+	return a.Name == b.Name &&
+		a.Type == b.Type &&
+		a.Value == b.Value &&
+		ttlOf(a) == ttlOf(b) &&
+		IsProxied(&a) == IsProxied(&b)
+}
+
+// RecordNeedsUpdate checks whether existing has to be PATCHed to become
+// desired. That's only the case for the same name and type, since changing
+// those makes it a different record, and only for the fields that desired
+// specifies: an empty or 0 Ttl means don't care, and so does a proxied flag
+// that IsProxied doesn't know, like that of a record that doesn't exist yet.
+func RecordNeedsUpdate(existing, desired cloudflare.Record) bool {
+	if existing.Name != desired.Name || existing.Type != desired.Type {
+		return false
+	}
+	if existing.Value != desired.Value {
+		return true
+	}
+	if ttl := ttlOf(desired); ttl != 0 && ttl != ttlOf(existing) {
+		return true
+	}
+	proxied, known := proxiedOf(&desired)
+	return known && proxied != IsProxied(&existing)
+}
+
+func ttlOf(r cloudflare.Record) int {
+	ttl, _ := strconv.Atoi(r.Ttl)
+	return ttl
+}
+
+type batchRecord struct {
+	Id      string `json:"id,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Content string `json:"content,omitempty"`
+	Ttl     int    `json:"ttl,omitempty"`
+	Proxied bool   `json:"proxied,omitempty"`
+	Comment string `json:"comment,omitempty"`
+}
+
+type batchRequest struct {
+	Posts   []batchRecord `json:"posts,omitempty"`
+	Patches []batchRecord `json:"patches,omitempty"`
+	Deletes []batchRecord `json:"deletes,omitempty"`
+}
+
+// executeBatch sends the given operations to the v4 API's batch endpoint.
+// Created records get util.createdComment() and updated ones
+// util.recordComment().
+func (util *Util) executeBatch(zone string, ops []Op) error {
+	var req batchRequest
+	comment := util.recordComment()
+	created := util.createdComment()
+	for _, op := range ops {
+		s := op.Record
+		switch op.Type {
+		case OpCreate:
+			req.Posts = append(req.Posts, batchRecord{Type: s.Type, Name: util.fullName(s.Name), Content: s.Value, Ttl: s.Ttl, Comment: created})
+		case OpUpdate:
+			req.Patches = append(req.Patches, batchRecord{Id: s.Id, Ttl: s.Ttl, Comment: comment})
+		case OpDelete:
+			req.Deletes = append(req.Deletes, batchRecord{Id: s.Id})
+		}
+	}
+	err := util.v4Request("POST", fmt.Sprintf("/zones/%v/dns_records/batch", zone), &req, nil)
+	if err == nil {
+		for _, d := range req.Deletes {
+			forgetProxied(d.Id)
+		}
+	}
+	return err
+}
+
+func (util *Util) fullName(name string) string {
+	if name == "" || name == "@" {
+		return util.domain
+	}
+	return name + "." + util.domain
+}
+
+type byNameAndValue []Op
+
+func (a byNameAndValue) Len() int      { return len(a) }
+func (a byNameAndValue) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byNameAndValue) Less(i, j int) bool {
+	if a[i].Record.Name != a[j].Record.Name {
+		return a[i].Record.Name < a[j].Record.Name
+	}
+	return a[i].Record.Value < a[j].Record.Value
+}
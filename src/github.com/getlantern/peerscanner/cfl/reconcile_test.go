@@ -0,0 +1,105 @@
+package cfl
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/getlantern/cloudflare"
+	"github.com/getlantern/testify/assert"
+)
+
+func TestBulkReconcile(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+
+	var batches []batchRequest
+	f.handle("POST", "/zones/"+fakeZoneId+"/dns_records/batch", func(body []byte) (int, interface{}) {
+		var req batchRequest
+		json.Unmarshal(body, &req)
+		batches = append(batches, req)
+		return 200, map[string]interface{}{}
+	})
+
+	// 100 records to keep, 100 to add and 100 to remove
+	var desired []RecordSpec
+	var actual []cloudflare.Record
+	for i := 0; i < 100; i++ {
+		desired = append(desired, RecordSpec{Type: "A", Name: "keep", Value: fmt.Sprintf("10.0.0.%d", i)})
+		actual = append(actual, cloudflare.Record{Id: fmt.Sprintf("keep%d", i), Type: "A", Name: "keep", Value: fmt.Sprintf("10.0.0.%d", i)})
+		desired = append(desired, RecordSpec{Type: "A", Name: "add", Value: fmt.Sprintf("10.0.1.%d", i)})
+		actual = append(actual, cloudflare.Record{Id: fmt.Sprintf("remove%d", i), Type: "A", Name: "remove", Value: fmt.Sprintf("10.0.2.%d", i)})
+	}
+
+	ops, err := f.util.BulkReconcile(desired, actual)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, ops, 200, "Should have added 100 and removed 100 records")
+	assert.Len(t, batches, 4, "Should have made exactly 4 batch calls")
+	creates, deletes := 0, 0
+	for _, b := range batches {
+		assert.True(t, len(b.Posts)+len(b.Deletes) <= reconcileBatchSize, "Batch too big")
+		creates += len(b.Posts)
+		deletes += len(b.Deletes)
+		for _, p := range b.Posts {
+			assert.Equal(t, "add.example.com", p.Name)
+		}
+		for _, d := range b.Deletes {
+			assert.Regexp(t, "^remove", d.Id)
+		}
+	}
+	assert.Equal(t, 100, creates)
+	assert.Equal(t, 100, deletes)
+}
+
+func TestDiffRecords(t *testing.T) {
+	desired := []RecordSpec{
+		{Type: "A", Name: "b", Value: "1.1.1.1", Ttl: 120},
+		{Type: "A", Name: "a", Value: "1.1.1.2"},
+		{Type: "A", Name: "a", Value: "1.1.1.2"},
+	}
+	actual := []cloudflare.Record{
+		{Id: "1", Type: "A", Name: "b", Value: "1.1.1.1", Ttl: "300"},
+		{Id: "2", Type: "A", Name: "c", Value: "1.1.1.3", Ttl: "1"},
+	}
+	ops := diffRecords(desired, actual)
+	if assert.Len(t, ops, 3) {
+		assert.Equal(t, Op{OpCreate, RecordSpec{Type: "A", Name: "a", Value: "1.1.1.2"}}, ops[0])
+		assert.Equal(t, Op{OpUpdate, RecordSpec{Id: "1", Type: "A", Name: "b", Value: "1.1.1.1", Ttl: 120}}, ops[1])
+		assert.Equal(t, Op{OpDelete, RecordSpec{Id: "2", Type: "A", Name: "c", Value: "1.1.1.3", Ttl: 1}}, ops[2])
+	}
+
+	f := newFakeV4("example.com")
+	defer f.Close()
+	done, err := f.util.BulkReconcile(desired[:1], actual[:1])
+	assert.Error(t, err, "Failed batch should be reported")
+	assert.Len(t, done, 0, "Failed batch shouldn't be reported as done")
+}
+
+func TestRecordsEqual(t *testing.T) {
+	existing := cloudflare.Record{Id: "1", Type: "A", Name: "roundrobin", Value: "1.1.1.1", Ttl: "300"}
+	setProxied(existing.Id, false)
+	defer forgetProxied(existing.Id)
+	// Desired records without ids have no known proxied flag
+	setProxied("2", true)
+	defer forgetProxied("2")
+	tests := []struct {
+		desc        string
+		desired     cloudflare.Record
+		equal       bool
+		needsUpdate bool
+	}{
+		{"identical", cloudflare.Record{Type: "A", Name: "roundrobin", Value: "1.1.1.1", Ttl: "300"}, true, false},
+		{"ttl change only", cloudflare.Record{Type: "A", Name: "roundrobin", Value: "1.1.1.1", Ttl: "120"}, false, true},
+		{"ip change", cloudflare.Record{Type: "A", Name: "roundrobin", Value: "1.1.1.2", Ttl: "300"}, false, true},
+		{"proxied flag change", cloudflare.Record{Id: "2", Type: "A", Name: "roundrobin", Value: "1.1.1.1", Ttl: "300"}, false, true},
+		{"unspecified ttl and proxied flag", cloudflare.Record{Type: "A", Name: "roundrobin", Value: "1.1.1.1"}, false, false},
+		{"different name", cloudflare.Record{Type: "A", Name: "fallbacks", Value: "1.1.1.1", Ttl: "300"}, false, false},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.equal, RecordsEqual(existing, test.desired), "RecordsEqual: %v", test.desc)
+		assert.Equal(t, test.equal, RecordsEqual(test.desired, existing), "RecordsEqual should be symmetric: %v", test.desc)
+		assert.Equal(t, test.needsUpdate, RecordNeedsUpdate(existing, test.desired), "RecordNeedsUpdate: %v", test.desc)
+	}
+}
@@ -0,0 +1,79 @@
+package cfl
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	// srvPrefix is the service and protocol of the SRV records that Lantern
+	// clients look up
+	srvPrefix = "_lantern._tcp."
+)
+
+// srvData is the data of an SRV record in the v4 API's schema
+type srvData struct {
+	Priority int    `json:"priority"`
+	Weight   int    `json:"weight"`
+	Port     int    `json:"port"`
+	Target   string `json:"target"`
+}
+
+type srvRecord struct {
+	Type    string  `json:"type"`
+	Name    string  `json:"name"`
+	Data    srvData `json:"data"`
+	Comment string  `json:"comment,omitempty"`
+}
+
+// SRVName returns the name (relative to our zone) of the SRV record for the
+// host with the given name.
+func SRVName(name string) string {
+	return srvPrefix + name
+}
+
+// CreateSRVRecord creates a _lantern._tcp.<name> SRV record pointing at target
+// and port with the given priority and weight, so that clients can discover
+// which port to use. It succeeds if the record already exists.
+func (util *Util) CreateSRVRecord(name string, target string, port int, priority int, weight int) error {
+	zone, err := util.zoneId()
+	if err != nil {
+		return err
+	}
+	rec := srvRecord{
+		Type: "SRV",
+		Name: util.fullName(SRVName(name)),
+		Data: srvData{Priority: priority, Weight: weight, Port: port, Target: target},
+	}
+	rec.Comment = util.recordComment()
+	err = util.v4Request("POST", fmt.Sprintf("/zones/%v/dns_records", zone), &rec, nil)
+	if err != nil {
+		if _, ok := err.(*APIError); ok && strings.Contains(strings.ToLower(err.Error()), "already exists") {
+			log.Debugf("SRV record for %v already exists", name)
+			return nil
+		}
+		return fmt.Errorf("Unable to create SRV record for %v: %v", name, err)
+	}
+	return nil
+}
+
+// DestroySRVRecord destroys the SRV records created by CreateSRVRecord for
+// name, if any.
+func (util *Util) DestroySRVRecord(name string) error {
+	zone, err := util.zoneId()
+	if err != nil {
+		return err
+	}
+	recs, err := util.listDnsRecords(url.Values{"type": {"SRV"}, "name": {util.fullName(SRVName(name))}})
+	if err != nil {
+		return err
+	}
+	for _, r := range recs {
+		err := util.v4Request("DELETE", fmt.Sprintf("/zones/%v/dns_records/%v", zone, r.Id), nil, nil)
+		if err != nil && !isNotFound(err) {
+			return fmt.Errorf("Unable to destroy SRV record for %v: %v", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package cfl
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestCreateSRVRecord(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+
+	var payload map[string]interface{}
+	f.handle("POST", "/zones/"+fakeZoneId+"/dns_records", func(body []byte) (int, interface{}) {
+		json.Unmarshal(body, &payload)
+		return 200, map[string]interface{}{"id": "srv1"}
+	})
+
+	err := f.util.CreateSRVRecord("fl-us-1", "fl-us-1.example.com", 443, 10, 5)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "SRV", payload["type"])
+	assert.Equal(t, "_lantern._tcp.fl-us-1.example.com", payload["name"])
+	assert.Equal(t, map[string]interface{}{
+		"priority": float64(10),
+		"weight":   float64(5),
+		"port":     float64(443),
+		"target":   "fl-us-1.example.com",
+	}, payload["data"], "SRV data should have the fields of the v4 schema")
+
+	f.handle("POST", "/zones/"+fakeZoneId+"/dns_records", func(body []byte) (int, interface{}) {
+		return 400, nil
+	})
+	assert.Error(t, f.util.CreateSRVRecord("fl-us-1", "fl-us-1.example.com", 443, 10, 5), "Failed create should be reported")
+}
+
+func TestDestroySRVRecord(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+
+	f.handle("GET", "/zones/"+fakeZoneId+"/dns_records", func(body []byte) (int, interface{}) {
+		return 200, []dnsRecord{{Id: "srv1", Type: "SRV", Name: "_lantern._tcp.fl-us-1.example.com"}}
+	})
+	f.handle("DELETE", "/zones/"+fakeZoneId+"/dns_records/srv1", func(body []byte) (int, interface{}) {
+		return 200, map[string]interface{}{"id": "srv1"}
+	})
+	assert.NoError(t, f.util.DestroySRVRecord("fl-us-1"))
+	assert.True(t, f.requested("DELETE", "/zones/"+fakeZoneId+"/dns_records/srv1"), "SRV record should have been deleted")
+}
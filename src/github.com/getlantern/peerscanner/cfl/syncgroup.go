@@ -0,0 +1,221 @@
+package cfl
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/getlantern/peerscanner/backoff"
+)
+
+const (
+	// syncGroupPropagationTimeout is how long SyncGroup waits for records it
+	// added to show up
+	syncGroupPropagationTimeout = 30 * time.Second
+	// syncGroupLockTtl is how long the zone lock taken by SyncGroup lasts
+	// unless renewed
+	syncGroupLockTtl = 30 * time.Second
+)
+
+var (
+	// retryPolicy is how SyncGroup and BatchCreateRecords retry failed calls
+	retryPolicy = backoff.DefaultCFPolicy
+
+	// sleep is time.Sleep, replaced in tests
+	sleep = time.Sleep
+)
+
+// SyncGroup makes the round robin group with the given name (relative to our
+// zone) contain exactly the A records for desiredIPs. It removes the records
+// that shouldn't be there and then adds the missing ones, in batches. Since
+// every attempt starts by looking at the group's current records, a sync that
+// failed part way through is completed by the next attempt. Transient
+// CloudFlare errors are retried, after the Retry-After that CloudFlare sent
+// if it rate limited us. It holds util.Lock throughout.
+func (util *Util) SyncGroup(groupName string, desiredIPs []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), syncGroupLockTtl)
+	err := util.Lock.TryLock(ctx, syncGroupLockTtl)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("Unable to lock zone to sync group %v: %v", groupName, err)
+	}
+	defer func() {
+		if err := util.Lock.Unlock(); err != nil {
+			log.Errorf("Unable to unlock zone after syncing group %v: %v", groupName, err)
+		}
+	}()
+
+	for attempt := 1; ; attempt++ {
+		err := util.syncGroupOnce(groupName, desiredIPs)
+		if err == nil {
+			return nil
+		}
+		wait := util.retryWait(retryPolicy, attempt, err)
+		if wait == backoff.Stop || !isTransient(err) {
+			return err
+		}
+		log.Debugf("Unable to sync group %v on attempt %d, retrying in %v: %v", groupName, attempt, wait, err)
+		sleep(wait)
+	}
+}
+
+func (util *Util) syncGroupOnce(groupName string, desiredIPs []string) error {
+	zone, err := util.zoneId()
+	if err != nil {
+		return err
+	}
+	existing, err := util.listDnsRecords(url.Values{"type": {"A"}, "name": {util.fullName(groupName)}})
+	if err != nil {
+		return err
+	}
+
+	desired := make(map[string]bool, len(desiredIPs))
+	for _, ip := range desiredIPs {
+		desired[ip] = true
+	}
+	var removes, adds []Op
+	for _, r := range existing {
+		if desired[r.Content] {
+			// Already there, don't add it again
+			delete(desired, r.Content)
+		} else {
+			removes = append(removes, Op{OpDelete, RecordSpec{Id: r.Id, Type: r.Type, Name: groupName, Value: r.Content}})
+		}
+	}
+	for _, ip := range desiredIPs {
+		if desired[ip] {
+			delete(desired, ip)
+			adds = append(adds, Op{OpCreate, RecordSpec{Type: "A", Name: groupName, Value: ip}})
+		}
+	}
+	if len(removes) == 0 && len(adds) == 0 {
+		return nil
+	}
+	log.Debugf("Syncing group %v: removing %d and adding %d records", groupName, len(removes), len(adds))
+
+	errs := &syncErrors{group: groupName}
+	var added []Op
+	for _, ops := range [][]Op{removes, adds} {
+		for len(ops) > 0 {
+			n := reconcileBatchSize
+			if n > len(ops) {
+				n = len(ops)
+			}
+			if err := util.executeBatch(zone, ops[:n]); err != nil {
+				errs.errs = append(errs.errs, err)
+			} else if ops[0].Type == OpCreate {
+				added = append(added, ops[:n]...)
+			}
+			ops = ops[n:]
+		}
+	}
+	util.waitForAdded(added)
+	if len(errs.errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// waitForAdded waits for the records that a sync added to show up, so that the
+// next sync (or a retry) doesn't add them again. Records that don't show up in
+// time are only logged, since they will show up eventually.
+func (util *Util) waitForAdded(added []Op) {
+	var wg sync.WaitGroup
+	for _, op := range added {
+		wg.Add(1)
+		go func(s RecordSpec) {
+			defer wg.Done()
+			if err := util.WaitForRecord(s.Name, s.Value, syncGroupPropagationTimeout); err != nil {
+				log.Debugf("Not waiting any longer: %v", err)
+			}
+		}(op.Record)
+	}
+	wg.Wait()
+}
+
+// syncErrors combines the errors of the batches in a single sync of group. The
+// errors are kept as is so that isTransient can look at them.
+type syncErrors struct {
+	group string
+	errs  []error
+}
+
+func (e *syncErrors) Error() string {
+	msgs := make([]string, 0, len(e.errs))
+	for _, err := range e.errs {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("Unable to sync group %v: %v", e.group, strings.Join(msgs, "; "))
+}
+
+// retryAfterOf returns the longest Retry-After that CloudFlare sent with err,
+// or 0 if it didn't send any.
+func retryAfterOf(err error) time.Duration {
+	if e, ok := err.(*syncErrors); ok {
+		var longest time.Duration
+		for _, err := range e.errs {
+			if retryAfter := retryAfterOf(err); retryAfter > longest {
+				longest = retryAfter
+			}
+		}
+		return longest
+	}
+	if apiErr, ok := err.(*APIError); ok {
+		return apiErr.RetryAfter
+	}
+	return 0
+}
+
+// retryWait is how long to wait after the given failed attempt, which failed
+// with errs, as policy allows, or backoff.Stop if there shouldn't be another
+// one. The longest Retry-After that CloudFlare sent with errs takes precedence
+// over policy's delay.
+func (util *Util) retryWait(policy backoff.Policy, attempt int, errs ...error) time.Duration {
+	wait := policy.Next(attempt)
+	if wait == backoff.Stop {
+		return wait
+	}
+	var longest time.Duration
+	for _, err := range errs {
+		if retryAfter := retryAfterOf(err); retryAfter > longest {
+			longest = retryAfter
+		}
+	}
+	if longest > 0 {
+		return util.jitterRetryAfter(longest)
+	}
+	return wait
+}
+
+// jitterRetryAfter caps retryAfter at util.maxRetryAfter and randomizes it by
+// +/- 5%, so that instances that were rate limited together don't all retry at
+// the same moment.
+func (util *Util) jitterRetryAfter(retryAfter time.Duration) time.Duration {
+	if retryAfter > util.maxRetryAfter {
+		retryAfter = util.maxRetryAfter
+	}
+	return time.Duration(float64(retryAfter) * (1 + 0.05*(2*rand.Float64()-1)))
+}
+
+// isTransient indicates whether err is likely to go away if we try again,
+// which is the case for rate limiting, server errors and errors that happened
+// before CloudFlare's API could respond.
+func isTransient(err error) bool {
+	if e, ok := err.(*syncErrors); ok {
+		for _, err := range e.errs {
+			if !isTransient(err) {
+				return false
+			}
+		}
+		return true
+	}
+	if apiErr, ok := err.(*APIError); ok {
+		return apiErr.Status == http.StatusTooManyRequests || apiErr.Status >= 500
+	}
+	return true
+}
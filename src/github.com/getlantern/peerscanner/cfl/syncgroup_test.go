@@ -0,0 +1,194 @@
+package cfl
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getlantern/testify/assert"
+)
+
+// fakeGroup serves the records of a single group through the fake v4 API,
+// applying batches to them.
+type fakeGroup struct {
+	sync.Mutex
+	name    string
+	records map[string]dnsRecord
+	nextId  int
+	batches int
+	// failBatch, if set, makes the batch with that (1-based) number fail
+	failBatch int
+	// failStatus is the status code with which failBatch fails
+	failStatus int
+}
+
+func newFakeGroup(f *fakeV4, name string, ips ...string) *fakeGroup {
+	g := &fakeGroup{name: name + ".example.com", records: make(map[string]dnsRecord), failStatus: 500}
+	for _, ip := range ips {
+		g.add(ip)
+	}
+	f.handle("GET", "/zones/"+fakeZoneId+"/dns_records", func(body []byte) (int, interface{}) {
+		g.Lock()
+		defer g.Unlock()
+		recs := make([]dnsRecord, 0, len(g.records))
+		for _, r := range g.records {
+			recs = append(recs, r)
+		}
+		return 200, recs
+	})
+	f.handle("POST", "/zones/"+fakeZoneId+"/dns_records/batch", func(body []byte) (int, interface{}) {
+		g.Lock()
+		defer g.Unlock()
+		g.batches++
+		if g.batches == g.failBatch {
+			return g.failStatus, nil
+		}
+		var req batchRequest
+		json.Unmarshal(body, &req)
+		for _, d := range req.Deletes {
+			delete(g.records, d.Id)
+		}
+		for _, p := range req.Posts {
+			g.add(p.Content)
+		}
+		return 200, map[string]interface{}{}
+	})
+	return g
+}
+
+func (g *fakeGroup) add(ip string) {
+	g.nextId++
+	id := fmt.Sprintf("rec%d", g.nextId)
+	g.records[id] = dnsRecord{Id: id, Type: "A", Name: g.name, Content: ip}
+}
+
+func (g *fakeGroup) ips() []string {
+	g.Lock()
+	defer g.Unlock()
+	ips := make([]string, 0, len(g.records))
+	for _, r := range g.records {
+		ips = append(ips, r.Content)
+	}
+	sort.Strings(ips)
+	return ips
+}
+
+func ipRange(prefix string, n int) []string {
+	ips := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		ips = append(ips, fmt.Sprintf("%v%d", prefix, i))
+	}
+	return ips
+}
+
+func TestSyncGroup(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+	g := newFakeGroup(f, "roundrobin", "10.0.0.1", "10.0.0.2", "10.0.0.2")
+
+	err := f.util.SyncGroup("roundrobin", []string{"10.0.0.2", "10.0.0.3"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"10.0.0.2", "10.0.0.3"}, g.ips(), "Group should contain exactly the desired ips, without duplicates")
+	}
+	assert.Equal(t, 2, g.batches, "Should have made one batch of removes and one of adds")
+
+	err = f.util.SyncGroup("roundrobin", []string{"10.0.0.3", "10.0.0.2"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, g.batches, "Group already in sync shouldn't need any batches")
+}
+
+func TestSyncGroupCompletesInterruptedSync(t *testing.T) {
+	defer withoutRetryDelay()()
+	f := newFakeV4("example.com")
+	defer f.Close()
+	remove := ipRange("10.0.1.", reconcileBatchSize+10)
+	g := newFakeGroup(f, "roundrobin", remove...)
+	// The second batch of removes fails, leaving the group half synced
+	g.failBatch = 2
+
+	desired := ipRange("10.0.2.", 20)
+	err := f.util.SyncGroup("roundrobin", desired)
+	if assert.NoError(t, err, "Transient failure should have been retried") {
+		sort.Strings(desired)
+		assert.Equal(t, desired, g.ips(), "Group should end up in the desired state")
+	}
+	assert.Equal(t, 4, g.batches, "Retry should only have redone what the first attempt didn't do")
+}
+
+func TestSyncGroupDoesNotRetryPermanentErrors(t *testing.T) {
+	defer withoutRetryDelay()()
+	f := newFakeV4("example.com")
+	defer f.Close()
+	g := newFakeGroup(f, "roundrobin", "10.0.0.1")
+	g.failBatch = 1
+	g.failStatus = 403
+
+	err := f.util.SyncGroup("roundrobin", []string{"10.0.0.2"})
+	if assert.Error(t, err) {
+		assert.True(t, strings.Contains(err.Error(), "roundrobin"), "Error should mention the group")
+	}
+	assert.Equal(t, 2, g.batches, "Adds should still have been attempted, but not retried")
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2"}, g.ips())
+}
+
+func TestSyncGroupHonorsRetryAfter(t *testing.T) {
+	var slept []time.Duration
+	defer withSleep(func(d time.Duration) { slept = append(slept, d) })()
+	f := newFakeV4("example.com")
+	defer f.Close()
+	f.Config.Handler = http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("Retry-After", "10")
+		f.serve(resp, req)
+	})
+	g := newFakeGroup(f, "roundrobin", "10.0.0.1")
+	g.failBatch = 1
+	g.failStatus = http.StatusTooManyRequests
+
+	err := f.util.SyncGroup("roundrobin", []string{"10.0.0.2"})
+	assert.NoError(t, err, "Rate limited sync should have been retried")
+	if assert.Len(t, slept, 1) {
+		assert.InDelta(t, float64(10*time.Second), float64(slept[0]), float64(500*time.Millisecond), "Retry should happen after Retry-After, give or take 5%%")
+	}
+
+	// Long Retry-Afters are capped
+	slept = nil
+	f.util.maxRetryAfter = 2 * time.Second
+	g.batches = 0
+	err = f.util.SyncGroup("roundrobin", []string{"10.0.0.3"})
+	assert.NoError(t, err)
+	if assert.Len(t, slept, 1) {
+		assert.InDelta(t, float64(2*time.Second), float64(slept[0]), float64(100*time.Millisecond), "Retry-After should be capped at the max")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	assert.Equal(t, 10*time.Second, parseRetryAfter("10"))
+	assert.Equal(t, time.Duration(0), parseRetryAfter(""))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("-1"))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("Wed, 21 Oct 2015 07:28:00 GMT"), "Only seconds are supported")
+}
+
+// withSleep makes SyncGroup call fn instead of sleeping, returning a function
+// that restores time.Sleep.
+func withSleep(fn func(time.Duration)) func() {
+	orig := sleep
+	sleep = fn
+	return func() {
+		sleep = orig
+	}
+}
+
+// withoutRetryDelay makes SyncGroup and BatchCreateRecords retry immediately,
+// returning a function that restores retryPolicy.
+func withoutRetryDelay() func() {
+	orig := retryPolicy
+	retryPolicy.Base = 0
+	return func() {
+		retryPolicy = orig
+	}
+}
@@ -0,0 +1,111 @@
+package cfl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/getlantern/cloudflare"
+)
+
+const (
+	// tagsCommentPrefix marks the part of a record's comment that holds its
+	// tags. We use the comment rather than CloudFlare's tags field, which is
+	// only available for enterprise zones.
+	tagsCommentPrefix = "tags: "
+)
+
+// ParseTags parses comma-separated key=value pairs, like
+// "env=staging,team=ops".
+func ParseTags(s string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("Invalid tag %v, expected key=value", pair)
+		}
+		tags[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return tags, nil
+}
+
+// FormatTags formats tags the way ParseTags expects them, sorted by key.
+func FormatTags(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for k, v := range tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// recordComment returns the comment for records we create, made up of
+// util.RecordComment and util.Tags (in that order, separated by "; ").
+func (util *Util) recordComment() string {
+	var parts []string
+	if util.RecordComment != "" {
+		parts = append(parts, util.RecordComment)
+	}
+	if len(util.Tags) > 0 {
+		parts = append(parts, tagsCommentPrefix+FormatTags(util.Tags))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// tagsFromComment extracts the tags from a record comment written by
+// recordComment.
+func tagsFromComment(comment string) map[string]string {
+	if i := strings.LastIndex(comment, "; "+tagsCommentPrefix); i >= 0 {
+		comment = comment[i+2:]
+	}
+	if !strings.HasPrefix(comment, tagsCommentPrefix) {
+		return nil
+	}
+	tags, err := ParseTags(strings.TrimPrefix(comment, tagsCommentPrefix))
+	if err != nil {
+		log.Debugf("Ignoring invalid tags in comment '%v': %v", comment, err)
+		return nil
+	}
+	return tags
+}
+
+// hasTags indicates whether tags includes all of util.Tags
+func (util *Util) hasTags(tags map[string]string) bool {
+	for k, v := range util.Tags {
+		if actual, found := tags[k]; !found || actual != v {
+			return false
+		}
+	}
+	return true
+}
+
+// FilterTagged returns those of recs that are tagged with all of util.Tags.
+func (util *Util) FilterTagged(recs []cloudflare.Record) ([]cloudflare.Record, error) {
+	if len(util.Tags) == 0 {
+		return recs, nil
+	}
+	dnsRecs, err := util.listDnsRecords(nil)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to look up record tags: %v", err)
+	}
+	tagged := make(map[string]bool, len(dnsRecs))
+	for _, r := range dnsRecs {
+		if util.hasTags(tagsFromComment(r.Comment)) {
+			tagged[r.Type+" "+r.Name+" "+r.Content] = true
+		}
+	}
+
+	filtered := make([]cloudflare.Record, 0, len(recs))
+	for _, r := range recs {
+		if tagged[r.Type+" "+r.FullName+" "+r.Value] {
+			filtered = append(filtered, r)
+		} else {
+			log.Tracef("Skipping record without tags %v: %v", FormatTags(util.Tags), r.FullName)
+		}
+	}
+	return filtered, nil
+}
@@ -0,0 +1,44 @@
+package cfl
+
+import (
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestParseTags(t *testing.T) {
+	tags, err := ParseTags(" env=staging, team=ops,, note=a=b")
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]string{"env": "staging", "team": "ops", "note": "a=b"}, tags)
+		assert.Equal(t, "env=staging,note=a=b,team=ops", FormatTags(tags))
+	}
+	tags, err = ParseTags("")
+	if assert.NoError(t, err) {
+		assert.Len(t, tags, 0)
+	}
+	_, err = ParseTags("env")
+	assert.Error(t, err, "Tag without value should be rejected")
+	_, err = ParseTags("=staging")
+	assert.Error(t, err, "Tag without key should be rejected")
+}
+
+func TestHasTags(t *testing.T) {
+	u := &Util{Tags: map[string]string{"env": "staging"}}
+	assert.True(t, u.hasTags(tagsFromComment("tags: env=staging,team=ops")))
+	assert.False(t, u.hasTags(tagsFromComment("tags: env=production")))
+	assert.False(t, u.hasTags(tagsFromComment("created by hand")))
+	assert.True(t, (&Util{}).hasTags(nil), "Without tags, everything matches")
+}
+
+func TestRecordComment(t *testing.T) {
+	u := &Util{}
+	assert.Equal(t, "", u.recordComment())
+	u.RecordComment = "peerscanner/1.2.3@ps-1"
+	assert.Equal(t, "peerscanner/1.2.3@ps-1", u.recordComment())
+	assert.Nil(t, tagsFromComment(u.recordComment()))
+	u.Tags = map[string]string{"env": "staging"}
+	assert.Equal(t, "peerscanner/1.2.3@ps-1; tags: env=staging", u.recordComment())
+	assert.True(t, u.hasTags(tagsFromComment(u.recordComment())), "Tags should be found after the record comment")
+	u.RecordComment = ""
+	assert.Equal(t, "tags: env=staging", u.recordComment())
+}
@@ -0,0 +1,102 @@
+package cfl
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// telemetrySize is how many calls WithTelemetry remembers
+	telemetrySize = 50
+)
+
+// TelemetryEntry describes a single call to CloudFlare. Status is 0 if the
+// call didn't get a response, in which case Error says why.
+type TelemetryEntry struct {
+	Time     time.Time     `json:"time"`
+	Method   string        `json:"method"`
+	URL      string        `json:"url"`
+	Status   int           `json:"status"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// telemetryTransport records the last telemetrySize calls that go through it.
+// It is safe for concurrent use.
+type telemetryTransport struct {
+	rt      http.RoundTripper
+	entries [telemetrySize]TelemetryEntry
+	// head is where the next entry goes
+	head  int
+	count int
+	mutex sync.Mutex
+}
+
+func newTelemetryTransport(rt http.RoundTripper) *telemetryTransport {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &telemetryTransport{rt: rt}
+}
+
+func (t *telemetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.rt.RoundTrip(req)
+	entry := TelemetryEntry{Time: start, Method: req.Method, URL: redactedURL(req), Duration: time.Since(start)}
+	if err != nil {
+		entry.Error = err.Error()
+	} else {
+		entry.Status = resp.StatusCode
+	}
+	t.add(entry)
+	return resp, err
+}
+
+func (t *telemetryTransport) add(entry TelemetryEntry) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.entries[t.head] = entry
+	t.head = (t.head + 1) % len(t.entries)
+	if t.count < len(t.entries) {
+		t.count++
+	}
+}
+
+// snapshot returns the recorded entries, oldest first.
+func (t *telemetryTransport) snapshot() []TelemetryEntry {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	result := make([]TelemetryEntry, 0, t.count)
+	start := (t.head - t.count + len(t.entries)) % len(t.entries)
+	for i := 0; i < t.count; i++ {
+		result = append(result, t.entries[(start+i)%len(t.entries)])
+	}
+	return result
+}
+
+// redactedURL is the URL of req without the credentials that the client API
+// puts in the query.
+func redactedURL(req *http.Request) string {
+	q := req.URL.Query()
+	if q.Get("tkn") == "" && q.Get("email") == "" {
+		return req.URL.String()
+	}
+	for _, param := range []string{"tkn", "email"} {
+		if q.Get(param) != "" {
+			q.Set(param, "REDACTED")
+		}
+	}
+	u := *req.URL
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// Telemetry returns the last 50 calls that the Util made to CloudFlare, oldest
+// first, if it was created WithTelemetry.
+func (util *Util) Telemetry() []TelemetryEntry {
+	if util.telemetry == nil {
+		return nil
+	}
+	return util.telemetry.snapshot()
+}
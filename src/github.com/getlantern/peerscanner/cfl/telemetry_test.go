@@ -0,0 +1,71 @@
+package cfl
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestTelemetry(t *testing.T) {
+	r := newHeaderRecorder()
+	defer r.Close()
+	u := newRecordedUtil(t, r, WithAPIKey("user@example.com", "key"), WithTelemetry())
+	for i := 0; i < 60; i++ {
+		assert.NoError(t, u.v4Request("GET", fmt.Sprintf("/zones/%d", i), nil, nil))
+	}
+	entries := u.Telemetry()
+	if assert.Len(t, entries, telemetrySize, "Only the last 50 calls should be kept") {
+		for i, e := range entries {
+			assert.Equal(t, fmt.Sprintf("%v/zones/%d", r.URL, i+10), e.URL, "Entries should be the latest calls, oldest first")
+			assert.Equal(t, "GET", e.Method)
+			assert.Equal(t, http.StatusOK, e.Status)
+			assert.Equal(t, "", e.Error)
+		}
+	}
+
+	plain := newRecordedUtil(t, r, WithAPIKey("user@example.com", "key"))
+	plain.v4Request("GET", "/zones", nil, nil)
+	assert.Nil(t, plain.Telemetry(), "Util without telemetry shouldn't record calls")
+}
+
+func TestTelemetryRecordsFailures(t *testing.T) {
+	u, err := New("example.com", WithAPIKey("user@example.com", "key"), WithTelemetry())
+	if !assert.NoError(t, err) {
+		return
+	}
+	u.Client.URL = "http://127.0.0.1:1/api_json.html"
+	u.Client.DestroyRecord("example.com", "rec1")
+	entries := u.Telemetry()
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, 0, entries[0].Status, "Call without a response shouldn't have a status")
+		assert.NotEqual(t, "", entries[0].Error)
+		assert.Contains(t, entries[0].URL, "tkn=REDACTED")
+		assert.False(t, strings.Contains(entries[0].URL, "key") || strings.Contains(entries[0].URL, "user%40"), "Credentials shouldn't be recorded")
+	}
+}
+
+func TestTelemetryConcurrency(t *testing.T) {
+	tt := newTelemetryTransport(nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				tt.add(TelemetryEntry{Method: "GET"})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				assert.True(t, len(tt.snapshot()) <= telemetrySize)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, tt.snapshot(), telemetrySize)
+}
@@ -0,0 +1,67 @@
+package cfl
+
+import (
+	"context"
+	"net/http"
+)
+
+// SpanContext identifies a span of a distributed trace. As in W3C Trace
+// Context, TraceId is 32 and SpanId 16 lowercase hex digits.
+type SpanContext struct {
+	TraceId string
+	SpanId  string
+	Sampled bool
+}
+
+// IsValid indicates whether sc identifies a span at all.
+func (sc SpanContext) IsValid() bool {
+	return len(sc.TraceId) == 32 && len(sc.SpanId) == 16
+}
+
+// TracerProvider starts the spans that requests to CloudFlare are traced in.
+// It's the part of OpenTelemetry's trace.TracerProvider that we need, so that
+// cfl doesn't depend on OpenTelemetry itself; an adapter just needs to start
+// a span and return its SpanContext.
+type TracerProvider interface {
+	// Start starts a span with the given name as a child of the span in ctx,
+	// if any. It returns the new span's context and a function that ends it.
+	Start(ctx context.Context, name string) (SpanContext, func())
+}
+
+// TracingTransport traces every request in a span started by its
+// TracerProvider and propagates the span to CloudFlare in both W3C
+// (traceparent) and B3 (X-B3-*) headers, so that calls to CloudFlare show up
+// in the traces of whatever caused them.
+type TracingTransport struct {
+	rt http.RoundTripper
+	tp TracerProvider
+}
+
+// NewTracingTransport creates a TracingTransport that sends requests through
+// rt, or http.DefaultTransport if rt is nil.
+func NewTracingTransport(rt http.RoundTripper, tp TracerProvider) *TracingTransport {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &TracingTransport{rt: rt, tp: tp}
+}
+
+func (t *TracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	sc, end := t.tp.Start(req.Context(), "CloudFlare "+req.Method+" "+req.URL.Path)
+	defer end()
+	if !sc.IsValid() {
+		return t.rt.RoundTrip(req)
+	}
+
+	// RoundTrippers mustn't modify the original request
+	req = req.Clone(req.Context())
+	flags, sampled := "00", "0"
+	if sc.Sampled {
+		flags, sampled = "01", "1"
+	}
+	req.Header.Set("traceparent", "00-"+sc.TraceId+"-"+sc.SpanId+"-"+flags)
+	req.Header.Set("X-B3-TraceId", sc.TraceId)
+	req.Header.Set("X-B3-SpanId", sc.SpanId)
+	req.Header.Set("X-B3-Sampled", sampled)
+	return t.rt.RoundTrip(req)
+}
@@ -0,0 +1,76 @@
+package cfl
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+type parentSpanKey struct{}
+
+// fakeTracerProvider starts spans in the trace of the parent span in the
+// context, or in a new trace if there is none.
+type fakeTracerProvider struct {
+	names []string
+	ended int
+}
+
+func (tp *fakeTracerProvider) Start(ctx context.Context, name string) (SpanContext, func()) {
+	tp.names = append(tp.names, name)
+	sc := SpanContext{TraceId: "11111111111111111111111111111111", Sampled: true}
+	if parent, ok := ctx.Value(parentSpanKey{}).(SpanContext); ok {
+		sc.TraceId, sc.Sampled = parent.TraceId, parent.Sampled
+	}
+	sc.SpanId = fmt.Sprintf("%016x", len(tp.names))
+	return sc, func() { tp.ended++ }
+}
+
+func TestWithTracing(t *testing.T) {
+	r := newHeaderRecorder()
+	defer r.Close()
+	tp := &fakeTracerProvider{}
+	c := &http.Client{}
+	u := newRecordedUtil(t, r, WithAPIKey("user@example.com", "key"), WithHTTPClient(c), WithTracing(tp))
+
+	parent := SpanContext{TraceId: "4bf92f3577b34da6a3ce929d0e0e4736", SpanId: "00f067aa0ba902b7", Sampled: false}
+	ctx := context.WithValue(context.Background(), parentSpanKey{}, parent)
+	_, err := u.v4RequestContext(ctx, "GET", "/user", nil, nil)
+	if !assert.NoError(t, err) || !assert.Equal(t, 1, r.count()) {
+		return
+	}
+	h := r.headers[0]
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000001-00", h.Get("traceparent"), "Span should be a child of the one in the request's context")
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", h.Get("X-B3-TraceId"))
+	assert.Equal(t, "0000000000000001", h.Get("X-B3-SpanId"))
+	assert.Equal(t, "0", h.Get("X-B3-Sampled"))
+
+	assert.NoError(t, u.v4Request("GET", "/user", nil, nil))
+	assert.Equal(t, "00-11111111111111111111111111111111-0000000000000002-01", r.headers[1].Get("traceparent"), "Requests without a span should start a new trace")
+	assert.Equal(t, "1", r.headers[1].Get("X-B3-Sampled"))
+
+	assert.Equal(t, []string{"CloudFlare GET /user", "CloudFlare GET /user"}, tp.names)
+	assert.Equal(t, 2, tp.ended, "Spans should have been ended")
+	assert.Nil(t, c.Transport, "Client passed in shouldn't have been modified")
+}
+
+// nopTracerProvider doesn't trace anything
+type nopTracerProvider struct{}
+
+func (nopTracerProvider) Start(ctx context.Context, name string) (SpanContext, func()) {
+	return SpanContext{}, func() {}
+}
+
+func TestTracingTransportWithoutSpan(t *testing.T) {
+	r := newHeaderRecorder()
+	defer r.Close()
+	u := newRecordedUtil(t, r, WithAPIKey("user@example.com", "key"), WithTracing(nopTracerProvider{}))
+	assert.NoError(t, u.v4Request("GET", "/user", nil, nil))
+	assert.Empty(t, r.headers[0].Get("traceparent"), "Invalid spans shouldn't be propagated")
+	assert.Empty(t, r.headers[0].Get("X-B3-TraceId"))
+
+	_, err := New("example.com", WithAPIKey("user@example.com", "key"), WithTracing(nil))
+	assert.Error(t, err, "Nil TracerProvider should be rejected")
+}
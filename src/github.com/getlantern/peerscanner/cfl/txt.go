@@ -0,0 +1,63 @@
+package cfl
+
+import (
+	"fmt"
+	"net/url"
+)
+
+type txtRecord struct {
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	Ttl     int    `json:"ttl"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// CreateTXTRecord creates a TXT record with the given name (relative to our
+// zone), content and ttl, e.g. for ACME DNS-01 challenges or domain ownership
+// verification.
+func (util *Util) CreateTXTRecord(name string, content string, ttl int) error {
+	if !IsValidTtl(ttl) {
+		return fmt.Errorf("Unsupported ttl %d", ttl)
+	}
+	zone, err := util.zoneId()
+	if err != nil {
+		return err
+	}
+	rec := txtRecord{
+		Type:    "TXT",
+		Name:    util.fullName(name),
+		Content: content,
+		Ttl:     ttl,
+	}
+	rec.Comment = util.recordComment()
+	err = util.v4Request("POST", fmt.Sprintf("/zones/%v/dns_records", zone), &rec, nil)
+	if err != nil {
+		return fmt.Errorf("Unable to create TXT record %v: %v", name, err)
+	}
+	return nil
+}
+
+// DestroyTXTRecordByContent destroys the TXT records with the given name
+// (relative to our zone) and content, leaving other TXT records with the same
+// name alone. It succeeds if there are none.
+func (util *Util) DestroyTXTRecordByContent(name string, content string) error {
+	zone, err := util.zoneId()
+	if err != nil {
+		return err
+	}
+	recs, err := util.listDnsRecords(url.Values{"type": {"TXT"}, "name": {util.fullName(name)}, "content": {content}})
+	if err != nil {
+		return err
+	}
+	for _, r := range recs {
+		if r.Content != content {
+			continue
+		}
+		err := util.v4Request("DELETE", fmt.Sprintf("/zones/%v/dns_records/%v", zone, r.Id), nil, nil)
+		if err != nil && !isNotFound(err) {
+			return fmt.Errorf("Unable to destroy TXT record %v: %v", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package cfl
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestCreateTXTRecord(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+
+	var payload map[string]interface{}
+	f.handle("POST", "/zones/"+fakeZoneId+"/dns_records", func(body []byte) (int, interface{}) {
+		json.Unmarshal(body, &payload)
+		return 200, map[string]interface{}{"id": "txt1"}
+	})
+
+	err := f.util.CreateTXTRecord("_acme-challenge", "challenge-value", 120)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "TXT", payload["type"])
+	assert.Equal(t, "_acme-challenge.example.com", payload["name"])
+	assert.Equal(t, "challenge-value", payload["content"])
+	assert.Equal(t, float64(120), payload["ttl"])
+
+	assert.Error(t, f.util.CreateTXTRecord("_acme-challenge", "challenge-value", 121), "Invalid ttl should be rejected")
+}
+
+func TestDestroyTXTRecordByContent(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+
+	f.handle("GET", "/zones/"+fakeZoneId+"/dns_records", func(body []byte) (int, interface{}) {
+		return 200, []dnsRecord{
+			{Id: "txt1", Type: "TXT", Name: "_acme-challenge.example.com", Content: "old-value"},
+			{Id: "txt2", Type: "TXT", Name: "_acme-challenge.example.com", Content: "challenge-value"},
+		}
+	})
+	for _, id := range []string{"txt1", "txt2"} {
+		id := id
+		f.handle("DELETE", "/zones/"+fakeZoneId+"/dns_records/"+id, func(body []byte) (int, interface{}) {
+			return 200, map[string]interface{}{"id": id}
+		})
+	}
+	assert.NoError(t, f.util.DestroyTXTRecordByContent("_acme-challenge", "challenge-value"))
+	assert.True(t, f.requested("DELETE", "/zones/"+fakeZoneId+"/dns_records/txt2"), "Matching record should have been deleted")
+	assert.False(t, f.requested("DELETE", "/zones/"+fakeZoneId+"/dns_records/txt1"), "Record with other content should have been kept")
+}
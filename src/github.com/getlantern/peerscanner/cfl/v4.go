@@ -0,0 +1,187 @@
+package cfl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultV4URL = "https://api.cloudflare.com/client/v4"
+)
+
+// v4Response is the envelope around all responses from CloudFlare's v4 API
+type v4Response struct {
+	Success bool            `json:"success"`
+	Errors  []v4Error       `json:"errors"`
+	Result  json.RawMessage `json:"result"`
+	Info    *v4ResultInfo   `json:"result_info"`
+}
+
+type v4Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type v4ResultInfo struct {
+	Page       int `json:"page"`
+	PerPage    int `json:"per_page"`
+	TotalPages int `json:"total_pages"`
+	Count      int `json:"count"`
+	TotalCount int `json:"total_count"`
+}
+
+// APIError is an error reported by the v4 API itself. CFRay is the cf-ray
+// header of the response, which identifies the request at CloudFlare's edge
+// and is what CloudFlare support asks for. RetryAfter is how long CloudFlare
+// asked us to wait before retrying a rate limited (429) request, if it did.
+type APIError struct {
+	Status     int
+	CFRay      string
+	RetryAfter time.Duration
+	msg        string
+}
+
+func (e *APIError) Error() string {
+	if e.CFRay == "" {
+		return e.msg
+	}
+	return fmt.Sprintf("%v cf_ray=%v", e.msg, e.CFRay)
+}
+
+// isNotFound indicates whether err is the v4 API reporting a 404
+func isNotFound(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.Status == http.StatusNotFound
+}
+
+// v4Request makes a request to CloudFlare's v4 API, which is needed for
+// features the client API doesn't offer. It authenticates with the API token,
+// if any, or else with the same email and key as the client API. If in is not
+// nil, it is sent as the JSON body. If out is not nil, the result is decoded
+// into it.
+func (util *Util) v4Request(method string, path string, in interface{}, out interface{}) error {
+	_, err := util.v4RequestWithInfo(method, path, in, out)
+	return err
+}
+
+// v4RequestWithInfo is like v4Request but also returns the result_info of
+// paginated responses.
+func (util *Util) v4RequestWithInfo(method string, path string, in interface{}, out interface{}) (*v4ResultInfo, error) {
+	return util.v4RequestContext(context.Background(), method, path, in, out)
+}
+
+// v4RequestContext is like v4RequestWithInfo but gives up once ctx is done.
+func (util *Util) v4RequestContext(ctx context.Context, method string, path string, in interface{}, out interface{}) (*v4ResultInfo, error) {
+	if util.DryRun && method != "GET" {
+		log.Debugf("Dry run, not calling %v %v", method, path)
+		return nil, nil
+	}
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to encode request to %v: %v", path, err)
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := util.newV4Request(method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := util.Client.Http.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("Error calling %v %v: %v", method, path, err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Debugf("Unable to close response body: %v", err)
+		}
+	}()
+
+	cfRay := resp.Header.Get("cf-ray")
+	var v4resp v4Response
+	err = json.NewDecoder(resp.Body).Decode(&v4resp)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode response to %v %v (%v) cf_ray=%v: %v", method, path, resp.Status, cfRay, err)
+	}
+	if !v4resp.Success {
+		msgs := make([]string, 0, len(v4resp.Errors))
+		for _, e := range v4resp.Errors {
+			msgs = append(msgs, fmt.Sprintf("%d: %v", e.Code, e.Message))
+		}
+		apiErr := &APIError{Status: resp.StatusCode, CFRay: cfRay, msg: fmt.Sprintf("API Error calling %v %v (%v): %v", method, path, resp.Status, strings.Join(msgs, ", "))}
+		if resp.StatusCode == http.StatusTooManyRequests {
+			apiErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+		}
+		return nil, apiErr
+	}
+	if out != nil && len(v4resp.Result) > 0 {
+		err = json.Unmarshal(v4resp.Result, out)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to decode result of %v %v: %v", method, path, err)
+		}
+	}
+	return v4resp.Info, nil
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds, which is how
+// CloudFlare sends it. It returns 0 if there's no valid header.
+func parseRetryAfter(header string) time.Duration {
+	seconds, err := strconv.Atoi(strings.TrimSpace(header))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// newV4Request creates an authenticated request to the v4 API.
+func (util *Util) newV4Request(method string, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, util.V4URL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create request to %v: %v", path, err)
+	}
+	if util.apiToken != "" {
+		req.Header.Set("Authorization", "Bearer "+util.apiToken)
+	} else {
+		req.Header.Set("X-Auth-Email", util.Client.Email)
+		req.Header.Set("X-Auth-Key", util.Client.Token)
+	}
+	return req, nil
+}
+
+// zoneId looks up (and caches) the v4 API's id for our domain.
+func (util *Util) zoneId() (string, error) {
+	util.zoneIdMutex.Lock()
+	defer util.zoneIdMutex.Unlock()
+	if util.cachedZoneId != "" {
+		return util.cachedZoneId, nil
+	}
+
+	var zones []struct {
+		Id   string `json:"id"`
+		Name string `json:"name"`
+	}
+	err := util.v4Request("GET", "/zones?name="+url.QueryEscape(util.domain), nil, &zones)
+	if err != nil {
+		return "", err
+	}
+	for _, z := range zones {
+		if z.Name == util.domain {
+			util.cachedZoneId = z.Id
+			return z.Id, nil
+		}
+	}
+	return "", fmt.Errorf("Zone %v not found", util.domain)
+}
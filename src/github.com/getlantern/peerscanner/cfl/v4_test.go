@@ -0,0 +1,159 @@
+package cfl
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/getlantern/cloudflare"
+	"github.com/getlantern/testify/assert"
+)
+
+const (
+	fakeZoneId = "fakezone"
+)
+
+// v4Handler handles a request to the fake v4 API, returning the status code
+// and result to respond with.
+type v4Handler func(body []byte) (int, interface{})
+
+// v4Paged is a result that fake v4 handlers can return to also respond with
+// result_info.
+type v4Paged struct {
+	result interface{}
+	info   v4ResultInfo
+}
+
+// v4Raw is a result that fake v4 handlers can return to respond with a raw
+// body instead of the usual envelope, like Workers KV does for values.
+type v4Raw []byte
+
+// fakeV4 is a fake v4 API that serves whichever handlers tests register.
+type fakeV4 struct {
+	*httptest.Server
+	util *Util
+
+	handlers map[string]v4Handler
+	requests []string
+	// queries are the query parameters of the latest request for each method
+	// and path
+	queries map[string]url.Values
+	mutex   sync.Mutex
+}
+
+func newFakeV4(domain string) *fakeV4 {
+	f := &fakeV4{handlers: make(map[string]v4Handler), queries: make(map[string]url.Values)}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	var err error
+	f.util, err = New(domain, WithAPIKey("test@example.com", "testkey"))
+	if err != nil {
+		panic(err)
+	}
+	f.util.V4URL = f.URL
+	f.handle("GET", "/zones", func(body []byte) (int, interface{}) {
+		return 200, []map[string]string{{"id": fakeZoneId, "name": domain}}
+	})
+	return f
+}
+
+func (f *fakeV4) handle(method string, path string, handler v4Handler) {
+	f.mutex.Lock()
+	f.handlers[method+" "+path] = handler
+	f.mutex.Unlock()
+}
+
+// serveRecords makes the fake list recs as the zone's DNS records, whatever
+// the query.
+func (f *fakeV4) serveRecords(recs []cloudflare.Record) {
+	dnsRecs := make([]dnsRecord, 0, len(recs))
+	for _, r := range recs {
+		ttl, _ := strconv.Atoi(r.Ttl)
+		dnsRecs = append(dnsRecs, dnsRecord{Id: r.Id, Type: r.Type, Name: r.FullName, Content: r.Value, Ttl: ttl, Proxied: IsProxied(&r)})
+	}
+	f.handle("GET", "/zones/"+fakeZoneId+"/dns_records", func(body []byte) (int, interface{}) {
+		return 200, dnsRecs
+	})
+}
+
+func (f *fakeV4) requested(method string, path string) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, r := range f.requests {
+		if r == method+" "+path {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeV4) query(method string, path string) url.Values {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.queries[method+" "+path]
+}
+
+func (f *fakeV4) serve(resp http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	key := req.Method + " " + req.URL.Path
+	f.mutex.Lock()
+	f.requests = append(f.requests, key)
+	f.queries[key] = req.URL.Query()
+	handler := f.handlers[key]
+	f.mutex.Unlock()
+
+	status, result := 404, interface{}(nil)
+	if handler != nil {
+		status, result = handler(body)
+	}
+	if raw, ok := result.(v4Raw); ok {
+		resp.WriteHeader(status)
+		resp.Write(raw)
+		return
+	}
+	v4resp := map[string]interface{}{"success": status < 300, "result": result}
+	if paged, ok := result.(v4Paged); ok {
+		v4resp["result"] = paged.result
+		v4resp["result_info"] = paged.info
+	}
+	if status >= 300 {
+		v4resp["errors"] = []map[string]interface{}{{"code": status, "message": http.StatusText(status)}}
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(status)
+	json.NewEncoder(resp).Encode(v4resp)
+}
+
+func TestV4Request(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+
+	zone, err := f.util.zoneId()
+	if assert.NoError(t, err) {
+		assert.Equal(t, fakeZoneId, zone)
+	}
+	err = f.util.v4Request("GET", "/zones/missing", nil, nil)
+	assert.Error(t, err, "Unknown path should fail")
+	assert.True(t, isNotFound(err), "Unknown path should be reported as not found")
+}
+
+func TestV4RequestErrorHasCFRay(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+	f.Config.Handler = http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("cf-ray", "8a1b2c3d4e5f6789-SJC")
+		f.serve(resp, req)
+	})
+
+	err := f.util.v4Request("GET", "/zones/missing", nil, nil)
+	apiErr, ok := err.(*APIError)
+	if assert.True(t, ok, "Failed request should return an APIError") {
+		assert.Equal(t, http.StatusNotFound, apiErr.Status)
+		assert.Equal(t, "8a1b2c3d4e5f6789-SJC", apiErr.CFRay)
+	}
+	assert.Contains(t, err.Error(), "cf_ray=8a1b2c3d4e5f6789-SJC", "Error message should include the cf-ray")
+}
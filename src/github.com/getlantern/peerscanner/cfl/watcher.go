@@ -0,0 +1,157 @@
+package cfl
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	auditLogsPerPage = 100
+)
+
+var (
+	// watchedActions are the audit log actions for DNS record changes
+	watchedActions = map[string]bool{
+		"rec.new":  true,
+		"rec.edit": true,
+		"rec.del":  true,
+	}
+)
+
+// auditLog is an entry in CloudFlare's audit logs
+type auditLog struct {
+	Id     string `json:"id"`
+	Action struct {
+		Type string `json:"type"`
+	} `json:"action"`
+	Actor struct {
+		Email string `json:"email"`
+	} `json:"actor"`
+	NewValue map[string]interface{} `json:"newValueJson"`
+	OldValue map[string]interface{} `json:"oldValueJson"`
+	When     time.Time              `json:"when"`
+}
+
+// recordName returns the (fully qualified) name of the record that entry is
+// about, if any.
+func (entry *auditLog) recordName() string {
+	for _, v := range []map[string]interface{}{entry.NewValue, entry.OldValue} {
+		if name, ok := v["name"].(string); ok && name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// RecordWatcher polls CloudFlare's audit logs for DNS record changes in our
+// zone that were made by someone other than us (e.g. in the dashboard), so
+// that they can be reconciled without waiting for the next full
+// reconciliation.
+type RecordWatcher struct {
+	util     *Util
+	interval time.Duration
+	// onChange is called with the name (relative to our zone) of every record
+	// that was changed externally
+	onChange func(name string)
+
+	since  time.Time
+	seen   map[string]bool
+	stopCh chan interface{}
+	once   sync.Once
+}
+
+// NewRecordWatcher creates a RecordWatcher that polls every interval once
+// started, calling onChange for each externally changed record.
+func (util *Util) NewRecordWatcher(interval time.Duration, onChange func(name string)) *RecordWatcher {
+	return &RecordWatcher{
+		util:     util,
+		interval: interval,
+		onChange: onChange,
+		since:    time.Now(),
+		seen:     make(map[string]bool),
+		stopCh:   make(chan interface{}),
+	}
+}
+
+// Start starts polling in the background.
+func (w *RecordWatcher) Start() {
+	go func() {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.stopCh:
+				return
+			case <-ticker.C:
+				if err := w.poll(); err != nil {
+					log.Errorf("Unable to check audit logs for external changes: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops polling.
+func (w *RecordWatcher) Stop() {
+	w.once.Do(func() {
+		close(w.stopCh)
+	})
+}
+
+// poll looks at the audit log entries since the last poll, calling onChange
+// once for every record name that was changed externally.
+func (w *RecordWatcher) poll() error {
+	q := url.Values{
+		"zone.name": {w.util.domain},
+		"since":     {w.since.UTC().Format(time.RFC3339)},
+		"direction": {"asc"},
+		"per_page":  {strconv.Itoa(auditLogsPerPage)},
+	}
+	var entries []auditLog
+	for page := 1; ; page++ {
+		q.Set("page", strconv.Itoa(page))
+		var result []auditLog
+		info, err := w.util.v4RequestWithInfo("GET", "/user/audit_logs?"+q.Encode(), nil, &result)
+		if err != nil {
+			return fmt.Errorf("Unable to list audit logs: %v", err)
+		}
+		entries = append(entries, result...)
+		if info == nil || page >= info.TotalPages {
+			break
+		}
+	}
+
+	changed := make(map[string]bool)
+	var names []string
+	for _, entry := range entries {
+		if entry.When.After(w.since) {
+			// Entries are returned with second precision, so the next poll will
+			// see entries from the latest second again. seen keeps track of
+			// which of them we already handled.
+			w.since = entry.When
+			w.seen = make(map[string]bool)
+		}
+		if w.seen[entry.Id] {
+			continue
+		}
+		w.seen[entry.Id] = true
+		if !watchedActions[entry.Action.Type] || entry.Actor.Email == w.util.Client.Email {
+			continue
+		}
+		name := entry.recordName()
+		if name == "" || changed[name] {
+			continue
+		}
+		changed[name] = true
+		names = append(names, name)
+	}
+
+	for _, name := range names {
+		log.Debugf("Record %v was changed outside of peerscanner", name)
+		w.onChange(w.util.relativeName(name))
+	}
+	return nil
+}
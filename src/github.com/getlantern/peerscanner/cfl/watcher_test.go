@@ -0,0 +1,55 @@
+package cfl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestRecordWatcherDetectsExternalChanges(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	entry := func(id string, action string, email string, name string, when time.Time) map[string]interface{} {
+		return map[string]interface{}{
+			"id":           id,
+			"action":       map[string]interface{}{"type": action, "result": true},
+			"actor":        map[string]interface{}{"email": email, "type": "user"},
+			"newValueJson": map[string]interface{}{"name": name, "type": "A", "content": "1.2.3.4"},
+			"when":         when.Format(time.RFC3339),
+		}
+	}
+	entries := []map[string]interface{}{
+		entry("1", "rec.edit", "ops@example.com", "fl-us-1.example.com", now.Add(1*time.Second)),
+		entry("2", "rec.del", "ops@example.com", "roundrobin.example.com", now.Add(2*time.Second)),
+		entry("3", "rec.new", f.util.Client.Email, "fl-us-2.example.com", now.Add(2*time.Second)),
+		entry("4", "zone.settings", "ops@example.com", "", now.Add(2*time.Second)),
+		entry("5", "rec.edit", "ops@example.com", "fl-us-1.example.com", now.Add(2*time.Second)),
+	}
+	f.handle("GET", "/user/audit_logs", func(body []byte) (int, interface{}) {
+		return 200, entries
+	})
+
+	var changed []string
+	w := f.util.NewRecordWatcher(time.Minute, func(name string) {
+		changed = append(changed, name)
+	})
+	w.since = now
+	if assert.NoError(t, w.poll()) {
+		assert.Equal(t, []string{"fl-us-1", "roundrobin"}, changed, "Should report each externally changed record once, ignoring our own changes")
+	}
+
+	// The audit logs return the last second again, which we've already seen
+	changed = nil
+	entries = entries[1:]
+	if assert.NoError(t, w.poll()) {
+		assert.Len(t, changed, 0, "Entries that were already seen shouldn't be reported again")
+	}
+
+	f.handle("GET", "/user/audit_logs", func(body []byte) (int, interface{}) {
+		return 500, nil
+	})
+	assert.Error(t, w.poll(), "Failing audit log request should be reported")
+}
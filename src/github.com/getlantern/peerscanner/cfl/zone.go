@@ -0,0 +1,225 @@
+package cfl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// exportedTypes are the record types that ExportZone and ImportZone handle
+var exportedTypes = map[string]bool{
+	"A":     true,
+	"AAAA":  true,
+	"CNAME": true,
+	"TXT":   true,
+}
+
+// ExportZone writes all of our zone's A, AAAA, CNAME and TXT records to w in
+// BIND zone file format, for disaster recovery.
+func (util *Util) ExportZone(w io.Writer) error {
+	recs, err := util.GetAllRecords()
+	if err != nil {
+		return err
+	}
+	specs := make([]RecordSpec, 0, len(recs))
+	for _, r := range recs {
+		if !exportedTypes[r.Type] {
+			log.Debugf("Not exporting %v record %v", r.Type, r.FullName)
+			continue
+		}
+		spec := specFor(r)
+		spec.Name = util.relativeName(r.FullName)
+		specs = append(specs, spec)
+	}
+	ops := make([]Op, 0, len(specs))
+	for _, s := range specs {
+		ops = append(ops, Op{Record: s})
+	}
+	sort.Sort(byNameAndValue(ops))
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "$ORIGIN %v.\n", util.domain)
+	for _, op := range ops {
+		s := op.Record
+		fmt.Fprintf(bw, "%v.\t%d\tIN\t%v\t%v\n", util.fullName(s.Name), s.Ttl, s.Type, util.rdataFor(s))
+	}
+	return bw.Flush()
+}
+
+// ImportZone reads a zone file as written by ExportZone from r and creates
+// those of its records that don't exist yet. Records that exist but aren't in
+// the zone file are left alone. If dryRun is true, nothing is created. It
+// returns the creations (to be) made.
+func (util *Util) ImportZone(r io.Reader, dryRun bool) ([]Op, error) {
+	specs, err := util.parseZone(r)
+	if err != nil {
+		return nil, err
+	}
+	recs, err := util.GetAllRecords()
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]bool, len(recs))
+	for _, rec := range recs {
+		spec := specFor(rec)
+		spec.Name = util.relativeName(rec.FullName)
+		existing[spec.key()] = true
+	}
+
+	var ops []Op
+	for _, spec := range specs {
+		if !existing[spec.key()] {
+			existing[spec.key()] = true
+			ops = append(ops, Op{OpCreate, spec})
+		}
+	}
+	sort.Sort(byNameAndValue(ops))
+	if dryRun || len(ops) == 0 {
+		return ops, nil
+	}
+
+	zone, err := util.zoneId()
+	if err != nil {
+		return nil, err
+	}
+	done := make([]Op, 0, len(ops))
+	for remaining := ops; len(remaining) > 0; {
+		n := reconcileBatchSize
+		if n > len(remaining) {
+			n = len(remaining)
+		}
+		err := util.executeBatch(zone, remaining[:n])
+		if err != nil {
+			return done, fmt.Errorf("Unable to create batch of %d records: %v", n, err)
+		}
+		done = append(done, remaining[:n]...)
+		remaining = remaining[n:]
+	}
+	return done, nil
+}
+
+// parseZone parses the records in a zone file as written by ExportZone. Names
+// in the returned specs are relative to our zone.
+func (util *Util) parseZone(r io.Reader) ([]RecordSpec, error) {
+	var specs []RecordSpec
+	scanner := bufio.NewScanner(r)
+	origin := util.domain
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+		fields, data := splitZoneLine(line)
+		if fields[0] == "$ORIGIN" {
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("Line %d: invalid $ORIGIN", lineNum)
+			}
+			origin = strings.TrimSuffix(fields[1], ".")
+			if origin != util.domain {
+				return nil, fmt.Errorf("Line %d: zone file is for %v, not %v", lineNum, origin, util.domain)
+			}
+			continue
+		}
+		if len(fields) < 4 || data == "" {
+			return nil, fmt.Errorf("Line %d: expected name, ttl, class, type and data", lineNum)
+		}
+		ttl, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: invalid ttl %v", lineNum, fields[1])
+		}
+		if fields[2] != "IN" {
+			return nil, fmt.Errorf("Line %d: unsupported class %v", lineNum, fields[2])
+		}
+		typ := fields[3]
+		if !exportedTypes[typ] {
+			return nil, fmt.Errorf("Line %d: unsupported type %v", lineNum, typ)
+		}
+		name := fields[0]
+		if !strings.HasSuffix(name, ".") {
+			name = name + "." + origin
+		}
+		if typ == "TXT" {
+			data, err = unquoteTxt(data)
+			if err != nil {
+				return nil, fmt.Errorf("Line %d: %v", lineNum, err)
+			}
+		} else if typ == "CNAME" {
+			data = strings.TrimSuffix(data, ".")
+		}
+		specs = append(specs, RecordSpec{
+			Type:  typ,
+			Name:  util.relativeName(strings.TrimSuffix(name, ".")),
+			Value: data,
+			Ttl:   ttl,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to read zone file: %v", err)
+	}
+	return specs, nil
+}
+
+// splitZoneLine splits a line of a zone file into its first 4 fields (name,
+// ttl, class and type) and the remaining data, which may contain spaces in the
+// case of TXT records.
+func splitZoneLine(line string) ([]string, string) {
+	var fields []string
+	rest := line
+	for len(fields) < 4 {
+		rest = strings.TrimLeft(rest, " \t")
+		if rest == "" {
+			break
+		}
+		end := strings.IndexAny(rest, " \t")
+		if end < 0 {
+			end = len(rest)
+		}
+		fields = append(fields, rest[:end])
+		rest = rest[end:]
+	}
+	return fields, strings.TrimSpace(rest)
+}
+
+func (util *Util) rdataFor(s RecordSpec) string {
+	switch s.Type {
+	case "TXT":
+		return quoteTxt(s.Value)
+	case "CNAME":
+		return s.Value + "."
+	default:
+		return s.Value
+	}
+}
+
+// relativeName turns a fully qualified name in our zone into one relative to
+// it, using "@" for the zone apex.
+func (util *Util) relativeName(fullName string) string {
+	if fullName == util.domain {
+		return "@"
+	}
+	return strings.TrimSuffix(fullName, "."+util.domain)
+}
+
+func quoteTxt(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+func unquoteTxt(data string) (string, error) {
+	if len(data) < 2 || data[0] != '"' || data[len(data)-1] != '"' {
+		return "", fmt.Errorf("TXT data %v isn't quoted", data)
+	}
+	var result []byte
+	inner := data[1 : len(data)-1]
+	for i := 0; i < len(inner); i++ {
+		if inner[i] == '\\' && i+1 < len(inner) {
+			i++
+		}
+		result = append(result, inner[i])
+	}
+	return string(result), nil
+}
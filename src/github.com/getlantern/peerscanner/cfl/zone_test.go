@@ -0,0 +1,91 @@
+package cfl
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/getlantern/cloudflare"
+	"github.com/getlantern/testify/assert"
+)
+
+var zoneRecords = []cloudflare.Record{
+	{Id: "1", Type: "A", Name: "fl-us-1", FullName: "fl-us-1.example.com", Value: "1.1.1.1", Ttl: "300"},
+	{Id: "2", Type: "AAAA", Name: "fl-us-1", FullName: "fl-us-1.example.com", Value: "2001:db8::1", Ttl: "1"},
+	{Id: "3", Type: "CNAME", Name: "www", FullName: "www.example.com", Value: "example.com", Ttl: "1"},
+	{Id: "4", Type: "TXT", Name: "example.com", FullName: "example.com", Value: `v=spf1 include:"quoted" \ -all`, Ttl: "3600"},
+	{Id: "5", Type: "MX", Name: "example.com", FullName: "example.com", Value: "mail.example.com", Ttl: "1"},
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+	f.serveRecords(zoneRecords)
+
+	var zoneFile bytes.Buffer
+	if !assert.NoError(t, f.util.ExportZone(&zoneFile)) {
+		return
+	}
+	lines := strings.Split(strings.TrimSpace(zoneFile.String()), "\n")
+	assert.Equal(t, "$ORIGIN example.com.", lines[0])
+	assert.Len(t, lines, 5, "MX record shouldn't have been exported")
+	assert.Contains(t, zoneFile.String(), "www.example.com.\t1\tIN\tCNAME\texample.com.\n")
+
+	specs, err := f.util.parseZone(bytes.NewReader(zoneFile.Bytes()))
+	if !assert.NoError(t, err) || !assert.Len(t, specs, 4) {
+		return
+	}
+	for _, r := range zoneRecords[:4] {
+		expected := specFor(r)
+		expected.Id = ""
+		expected.Name = f.util.relativeName(r.FullName)
+		found := false
+		for _, s := range specs {
+			if s == expected {
+				found = true
+			}
+		}
+		assert.True(t, found, "Round trip should have preserved %v", expected)
+	}
+
+	// Importing into the same zone shouldn't create anything
+	ops, err := f.util.ImportZone(bytes.NewReader(zoneFile.Bytes()), false)
+	if assert.NoError(t, err) {
+		assert.Len(t, ops, 0, "Existing records shouldn't be recreated")
+	}
+
+	// Importing into an empty zone should create everything
+	f.serveRecords(nil)
+	ops, err = f.util.ImportZone(bytes.NewReader(zoneFile.Bytes()), true)
+	if assert.NoError(t, err) {
+		assert.Len(t, ops, 4, "Dry run should report all records")
+	}
+	var created []batchRecord
+	f.handle("POST", "/zones/"+fakeZoneId+"/dns_records/batch", func(body []byte) (int, interface{}) {
+		var req batchRequest
+		json.Unmarshal(body, &req)
+		created = append(created, req.Posts...)
+		return 200, map[string]interface{}{}
+	})
+	ops, err = f.util.ImportZone(bytes.NewReader(zoneFile.Bytes()), false)
+	if assert.NoError(t, err) {
+		assert.Len(t, ops, 4)
+		assert.Len(t, created, 4, "All records should have been created")
+	}
+}
+
+func TestParseZoneErrors(t *testing.T) {
+	u := NewLegacy("example.com", "test@example.com", "testkey")
+	for _, zone := range []string{
+		"$ORIGIN other.com.\n",
+		"a.example.com. x IN A 1.1.1.1\n",
+		"a.example.com. 1 CH A 1.1.1.1\n",
+		"a.example.com. 1 IN MX mail.example.com.\n",
+		"a.example.com. 1 IN A\n",
+		"a.example.com. 1 IN TXT unquoted\n",
+	} {
+		_, err := u.parseZone(strings.NewReader(zone))
+		assert.Error(t, err, "Should have failed to parse %v", zone)
+	}
+}
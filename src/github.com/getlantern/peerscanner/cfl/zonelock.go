@@ -0,0 +1,245 @@
+package cfl
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// kvMinExpirationTtl is the shortest expiration_ttl that Workers KV
+	// accepts. Locks with shorter ttls still expire on time, since we store
+	// their expiry in the value.
+	kvMinExpirationTtl = 60 * time.Second
+)
+
+var (
+	// ErrLockHeld is returned by TryLock when someone else holds the lock.
+	ErrLockHeld = fmt.Errorf("Lock is held by someone else")
+
+	// kvLockSettle is how long TryLock waits after writing the lock before
+	// reading it back to check that nobody overwrote it.
+	kvLockSettle = 1 * time.Second
+)
+
+// Locker is a lock on our zone that is held while changing its records.
+type Locker interface {
+	// TryLock tries to acquire the lock, which expires after ttl unless it is
+	// renewed. It returns ErrLockHeld if someone else holds the lock.
+	TryLock(ctx context.Context, ttl time.Duration) error
+
+	// Unlock releases the lock if we hold it.
+	Unlock() error
+}
+
+// noopLock is the Locker used when there's nobody to coordinate with.
+type noopLock struct{}
+
+func (noopLock) TryLock(ctx context.Context, ttl time.Duration) error { return nil }
+
+func (noopLock) Unlock() error { return nil }
+
+// ZoneLock is a Locker shared by peerscanner instances, stored under
+// peerscanner:lock:{zone_id} in a Workers KV namespace. While held, it is
+// renewed in the background until Unlock is called.
+//
+// KV has no compare-and-swap, so TryLock writes the lock, waits for
+// kvLockSettle and reads it back to make sure nobody else wrote it in the
+// meantime. Since KV is eventually consistent, this is best effort; use the
+// Redis lock where strict mutual exclusion matters. A ZoneLock is not
+// reentrant.
+type ZoneLock struct {
+	namespace string
+	key       string
+
+	util      *Util
+	accountId string
+	token     string
+	stop      chan interface{}
+	mutex     sync.Mutex
+}
+
+// kvLockValue is what we store in KV for a held lock.
+type kvLockValue struct {
+	Token   string    `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+// NewZoneLock creates a ZoneLock for our zone in the given KV namespace.
+func (util *Util) NewZoneLock(namespace string) (*ZoneLock, error) {
+	zone, err := util.zoneId()
+	if err != nil {
+		return nil, err
+	}
+	var details struct {
+		Account struct {
+			Id string `json:"id"`
+		} `json:"account"`
+	}
+	err = util.v4Request("GET", "/zones/"+zone, nil, &details)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get zone details: %v", err)
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("Unable to generate lock token: %v", err)
+	}
+	return &ZoneLock{
+		namespace: namespace,
+		key:       "peerscanner:lock:" + zone,
+		util:      util,
+		accountId: details.Account.Id,
+		token:     hex.EncodeToString(b),
+	}, nil
+}
+
+func (l *ZoneLock) TryLock(ctx context.Context, ttl time.Duration) error {
+	if l.util.DryRun {
+		log.Debugf("Dry run, not taking lock %v", l.key)
+		return nil
+	}
+	current, err := l.get(ctx)
+	if err != nil {
+		return err
+	}
+	if current != nil && current.Token != l.token && time.Now().Before(current.Expires) {
+		return ErrLockHeld
+	}
+	if err := l.put(ctx, ttl); err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(kvLockSettle):
+	}
+	current, err = l.get(ctx)
+	if err != nil {
+		return err
+	}
+	if current == nil || current.Token != l.token {
+		return ErrLockHeld
+	}
+
+	l.mutex.Lock()
+	if l.stop == nil {
+		l.stop = make(chan interface{})
+		go l.renew(l.stop, ttl)
+	}
+	l.mutex.Unlock()
+	return nil
+}
+
+func (l *ZoneLock) Unlock() error {
+	l.mutex.Lock()
+	if l.stop != nil {
+		close(l.stop)
+		l.stop = nil
+	}
+	l.mutex.Unlock()
+
+	current, err := l.get(context.Background())
+	if err != nil {
+		return err
+	}
+	if current == nil || current.Token != l.token {
+		// Not ours (anymore), leave it alone
+		return nil
+	}
+	return l.util.v4Request("DELETE", l.path(), nil, nil)
+}
+
+// renew extends the lock every ttl/3 until stop is closed or we find that we
+// lost it.
+func (l *ZoneLock) renew(stop chan interface{}, ttl time.Duration) {
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			current, err := l.get(context.Background())
+			if err != nil {
+				log.Errorf("Unable to renew lock %v: %v", l.key, err)
+				continue
+			}
+			if current == nil || current.Token != l.token {
+				log.Errorf("Lost lock %v", l.key)
+				return
+			}
+			if err := l.put(context.Background(), ttl); err != nil {
+				log.Errorf("Unable to renew lock %v: %v", l.key, err)
+			}
+		}
+	}
+}
+
+func (l *ZoneLock) path() string {
+	return fmt.Sprintf("/accounts/%v/storage/kv/namespaces/%v/values/%v", l.accountId, l.namespace, url.PathEscape(l.key))
+}
+
+// get reads the lock from KV, returning nil if there isn't one. Values are
+// served raw rather than in the usual v4 envelope.
+func (l *ZoneLock) get(ctx context.Context) (*kvLockValue, error) {
+	req, err := l.util.newV4Request("GET", l.path(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := l.util.Client.Http.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read lock %v: %v", l.key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read lock %v: %v", l.key, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unable to read lock %v (%v): %v", l.key, resp.Status, string(body))
+	}
+	value := &kvLockValue{}
+	if err := json.Unmarshal(body, value); err != nil {
+		return nil, fmt.Errorf("Unable to decode lock %v: %v", l.key, err)
+	}
+	return value, nil
+}
+
+// put writes the lock to KV with our token, expiring after ttl.
+func (l *ZoneLock) put(ctx context.Context, ttl time.Duration) error {
+	b, err := json.Marshal(&kvLockValue{Token: l.token, Expires: time.Now().Add(ttl)})
+	if err != nil {
+		return err
+	}
+	expirationTtl := ttl
+	if expirationTtl < kvMinExpirationTtl {
+		expirationTtl = kvMinExpirationTtl
+	}
+	path := fmt.Sprintf("%v?expiration_ttl=%d", l.path(), int(expirationTtl/time.Second))
+	req, err := l.util.newV4Request("PUT", path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp, err := l.util.Client.Http.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("Unable to write lock %v: %v", l.key, err)
+	}
+	defer resp.Body.Close()
+	var v4resp v4Response
+	if err := json.NewDecoder(resp.Body).Decode(&v4resp); err != nil || !v4resp.Success {
+		return fmt.Errorf("Unable to write lock %v (%v)", l.key, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package cfl
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/getlantern/testify/assert"
+)
+
+const (
+	fakeAccountId   = "fakeaccount"
+	fakeKVNamespace = "fakenamespace"
+)
+
+// serveKV makes f serve a single KV value at the path of our zone's lock.
+func serveKV(f *fakeV4) {
+	path := "/accounts/" + fakeAccountId + "/storage/kv/namespaces/" + fakeKVNamespace + "/values/peerscanner:lock:" + fakeZoneId
+	var value []byte
+	var mutex sync.Mutex
+	f.handle("GET", "/zones/"+fakeZoneId, func(body []byte) (int, interface{}) {
+		return 200, map[string]interface{}{"id": fakeZoneId, "account": map[string]string{"id": fakeAccountId}}
+	})
+	f.handle("GET", path, func(body []byte) (int, interface{}) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if value == nil {
+			return 404, nil
+		}
+		return 200, v4Raw(value)
+	})
+	f.handle("PUT", path, func(body []byte) (int, interface{}) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		value = body
+		return 200, nil
+	})
+	f.handle("DELETE", path, func(body []byte) (int, interface{}) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		value = nil
+		return 200, nil
+	})
+}
+
+func withLockSettle(d time.Duration) func() {
+	orig := kvLockSettle
+	kvLockSettle = d
+	return func() { kvLockSettle = orig }
+}
+
+func TestZoneLock(t *testing.T) {
+	defer withLockSettle(time.Millisecond)()
+	f := newFakeV4("example.com")
+	defer f.Close()
+	serveKV(f)
+
+	a, err := f.util.NewZoneLock(fakeKVNamespace)
+	if !assert.NoError(t, err) {
+		return
+	}
+	b, err := f.util.NewZoneLock(fakeKVNamespace)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "peerscanner:lock:"+fakeZoneId, a.key)
+
+	ctx := context.Background()
+	assert.NoError(t, a.TryLock(ctx, time.Minute))
+	assert.Equal(t, ErrLockHeld, b.TryLock(ctx, time.Minute), "Lock should be exclusive")
+	assert.NoError(t, b.Unlock(), "Unlocking a lock someone else holds should do nothing")
+	assert.Equal(t, ErrLockHeld, b.TryLock(ctx, time.Minute), "Lock should still be held")
+
+	assert.NoError(t, a.Unlock())
+	assert.NoError(t, b.TryLock(ctx, time.Minute), "Lock should be free once unlocked")
+	assert.Equal(t, ErrLockHeld, a.TryLock(ctx, time.Minute))
+	assert.NoError(t, b.Unlock())
+}
+
+func TestZoneLockExpires(t *testing.T) {
+	defer withLockSettle(time.Millisecond)()
+	f := newFakeV4("example.com")
+	defer f.Close()
+	serveKV(f)
+
+	a, _ := f.util.NewZoneLock(fakeKVNamespace)
+	b, _ := f.util.NewZoneLock(fakeKVNamespace)
+	ctx := context.Background()
+	assert.NoError(t, a.TryLock(ctx, 300*time.Millisecond))
+	// Stop a from renewing, as if it had died
+	a.mutex.Lock()
+	close(a.stop)
+	a.stop = nil
+	a.mutex.Unlock()
+
+	assert.Equal(t, ErrLockHeld, b.TryLock(ctx, time.Minute))
+	time.Sleep(400 * time.Millisecond)
+	assert.NoError(t, b.TryLock(ctx, time.Minute), "Lock should be free once expired")
+	b.Unlock()
+}
+
+func TestZoneLockIsRenewed(t *testing.T) {
+	defer withLockSettle(time.Millisecond)()
+	f := newFakeV4("example.com")
+	defer f.Close()
+	serveKV(f)
+
+	a, _ := f.util.NewZoneLock(fakeKVNamespace)
+	b, _ := f.util.NewZoneLock(fakeKVNamespace)
+	ctx := context.Background()
+	assert.NoError(t, a.TryLock(ctx, 300*time.Millisecond))
+	time.Sleep(600 * time.Millisecond)
+	assert.Equal(t, ErrLockHeld, b.TryLock(ctx, time.Minute), "Lock should have been renewed")
+	a.Unlock()
+}
+
+func TestZoneLockMutualExclusion(t *testing.T) {
+	defer withLockSettle(20 * time.Millisecond)()
+	f := newFakeV4("example.com")
+	defer f.Close()
+	serveKV(f)
+
+	var holders, maxHolders, acquisitions int32
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		l, err := f.util.NewZoneLock(fakeKVNamespace)
+		if !assert.NoError(t, err) {
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if err := l.TryLock(context.Background(), time.Minute); err != nil {
+					time.Sleep(5 * time.Millisecond)
+					continue
+				}
+				atomic.AddInt32(&acquisitions, 1)
+				n := atomic.AddInt32(&holders, 1)
+				for {
+					m := atomic.LoadInt32(&maxHolders)
+					if n <= m || atomic.CompareAndSwapInt32(&maxHolders, m, n) {
+						break
+					}
+				}
+				time.Sleep(10 * time.Millisecond)
+				atomic.AddInt32(&holders, -1)
+				l.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.True(t, atomic.LoadInt32(&acquisitions) > 0, "Lock should have been acquired at least once")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&maxHolders), "Lock should never be held by both at once")
+}
+
+func TestSyncGroupHoldsLock(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+	lock := &recordingLock{}
+	f.util.Lock = lock
+	f.handle("GET", "/zones/"+fakeZoneId+"/dns_records", func(body []byte) (int, interface{}) {
+		return 200, []dnsRecord{}
+	})
+
+	assert.NoError(t, f.util.SyncGroup("roundrobin", nil))
+	assert.Equal(t, []string{"lock", "unlock"}, lock.calls)
+
+	lock.err = ErrLockHeld
+	lock.calls = nil
+	assert.Error(t, f.util.SyncGroup("roundrobin", nil), "SyncGroup should fail if the zone is locked")
+	assert.Equal(t, []string{"lock"}, lock.calls)
+}
+
+// recordingLock is a Locker that records how it's used.
+type recordingLock struct {
+	calls []string
+	err   error
+}
+
+func (l *recordingLock) TryLock(ctx context.Context, ttl time.Duration) error {
+	l.calls = append(l.calls, "lock")
+	return l.err
+}
+
+func (l *recordingLock) Unlock() error {
+	l.calls = append(l.calls, "unlock")
+	return nil
+}
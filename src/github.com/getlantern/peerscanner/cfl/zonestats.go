@@ -0,0 +1,66 @@
+package cfl
+
+import (
+	"fmt"
+)
+
+const (
+	// defaultRecordLimit is the number of records CloudFlare allows zones on
+	// plans we don't know about.
+	defaultRecordLimit = 1000
+)
+
+// recordLimits are the number of DNS records CloudFlare allows per zone, by
+// the legacy id of the zone's plan.
+var recordLimits = map[string]int{
+	"free":       1000,
+	"pro":        3500,
+	"business":   3500,
+	"enterprise": 3500,
+}
+
+// ZoneStats describes how close our zone is to its limit on DNS records.
+type ZoneStats struct {
+	RecordCount  int
+	RecordLimit  int
+	UsagePercent float64
+}
+
+// GetZoneStats looks up how many DNS records our zone has and how many its
+// plan allows.
+func (util *Util) GetZoneStats() (*ZoneStats, error) {
+	zone, err := util.zoneId()
+	if err != nil {
+		return nil, err
+	}
+
+	var details struct {
+		Plan struct {
+			LegacyId string `json:"legacy_id"`
+		} `json:"plan"`
+	}
+	err = util.v4Request("GET", "/zones/"+zone, nil, &details)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get zone details: %v", err)
+	}
+	limit, ok := recordLimits[details.Plan.LegacyId]
+	if !ok {
+		log.Debugf("Unknown plan %v, assuming a limit of %d records", details.Plan.LegacyId, defaultRecordLimit)
+		limit = defaultRecordLimit
+	}
+
+	// Only ask for 1 record, since all we need is the total count
+	info, err := util.v4RequestWithInfo("GET", fmt.Sprintf("/zones/%v/dns_records?per_page=1", zone), nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to count DNS records: %v", err)
+	}
+	if info == nil {
+		return nil, fmt.Errorf("No result_info in response to counting DNS records")
+	}
+
+	return &ZoneStats{
+		RecordCount:  info.TotalCount,
+		RecordLimit:  limit,
+		UsagePercent: 100 * float64(info.TotalCount) / float64(limit),
+	}, nil
+}
@@ -0,0 +1,70 @@
+package cfl
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+// zoneDetails is an abbreviated response from GET /zones/{id}
+const zoneDetails = `{
+	"id": "fakezone",
+	"name": "example.com",
+	"status": "active",
+	"paused": false,
+	"type": "full",
+	"name_servers": ["ada.ns.cloudflare.com", "bob.ns.cloudflare.com"],
+	"plan": {
+		"id": "0feeeeeeeeeeeeeeeeeeeeeeeeeeeeee",
+		"name": "Pro Website",
+		"price": 20,
+		"currency": "USD",
+		"frequency": "monthly",
+		"legacy_id": "pro",
+		"is_subscribed": true,
+		"can_subscribe": true
+	},
+	"meta": {
+		"page_rule_quota": 20,
+		"phishing_detected": false
+	}
+}`
+
+func TestGetZoneStats(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+	f.handle("GET", "/zones/"+fakeZoneId, func(body []byte) (int, interface{}) {
+		return 200, json.RawMessage(zoneDetails)
+	})
+	f.handle("GET", "/zones/"+fakeZoneId+"/dns_records", func(body []byte) (int, interface{}) {
+		return 200, v4Paged{
+			result: []dnsRecord{{Id: "rec1", Type: "A", Name: "fl-us-1.example.com", Content: "1.2.3.4"}},
+			info:   v4ResultInfo{Page: 1, PerPage: 1, TotalPages: 2975, Count: 1, TotalCount: 2975},
+		}
+	})
+
+	stats, err := f.util.GetZoneStats()
+	if assert.NoError(t, err) {
+		assert.Equal(t, 2975, stats.RecordCount)
+		assert.Equal(t, 3500, stats.RecordLimit, "Pro zones should allow 3500 records")
+		assert.InDelta(t, 85.0, stats.UsagePercent, 0.001)
+	}
+}
+
+func TestGetZoneStatsUnknownPlan(t *testing.T) {
+	f := newFakeV4("example.com")
+	defer f.Close()
+	f.handle("GET", "/zones/"+fakeZoneId, func(body []byte) (int, interface{}) {
+		return 200, map[string]interface{}{"id": fakeZoneId, "plan": map[string]string{"legacy_id": "custom"}}
+	})
+	f.handle("GET", "/zones/"+fakeZoneId+"/dns_records", func(body []byte) (int, interface{}) {
+		return 200, v4Paged{result: []dnsRecord{}, info: v4ResultInfo{TotalCount: 100}}
+	})
+
+	stats, err := f.util.GetZoneStats()
+	if assert.NoError(t, err) {
+		assert.Equal(t, defaultRecordLimit, stats.RecordLimit)
+		assert.InDelta(t, 10.0, stats.UsagePercent, 0.001)
+	}
+}
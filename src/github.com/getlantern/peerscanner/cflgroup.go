@@ -6,13 +6,13 @@ import (
 
 // cflGroup represents a host's participation in a rotation (e.g. roundrobin)
 type cflGroup struct {
-	subdomain  string
+	subdomain  GroupName
 	existing   *cloudflare.Record
 	isProxying bool
 }
 
 func (g *cflGroup) String() string {
-	return g.subdomain
+	return string(g.subdomain)
 }
 
 // register registers a host with this cflGroup in CloudFlare if it isn't
@@ -25,16 +25,19 @@ func (g *cflGroup) register(h *host) error {
 	log.Debugf("Registering to %v: %v", g.subdomain, h)
 
 	var err error
-	g.existing, g.isProxying, err = cflutil.EnsureRegistered(g.subdomain, h.ip, g.existing)
+	g.existing, g.isProxying, err = cflutil.EnsureRegisteredWithTtl(string(g.subdomain), h.ip, g.existing, h.getRecordTtl())
+	if g.existing != nil {
+		membersOf(g.subdomain).Add(h.ip)
+	}
 	return err
 }
 
 // deregister deregisters the host from this cflGroup in CloudFlare if it is
 // currently registered.
-func (g *cflGroup) deregister(h *host) {
+func (g *cflGroup) deregister(h *host) error {
 	if g.existing == nil {
 		log.Tracef("%v is not registered in Cloudflare's %v, no need to deregister", h, g.subdomain)
-		return
+		return nil
 	}
 
 	log.Debugf("Deregistering from %v: %v", g.subdomain, h)
@@ -43,9 +46,15 @@ func (g *cflGroup) deregister(h *host) {
 	err := cflutil.DestroyRecord(g.existing)
 	g.existing = nil
 	g.isProxying = false
+	members := membersOf(g.subdomain)
+	members.Remove(h.ip)
+	if config, configured := groupConfigFor(g.subdomain); configured && members.Len() < config.MinMembers {
+		log.Errorf("WARNING: %v is down to %d members, it should have at least %d", g.subdomain, members.Len(), config.MinMembers)
+	}
 
 	if err != nil {
 		log.Errorf("Unable to deregister host %v from Cloudflare's rotation %v: %v", h, g.subdomain, err)
-		return
+		return err
 	}
+	return nil
 }
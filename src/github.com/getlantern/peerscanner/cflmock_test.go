@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/getlantern/peerscanner/cfl"
+	"github.com/getlantern/peerscanner/cfl/cfltest"
+)
+
+// mockCfl is a cfltest.MockServer for getiantem.org that cflutil points at.
+type mockCfl struct {
+	*cfltest.MockServer
+	origUtil *cfl.Util
+}
+
+// newMockCfl starts a mockCfl and points cflutil at it. Call close() to
+// restore cflutil.
+func newMockCfl() *mockCfl {
+	m := &mockCfl{MockServer: cfltest.NewMockServer("getiantem.org"), origUtil: cflutil}
+	m.Start()
+	var err error
+	cflutil, err = m.NewUtil()
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func (m *mockCfl) close() {
+	cflutil = m.origUtil
+	m.Close()
+}
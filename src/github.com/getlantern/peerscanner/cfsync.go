@@ -0,0 +1,56 @@
+package main
+
+import (
+	"expvar"
+	"flag"
+	"time"
+)
+
+const (
+	cfSyncCheckInterval = 1 * time.Minute
+)
+
+var (
+	cfSyncAlertThreshold = flag.Duration("cf-sync-alert-threshold", 5*time.Minute, "Warn about hosts whose records haven't been confirmed in CloudFlare for longer than this, defaults to 5 minutes")
+)
+
+// cloudFlareSynced records the outcome of writing h's records to CloudFlare.
+// Writes that turn out not to be needed count as syncs too, since they
+// confirm that the records are as they should be.
+func (h *host) cloudFlareSynced(err error) {
+	h.lastCloudFlareSyncErr = err
+	if err == nil {
+		h.lastCloudFlareSync = time.Now()
+	}
+}
+
+// cfSyncAges returns how long ago the records of each host that isn't paused
+// were last synced to CloudFlare, in seconds. Hosts that were never synced
+// are left out.
+func (p *HostPool) cfSyncAges(now time.Time) map[string]float64 {
+	ages := make(map[string]float64)
+	for _, info := range p.Snapshot() {
+		if info.state == StatePaused || info.lastCfSync.IsZero() {
+			continue
+		}
+		ages[hostkey{info.name, info.ip}.String()] = now.Sub(info.lastCfSync).Seconds()
+	}
+	return ages
+}
+
+// startCfSyncMonitor publishes cf_sync_age_seconds for the hosts in pool and
+// warns about the ones whose age exceeds -cf-sync-alert-threshold.
+func startCfSyncMonitor(pool *HostPool) {
+	expvar.Publish("cf_sync_age_seconds", expvar.Func(func() interface{} {
+		return pool.cfSyncAges(time.Now())
+	}))
+	go func() {
+		for range time.Tick(cfSyncCheckInterval) {
+			for host, age := range pool.cfSyncAges(time.Now()) {
+				if age > cfSyncAlertThreshold.Seconds() {
+					log.Errorf("WARNING: Records of %v were last synced to CloudFlare %v ago", host, time.Duration(age*float64(time.Second)).Round(time.Second))
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/getlantern/peerscanner/cfl/cfltest"
+	"github.com/getlantern/testify/assert"
+)
+
+func TestCloudFlareSyncAfterCheck(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	name, ip := "fl-us-cfsync", "45.63.10.1"
+	f := newFakeFallback(name)
+	defer f.close()
+	h, _ := newTestHost(name, ip, f)
+
+	before := time.Now()
+	h.check()
+	info := h.getInfo()
+	assert.True(t, !info.lastCfSync.Before(before), "Successful write should have been recorded")
+	assert.Equal(t, "", info.lastCfSyncErr)
+
+	pool := newTestPool(h)
+	rec := httptest.NewRecorder()
+	pool.listHosts(rec, httptest.NewRequest("GET", "/debug/hosts", nil))
+	var reports []map[string]interface{}
+	if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &reports)) && assert.Len(t, reports, 1) {
+		assert.Equal(t, info.lastCfSync.Format(time.RFC3339Nano), reports[0]["lastCloudFlareSync"])
+	}
+	ages := pool.cfSyncAges(info.lastCfSync.Add(time.Minute))
+	assert.Equal(t, map[string]float64{name + "@" + ip: 60}, ages)
+}
+
+func TestCloudFlareSyncError(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	m.SetV4Fail(func(req cfltest.V4Request) bool { return true })
+	name, ip := "fl-us-cfsyncerr", "45.63.10.2"
+	f := newFakeFallback(name)
+	defer f.close()
+	h, _ := newTestHost(name, ip, f)
+
+	h.check()
+	info := h.getInfo()
+	assert.True(t, info.lastCfSync.IsZero(), "Failed write shouldn't count as a sync")
+	assert.NotEqual(t, "", info.lastCfSyncErr, "Error should have been recorded")
+	assert.Len(t, newTestPool(h).cfSyncAges(time.Now()), 0, "Host that was never synced shouldn't have an age")
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/getlantern/peerscanner/cfl"
+)
+
+// listCfTelemetry is the debug endpoint that lists the last calls that we made
+// to CloudFlare, oldest first, for debugging problems with CloudFlare's API.
+func listCfTelemetry(resp http.ResponseWriter, req *http.Request) {
+	entries := cflutil.Telemetry()
+	if entries == nil {
+		entries = make([]cfl.TelemetryEntry, 0)
+	}
+	writeJSON(resp, entries)
+}
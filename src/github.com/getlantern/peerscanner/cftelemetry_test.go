@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/getlantern/peerscanner/cfl"
+	"github.com/getlantern/testify/assert"
+)
+
+func TestListCfTelemetry(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	rec := httptest.NewRecorder()
+	listCfTelemetry(rec, httptest.NewRequest("GET", "/debug/cf-telemetry", nil))
+	assert.Equal(t, "[]", strings.TrimSpace(rec.Body.String()), "Util without telemetry should have no entries")
+
+	var err error
+	cflutil, err = m.NewUtil(cfl.WithTelemetry())
+	if !assert.NoError(t, err) {
+		return
+	}
+	m.AddRecord("A", string(RoundRobin), "45.63.12.1")
+	_, err = cflutil.FindRecord(string(RoundRobin), "45.63.12.1")
+	assert.NoError(t, err)
+
+	rec = httptest.NewRecorder()
+	listCfTelemetry(rec, httptest.NewRequest("GET", "/debug/cf-telemetry", nil))
+	var entries []cfl.TelemetryEntry
+	if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &entries)) && assert.NotEmpty(t, entries) {
+		last := entries[len(entries)-1]
+		assert.Equal(t, 200, last.Status)
+		assert.NotEqual(t, "", last.URL)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"sync"
+	"time"
+)
+
+var (
+	checkWorkers = flag.Int("check-workers", 100, "Number of workers that run host checks, defaults to 100")
+
+	checkRequests         = make(chan *checkRequest)
+	checkTimeout          = ttl
+	startCheckWorkersOnce sync.Once
+)
+
+// checkRequest asks a check worker to test connectivity via a host
+type checkRequest struct {
+	h        *host
+	resultCh chan *checkResult
+}
+
+type checkResult struct {
+	s        *status
+	timedOut bool
+	err      error
+	// elapsed is how long the check took once a worker picked it up
+	elapsed time.Duration
+}
+
+// submitCheck hands the check for h to the shared pool of check workers and
+// waits for its result. This way, the number of checks in flight is bounded
+// by -check-workers no matter how many hosts there are.
+func submitCheck(h *host) *checkResult {
+	startCheckWorkersOnce.Do(func() {
+		log.Debugf("Starting %d check workers", *checkWorkers)
+		for i := 0; i < *checkWorkers; i++ {
+			go checkWorker()
+		}
+	})
+	req := &checkRequest{h, make(chan *checkResult, 1)}
+	checkRequests <- req
+	return <-req.resultCh
+}
+
+func checkWorker() {
+	for req := range checkRequests {
+		req.resultCh <- runCheck(req.h)
+	}
+}
+
+// runCheck tests connectivity via h, giving up after checkTimeout. The check's
+// dials and requests are bound to the timeout, so a check that times out
+// stops rather than running on in the background.
+func runCheck(h *host) *checkResult {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+	online, connectionRefused, err := h.isAbleToProxy(ctx)
+	s := &status{online, connectionRefused}
+	timedOut := ctx.Err() == context.DeadlineExceeded
+	if timedOut {
+		s = &status{false, false}
+	}
+	return &checkResult{s, timedOut, err, time.Since(start)}
+}
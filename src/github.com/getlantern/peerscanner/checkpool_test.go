@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestSubmitCheck(t *testing.T) {
+	h := mustNewHost("fl-us-pool", "45.63.3.1", "80")
+	h.dialer = &mockDialer{err: fmt.Errorf("connection refused")}
+	result := submitCheck(h)
+	assert.False(t, result.s.online, "Check should have failed")
+	assert.False(t, result.timedOut, "Check shouldn't have timed out")
+	assert.Error(t, result.err, "Check should report error")
+}
+
+// hangingDialer never connects, it waits for the dial to be given up on.
+type hangingDialer struct {
+	gaveUp chan struct{}
+}
+
+func (d *hangingDialer) Dial(ctx context.Context, network, address string) (net.Conn, error) {
+	<-ctx.Done()
+	close(d.gaveUp)
+	return nil, ctx.Err()
+}
+
+func TestRunCheckTimesOut(t *testing.T) {
+	orig := checkTimeout
+	defer func() { checkTimeout = orig }()
+	checkTimeout = 50 * time.Millisecond
+	h := mustNewHost("fl-us-hanging", "45.63.3.2", "80")
+	d := &hangingDialer{gaveUp: make(chan struct{})}
+	h.dialer = d
+
+	result := runCheck(h)
+	assert.True(t, result.timedOut, "Check should have timed out")
+	assert.False(t, result.s.online)
+	assert.True(t, result.elapsed >= checkTimeout && result.elapsed < time.Second, "Elapsed time should be about the timeout, was %v", result.elapsed)
+	select {
+	case <-d.gaveUp:
+	default:
+		t.Error("Timed out check should have stopped dialing")
+	}
+}
+
+func BenchmarkChecks1000WithoutPool(b *testing.B) {
+	benchmarkChecks(b, 1000, runCheck)
+}
+
+func BenchmarkChecks1000WithPool(b *testing.B) {
+	benchmarkChecks(b, 1000, submitCheck)
+}
+
+func benchmarkChecks(b *testing.B, numHosts int, check func(*host) *checkResult) {
+	d := &mockDialer{err: fmt.Errorf("connection refused")}
+	hs := make([]*host, numHosts)
+	for i := range hs {
+		hs[i] = mustNewHost(fmt.Sprintf("fl-us-bench%d", i), fmt.Sprintf("45.63.%d.%d", i/256, i%256), "80")
+		hs[i].dialer = d
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var wg sync.WaitGroup
+		wg.Add(numHosts)
+		for _, h := range hs {
+			go func(h *host) {
+				check(h)
+				wg.Done()
+			}(h)
+		}
+		wg.Wait()
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"expvar"
+	"flag"
+	"strings"
+)
+
+const (
+	// minChecksForTimeoutRatio is how many checks a host needs in its health
+	// history before its timeout ratio is judged, so that a single timeout
+	// of a new host isn't warned about
+	minChecksForTimeoutRatio = 10
+)
+
+var (
+	timeoutRatioThreshold = flag.Float64("timeout-ratio-threshold", 0.3, "Warn about hosts more than this fraction of whose last 100 checks timed out, defaults to 0.3")
+
+	// checkTimeouts counts the checks that timed out by <name>@<ip>
+	checkTimeouts = expvar.NewMap("peer_check_timeout_total")
+)
+
+// isCheckTimeout tells whether a check with the given result timed out, either
+// because it took longer than the ttl or because a dial or request that it
+// made timed out. The check wraps the errors it gets, so timeouts are told by
+// their message.
+func isCheckTimeout(result *checkResult) bool {
+	if result.timedOut {
+		return true
+	}
+	return result.err != nil && strings.Contains(strings.ToLower(result.err.Error()), "timeout")
+}
+
+// timeoutRatio returns the fraction of the results in the history that timed
+// out and how many results there are.
+func (hh *HealthHistory) timeoutRatio() (float64, int) {
+	results := hh.snapshot()
+	if len(results) == 0 {
+		return 0, 0
+	}
+	timeouts := 0
+	for _, r := range results {
+		if r.timedOut {
+			timeouts++
+		}
+	}
+	return float64(timeouts) / float64(len(results)), len(results)
+}
+
+// checkTimedOut counts a check of h that timed out in peer_check_timeout_total.
+func (h *host) checkTimedOut() {
+	checkTimeouts.Add(hostkey{h.name, h.ip}.String(), 1)
+}
+
+// warnAboutTimeouts warns once h's timeout ratio goes over
+// -timeout-ratio-threshold, and again only after it has come back under. Such
+// a host is usually reachable but slow, which is different from a host that
+// keeps failing its checks outright.
+func (h *host) warnAboutTimeouts() {
+	ratio, checks := h.healthHistory.timeoutRatio()
+	exceeded := checks >= minChecksForTimeoutRatio && ratio > *timeoutRatioThreshold
+	if exceeded && !h.timeoutRatioExceeded {
+		log.Errorf("WARNING: %.0f%% of the last %d checks of %v timed out", ratio*100, checks, h)
+	}
+	h.timeoutRatioExceeded = exceeded
+}
+
+// checkTimeoutRatios returns the timeout ratio of each host in the pool that
+// has been checked, by <name>@<ip>.
+func (p *HostPool) checkTimeoutRatios() map[string]float64 {
+	ratios := make(map[string]float64)
+	for _, info := range p.Snapshot() {
+		if info.lastTest.IsZero() {
+			continue
+		}
+		ratios[hostkey{info.name, info.ip}.String()] = info.timeoutRatio
+	}
+	return ratios
+}
+
+// publishCheckTimeoutRatios publishes peer_check_timeout_ratio for the hosts in
+// pool.
+func publishCheckTimeoutRatios(pool *HostPool) {
+	expvar.Publish("peer_check_timeout_ratio", expvar.Func(func() interface{} {
+		return pool.checkTimeoutRatios()
+	}))
+}
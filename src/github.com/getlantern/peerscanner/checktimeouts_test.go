@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestIsCheckTimeout(t *testing.T) {
+	assert.True(t, isCheckTimeout(&checkResult{timedOut: true}), "Check that took longer than the ttl should have timed out")
+	assert.True(t, isCheckTimeout(&checkResult{err: fmt.Errorf("Unable to make proxied HEAD request: dial tcp 45.63.5.1:443: i/o timeout")}))
+	assert.True(t, isCheckTimeout(&checkResult{err: fmt.Errorf("Unable to make proxied HEAD request: net/http: TLS handshake timeout")}))
+	assert.False(t, isCheckTimeout(&checkResult{err: fmt.Errorf("connection refused")}))
+	assert.False(t, isCheckTimeout(&checkResult{}))
+}
+
+func TestHealthHistoryTimeoutRatio(t *testing.T) {
+	hh := &HealthHistory{}
+	ratio, checks := hh.timeoutRatio()
+	assert.Equal(t, 0.0, ratio)
+	assert.Equal(t, 0, checks)
+
+	for i := 0; i < healthHistorySize+20; i++ {
+		// The first 20 are all timeouts and drop out of the history
+		hh.add(healthResult{timedOut: i < 20 || i%4 == 0})
+	}
+	ratio, checks = hh.timeoutRatio()
+	assert.Equal(t, 0.25, ratio, "Only the last 100 checks should count")
+	assert.Equal(t, healthHistorySize, checks)
+}
+
+func TestCheckTimeouts(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	origThreshold := *timeoutRatioThreshold
+	*timeoutRatioThreshold = 0.3
+	defer func() { *timeoutRatioThreshold = origThreshold }()
+	name, ip := "fl-us-timeouts", "45.63.5.1"
+	f := newFakeFallback(name)
+	defer f.close()
+	h, d := newTestHost(name, ip, f)
+	key := hostkey{name, ip}.String()
+	before := int64(0)
+	if v := checkTimeouts.Get(key); v != nil {
+		before = v.(interface{ Value() int64 }).Value()
+	}
+
+	for i := 0; i < 6; i++ {
+		h.check()
+	}
+	d.setErr(fmt.Errorf("dial tcp %v:443: i/o timeout", ip))
+	for i := 0; i < 3; i++ {
+		h.check()
+	}
+	assert.Equal(t, fmt.Sprint(before+3), checkTimeouts.Get(key).String(), "Timeouts should be counted")
+	assert.False(t, h.timeoutRatioExceeded, "Too few checks to judge the ratio")
+
+	h.check()
+	assert.True(t, h.timeoutRatioExceeded, "4 of 10 checks timing out should exceed the threshold")
+	assert.Equal(t, 4, h.consecutiveFailures, "Timeouts should count as failures too")
+
+	pool := newTestPool(h)
+	assert.Equal(t, 0.4, pool.checkTimeoutRatios()[key])
+	rec := httptest.NewRecorder()
+	pool.listHosts(rec, httptest.NewRequest("GET", "/debug/hosts", nil))
+	var reports []hostReport
+	if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &reports)) && assert.Len(t, reports, 1) {
+		assert.Equal(t, 0.4, reports[0].CheckTimeoutRatio)
+	}
+
+	d.setErr(nil)
+	for i := 0; i < 5; i++ {
+		h.check()
+	}
+	assert.False(t, h.timeoutRatioExceeded, "4 of 15 checks timing out shouldn't exceed the threshold")
+	assert.Equal(t, 0, h.consecutiveFailures)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// circularBuffer holds the most recent durations added to it, up to its
+// capacity. It is safe for concurrent use.
+type circularBuffer struct {
+	mutex  sync.Mutex
+	values []time.Duration
+	next   int
+	full   bool
+}
+
+func newCircularBuffer(capacity int) *circularBuffer {
+	return &circularBuffer{values: make([]time.Duration, capacity)}
+}
+
+// add adds a duration, overwriting the oldest one if the buffer is full.
+func (b *circularBuffer) add(d time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.values[b.next] = d
+	b.next = (b.next + 1) % len(b.values)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// snapshot returns the durations currently in the buffer, oldest first.
+func (b *circularBuffer) snapshot() []time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if !b.full {
+		return append([]time.Duration(nil), b.values[:b.next]...)
+	}
+	result := make([]time.Duration, 0, len(b.values))
+	result = append(result, b.values[b.next:]...)
+	return append(result, b.values[:b.next]...)
+}
+
+// percentiles returns the given percentiles (0-100) of the durations in the
+// buffer using the nearest-rank method. If the buffer is empty, all
+// percentiles are 0.
+func (b *circularBuffer) percentiles(ps ...float64) []time.Duration {
+	values := b.snapshot()
+	sort.Sort(durations(values))
+	result := make([]time.Duration, len(ps))
+	if len(values) == 0 {
+		return result
+	}
+	for i, p := range ps {
+		rank := int(math.Ceil(p / 100 * float64(len(values))))
+		if rank < 1 {
+			rank = 1
+		}
+		result[i] = values[rank-1]
+	}
+	return result
+}
+
+type durations []time.Duration
+
+func (a durations) Len() int           { return len(a) }
+func (a durations) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a durations) Less(i, j int) bool { return a[i] < a[j] }
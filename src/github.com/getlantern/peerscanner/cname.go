@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+)
+
+const (
+	// cnameAliasSuffix is appended to a group's name to get the name of its
+	// CNAME alias
+	cnameAliasSuffix = "-alias"
+)
+
+var (
+	cfCreateCnameAliases = flag.Bool("cf-create-cname-aliases", false, "After loading the rotations, make sure every group has a <group>-alias CNAME record pointing at it, for alias based discovery, defaults to false")
+)
+
+// cnameAliasFor returns the name of the CNAME alias for group g.
+func cnameAliasFor(g GroupName) string {
+	return string(g) + cnameAliasSuffix
+}
+
+// createCnameAliases creates a CNAME alias for each of the given groups,
+// except for StagingGroup, whose members don't get any traffic.
+func createCnameAliases(groups []GroupName) {
+	for _, g := range groups {
+		if g == StagingGroup {
+			continue
+		}
+		target := string(g) + "." + *cfldomain
+		if err := cflutil.CreateCNAMERecord(cnameAliasFor(g), target); err != nil {
+			log.Errorf("Unable to create CNAME alias for %v: %v", g, err)
+		}
+	}
+}
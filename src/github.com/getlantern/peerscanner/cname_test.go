@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestLoadHostsCreatesCnameAliases(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	*cfCreateCnameAliases = true
+	defer func() {
+		*cfCreateCnameAliases = false
+	}()
+
+	m.AddRecord("A", "us.fallbacks", "45.63.9.1")
+	m.AddRecord("CNAME", "fl-us-cname", "roundrobin.getiantem.org")
+
+	pool := NewHostPool()
+	if !assert.NoError(t, pool.Load()) {
+		return
+	}
+	assert.Equal(t, 0, pool.Len(), "CNAME records shouldn't be loaded as hosts")
+	for _, g := range []GroupName{RoundRobin, Fallbacks, Peers, "us.fallbacks"} {
+		aliases := m.FindRecords(string(g)+"-alias", "")
+		if assert.Len(t, aliases, 1, "%v should have an alias", g) {
+			assert.Equal(t, "CNAME", aliases[0].Type)
+			assert.Equal(t, string(g)+".getiantem.org", aliases[0].Value)
+		}
+	}
+	assert.Len(t, m.FindRecords(string(StagingGroup)+"-alias", ""), 0, "Staging shouldn't have an alias")
+
+	// Creating aliases again shouldn't duplicate them
+	assert.NoError(t, NewHostPool().Load())
+	assert.Len(t, m.FindRecords(string(RoundRobin)+"-alias", ""), 1)
+}
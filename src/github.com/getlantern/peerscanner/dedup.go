@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"time"
+
+	"github.com/hashicorp/golang-lru"
+)
+
+const (
+	seenCacheSize = 10000
+)
+
+var (
+	dedupWindow = flag.Duration("dedup-window", 5*time.Second, "Registrations for the same name and ip within this window are acknowledged without being processed, defaults to 5s")
+
+	// seenCache remembers when we last processed a registration for a given
+	// name and ip.
+	seenCache = newSeenCache()
+)
+
+func newSeenCache() *lru.Cache {
+	c, err := lru.New(seenCacheSize)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// isDuplicateRegistration checks whether we already processed a registration
+// for the given name and ip within the dedup window. If we didn't, it records
+// this registration as the most recent one. Since lru.Cache does its own
+// locking, this doesn't need to lock the HostPool.
+func isDuplicateRegistration(name string, ip string) bool {
+	key := name + "@" + ip
+	now := time.Now()
+	last, found := seenCache.Get(key)
+	if found && now.Sub(last.(time.Time)) < *dedupWindow {
+		dedupHits.Add(1)
+		return true
+	}
+	seenCache.Add(key, now)
+	return false
+}
+
+// forgetRegistration forgets the registration for the given name and ip, so
+// that a retry within the dedup window is processed. It's for registrations
+// that we rejected after isDuplicateRegistration recorded them.
+func forgetRegistration(name string, ip string) {
+	seenCache.Remove(name + "@" + ip)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/getlantern/cloudflare"
+)
+
+// deduplicateRecords finds records with the same type, name and value, which
+// CloudFlare allows and which we can end up with if we crash while creating
+// records. Of each set of duplicates, it keeps the one with the lowest id, so
+// that all instances sharing the zone keep the same one, and returns the rest
+// as deleted. Kept records are in their original order.
+func deduplicateRecords(recs []cloudflare.Record) (kept []cloudflare.Record, deleted []cloudflare.Record) {
+	first := make(map[string]int, len(recs))
+	for i, r := range recs {
+		key := r.Type + " " + r.Name + " " + r.Value
+		j, found := first[key]
+		if !found || idLess(r.Id, recs[j].Id) {
+			first[key] = i
+		}
+	}
+
+	keep := make(map[int]bool, len(first))
+	for _, i := range first {
+		keep[i] = true
+	}
+	for i, r := range recs {
+		if keep[i] {
+			kept = append(kept, r)
+		} else {
+			deleted = append(deleted, r)
+		}
+	}
+	sort.Slice(deleted, func(i, j int) bool { return idLess(deleted[i].Id, deleted[j].Id) })
+	return kept, deleted
+}
+
+// idLess compares record ids numerically if they're numbers (like the client
+// API's ids used to be) and lexically otherwise (like v4 ids).
+func idLess(a string, b string) bool {
+	ai, aErr := strconv.ParseInt(a, 10, 64)
+	bi, bErr := strconv.ParseInt(b, 10, 64)
+	if aErr == nil && bErr == nil {
+		return ai < bi
+	}
+	return a < b
+}
+
+// removeDuplicateRecords destroys the duplicates among recs, returning the
+// records that are left. Duplicates that couldn't be destroyed are still in
+// CloudFlare, so they're returned too, in their original order, and the next
+// Load tries again.
+func removeDuplicateRecords(recs []cloudflare.Record) []cloudflare.Record {
+	_, deleted := deduplicateRecords(recs)
+	if len(deleted) == 0 {
+		return recs
+	}
+	destroyed := make(map[string]bool, len(deleted))
+	for _, r := range deleted {
+		if err := cflutil.DestroyRecordById(r.Id); err != nil {
+			log.Errorf("Unable to delete duplicate record %v (%v): %v", r.FullName, r.Value, err)
+			continue
+		}
+		destroyed[r.Id] = true
+	}
+	log.Debugf("Deleted %d of %d duplicate Cloudflare records", len(destroyed), len(deleted))
+	left := make([]cloudflare.Record, 0, len(recs)-len(destroyed))
+	for _, r := range recs {
+		if !destroyed[r.Id] {
+			left = append(left, r)
+		}
+	}
+	return left
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/getlantern/cloudflare"
+	"github.com/getlantern/peerscanner/cfl/cfltest"
+	"github.com/getlantern/testify/assert"
+)
+
+func TestDeduplicateRecords(t *testing.T) {
+	rec := func(id string, name string, value string) cloudflare.Record {
+		return cloudflare.Record{Id: id, Type: "A", Name: name, Value: value}
+	}
+	recs := []cloudflare.Record{
+		rec("12", "fl-us-dup", "45.63.10.1"),
+		rec("3", "fl-us-other", "45.63.10.2"),
+		rec("9", "fl-us-dup", "45.63.10.1"),
+		rec("10", "fl-us-dup", "45.63.10.1"),
+		rec("4", string(RoundRobin), "45.63.10.1"),
+	}
+	kept, deleted := deduplicateRecords(recs)
+	assert.Equal(t, []cloudflare.Record{recs[1], recs[2], recs[4]}, kept, "Should keep the record with the lowest id of every name and value")
+	assert.Equal(t, []cloudflare.Record{recs[3], recs[0]}, deleted, "Should delete the later duplicates")
+}
+
+func TestLoadHostsRemovesDuplicates(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+
+	// Use a peer so that Load doesn't start checking it
+	first := m.AddRecord("A", "peer-dup", "1.2.3.4")
+	m.AddRecord("A", "peer-dup", "1.2.3.4")
+	m.AddRecord("A", "peer-dup", "1.2.3.4")
+
+	err := NewHostPool().Load()
+	assert.NoError(t, err)
+	found := m.FindRecords("peer-dup", "1.2.3.4")
+	if assert.Len(t, found, 1, "Duplicates should have been deleted") {
+		assert.Equal(t, first.Id, found[0].Id, "Record with the lowest id should have been kept")
+	}
+}
+
+func TestRemoveDuplicateRecordsKeepsFailedDeletions(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	first := m.AddRecord("A", "peer-dup", "1.2.3.4")
+	second := m.AddRecord("A", "peer-dup", "1.2.3.4")
+	third := m.AddRecord("A", "peer-dup", "1.2.3.4")
+	m.SetV4Fail(func(req cfltest.V4Request) bool {
+		return req.Method == "DELETE" && strings.HasSuffix(req.Path, "/"+second.Id)
+	})
+
+	left := removeDuplicateRecords([]cloudflare.Record{first, second, third})
+	assert.Equal(t, []cloudflare.Record{first, second}, left, "Duplicate that couldn't be deleted should still be there")
+	assert.Len(t, m.FindRecords("peer-dup", "1.2.3.4"), 2, "Only the other duplicate should have been deleted")
+}
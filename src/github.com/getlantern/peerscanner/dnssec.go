@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// dnssecRequest is the body of POST /v1/admin/dnssec
+type dnssecRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// setDNSSEC is the admin endpoint at POST /v1/admin/dnssec that enables or
+// disables DNSSEC for our zone, given {"enabled": true} or
+// {"enabled": false}. It responds with the zone's DNSSEC status afterwards,
+// which includes the DS record to add at the registrar.
+func setDNSSEC(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		resp.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintln(resp, "Only POST is supported")
+		return
+	}
+	var body dnssecRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body.Enabled == nil {
+		resp.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(resp, `Body must be {"enabled": true} or {"enabled": false}`)
+		return
+	}
+
+	var err error
+	if *body.Enabled {
+		err = cflutil.EnableDNSSEC(req.Context())
+	} else {
+		err = cflutil.DisableDNSSEC(req.Context())
+	}
+	if err != nil {
+		resp.WriteHeader(http.StatusBadGateway)
+		fmt.Fprintln(resp, err.Error())
+		return
+	}
+	status, err := cflutil.GetDNSSECStatus()
+	if err != nil {
+		resp.WriteHeader(http.StatusBadGateway)
+		fmt.Fprintln(resp, err.Error())
+		return
+	}
+	log.Debugf("DNSSEC is now %v", status.Status)
+	writeJSON(resp, status)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/getlantern/peerscanner/cfl"
+	"github.com/getlantern/testify/assert"
+)
+
+func TestSetDNSSEC(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+
+	rec := httptest.NewRecorder()
+	setDNSSEC(rec, httptest.NewRequest("POST", "/v1/admin/dnssec", strings.NewReader(`{"enabled": true}`)))
+	assert.Equal(t, 200, rec.Code)
+	var status cfl.DNSSECStatus
+	if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status)) {
+		assert.Equal(t, "active", status.Status)
+		assert.Equal(t, 2371, status.KeyTag)
+		assert.Contains(t, status.DS, "IN DS 2371 13 2")
+	}
+
+	rec = httptest.NewRecorder()
+	setDNSSEC(rec, httptest.NewRequest("POST", "/v1/admin/dnssec", strings.NewReader(`{"enabled": false}`)))
+	assert.Equal(t, 200, rec.Code)
+	status = cfl.DNSSECStatus{}
+	if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status)) {
+		assert.Equal(t, cfl.DNSSECStatus{Status: "disabled"}, status)
+	}
+	assert.Equal(t, "disabled", m.DNSSECStatus())
+}
+
+func TestSetDNSSECBadRequest(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+
+	for _, body := range []string{"", "{}", `{"enabled": "yes"}`} {
+		rec := httptest.NewRecorder()
+		setDNSSEC(rec, httptest.NewRequest("POST", "/v1/admin/dnssec", strings.NewReader(body)))
+		assert.Equal(t, 400, rec.Code, "Body %q should be rejected", body)
+	}
+	rec := httptest.NewRecorder()
+	setDNSSEC(rec, httptest.NewRequest("GET", "/v1/admin/dnssec", nil))
+	assert.Equal(t, 405, rec.Code)
+	assert.Equal(t, "disabled", m.DNSSECStatus(), "Bad requests shouldn't change DNSSEC")
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	dohTimeout = 5 * time.Second
+)
+
+var (
+	dohURL = flag.String("doh-url", "", "(optional) DNS-over-HTTPS endpoint (e.g. https://1.1.1.1/dns-query) used to resolve names when checking hosts, instead of the system resolver")
+)
+
+// newDoHResolver creates a net.Resolver that sends its queries to the
+// DNS-over-HTTPS endpoint at url (RFC 8484) instead of to the system's DNS
+// servers, which may be monitored or censored.
+func newDoHResolver(url string) *net.Resolver {
+	client := &http.Client{Timeout: dohTimeout}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return &dohConn{ctx: ctx, url: url, client: client}, nil
+		},
+	}
+}
+
+// dohDialer returns the Dialer to use for checking hosts, which resolves names
+// through -doh-url if it's set.
+func dohDialer() Dialer {
+	if *dohURL == "" {
+		return defaultDialer
+	}
+	log.Debugf("Resolving names for host checks through %v", *dohURL)
+	return dialerFunc((&net.Dialer{Resolver: newDoHResolver(*dohURL)}).DialContext)
+}
+
+// dohConn looks like a TCP connection to a DNS server to Go's resolver. DNS
+// messages written to it (prefixed with their 2 byte length, as with DNS over
+// TCP) are POSTed to the DoH endpoint, and the responses can be read back in
+// the same format.
+type dohConn struct {
+	ctx    context.Context
+	url    string
+	client *http.Client
+
+	out bytes.Buffer
+	in  bytes.Buffer
+}
+
+func (c *dohConn) Write(b []byte) (int, error) {
+	return c.out.Write(b)
+}
+
+func (c *dohConn) Read(b []byte) (int, error) {
+	if c.in.Len() == 0 {
+		if err := c.roundTrip(); err != nil {
+			return 0, err
+		}
+	}
+	return c.in.Read(b)
+}
+
+// roundTrip sends the query that was written to the DoH endpoint and queues up
+// the response for reading.
+func (c *dohConn) roundTrip() error {
+	if c.out.Len() < 2 {
+		return io.EOF
+	}
+	size := int(binary.BigEndian.Uint16(c.out.Bytes()))
+	if c.out.Len() < 2+size {
+		return fmt.Errorf("Incomplete DNS query")
+	}
+	c.out.Next(2)
+	query := c.out.Next(size)
+
+	req, err := http.NewRequest("POST", c.url, bytes.NewReader(query))
+	if err != nil {
+		return fmt.Errorf("Unable to create DoH request: %v", err)
+	}
+	req = req.WithContext(c.ctx)
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Unable to query %v: %v", c.url, err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Debugf("Unable to close DoH response body: %v", err)
+		}
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v responded with unexpected status %d", c.url, resp.StatusCode)
+	}
+	answer, err := ioutil.ReadAll(io.LimitReader(resp.Body, 65535))
+	if err != nil {
+		return fmt.Errorf("Unable to read DoH response: %v", err)
+	}
+	var prefix [2]byte
+	binary.BigEndian.PutUint16(prefix[:], uint16(len(answer)))
+	c.in.Write(prefix[:])
+	c.in.Write(answer)
+	return nil
+}
+
+func (c *dohConn) Close() error                       { return nil }
+func (c *dohConn) LocalAddr() net.Addr                { return dohAddr(c.url) }
+func (c *dohConn) RemoteAddr() net.Addr               { return dohAddr(c.url) }
+func (c *dohConn) SetDeadline(t time.Time) error      { return nil }
+func (c *dohConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *dohConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// dohAddr is the address of a DoH endpoint
+type dohAddr string
+
+func (a dohAddr) Network() string { return "https" }
+func (a dohAddr) String() string  { return string(a) }
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+const (
+	dnsTypeA = 1
+)
+
+// newMockDoH starts a DoH server that answers A queries for name with ip and
+// everything else with no answers.
+func newMockDoH(name string, ip net.IP) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" || req.Header.Get("Content-Type") != "application/dns-message" {
+			resp.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		query, err := ioutil.ReadAll(req.Body)
+		if err != nil || len(query) < 12 {
+			resp.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		qname, qtype, end := parseQuestion(query)
+		if end < 0 {
+			resp.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// Header: same id, response with recursion available, 1 question
+		answer := make([]byte, 12, 512)
+		copy(answer, query[:2])
+		binary.BigEndian.PutUint16(answer[2:], 0x8180)
+		binary.BigEndian.PutUint16(answer[4:], 1)
+		answer = append(answer, query[12:end]...)
+		if qtype == dnsTypeA && strings.EqualFold(qname, name+".") {
+			binary.BigEndian.PutUint16(answer[6:], 1)
+			// Pointer to the question's name, type A, class IN, TTL 60, 4 bytes
+			answer = append(answer, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4)
+			answer = append(answer, ip.To4()...)
+		}
+		resp.Header().Set("Content-Type", "application/dns-message")
+		resp.Write(answer)
+	}))
+}
+
+// parseQuestion reads the first question of a DNS message, returning its name,
+// type and the offset just past it (or -1 if it's malformed).
+func parseQuestion(msg []byte) (string, uint16, int) {
+	var labels []string
+	i := 12
+	for {
+		if i >= len(msg) {
+			return "", 0, -1
+		}
+		l := int(msg[i])
+		i++
+		if l == 0 {
+			break
+		}
+		if i+l > len(msg) {
+			return "", 0, -1
+		}
+		labels = append(labels, string(msg[i:i+l]))
+		i += l
+	}
+	if i+4 > len(msg) {
+		return "", 0, -1
+	}
+	return strings.Join(labels, ".") + ".", binary.BigEndian.Uint16(msg[i:]), i + 4
+}
+
+func TestDoHResolver(t *testing.T) {
+	doh := newMockDoH("peer.example.com", net.ParseIP("10.11.12.13"))
+	defer doh.Close()
+
+	addrs, err := newDoHResolver(doh.URL).LookupHost(context.Background(), "peer.example.com")
+	if assert.NoError(t, err, "Should have resolved peer through DoH") {
+		assert.Equal(t, []string{"10.11.12.13"}, addrs)
+	}
+
+	_, err = newDoHResolver(doh.URL).LookupHost(context.Background(), "unknown.example.com")
+	assert.Error(t, err, "Name the DoH server doesn't know shouldn't resolve")
+}
+
+func TestDoHDialer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	doh := newMockDoH("peer.example.com", net.ParseIP("127.0.0.1"))
+	defer doh.Close()
+
+	oldURL := *dohURL
+	*dohURL = doh.URL
+	defer func() { *dohURL = oldURL }()
+
+	conn, err := dohDialer().Dial(context.Background(), "tcp", net.JoinHostPort("peer.example.com", port))
+	if assert.NoError(t, err, "Should have dialed peer resolved through DoH") {
+		assert.Equal(t, l.Addr().String(), conn.RemoteAddr().String())
+		conn.Close()
+	}
+}
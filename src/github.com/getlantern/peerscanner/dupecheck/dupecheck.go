@@ -7,7 +7,7 @@ import (
 	"strings"
 
 	"github.com/getlantern/cloudflare"
-	"github.com/getlantern/peerscanner/cf"
+	"github.com/getlantern/peerscanner/cfl"
 )
 
 func main() {
@@ -18,7 +18,7 @@ func main() {
 		"roundrobin": make(map[string][]cloudflare.Record),
 	}
 
-	u := cf.New("getiantem.org", os.Getenv("CFL_USER"), os.Getenv("CFL_API_KEY"))
+	u := cfl.NewLegacy("getiantem.org", os.Getenv("CFL_USER"), os.Getenv("CFL_API_KEY"))
 	u.Client.Http.Transport = &http.Transport{
 		DisableKeepAlives: true,
 	}
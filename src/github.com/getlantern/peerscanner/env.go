@@ -0,0 +1,78 @@
+package main
+
+import (
+	"embed"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/getlantern/yaml"
+)
+
+const (
+	defaultEnvironment = "production"
+)
+
+// environments holds the defaults for each PEERSCANNER_ENV, one YAML file per
+// environment.
+//
+//go:embed environments/*.yaml
+var environments embed.FS
+
+// environment is the set of defaults that PEERSCANNER_ENV selects.
+type environment struct {
+	// CflDomain is the default for -cfldomain
+	CflDomain string `yaml:"cfldomain"`
+	// GroupPrefix is prepended to the names of our rotations, so that
+	// environments can share a zone without sharing rotations.
+	GroupPrefix string `yaml:"groupprefix"`
+}
+
+// loadEnvironment loads the bundled defaults for the environment with the
+// given name, which defaults to production.
+func loadEnvironment(name string) (*environment, error) {
+	if name == "" {
+		name = defaultEnvironment
+	}
+	data, err := environments.ReadFile("environments/" + name + ".yaml")
+	if err != nil {
+		return nil, fmt.Errorf("Unknown environment %v, must be one of %v", name, strings.Join(environmentNames(), ", "))
+	}
+	env := &environment{}
+	if err := yaml.Unmarshal(data, env); err != nil {
+		return nil, fmt.Errorf("Unable to parse environment %v: %v", name, err)
+	}
+	return env, nil
+}
+
+// environmentNames lists the environments that we have defaults for.
+func environmentNames() []string {
+	entries, _ := environments.ReadDir("environments")
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, strings.TrimSuffix(e.Name(), ".yaml"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyEnvironment applies the defaults for the environment with the given
+// name. It needs to be called before flag.Parse so that flags given on the
+// command line take precedence.
+func applyEnvironment(name string) error {
+	env, err := loadEnvironment(name)
+	if err != nil {
+		return err
+	}
+	if env.CflDomain != "" {
+		f := flag.Lookup("cfldomain")
+		if err := f.Value.Set(env.CflDomain); err != nil {
+			return err
+		}
+		f.DefValue = env.CflDomain
+	}
+	groupPrefix = env.GroupPrefix
+	applyGroupConfigs(groupConfigs)
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestEnvironmentDefaults(t *testing.T) {
+	env, err := loadEnvironment("")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "getiantem.org", env.CflDomain, "Production should use the zone we've always used")
+		assert.Equal(t, "", env.GroupPrefix, "Production rotations shouldn't be prefixed")
+	}
+	prod, err := loadEnvironment("production")
+	if assert.NoError(t, err) {
+		assert.Equal(t, env, prod, "Production should be the default")
+	}
+
+	defer withEnvironment(t, "production")()
+	assert.Equal(t, GroupName("roundrobin"), RoundRobin)
+	assert.Equal(t, GroupName("peers"), Peers)
+	assert.Equal(t, GroupName("fallbacks"), Fallbacks)
+	assert.Equal(t, GroupName("us.fallbacks"), countryGroup("us"))
+	assert.Equal(t, "getiantem.org", *cfldomain)
+}
+
+func TestStagingEnvironment(t *testing.T) {
+	defer withEnvironment(t, "staging")()
+	assert.Equal(t, GroupName("staging-roundrobin"), RoundRobin)
+	assert.Equal(t, GroupName("us.staging-fallbacks"), countryGroup("us"))
+	_, isGroup := groupNameFor("roundrobin")
+	assert.False(t, isGroup, "Production rotation shouldn't be a staging group")
+}
+
+func TestUnknownEnvironment(t *testing.T) {
+	assert.Error(t, applyEnvironment("prod"), "Unknown environment should be rejected")
+	assert.Equal(t, []string{"production", "staging", "test"}, environmentNames())
+}
+
+// withEnvironment applies the named environment, returning a function that
+// restores the defaults that tests expect.
+func withEnvironment(t *testing.T, name string) func() {
+	f := flag.Lookup("cfldomain")
+	origDomain, origDef := f.Value.String(), f.DefValue
+	if err := applyEnvironment(name); err != nil {
+		t.Fatalf("Unable to apply %v: %v", name, err)
+	}
+	return func() {
+		applyEnvironment("production")
+		f.Value.Set(origDomain)
+		f.DefValue = origDef
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"embed"
+	"expvar"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/getlantern/geolookup"
+)
+
+const (
+	// unknownLocation is the country and continent of hosts whose location
+	// isn't known (yet)
+	unknownLocation = "unknown"
+)
+
+var (
+	geoLookup = flag.Bool("geo-lookup", false, "Look up the location of hosts in rotation with the geolocation service, for peers_in_rotation_by_country and peers_in_rotation_by_continent, defaults to false")
+
+	// locateIp looks up the location of ip, replaced in tests
+	locateIp = lookupLocation
+
+	rotationMembers = newRotationGeo(
+		expvar.NewInt("peers_in_rotation"),
+		expvar.NewMap("peers_in_rotation_by_country"),
+		expvar.NewMap("peers_in_rotation_by_continent"))
+
+	//go:embed dashboards/*.json
+	dashboards embed.FS
+)
+
+// geoLocation is where a host is, as ISO country code (e.g. US) and continent
+// code (e.g. NA).
+type geoLocation struct {
+	country   string
+	continent string
+}
+
+func lookupLocation(ip string) (geoLocation, error) {
+	city, _, err := geolookup.LookupIPWithClient(ip, nil)
+	if err != nil {
+		return geoLocation{}, err
+	}
+	return geoLocation{city.Country.IsoCode, city.Continent.Code}, nil
+}
+
+// rotationGeo keeps track of which hosts are in rotation and where they are,
+// for the peers_in_rotation gauges. Locations are looked up once per ip, in
+// the background so that checks don't wait for the geolocation service. It is
+// safe for concurrent use.
+type rotationGeo struct {
+	total       *expvar.Int
+	byCountry   *expvar.Map
+	byContinent *expvar.Map
+
+	inRotation map[string]bool
+	locations  map[string]geoLocation
+	// lookingUp are the ips whose location is being looked up
+	lookingUp map[string]bool
+	mutex     sync.Mutex
+}
+
+func newRotationGeo(total *expvar.Int, byCountry *expvar.Map, byContinent *expvar.Map) *rotationGeo {
+	return &rotationGeo{
+		total:       total,
+		byCountry:   byCountry,
+		byContinent: byContinent,
+		inRotation:  make(map[string]bool),
+		locations:   make(map[string]geoLocation),
+		lookingUp:   make(map[string]bool),
+	}
+}
+
+// set records whether the host with the given ip is in rotation and updates
+// the gauges.
+func (g *rotationGeo) set(ip string, inRotation bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if inRotation {
+		g.inRotation[ip] = true
+	} else {
+		delete(g.inRotation, ip)
+	}
+	if inRotation && *geoLookup {
+		_, known := g.locations[ip]
+		if !known && !g.lookingUp[ip] {
+			g.lookingUp[ip] = true
+			go g.locate(ip)
+		}
+	}
+	g.updateGauges()
+}
+
+func (g *rotationGeo) locate(ip string) {
+	loc, err := locateIp(ip)
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	delete(g.lookingUp, ip)
+	if err != nil {
+		log.Debugf("Unable to look up location of %v: %v", ip, err)
+		return
+	}
+	g.locations[ip] = loc
+	g.updateGauges()
+}
+
+// updateGauges sets the peers_in_rotation gauges. Countries and continents
+// whose last host left the rotation are kept at 0, so that it shows. It needs
+// g.mutex to be held.
+func (g *rotationGeo) updateGauges() {
+	byCountry := make(map[string]int64)
+	byContinent := make(map[string]int64)
+	g.byCountry.Do(func(kv expvar.KeyValue) { byCountry[kv.Key] = 0 })
+	g.byContinent.Do(func(kv expvar.KeyValue) { byContinent[kv.Key] = 0 })
+	for ip := range g.inRotation {
+		loc, known := g.locations[ip]
+		if !known || loc.country == "" {
+			loc.country = unknownLocation
+		}
+		if !known || loc.continent == "" {
+			loc.continent = unknownLocation
+		}
+		byCountry[loc.country]++
+		byContinent[loc.continent]++
+	}
+	g.total.Set(int64(len(g.inRotation)))
+	setGauges(g.byCountry, byCountry)
+	setGauges(g.byContinent, byContinent)
+}
+
+func setGauges(m *expvar.Map, values map[string]int64) {
+	for k, v := range values {
+		gauge, ok := m.Get(k).(*expvar.Int)
+		if !ok {
+			gauge = new(expvar.Int)
+			m.Set(k, gauge)
+		}
+		gauge.Set(v)
+	}
+}
+
+// updateRotationMembership updates the peers_in_rotation gauges if h joined
+// or left its rotations.
+func (h *host) updateRotationMembership(wasOnline bool) {
+	if h.online != wasOnline {
+		rotationMembers.set(h.ip, h.online)
+	}
+}
+
+// serveDashboard serves the Grafana dashboards in dashboards, such as the one
+// for the peers_in_rotation gauges, at /debug/dashboards/<name>.json for
+// importing into Grafana.
+func serveDashboard(resp http.ResponseWriter, req *http.Request) {
+	b, err := dashboards.ReadFile("dashboards/" + strings.TrimPrefix(req.URL.Path, "/debug/dashboards/"))
+	if err != nil {
+		resp.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(resp, "No such dashboard")
+		return
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Write(b)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestRotationGaugesByCountry(t *testing.T) {
+	defer withGeoLookup(map[string]geoLocation{
+		"10.3.0.1": {"US", "NA"},
+		"10.3.0.2": {"US", "NA"},
+		"10.3.0.3": {"DE", "EU"},
+		"10.3.0.4": {"BR", "SA"},
+	})()
+	g := newRotationGeo(new(expvar.Int), new(expvar.Map).Init(), new(expvar.Map).Init())
+
+	for i := 1; i <= 4; i++ {
+		g.set(fmt.Sprintf("10.3.0.%d", i), true)
+	}
+	countries := func() map[string]string {
+		g.mutex.Lock()
+		defer g.mutex.Unlock()
+		gauges := make(map[string]string)
+		g.byCountry.Do(func(kv expvar.KeyValue) { gauges[kv.Key] = kv.Value.String() })
+		return gauges
+	}
+	// Locations are looked up in the background
+	for i := 0; i < 100 && countries()[unknownLocation] != "0"; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, map[string]string{"US": "2", "DE": "1", "BR": "1", unknownLocation: "0"}, countries(), "There should be a gauge for every country")
+	assert.Equal(t, "1", g.byContinent.Get("EU").String())
+	assert.Equal(t, "2", g.byContinent.Get("NA").String())
+	assert.Equal(t, int64(4), g.total.Value())
+
+	g.set("10.3.0.3", false)
+	assert.Equal(t, "0", countries()["DE"], "Country without hosts in rotation should be at 0")
+	assert.Equal(t, int64(3), g.total.Value())
+}
+
+func TestCheckUpdatesRotationGauges(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	name, ip := "fl-us-geo", "45.63.12.1"
+	f := newFakeFallback(name)
+	defer f.close()
+	h, _ := newTestHost(name, ip, f)
+
+	h.check()
+	rotationMembers.mutex.Lock()
+	assert.True(t, rotationMembers.inRotation[ip], "Host that passed its check should be counted")
+	rotationMembers.mutex.Unlock()
+}
+
+func TestServeDashboard(t *testing.T) {
+	rec := httptest.NewRecorder()
+	serveDashboard(rec, httptest.NewRequest("GET", "/debug/dashboards/peers-in-rotation.json", nil))
+	assert.Equal(t, 200, rec.Code)
+	assert.Contains(t, rec.Body.String(), "peers_in_rotation_by_country")
+
+	rec = httptest.NewRecorder()
+	serveDashboard(rec, httptest.NewRequest("GET", "/debug/dashboards/missing.json", nil))
+	assert.Equal(t, 404, rec.Code)
+}
+
+// withGeoLookup turns on -geo-lookup with ips located at the given locations,
+// returning a function that restores the original lookup.
+func withGeoLookup(locations map[string]geoLocation) func() {
+	origEnabled, origLocate := *geoLookup, locateIp
+	*geoLookup = true
+	locateIp = func(ip string) (geoLocation, error) {
+		loc, found := locations[ip]
+		if !found {
+			return geoLocation{}, fmt.Errorf("Unknown ip %v", ip)
+		}
+		return loc, nil
+	}
+	return func() {
+		*geoLookup, locateIp = origEnabled, origLocate
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type groupMembershipReport struct {
+	Group GroupName `json:"group"`
+	// InMemory is whether we think we registered the host in the group,
+	// InCloudFlare whether CloudFlare has its record
+	InMemory     bool   `json:"in_memory"`
+	InCloudFlare bool   `json:"in_cloudflare"`
+	Error        string `json:"error,omitempty"`
+}
+
+// hostEndpoints serves the debug endpoints under /debug/hosts/{name}/{ip}/.
+func (p *HostPool) hostEndpoints(resp http.ResponseWriter, req *http.Request) {
+	if strings.HasSuffix(req.URL.Path, "/groups") {
+		p.groupMembership(resp, req)
+		return
+	}
+	p.healthHistory(resp, req)
+}
+
+// groupMembership is the debug endpoint at /debug/hosts/{name}/{ip}/groups
+// that reports, for each group that a host is eligible for, whether it's in
+// it according to us and according to CloudFlare, which should agree.
+func (p *HostPool) groupMembership(resp http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/debug/hosts/"), "/")
+	if len(parts) != 3 || parts[2] != "groups" {
+		resp.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(resp, "Not found")
+		return
+	}
+	name, ip := parts[0], parts[1]
+	h := p.Get(ip)
+	if h == nil || h.getInfo().name != name {
+		resp.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(resp, "Host %v (%v) not found\n", name, ip)
+		return
+	}
+
+	groups := h.getInfo().groups
+	reports := make([]groupMembershipReport, 0, len(groups))
+	for _, g := range groups {
+		report := groupMembershipReport{Group: g, InMemory: membersOf(g).Contains(ip)}
+		var err error
+		report.InCloudFlare, err = cflutil.IsRecordInGroup(ip, string(g))
+		if err != nil {
+			report.Error = err.Error()
+		}
+		reports = append(reports, report)
+	}
+	writeJSON(resp, reports)
+}
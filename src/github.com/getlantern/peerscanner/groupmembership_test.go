@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestGroupMembershipEndpoint(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	name, ip := "fl-us-membership", "45.63.4.2"
+	f := newFakeFallback(name)
+	defer f.close()
+	h, _ := newTestHost(name, ip, f)
+	h.check()
+	pool := newTestPool(h)
+	// CloudFlare lost track of one of its records
+	for _, r := range m.FindRecords(string(Fallbacks), ip) {
+		m.RemoveRecord(r.Id)
+	}
+
+	rec := httptest.NewRecorder()
+	pool.hostEndpoints(rec, httptest.NewRequest("GET", "/debug/hosts/"+name+"/"+ip+"/groups", nil))
+	assert.Equal(t, 200, rec.Code)
+	var reports []groupMembershipReport
+	if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &reports), "Response should be valid JSON") {
+		byGroup := make(map[GroupName]groupMembershipReport)
+		for _, r := range reports {
+			byGroup[r.Group] = r
+		}
+		assert.Equal(t, groupMembershipReport{Group: RoundRobin, InMemory: true, InCloudFlare: true}, byGroup[RoundRobin])
+		assert.Equal(t, groupMembershipReport{Group: Fallbacks, InMemory: true, InCloudFlare: false}, byGroup[Fallbacks], "Record missing in CloudFlare should be reported")
+	}
+
+	rec = httptest.NewRecorder()
+	pool.hostEndpoints(rec, httptest.NewRequest("GET", "/debug/hosts/fl-us-other/"+ip+"/groups", nil))
+	assert.Equal(t, 404, rec.Code, "Host with the wrong name should not be found")
+
+	rec = httptest.NewRecorder()
+	pool.hostEndpoints(rec, httptest.NewRequest("GET", "/debug/hosts/"+name+"/"+ip+"/health-history", nil))
+	assert.Equal(t, 200, rec.Code, "Health history should still be served")
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/getlantern/cloudflare"
+)
+
+// ValidGroupNames returns the names of the rotations that every fallback
+// belongs to (see -groups-config), along with StagingGroup. On top of these,
+// fallbacks belong to the rotation for their country (see countryGroup).
+func ValidGroupNames() []GroupName {
+	names := make([]GroupName, 0, len(groupConfigs)+1)
+	for i := range groupConfigs {
+		names = append(names, groupConfigs[i].subdomain())
+	}
+	return append(names, StagingGroup)
+}
+
+// countryGroup returns the name of the rotation for fallbacks in the given
+// country, e.g. us.fallbacks.
+func countryGroup(country string) GroupName {
+	return GroupName(country + countryGroupSuffix())
+}
+
+// validateGroupName checks that g is one of the ValidGroupNames or a country
+// group.
+func validateGroupName(g GroupName) error {
+	for _, valid := range ValidGroupNames() {
+		if g == valid {
+			return nil
+		}
+	}
+	s := string(g)
+	suffix := countryGroupSuffix()
+	if strings.HasSuffix(s, suffix) && len(s) > len(suffix) {
+		return nil
+	}
+	return fmt.Errorf("Unknown group %v", g)
+}
+
+// countryGroupSuffix is what the names of country groups end in.
+func countryGroupSuffix() string {
+	return "." + string(Fallbacks)
+}
+
+// groupNameFor returns the group for a Cloudflare record name, if the name is
+// that of a group.
+func groupNameFor(recordName string) (GroupName, bool) {
+	g := GroupName(recordName)
+	return g, validateGroupName(g) == nil
+}
+
+// newCflGroupRecords creates the map that HostPool.Load uses to collect the
+// records in each rotation, keyed by group and then by ip. It has an entry
+// for each of the ValidGroupNames from the start.
+func newCflGroupRecords() map[GroupName]map[string]*cloudflare.Record {
+	groups := make(map[GroupName]map[string]*cloudflare.Record)
+	for _, g := range ValidGroupNames() {
+		groups[g] = make(map[string]*cloudflare.Record)
+	}
+	return groups
+}
+
+// addToCflGroup adds r to the records collected for the group with the given
+// name, logging an error if that isn't a known group.
+func addToCflGroup(groups map[GroupName]map[string]*cloudflare.Record, name GroupName, r cloudflare.Record) {
+	if err := validateGroupName(name); err != nil {
+		log.Errorf("Not adding %v to group: %v", r.Value, err)
+		return
+	}
+	log.Debugf("Adding to %v: %v", name, r.Value)
+	g := groups[name]
+	if g == nil {
+		g = make(map[string]*cloudflare.Record, 1)
+		groups[name] = g
+	}
+	g[r.Value] = &r
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/getlantern/cloudflare"
+	"github.com/getlantern/golog"
+	"github.com/getlantern/testify/assert"
+)
+
+func TestGroupNames(t *testing.T) {
+	for _, g := range ValidGroupNames() {
+		assert.NoError(t, validateGroupName(g))
+	}
+	assert.NoError(t, validateGroupName(countryGroup("us")))
+	assert.Equal(t, countryGroup("us"), fallbackCountry("fl-us-20150101-001"))
+	for _, name := range []string{"roundrobbin", "fallback", ".fallbacks", ""} {
+		_, ok := groupNameFor(name)
+		assert.False(t, ok, "%v shouldn't be a group", name)
+	}
+}
+
+func TestLoadHostsGroupsHaveEntryForEachValidGroupName(t *testing.T) {
+	groups := newCflGroupRecords()
+	for _, g := range ValidGroupNames() {
+		_, found := groups[g]
+		assert.True(t, found, "Missing group %v", g)
+	}
+}
+
+func TestAddToUnknownGroupLogsError(t *testing.T) {
+	var errors bytes.Buffer
+	golog.SetOutputs(&errors, &bytes.Buffer{})
+	defer golog.ResetOutputs()
+
+	groups := newCflGroupRecords()
+	addToCflGroup(groups, GroupName("roundrobbin"), cloudflare.Record{Name: "roundrobbin", Value: "45.63.6.1"})
+	assert.Contains(t, errors.String(), "Unknown group roundrobbin", "Unknown group should be logged as error")
+	_, found := groups[GroupName("roundrobbin")]
+	assert.False(t, found, "Record shouldn't have been added to unknown group")
+
+	errors.Reset()
+	addToCflGroup(groups, RoundRobin, cloudflare.Record{Name: "roundrobin", Value: "45.63.6.1"})
+	assert.Equal(t, "", errors.String(), "Known group shouldn't log an error")
+	assert.Len(t, groups[RoundRobin], 1)
+}
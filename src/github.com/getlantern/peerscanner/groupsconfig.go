@@ -0,0 +1,155 @@
+package main
+
+import (
+	_ "embed"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/getlantern/yaml"
+)
+
+var (
+	groupsConfigFile = flag.String("groups-config", "", "YAML file that defines the rotations that fallbacks are added to, see groups.yaml for the format, defaults to roundrobin, fallbacks and peers")
+
+	//go:embed groups.yaml
+	defaultGroupsConfig []byte
+
+	// groupConfigs are the rotations that fallbacks are added to, see
+	// applyGroupConfigs
+	groupConfigs = mustParseGroupConfigs(defaultGroupsConfig)
+	// groupPrefix is what PEERSCANNER_ENV prefixes the subdomains of our
+	// rotations with
+	groupPrefix string
+
+	dnsLabelPattern = regexp.MustCompile(`^(?i)[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+)
+
+// GroupConfig defines one of the rotations that fallbacks are added to.
+type GroupConfig struct {
+	// Name identifies the rotation. The roundrobin, fallbacks and peers
+	// rotations have to be defined, since they mean something to peerscanner.
+	Name GroupName `yaml:"name"`
+	// CFSubdomain is the name of the rotation's records, relative to
+	// -cfldomain and without PEERSCANNER_ENV's prefix
+	CFSubdomain string `yaml:"cfsubdomain"`
+	// MinMembers is how many hosts the rotation should have at least, 0 for
+	// no minimum. It's only warned about.
+	MinMembers int `yaml:"minmembers"`
+	// MaxMembers is how many hosts may be added to the rotation, 0 for no
+	// limit
+	MaxMembers int `yaml:"maxmembers"`
+	// CheckProtocol is the smoke test protocol (see -smoke-test-protocol) that
+	// hosts need to pass to be added to the rotation, -smoke-test-protocol if
+	// empty
+	CheckProtocol string `yaml:"checkprotocol"`
+}
+
+// subdomain is the GroupName that the rotation's records have in our zone.
+func (c *GroupConfig) subdomain() GroupName {
+	return GroupName(groupPrefix + c.CFSubdomain)
+}
+
+// checkProtocol is the smoke test protocol for hosts joining the rotation.
+func (c *GroupConfig) checkProtocol() string {
+	if c.CheckProtocol == "" {
+		return *smokeTestProtocol
+	}
+	return c.CheckProtocol
+}
+
+// parseGroupConfigs parses and validates the YAML list of GroupConfigs in
+// data.
+func parseGroupConfigs(data []byte) ([]GroupConfig, error) {
+	var configs []GroupConfig
+	if err := yaml.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("Unable to parse groups: %v", err)
+	}
+	names := make(map[GroupName]bool, len(configs))
+	subdomains := make(map[string]bool, len(configs))
+	for _, c := range configs {
+		if c.Name == "" {
+			return nil, fmt.Errorf("Group with subdomain %v has no name", c.CFSubdomain)
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("Group %v is defined more than once", c.Name)
+		}
+		names[c.Name] = true
+		if !dnsLabelPattern.MatchString(c.CFSubdomain) {
+			return nil, fmt.Errorf("Subdomain %q of group %v isn't a valid DNS label", c.CFSubdomain, c.Name)
+		}
+		if subdomains[c.CFSubdomain] {
+			return nil, fmt.Errorf("Subdomain %v of group %v is used by another group", c.CFSubdomain, c.Name)
+		}
+		subdomains[c.CFSubdomain] = true
+		if c.MinMembers < 0 || c.MaxMembers < 0 || (c.MaxMembers > 0 && c.MinMembers > c.MaxMembers) {
+			return nil, fmt.Errorf("Group %v has invalid bounds %d to %d on its members", c.Name, c.MinMembers, c.MaxMembers)
+		}
+		if c.CheckProtocol != "" {
+			if err := validateSmokeTestProtocol(c.CheckProtocol); err != nil {
+				return nil, fmt.Errorf("Group %v: %v", c.Name, err)
+			}
+		}
+	}
+	for _, required := range []GroupName{"roundrobin", "fallbacks", "peers"} {
+		if !names[required] {
+			return nil, fmt.Errorf("Group %v isn't defined", required)
+		}
+	}
+	return configs, nil
+}
+
+func mustParseGroupConfigs(data []byte) []GroupConfig {
+	configs, err := parseGroupConfigs(data)
+	if err != nil {
+		panic(err)
+	}
+	return configs
+}
+
+// loadGroupConfigs loads the groups from -groups-config if it's given, or
+// the built-in ones otherwise.
+func loadGroupConfigs() error {
+	configs := mustParseGroupConfigs(defaultGroupsConfig)
+	if *groupsConfigFile != "" {
+		data, err := os.ReadFile(*groupsConfigFile)
+		if err != nil {
+			return fmt.Errorf("Unable to read %v: %v", *groupsConfigFile, err)
+		}
+		configs, err = parseGroupConfigs(data)
+		if err != nil {
+			return fmt.Errorf("Invalid %v: %v", *groupsConfigFile, err)
+		}
+	}
+	applyGroupConfigs(configs)
+	return nil
+}
+
+// applyGroupConfigs makes configs our rotations, pointing RoundRobin,
+// Fallbacks and Peers at the subdomains that they define for them.
+func applyGroupConfigs(configs []GroupConfig) {
+	groupConfigs = configs
+	for _, c := range configs {
+		switch c.Name {
+		case "roundrobin":
+			RoundRobin = c.subdomain()
+		case "fallbacks":
+			Fallbacks = c.subdomain()
+		case "peers":
+			Peers = c.subdomain()
+		}
+	}
+	StagingGroup = GroupName(groupPrefix + "staging")
+}
+
+// groupConfigFor returns the config of the rotation with the given subdomain,
+// if it has one. Country rotations and StagingGroup don't.
+func groupConfigFor(subdomain GroupName) (*GroupConfig, bool) {
+	for i := range groupConfigs {
+		if groupConfigs[i].subdomain() == subdomain {
+			return &groupConfigs[i], true
+		}
+	}
+	return nil, false
+}
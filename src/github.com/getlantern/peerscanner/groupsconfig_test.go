@@ -0,0 +1,129 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+const premiumGroupsConfig = `
+- name: roundrobin
+  cfsubdomain: roundrobin
+- name: fallbacks
+  cfsubdomain: fallbacks
+- name: peers
+  cfsubdomain: peers
+- name: premium
+  cfsubdomain: premium-peers
+  minmembers: 1
+  maxmembers: 1
+  checkprotocol: tcp
+`
+
+// withGroupsConfig loads the groups from a file with the given contents,
+// returning a function that restores the built-in ones.
+func withGroupsConfig(t *testing.T, config string) func() {
+	file := filepath.Join(t.TempDir(), "groups.yaml")
+	if err := os.WriteFile(file, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	orig := *groupsConfigFile
+	*groupsConfigFile = file
+	if err := loadGroupConfigs(); err != nil {
+		t.Fatalf("Unable to load groups: %v", err)
+	}
+	return func() {
+		*groupsConfigFile = orig
+		loadGroupConfigs()
+	}
+}
+
+func TestDefaultGroupsConfig(t *testing.T) {
+	assert.NoError(t, loadGroupConfigs())
+	assert.Equal(t, []GroupName{"roundrobin", "fallbacks", "peers", "staging"}, ValidGroupNames())
+	assert.Equal(t, GroupName("roundrobin"), RoundRobin)
+	assert.Equal(t, GroupName("fallbacks"), Fallbacks)
+	assert.Equal(t, GroupName("peers"), Peers)
+	for _, c := range groupConfigs {
+		assert.Equal(t, 0, c.MaxMembers, "Built-in groups shouldn't be limited")
+		assert.Equal(t, *smokeTestProtocol, c.checkProtocol())
+	}
+
+	defer withEnvironment(t, "staging")()
+	assert.Equal(t, []GroupName{"staging-roundrobin", "staging-fallbacks", "staging-peers", "staging-staging"}, ValidGroupNames())
+}
+
+func TestCustomGroupsConfig(t *testing.T) {
+	defer withGroupsConfig(t, premiumGroupsConfig)()
+	assert.Equal(t, []GroupName{"roundrobin", "fallbacks", "peers", "premium-peers", "staging"}, ValidGroupNames())
+	config, found := groupConfigFor("premium-peers")
+	if assert.True(t, found) {
+		assert.Equal(t, GroupConfig{Name: "premium", CFSubdomain: "premium-peers", MinMembers: 1, MaxMembers: 1, CheckProtocol: "tcp"}, *config)
+	}
+	_, isGroup := groupNameFor("premium-peers")
+	assert.True(t, isGroup, "Custom group should be known")
+
+	h := mustNewHost("fl-us-premium", "45.63.11.1", "443")
+	_, found = h.cflGroups["premium-peers"]
+	assert.True(t, found, "Fallbacks should belong to the custom group")
+}
+
+func TestGroupsConfigRenamesBuiltInGroups(t *testing.T) {
+	defer withGroupsConfig(t, `
+- name: roundrobin
+  cfsubdomain: rr
+- name: fallbacks
+  cfsubdomain: fallbacks
+- name: peers
+  cfsubdomain: peers
+`)()
+	assert.Equal(t, GroupName("rr"), RoundRobin)
+	_, isGroup := groupNameFor("roundrobin")
+	assert.False(t, isGroup, "Old name of the rotation shouldn't be a group anymore")
+}
+
+func TestInvalidGroupsConfig(t *testing.T) {
+	for config, expected := range map[string]string{
+		"- name: [roundrobin": "Unable to parse",
+		"- name: roundrobin\n  cfsubdomain: roundrobin\n- name: peers\n  cfsubdomain: peers":          "Group fallbacks isn't defined",
+		premiumGroupsConfig + "- name: premium\n  cfsubdomain: other":                                 "defined more than once",
+		premiumGroupsConfig + "- name: other\n  cfsubdomain: peers":                                   "used by another group",
+		premiumGroupsConfig + "- name: other\n  cfsubdomain: other.peers":                             "isn't a valid DNS label",
+		premiumGroupsConfig + "- name: other\n  cfsubdomain: -other":                                  "isn't a valid DNS label",
+		premiumGroupsConfig + "- name: other\n  cfsubdomain: other\n  minmembers: 2\n  maxmembers: 1": "invalid bounds",
+		premiumGroupsConfig + "- name: other\n  cfsubdomain: other\n  checkprotocol: udp":             "Unsupported smoke test protocol",
+	} {
+		_, err := parseGroupConfigs([]byte(config))
+		if assert.Error(t, err, "%v should be invalid", config) {
+			assert.Contains(t, err.Error(), expected)
+		}
+	}
+
+	orig := *groupsConfigFile
+	defer func() { *groupsConfigFile = orig }()
+	*groupsConfigFile = filepath.Join(t.TempDir(), "missing.yaml")
+	assert.Error(t, loadGroupConfigs(), "Missing file should be reported")
+	assert.Equal(t, GroupName("roundrobin"), RoundRobin, "Failing to load shouldn't change the groups")
+}
+
+func TestGroupMaxMembers(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	defer withGroupsConfig(t, premiumGroupsConfig)()
+	name, ip := "fl-us-maxmembers", "45.63.11.2"
+	f := newFakeFallback(name)
+	defer f.close()
+	h, _ := newTestHost(name, ip, f)
+	membersOf("premium-peers").Add("45.63.11.3")
+	defer membersOf("premium-peers").Remove("45.63.11.3")
+
+	h.check()
+	assert.Len(t, m.FindRecords(string(RoundRobin), ip), 1, "Host should be in the unlimited rotations")
+	assert.Len(t, m.FindRecords("premium-peers", ip), 0, "Host shouldn't be added to a full rotation")
+
+	membersOf("premium-peers").Remove("45.63.11.3")
+	h.check()
+	assert.Len(t, m.FindRecords("premium-peers", ip), 1, "Host should be added once there's room")
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// groupRecordCountMaxAge is how long we use a group's record count from
+	// CloudFlare before fetching it again
+	groupRecordCountMaxAge = 60 * time.Second
+)
+
+var (
+	groupRecordCounts = &recordCountCache{counts: make(map[GroupName]recordCount)}
+)
+
+type groupMemberReport struct {
+	Name string `json:"name"`
+	Ip   string `json:"ip"`
+	// Score is the host's weight in /v1/peers
+	Score int    `json:"score"`
+	State string `json:"state"`
+}
+
+type groupReport struct {
+	Group   GroupName           `json:"group"`
+	Members []groupMemberReport `json:"members"`
+	// CfRecordCount is how many records CloudFlare has for the group, which
+	// should match the number of members.
+	CfRecordCount int `json:"cf_record_count"`
+	// LastSync is when we got CfRecordCount from CloudFlare
+	LastSync time.Time `json:"last_sync"`
+	// MinMembers and MaxMembers are the group's bounds from -groups-config,
+	// 0 if it has none
+	MinMembers int `json:"min_members,omitempty"`
+	MaxMembers int `json:"max_members,omitempty"`
+}
+
+// groupStatus is the admin endpoint that reports on the hosts we've
+// registered in a single group, along with how many records CloudFlare has
+// for it.
+func (p *HostPool) groupStatus(resp http.ResponseWriter, req *http.Request) {
+	g := GroupName(strings.TrimPrefix(req.URL.Path, "/v1/admin/groups/"))
+	if err := validateGroupName(g); err != nil {
+		resp.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(resp, err.Error())
+		return
+	}
+
+	count, fetched, err := groupRecordCounts.get(g)
+	if err != nil {
+		resp.WriteHeader(http.StatusBadGateway)
+		fmt.Fprintf(resp, "Unable to count CloudFlare records for %v: %v\n", g, err)
+		return
+	}
+
+	members := membersOf(g)
+	infos := p.Snapshot()
+	sort.Sort(byName(infos))
+	report := groupReport{Group: g, Members: make([]groupMemberReport, 0), CfRecordCount: count, LastSync: fetched}
+	if config, configured := groupConfigFor(g); configured {
+		report.MinMembers, report.MaxMembers = config.MinMembers, config.MaxMembers
+	}
+	for _, info := range infos {
+		if members.Contains(info.ip) {
+			report.Members = append(report.Members, groupMemberReport{
+				Name:  info.name,
+				Ip:    info.ip,
+				Score: info.weight,
+				State: info.state,
+			})
+		}
+	}
+	writeJSON(resp, report)
+}
+
+// recordCountCache caches how many records CloudFlare has for each group.
+type recordCountCache struct {
+	counts map[GroupName]recordCount
+	mutex  sync.Mutex
+}
+
+type recordCount struct {
+	count   int
+	fetched time.Time
+}
+
+// get returns the number of records for g and when we got it from
+// CloudFlare, fetching it again if it's older than groupRecordCountMaxAge.
+func (c *recordCountCache) get(g GroupName) (int, time.Time, error) {
+	c.mutex.Lock()
+	cached, found := c.counts[g]
+	c.mutex.Unlock()
+	if found && time.Since(cached.fetched) < groupRecordCountMaxAge {
+		return cached.count, cached.fetched, nil
+	}
+
+	count, err := cflutil.CountRecords(string(g))
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	cached = recordCount{count, time.Now()}
+	c.mutex.Lock()
+	c.counts[g] = cached
+	c.mutex.Unlock()
+	return cached.count, cached.fetched, nil
+}
+
+// purge empties the cache.
+func (c *recordCountCache) purge() {
+	c.mutex.Lock()
+	c.counts = make(map[GroupName]recordCount)
+	c.mutex.Unlock()
+}
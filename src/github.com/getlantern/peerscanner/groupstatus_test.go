@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestGroupStatus(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	groupRecordCounts.purge()
+	defer groupRecordCounts.purge()
+
+	var hs []*host
+	for i := 1; i <= 3; i++ {
+		name, ip := fmt.Sprintf("fl-us-group%d", i), fmt.Sprintf("45.63.3.%d", i)
+		f := newFakeFallback(name)
+		defer f.close()
+		h, _ := newTestHost(name, ip, f)
+		h.check()
+		hs = append(hs, h)
+	}
+	hs[2].setWeight(50)
+	hs[2].publishInfo()
+	pool := newTestPool(hs...)
+
+	rec := httptest.NewRecorder()
+	pool.groupStatus(rec, httptest.NewRequest("GET", "/v1/admin/groups/"+string(RoundRobin), nil))
+	assert.Equal(t, 200, rec.Code)
+	var report map[string]interface{}
+	if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report), "Response should be valid JSON") {
+		assert.Equal(t, string(RoundRobin), report["group"])
+		assert.Equal(t, float64(3), report["cf_record_count"])
+		assert.NotEmpty(t, report["last_sync"])
+		assert.Equal(t, []interface{}{
+			map[string]interface{}{"name": "fl-us-group1", "ip": "45.63.3.1", "score": float64(10), "state": StateOnline},
+			map[string]interface{}{"name": "fl-us-group2", "ip": "45.63.3.2", "score": float64(10), "state": StateOnline},
+			map[string]interface{}{"name": "fl-us-group3", "ip": "45.63.3.3", "score": float64(50), "state": StateOnline},
+		}, report["members"])
+	}
+
+	// The record count should be cached
+	m.AddRecord("A", string(RoundRobin), "45.63.3.99")
+	rec = httptest.NewRecorder()
+	pool.groupStatus(rec, httptest.NewRequest("GET", "/v1/admin/groups/"+string(RoundRobin), nil))
+	if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report)) {
+		assert.Equal(t, float64(3), report["cf_record_count"], "Record count should come from the cache")
+	}
+
+	rec = httptest.NewRecorder()
+	pool.groupStatus(rec, httptest.NewRequest("GET", "/v1/admin/groups/nonsense", nil))
+	assert.Equal(t, 404, rec.Code, "Unknown group should not be found")
+}
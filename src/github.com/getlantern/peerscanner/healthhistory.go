@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	healthHistorySize = 100
+)
+
+// healthResult is the outcome of a single check of a host.
+type healthResult struct {
+	ts        time.Time
+	success   bool
+	latencyMs int64
+	errMsg    string
+	// timedOut is set for checks that isCheckTimeout considers timed out
+	timedOut bool
+}
+
+// HealthHistory holds the results of a host's last healthHistorySize checks.
+// It is safe for concurrent use.
+type HealthHistory struct {
+	results [healthHistorySize]healthResult
+	// head is where the next result goes
+	head  int
+	count int
+	mutex sync.Mutex
+}
+
+// add records a result, overwriting the oldest one once the history is full.
+func (hh *HealthHistory) add(r healthResult) {
+	hh.mutex.Lock()
+	defer hh.mutex.Unlock()
+	hh.results[hh.head] = r
+	hh.head = (hh.head + 1) % len(hh.results)
+	if hh.count < len(hh.results) {
+		hh.count++
+	}
+}
+
+// snapshot returns the results in the history, oldest first.
+func (hh *HealthHistory) snapshot() []healthResult {
+	hh.mutex.Lock()
+	defer hh.mutex.Unlock()
+	result := make([]healthResult, 0, hh.count)
+	start := (hh.head - hh.count + len(hh.results)) % len(hh.results)
+	for i := 0; i < hh.count; i++ {
+		result = append(result, hh.results[(start+i)%len(hh.results)])
+	}
+	return result
+}
+
+type healthResultReport struct {
+	Ts        time.Time `json:"ts"`
+	Success   bool      `json:"success"`
+	LatencyMs int64     `json:"latencyMs"`
+	Error     string    `json:"error,omitempty"`
+}
+
+type healthSummary struct {
+	Total        int   `json:"total"`
+	Successes    int   `json:"successes"`
+	Failures     int   `json:"failures"`
+	P50LatencyMs int64 `json:"p50LatencyMs"`
+	P95LatencyMs int64 `json:"p95LatencyMs"`
+}
+
+type healthHistoryReport struct {
+	History []healthResultReport `json:"history"`
+	Summary healthSummary        `json:"summary"`
+}
+
+// summarize computes summary stats for the given results. Percentiles use
+// the nearest-rank method, like circularBuffer.percentiles.
+func summarize(results []healthResult) healthSummary {
+	s := healthSummary{Total: len(results)}
+	latencies := make([]int64, 0, len(results))
+	for _, r := range results {
+		if r.success {
+			s.Successes++
+		} else {
+			s.Failures++
+		}
+		latencies = append(latencies, r.latencyMs)
+	}
+	if len(latencies) == 0 {
+		return s
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	percentile := func(p float64) int64 {
+		rank := int(math.Ceil(p / 100 * float64(len(latencies))))
+		if rank < 1 {
+			rank = 1
+		}
+		return latencies[rank-1]
+	}
+	s.P50LatencyMs = percentile(50)
+	s.P95LatencyMs = percentile(95)
+	return s
+}
+
+// healthHistory is the debug endpoint at
+// /debug/hosts/{name}/{ip}/health-history that reports a host's recent
+// checks, oldest first, along with summary stats.
+func (p *HostPool) healthHistory(resp http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/debug/hosts/"), "/")
+	if len(parts) != 3 || parts[2] != "health-history" {
+		resp.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(resp, "Not found")
+		return
+	}
+	name, ip := parts[0], parts[1]
+	h := p.Get(ip)
+	if h == nil || h.getInfo().name != name {
+		resp.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(resp, "Host %v (%v) not found\n", name, ip)
+		return
+	}
+
+	results := h.healthHistory.snapshot()
+	report := healthHistoryReport{History: make([]healthResultReport, 0, len(results)), Summary: summarize(results)}
+	for _, r := range results {
+		report.History = append(report.History, healthResultReport{
+			Ts:        r.ts,
+			Success:   r.success,
+			LatencyMs: r.latencyMs,
+			Error:     r.errMsg,
+		})
+	}
+	writeJSON(resp, report)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestHealthHistoryWraps(t *testing.T) {
+	hh := &HealthHistory{}
+	assert.Equal(t, 0, len(hh.snapshot()), "New history should be empty")
+
+	start := time.Now()
+	for i := 0; i < healthHistorySize+1; i++ {
+		hh.add(healthResult{ts: start.Add(time.Duration(i) * time.Second), success: i%2 == 0, latencyMs: int64(i)})
+	}
+	results := hh.snapshot()
+	if assert.Equal(t, healthHistorySize, len(results), "History should be capped") {
+		assert.Equal(t, int64(1), results[0].latencyMs, "Oldest result should have been overwritten")
+		assert.Equal(t, int64(healthHistorySize), results[healthHistorySize-1].latencyMs, "Newest result should be last")
+		for i := 1; i < len(results); i++ {
+			assert.True(t, results[i-1].ts.Before(results[i].ts), "Results should be oldest first")
+		}
+	}
+
+	s := summarize(results)
+	assert.Equal(t, healthSummary{Total: 100, Successes: 50, Failures: 50, P50LatencyMs: 50, P95LatencyMs: 95}, s)
+}
+
+func TestHealthHistoryEndpoint(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	name, ip := "fl-us-history", "45.63.4.1"
+	f := newFakeFallback(name)
+	defer f.close()
+	h, d := newTestHost(name, ip, f)
+	h.check()
+	d.setErr(fmt.Errorf("connection refused"))
+	h.check()
+	pool := newTestPool(h)
+
+	rec := httptest.NewRecorder()
+	pool.healthHistory(rec, httptest.NewRequest("GET", "/debug/hosts/"+name+"/"+ip+"/health-history", nil))
+	assert.Equal(t, 200, rec.Code)
+	var report struct {
+		History []map[string]interface{} `json:"history"`
+		Summary map[string]interface{}   `json:"summary"`
+	}
+	if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report), "Response should be valid JSON") && assert.Len(t, report.History, 2) {
+		assert.Equal(t, true, report.History[0]["success"])
+		assert.Equal(t, false, report.History[1]["success"])
+		assert.Contains(t, report.History[1]["error"], "connection refused")
+		assert.Equal(t, float64(2), report.Summary["total"])
+		assert.Equal(t, float64(1), report.Summary["successes"])
+		assert.Equal(t, float64(1), report.Summary["failures"])
+	}
+
+	rec = httptest.NewRecorder()
+	pool.healthHistory(rec, httptest.NewRequest("GET", "/debug/hosts/fl-us-other/"+ip+"/health-history", nil))
+	assert.Equal(t, 404, rec.Code, "Host with the wrong name should not be found")
+}
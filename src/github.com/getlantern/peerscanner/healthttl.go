@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/getlantern/cloudflare"
+	"github.com/getlantern/peerscanner/cfl"
+)
+
+const (
+	// healthWindow is the period over which healthWeight computes a host's
+	// check pass rate
+	healthWindow = 5 * time.Minute
+)
+
+var (
+	healthTtl = flag.Bool("health-ttl", false, "Set the TTL of each fallback's records from its check pass rate over the last 5 minutes, from -max-ttl at 0% to -min-ttl at 100%, defaults to false")
+	minTtl    = flag.Int("min-ttl", 120, "TTL in seconds that -health-ttl gives fallbacks that pass all their checks, defaults to 120")
+	maxTtl    = flag.Int("max-ttl", 3600, "TTL in seconds that -health-ttl gives fallbacks that fail all their checks, defaults to 3600")
+)
+
+// healthWeight is the fraction of h's checks within healthWindow before now
+// that passed. Hosts without any checks in that window count as healthy.
+func (h *host) healthWeight(now time.Time) float64 {
+	total, passed := 0, 0
+	for _, r := range h.healthHistory.snapshot() {
+		if now.Sub(r.ts) > healthWindow {
+			continue
+		}
+		total++
+		if r.success {
+			passed++
+		}
+	}
+	if total == 0 {
+		return 1
+	}
+	return float64(passed) / float64(total)
+}
+
+// ttlForHealth interpolates linearly between -max-ttl for a weight of 0 and
+// -min-ttl for a weight of 1, so that clients re-resolve healthy fallbacks
+// more often and leave likely unreachable ones cached for longer. The result
+// is rounded to the nearest TTL that CloudFlare accepts.
+func ttlForHealth(weight float64) int {
+	ttl := float64(*maxTtl) - weight*float64(*maxTtl-*minTtl)
+	return cfl.NearestValidTtl(int(ttl + 0.5))
+}
+
+// updateHealthTtl sets the TTL of h's records in CloudFlare from its
+// healthWeight (see -health-ttl). Only records whose TTL changes get
+// updated.
+func (h *host) updateHealthTtl() {
+	if !*healthTtl || !h.isFallback() {
+		return
+	}
+	now := time.Now()
+	weight := h.healthWeight(now)
+	ttl := ttlForHealth(weight)
+	recs := []*cloudflare.Record{h.cflRecord}
+	for _, g := range h.cflGroups {
+		recs = append(recs, g.existing)
+	}
+	for _, r := range recs {
+		if r == nil || r.Ttl == strconv.Itoa(ttl) {
+			continue
+		}
+		log.Debugf("Setting ttl of %v record for %v to %d", r.Name, h, ttl)
+		if err := cflutil.SetRecordTtl(r.Id, ttl); err != nil {
+			log.Errorf("Unable to set ttl of %v record for %v: %v", r.Name, h, err)
+			continue
+		}
+		r.Ttl = strconv.Itoa(ttl)
+		comment := fmt.Sprintf("updated by health check at %v (score: %.2f)", now.UTC().Format(time.RFC3339), weight)
+		if err := cflutil.SetRecordComment(r.Id, comment); err != nil {
+			log.Errorf("Unable to comment %v record for %v: %v", r.Name, h, err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestTtlForHealth(t *testing.T) {
+	assert.Equal(t, 3600, ttlForHealth(0), "Host failing all checks should get -max-ttl")
+	assert.Equal(t, 1800, ttlForHealth(0.5), "Half way should be rounded to the nearest TTL that CloudFlare accepts")
+	assert.Equal(t, 120, ttlForHealth(1), "Host passing all checks should get -min-ttl")
+}
+
+func TestHealthWeight(t *testing.T) {
+	h := mustNewHost("fl-us-healthweight", "45.63.7.1", "443")
+	now := time.Now()
+	assert.Equal(t, 1.0, h.healthWeight(now), "Host without checks should count as healthy")
+
+	h.healthHistory.add(healthResult{ts: now.Add(-10 * time.Minute), success: false})
+	h.healthHistory.add(healthResult{ts: now.Add(-3 * time.Minute), success: true})
+	h.healthHistory.add(healthResult{ts: now.Add(-2 * time.Minute), success: false})
+	h.healthHistory.add(healthResult{ts: now.Add(-1 * time.Minute), success: true})
+	h.healthHistory.add(healthResult{ts: now, success: true})
+	assert.Equal(t, 0.75, h.healthWeight(now), "Checks older than %v shouldn't count", healthWindow)
+}
+
+func TestUpdateHealthTtl(t *testing.T) {
+	orig := *healthTtl
+	defer func() { *healthTtl = orig }()
+	*healthTtl = true
+	m := newMockCfl()
+	defer m.close()
+	name, ip := "fl-us-healthttl", "45.63.7.2"
+	r := m.AddRecord("A", name, ip)
+	h := mustNewHost(name, ip, "443")
+	h.cflRecord = &r
+
+	h.healthHistory.add(healthResult{ts: time.Now(), success: false})
+	h.updateHealthTtl()
+	if recs := m.FindRecords(name, ip); assert.Len(t, recs, 1) {
+		assert.Equal(t, "3600", recs[0].Ttl, "Failing host should get -max-ttl")
+	}
+	assert.True(t, regexp.MustCompile(`^updated by health check at \S+Z \(score: 0\.00\)`).MatchString(m.Comment(r.Id)), "Update should have been commented")
+	requests := len(m.V4Requests())
+	h.updateHealthTtl()
+	assert.Len(t, m.V4Requests(), requests, "Unchanged ttl shouldn't be updated")
+}
+
+func TestUpdateHealthTtlOfRegisteredHost(t *testing.T) {
+	orig := *healthTtl
+	defer func() { *healthTtl = orig }()
+	*healthTtl = true
+	m := newMockCfl()
+	defer m.close()
+	name, ip := "fl-us-healthttlreg", "45.63.7.3"
+	f := newFakeFallback(name)
+	defer f.close()
+	h, _ := newTestHost(name, ip, f)
+
+	// Checking registers the host, using the ids of the records it created
+	h.check()
+	if recs := m.FindRecords(name, ip); assert.Len(t, recs, 1, "Host should have been registered") {
+		assert.Equal(t, "120", recs[0].Ttl, "Healthy host's record should get -min-ttl")
+		assert.True(t, regexp.MustCompile(`^updated by health check at \S+Z \(score: 1\.00\)`).MatchString(m.Comment(recs[0].Id)), "Update of the host's record should have been commented")
+	}
+	if recs := m.FindRecords(string(RoundRobin), ip); assert.Len(t, recs, 1, "Host should be in round robin") {
+		assert.Equal(t, "120", recs[0].Ttl, "Healthy host's round robin record should get -min-ttl")
+		assert.True(t, regexp.MustCompile(`^updated by health check at \S+Z \(score: 1\.00\)`).MatchString(m.Comment(recs[0].Id)), "Update of the round robin record should have been commented")
+	}
+}
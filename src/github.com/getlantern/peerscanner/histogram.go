@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// histogram is an expvar.Var that counts observations in cumulative buckets,
+// separately for each combination of label values, like a Prometheus
+// histogram.
+type histogram struct {
+	labelNames []string
+	buckets    []float64
+
+	series map[string]*histogramSeries
+	mutex  sync.Mutex
+}
+
+type histogramSeries struct {
+	labelValues []string
+	counts      []int64
+	count       int64
+	sum         float64
+}
+
+// newHistogram creates a histogram with the given bucket upper bounds (in
+// ascending order) and label names, and publishes it under name.
+func newHistogram(name string, buckets []float64, labelNames ...string) *histogram {
+	h := &histogram{
+		labelNames: labelNames,
+		buckets:    buckets,
+		series:     make(map[string]*histogramSeries),
+	}
+	expvar.Publish(name, h)
+	return h
+}
+
+// observe records v for the given label values, which must be in the same
+// order as the histogram's label names.
+func (h *histogram) observe(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\x00")
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	s := h.series[key]
+	if s == nil {
+		s = &histogramSeries{labelValues: labelValues, counts: make([]int64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, le := range h.buckets {
+		if v <= le {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+// count returns the number of observations for the given label values.
+func (h *histogram) count(labelValues ...string) int64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	s := h.series[strings.Join(labelValues, "\x00")]
+	if s == nil {
+		return 0
+	}
+	return s.count
+}
+
+// String implements expvar.Var, listing each series with its labels, buckets
+// (keyed by upper bound, including +Inf), count and sum.
+func (h *histogram) String() string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	out := make([]map[string]interface{}, 0, len(keys))
+	for _, k := range keys {
+		s := h.series[k]
+		labels := make(map[string]string, len(h.labelNames))
+		for i, n := range h.labelNames {
+			labels[n] = s.labelValues[i]
+		}
+		buckets := make(map[string]int64, len(h.buckets)+1)
+		for i, le := range h.buckets {
+			buckets[strconv.FormatFloat(le, 'g', -1, 64)] = s.counts[i]
+		}
+		buckets["+Inf"] = s.count
+		out = append(out, map[string]interface{}{
+			"labels":  labels,
+			"buckets": buckets,
+			"count":   s.count,
+			"sum":     s.sum,
+		})
+	}
+	b, err := json.Marshal(out)
+	if err != nil {
+		log.Errorf("Unable to encode histogram: %v", err)
+		return "[]"
+	}
+	return string(b)
+}
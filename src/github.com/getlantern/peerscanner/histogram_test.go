@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestHistogram(t *testing.T) {
+	h := &histogram{
+		labelNames: []string{"result"},
+		buckets:    []float64{0.1, 1},
+		series:     make(map[string]*histogramSeries),
+	}
+	h.observe(0.05, "success")
+	h.observe(0.5, "success")
+	h.observe(5, "success")
+	h.observe(1, "failure")
+	assert.Equal(t, int64(3), h.count("success"))
+	assert.Equal(t, int64(1), h.count("failure"))
+	assert.Equal(t, int64(0), h.count("other"))
+
+	var series []struct {
+		Labels  map[string]string `json:"labels"`
+		Buckets map[string]int64  `json:"buckets"`
+		Count   int64             `json:"count"`
+		Sum     float64           `json:"sum"`
+	}
+	if assert.NoError(t, json.Unmarshal([]byte(h.String()), &series)) && assert.Len(t, series, 2) {
+		assert.Equal(t, map[string]string{"result": "failure"}, series[0].Labels)
+		assert.Equal(t, map[string]int64{"0.1": 0, "1": 1, "+Inf": 1}, series[0].Buckets, "Bucket bounds should be inclusive")
+		assert.Equal(t, map[string]int64{"0.1": 1, "1": 2, "+Inf": 3}, series[1].Buckets, "Buckets should be cumulative")
+		assert.Equal(t, int64(3), series[1].Count)
+		assert.InDelta(t, 5.55, series[1].Sum, 0.0001)
+	}
+}
+
+func TestCheckDurationIsObserved(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	defer withDrainTime(0)()
+	name, ip := "fl-us-histogram", "45.63.1.9"
+	f := newFakeFallback(name)
+	defer f.close()
+	h, d := newTestHost(name, ip, f)
+
+	successes := checkDuration.count("fallback", "success")
+	failures := checkDuration.count("fallback", "failure")
+	for i := 0; i < 5; i++ {
+		h.check()
+	}
+	d.setErr(fmt.Errorf("connection refused"))
+	for i := 0; i < 5; i++ {
+		h.check()
+	}
+	assert.Equal(t, successes+5, checkDuration.count("fallback", "success"))
+	assert.Equal(t, failures+5, checkDuration.count("fallback", "failure"))
+	total := checkDuration.count("fallback", "success") + checkDuration.count("fallback", "failure")
+	assert.Equal(t, successes+failures+10, total, "Each check should have been observed exactly once")
+}
@@ -1,33 +1,61 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"flag"
 	"fmt"
 	"io"
+	"math"
 	"math/rand"
 	"net"
 	"net/http"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/getlantern/cloudflare"
 	"github.com/getlantern/enproxy"
+	"github.com/getlantern/peerscanner/backoff"
+	"github.com/getlantern/peerscanner/cfl"
+	"github.com/getlantern/peerscanner/watchdog"
 	// Temporarily disable CloudFront/DNSimple.
 	//"github.com/getlantern/go-dnsimple/dnsimple"
 	//"github.com/getlantern/peerscanner/cfr"
-	"github.com/getlantern/tlsdialer"
-	"github.com/getlantern/withtimeout"
+)
+
+// States in which a host can be, as reported in hostInfo
+const (
+	StateOnline  = "online"
+	StateOffline = "offline"
+	StatePaused  = "paused"
+	// StateDraining means that the host failed its check but is kept in DNS
+	// until its drain time has passed.
+	StateDraining = "draining"
+	// StateProbation means that the host was removed from its rotations for
+	// failing its checks and has passed some since, but not -success-threshold
+	// yet.
+	StateProbation = "probation"
 )
 
 var (
+	drainTime        = flag.Duration("drain-time", 60*time.Second, "How long to keep a failing host in DNS before removing it, giving clients time to stop using it, defaults to 60s")
+	failThreshold    = flag.Int("fail-threshold", 3, "How many checks in a row a host has to fail before it's removed from its rotations, so that momentary congestion doesn't churn DNS, defaults to 3")
+	maxBackoff       = flag.Duration("max-backoff", 5*time.Minute, "Longest interval between checks of a host that is out of its rotations and keeps failing, defaults to 5m")
+	successThreshold = flag.Int("success-threshold", 2, "How many checks in a row a host that was removed from its rotations has to pass before it's added back, defaults to 2")
+
 	// Set a short ttl on DNS entries
 	ttl = 30 * time.Second
 
 	// Test with a period of half the ttl
 	testPeriod = ttl / 2
 
+	// How many check durations to keep per host for reporting
+	checkDurationsKept = 100
+
 	// If we haven't had a successul test or reset after this amount of time,
 	// pause testing until receipt of next register call.
 	pauseAfter = 10 * time.Minute
@@ -37,7 +65,6 @@ var (
 
 	dialTimeout    = 3 * time.Second // how long to wait on connecting to host
 	requestTimeout = 6 * time.Second // how long to wait for test requests to process
-	proxyAttempts  = 1               // how many times to try a test request before considering host down
 
 	// Sites to use for testing connectivity. WARNING - these should only be
 	// sites with consistent fast response times, around the world, otherwise
@@ -45,8 +72,31 @@ var (
 	testSites = []string{"www.google.com", "www.youtube.com", "www.facebook.com"}
 
 	fallbackNamePattern = regexp.MustCompile(`^fl-([a-z]{2})-.+$`)
+
+	// defaultDialer is the Dialer used by hosts unless something else is
+	// specified.
+	defaultDialer Dialer = dialerFunc((&net.Dialer{}).DialContext)
 )
 
+func init() {
+	// -check-consecutive-success-before-add is what the threshold is called in
+	// our other services' configs
+	flag.IntVar(successThreshold, "check-consecutive-success-before-add", *successThreshold, "Same as -success-threshold")
+}
+
+// Dialer dials the connections used to check a host. Tests plug in their own
+// to avoid touching the real network.
+type Dialer interface {
+	Dial(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// dialerFunc adapts a function to the Dialer interface
+type dialerFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+func (f dialerFunc) Dial(ctx context.Context, network, address string) (net.Conn, error) {
+	return f(ctx, network, address)
+}
+
 type status struct {
 	online            bool
 	connectionRefused bool
@@ -58,12 +108,23 @@ type status struct {
 // If the host hasn't heard from the real-world host in over 10 minutes, it
 // pauses its processing and only resumes once it hears from the client again.
 type host struct {
-	name       string
-	ip         string
-	port       string
-	cflRecord  *cloudflare.Record
-	isProxying bool
-	cflGroups  map[string]*cflGroup
+	name      string
+	ip        string
+	port      string
+	cflRecord *cloudflare.Record
+	// cflRecordId caches the id of cflRecord so that we can update and destroy
+	// the record without looking it up first.
+	cflRecordId string
+	isProxying  bool
+	// srvRegistered indicates whether we created the host's SRV record (see
+	// -cf-srv)
+	srvRegistered bool
+	// srvWeight is the weight of the SRV record we created
+	srvWeight int
+	// healthCheckRegistered indicates whether we created the host's
+	// CloudFlare health check (see -cf-health-checks)
+	healthCheckRegistered bool
+	cflGroups             map[GroupName]*cflGroup
 	/* Temporarily disable CloudFront/DNSimple.
 	dspRecord *dnsimple.Record
 	cfrDist     *cfr.Distribution
@@ -74,28 +135,113 @@ type host struct {
 
 	resetCh      chan string
 	unregisterCh chan interface{}
+	resyncCh     chan interface{}
 	statusCh     chan chan *status
 	// Temporarily disable CloudFront/DNSimple.
 	//initCfrCh    chan interface{}
 
+	dialer            Dialer
 	proxiedClient     *http.Client
 	reportedHost      string
 	reportedHostMutex sync.Mutex
+
+	online              bool
+	paused              bool
+	consecutiveFailures int
+	drainingUntil       time.Time
+	checkDurations      *circularBuffer
+	healthHistory       *HealthHistory
+	info                hostInfo
+	infoMutex           sync.RWMutex
+	metadata            map[string]string
+	metadataMutex       sync.RWMutex
+	recordTtl           int
+	recordTtlMutex      sync.RWMutex
+	sni                 string
+	sniMutex            sync.RWMutex
+	weight              int
+	weightMutex         sync.RWMutex
+
+	// consecutiveSuccesses counts the checks passed since the last failure
+	consecutiveSuccesses int
+	// probation indicates that the host was removed from its rotations for
+	// failing and needs to pass -success-threshold checks to be added back
+	probation bool
+	// checkBackoff is how the interval between checks grows while the host is
+	// out of its rotations and keeps failing, backoffFailures being the number
+	// of such failures
+	checkBackoff    backoff.Policy
+	backoffFailures int
+	// lastCloudFlareSync is when the host's records were last known to be in
+	// CloudFlare the way they should be, lastCloudFlareSyncErr is why the last
+	// attempt to get them there failed, if it did
+	lastCloudFlareSync    time.Time
+	lastCloudFlareSyncErr error
+	// obfs4 is how the host's obfs4 server can be reached, if it has one
+	obfs4      *obfs4Config
+	obfs4Mutex sync.RWMutex
+	// runTicker is how hostWatchdog tells whether the run loop is stuck
+	runTicker *watchdog.Ticker
+	// goroutineAlive is set by the run loop's heartbeat and reset by the
+	// reaper, which removes hosts whose loops are gone (see reap)
+	goroutineAlive atomic.Bool
+	// timeoutRatioExceeded indicates that the host has been warned about for
+	// timing out too often
+	timeoutRatioExceeded bool
+}
+
+// hostInfo is a point in time snapshot of a host's state that can safely be
+// read from outside of the host's run loop.
+type hostInfo struct {
+	name                string
+	ip                  string
+	port                string
+	online              bool
+	state               string
+	consecutiveFailures int
+	lastTest            time.Time
+	cflRecordId         string
+	checkDurations      *circularBuffer
+	metadata            map[string]string
+	sni                 string
+	weight              int
+	// lastCfSync and lastCfSyncErr are lastCloudFlareSync and
+	// lastCloudFlareSyncErr
+	lastCfSync    time.Time
+	lastCfSyncErr string
+	// groups are the rotations that the host is eligible for, sorted
+	groups []GroupName
+	// timeoutRatio is the fraction of the checks in the health history that
+	// timed out
+	timeoutRatio float64
 }
 
+// String identifies h in logs as <name>@<ip>, or <name>@<ip>:<port> if its
+// port is known. The port comes from the published info, since the run loop
+// sets h.port once it finds the port of a fallback that was loaded without one.
 func (h *host) String() string {
-	return fmt.Sprintf("%v (%v)", h.name, h.ip)
+	h.infoMutex.RLock()
+	port := h.info.port
+	h.infoMutex.RUnlock()
+	if port == "" {
+		return hostkey{h.name, h.ip}.String()
+	}
+	return fmt.Sprintf("%v@%v", h.name, net.JoinHostPort(h.ip, port))
 }
 
 /*******************************************************************************
  * API for interacting with host
  ******************************************************************************/
 
-// newHost creates a new host for the given name, ip and optional DNS records.
+// newHost creates a new host for the given name, ip and optional DNS records,
+// failing if name and ip aren't valid (see validateHostKey).
 
 // Temporarily disable CloudFront/DNSimple.
-//func newHost(name string, ip string, port string, cflRecord *cloudflare.Record, dspRecord *dnsimple.Record) *host {
-func newHost(name string, ip string, port string, cflRecord *cloudflare.Record) *host {
+//func newHost(name string, ip string, port string, cflRecord *cloudflare.Record, dspRecord *dnsimple.Record) (*host, error) {
+func newHost(name string, ip string, port string, cflRecord *cloudflare.Record) (*host, error) {
+	if err := validateHostKey(hostkey{name, ip}); err != nil {
+		return nil, err
+	}
 	h := &host{
 		name:      name,
 		ip:        ip,
@@ -105,17 +251,31 @@ func newHost(name string, ip string, port string, cflRecord *cloudflare.Record)
 		//dspRecord:    dspRecord,
 		resetCh:      make(chan string, 1000),
 		unregisterCh: make(chan interface{}, 1),
+		resyncCh:     make(chan interface{}, 1),
 		statusCh:     make(chan chan *status, 1000),
 		// Temporarily disable CloudFront/DNSimple.
 		//initCfrCh:    make(chan interface{}, 1),
+		dialer:         defaultDialer,
+		checkDurations: newCircularBuffer(checkDurationsKept),
+		healthHistory:  &HealthHistory{},
+		recordTtl:      cfl.AutoTtl,
+		weight:         defaultWeight,
+		checkBackoff:   backoff.Policy{Base: testPeriod, Max: *maxBackoff, Multiplier: 2, MaxAttempts: math.MaxInt32},
+	}
+	h.info = hostInfo{name: name, ip: ip, port: port, state: StateOffline, checkDurations: h.checkDurations}
+	// Until the run loop starts
+	h.heartbeat()
+
+	if cflRecord != nil {
+		h.cflRecordId = cflRecord.Id
 	}
 
 	if h.isFallback() {
 
-		h.cflGroups = map[string]*cflGroup{
-			RoundRobin: &cflGroup{subdomain: RoundRobin},
-			Fallbacks:  &cflGroup{subdomain: Fallbacks},
-			Peers:      &cflGroup{subdomain: Peers},
+		h.cflGroups = make(map[GroupName]*cflGroup, len(groupConfigs)+2)
+		for i := range groupConfigs {
+			subdomain := groupConfigs[i].subdomain()
+			h.cflGroups[subdomain] = &cflGroup{subdomain: subdomain}
 		}
 		/* Temporarily disable CloudFront/DNSimple.
 		h.dspGroups = map[string]*dspGroup{
@@ -124,6 +284,9 @@ func newHost(name string, ip string, port string, cflRecord *cloudflare.Record)
 			Peers:      &dspGroup{subdomain: Peers},
 		}
 		*/
+		if rollouts != nil {
+			h.cflGroups[StagingGroup] = &cflGroup{subdomain: StagingGroup}
+		}
 		country := fallbackCountry(name)
 		if country != "" {
 			// Add host to country-specific rotation
@@ -132,10 +295,11 @@ func newHost(name string, ip string, port string, cflRecord *cloudflare.Record)
 			//h.dspGroups[country] = &dspGroup{subdomain: country}
 		}
 	} else {
-		log.Errorf("Somehow adding peer host? %v (%v)", name, ip)
+		log.Errorf("Somehow adding peer host? %v", hostkey{name, ip})
 	}
+	h.info.groups = h.groupNames()
 
-	return h
+	return h, nil
 }
 
 // resetProxiedClient reconfigures the host so attempts to proxy through it,
@@ -150,17 +314,16 @@ func newHost(name string, ip string, port string, cflRecord *cloudflare.Record)
 // it uses.
 func (h *host) resetProxiedClient(port string) {
 
-	var dial func(addr string) (net.Conn, error)
+	var dial func(ctx context.Context) (net.Conn, error)
 	if port == "80" {
-		dial = func(addr string) (net.Conn, error) {
-			dialer := net.Dialer{Timeout: dialTimeout}
-			return dialer.Dial("tcp", h.ip+":80")
+		dial = func(ctx context.Context) (net.Conn, error) {
+			return h.dial(ctx, h.ip+":80")
 		}
 	} else if port == "443" {
-		dial = func(addr string) (net.Conn, error) {
-			return tlsdialer.DialWithDialer(&net.Dialer{
-				Timeout: dialTimeout,
-			}, "tcp", h.ip+":443", true, &tls.Config{
+		dial = func(ctx context.Context) (net.Conn, error) {
+			return h.dialTLS(ctx, h.ip+":443", &tls.Config{
+				// Present the same SNI that clients will
+				ServerName:         h.getSni(),
 				InsecureSkipVerify: true,
 				// Cache TLS sessions
 				ClientSessionCache: tls.NewLRUClientSessionCache(1000),
@@ -173,11 +336,13 @@ func (h *host) resetProxiedClient(port string) {
 
 	h.proxiedClient = &http.Client{
 		Transport: &http.Transport{
-			Dial: func(network, addr string) (net.Conn, error) {
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
 				return enproxy.Dial(addr, &enproxy.Config{
-					DialProxy: dial,
-					NewRequest: func(upstreamHost string, method string, body io.Reader) (req *http.Request, err error) {
-						return http.NewRequest(method, "http://"+h.ip+"/", body)
+					DialProxy: func(addr string) (net.Conn, error) {
+						return dial(ctx)
+					},
+					NewRequest: func(upstreamHost, path, method string, body io.Reader) (req *http.Request, err error) {
+						return http.NewRequest(method, "http://"+h.ip+"/"+path+"/", body)
 					},
 					OnFirstResponse: func(resp *http.Response) {
 						h.reportedHostMutex.Lock()
@@ -192,6 +357,37 @@ func (h *host) resetProxiedClient(port string) {
 	}
 }
 
+// dial dials the given address using this host's Dialer, giving up after
+// dialTimeout or once ctx is done.
+func (h *host) dial(ctx context.Context, addr string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	return h.dialer.Dial(ctx, "tcp", addr)
+}
+
+// dialTLS dials the given address using this host's Dialer and completes a
+// TLS handshake on the resulting connection.
+func (h *host) dialTLS(ctx context.Context, addr string, config *tls.Config) (net.Conn, error) {
+	conn, err := h.dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	tlsConn := tls.Client(conn, config)
+	if err := conn.SetDeadline(time.Now().Add(dialTimeout)); err != nil {
+		log.Debugf("Unable to set handshake deadline: %v", err)
+	}
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		if err := conn.Close(); err != nil {
+			log.Debugf("Unable to close connection: %v", err)
+		}
+		return nil, fmt.Errorf("TLS handshake with %v failed: %v", addr, err)
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		log.Debugf("Unable to clear handshake deadline: %v", err)
+	}
+	return tlsConn, nil
+}
+
 // status returns the status of this host as of the next scheduled check
 func (h *host) status() (online bool, connectionRefused bool, timedOut bool) {
 	// Buffer the channel so that if we time out, reportStatus can still report
@@ -206,6 +402,17 @@ func (h *host) status() (online bool, connectionRefused bool, timedOut bool) {
 	}
 }
 
+// getInfo returns the most recently published snapshot of this host's state.
+func (h *host) getInfo() hostInfo {
+	h.infoMutex.RLock()
+	info := h.info
+	h.infoMutex.RUnlock()
+	info.metadata = h.getMetadata()
+	info.sni = h.getSni()
+	info.weight = h.getWeight()
+	return info
+}
+
 // reset resets this host's run loop in response to the host having reported in,
 // which can include changing the name if the given name is new.
 func (h *host) reset(newName string) {
@@ -223,6 +430,18 @@ func (h *host) unregister() {
 	}
 }
 
+// resync makes this host forget what it knows about its DNS records and check
+// itself right away, registering its records again if it's online. This is
+// used when its records were changed outside of peerscanner.
+func (h *host) resync() {
+	select {
+	case h.resyncCh <- nil:
+		log.Tracef("Resyncing host %v", h)
+	default:
+		log.Tracef("Already resyncing host %v, ignoring new request", h)
+	}
+}
+
 /* Temporarily disable CloudFront/DNSimple.
 func (h *host) initCloudfront() {
 	h.initCfrCh <- nil
@@ -256,16 +475,27 @@ func (h *host) doInitCfrDist() {
 
 // run is the main run loop for this host
 func (h *host) run() {
+	generation := h.watchRun()
+	defer h.recoverRun()
 	checkImmediately := true
 	h.lastSuccess = time.Now()
 	h.lastTest = time.Now()
 	periodTimer := time.NewTimer(0)
 	pauseTimer := time.NewTimer(0)
+	heartbeatTicker := time.NewTicker(heartbeatInterval)
+	defer heartbeatTicker.Stop()
 
 	for {
+		if !h.runTicker.Current(generation) {
+			log.Debugf("Run loop of %v was restarted by the watchdog, exiting", h)
+			return
+		}
+		h.runTicker.Reset(2 * h.checkInterval())
+		h.heartbeat()
+
 		if !checkImmediately {
 			// Limit the rate at which we run tests
-			waitTime := h.lastTest.Add(testPeriod).Sub(time.Now())
+			waitTime := h.lastTest.Add(h.checkInterval()).Sub(time.Now())
 			log.Tracef("Waiting %v until testing %v", waitTime, h)
 			periodTimer.Reset(waitTime)
 		}
@@ -280,6 +510,8 @@ func (h *host) run() {
 			log.Debugf("Unregistering %v and pausing", h)
 			h.pause()
 			checkImmediately = true
+		case <-h.resyncCh:
+			h.doResync()
 		/* Temporarily disable CloudFront/DNSimple.
 		case <-h.initCfrCh:
 			 h.doInitCfrDist()
@@ -289,52 +521,159 @@ func (h *host) run() {
 			h.pause()
 			checkImmediately = true
 		case <-periodTimer.C:
-			log.Tracef("Testing %v", h)
-			_s, timedOut, err := withtimeout.Do(ttl, func() (interface{}, error) {
-				online, connectionRefused, err := h.isAbleToProxy()
-				return &status{online, connectionRefused}, err
-			})
-			s := &status{false, false}
-			if timedOut {
-				log.Debugf("Testing %v timed out unexpectedly", h)
-			}
-			if _s != nil {
-				s = _s.(*status)
-			}
-			h.reportStatus(s)
-			h.lastTest = time.Now()
+			h.check()
 			checkImmediately = false
-			if s.online {
-				log.Tracef("Test for %v successful", h)
-				h.lastSuccess = time.Now()
-				err := h.register()
-				if err != nil {
-					log.Errorf("Error registering %v: %v", h, err)
-				}
-			} else {
-				log.Tracef("Test for %v failed with error: %v", h, err)
-				// Deregister this host from its rotations. We leave the host
-				// itself registered to support continued sticky routing in case
-				// any clients still have connections open to it.
-				h.deregisterFromRotations()
+		case <-heartbeatTicker.C:
+			// Nothing to do but go around the loop
+		}
+	}
+}
+
+// check tests connectivity via this host once, reports the resulting status
+// and registers or deregisters the host accordingly.
+func (h *host) check() {
+	log.Tracef("Testing %v", h)
+	result := submitCheck(h)
+	elapsed := result.elapsed
+	h.checkDurations.add(elapsed)
+	s, err := result.s, result.err
+	observeCheckDuration(h.isFallback(), s.online, elapsed)
+	if result.timedOut {
+		log.Debugf("Testing %v timed out unexpectedly", h)
+	}
+	h.reportStatus(s)
+	h.lastTest = time.Now()
+	errMsg := ""
+	if err != nil {
+		errMsg = err.Error()
+	} else if result.timedOut {
+		errMsg = "timed out"
+	}
+	timedOut := isCheckTimeout(result)
+	if timedOut {
+		h.checkTimedOut()
+	}
+	h.healthHistory.add(healthResult{ts: h.lastTest, success: s.online, latencyMs: int64(elapsed / time.Millisecond), errMsg: errMsg, timedOut: timedOut})
+	h.warnAboutTimeouts()
+	// Once the records that remain after this check are known
+	defer h.updateHealthTtl()
+	wasOnline := h.online
+	if s.online {
+		log.Tracef("Test for %v successful", h)
+		h.lastSuccess = time.Now()
+		h.consecutiveFailures = 0
+		h.consecutiveSuccesses++
+		if h.backoffFailures > 0 {
+			log.Debugf("%v passed its check, no longer backing off", h)
+			h.backoffFailures = 0
+		}
+		if !h.drainingUntil.IsZero() {
+			// Still in DNS, so no need for probation
+			log.Debugf("%v recovered while draining", h)
+			h.drainingUntil = time.Time{}
+			h.probation = false
+		}
+		if h.probation && h.consecutiveSuccesses < *successThreshold {
+			log.Debugf("%v passed %d of %d checks needed to end its probation", h, h.consecutiveSuccesses, *successThreshold)
+			h.publishInfo()
+			return
+		}
+		h.probation = false
+		h.online = true
+		err := h.register()
+		h.cloudFlareSynced(err)
+		if err != nil {
+			log.Errorf("Error registering %v: %v", h, err)
+		}
+	} else {
+		log.Tracef("Test for %v failed with error: %v", h, err)
+		h.consecutiveSuccesses = 0
+		h.consecutiveFailures++
+		if wasOnline && h.consecutiveFailures < *failThreshold {
+			log.Debugf("%v failed %d of %d checks before it's removed, keeping it for now", h, h.consecutiveFailures, *failThreshold)
+			h.publishInfo()
+			return
+		}
+		h.online = false
+		if wasOnline {
+			h.probation = true
+		}
+		h.drainOrDeregister(wasOnline)
+		if h.drainingUntil.IsZero() {
+			// Out of DNS, so no need to find out right away when it's back
+			h.backoffFailures++
+			if h.backoffFailures == 1 {
+				log.Debugf("%v is out of its rotations and failing, backing off checks up to %v", h, h.checkBackoff.Max)
 			}
 		}
 	}
+	h.updateRotationMembership(wasOnline)
+	h.publishInfo()
+}
+
+// checkInterval returns how long to wait between the last check and the next
+// one, which doubles with every failure once the host is out of its rotations.
+func (h *host) checkInterval() time.Duration {
+	if h.backoffFailures == 0 {
+		return testPeriod
+	}
+	return h.checkBackoff.Next(h.backoffFailures + 1)
+}
+
+// drainOrDeregister handles a failed check. If the host was online, it starts
+// draining, which leaves it in DNS for -drain-time so that clients with
+// cached DNS answers have a chance to move on. Once draining is over, the host
+// is deregistered from its rotations. We leave the host itself registered to
+// support continued sticky routing in case any clients still have connections
+// open to it.
+func (h *host) drainOrDeregister(wasOnline bool) {
+	now := time.Now()
+	if h.drainingUntil.IsZero() && wasOnline && *drainTime > 0 {
+		h.drainingUntil = now.Add(*drainTime)
+		log.Debugf("%v failed its check, draining until %v", h, h.drainingUntil)
+		return
+	}
+	if now.Before(h.drainingUntil) {
+		log.Tracef("%v still draining until %v", h, h.drainingUntil)
+		return
+	}
+	if !h.drainingUntil.IsZero() {
+		log.Debugf("%v done draining", h)
+		h.drainingUntil = time.Time{}
+	}
+	h.deregisterFromRotations()
 }
 
 // pause deregisters this host from rotations and then waits for the next reset
 // before continuing
 func (h *host) pause() {
+	// Waiting for a reset can take forever, that's fine
+	h.runTicker.Stop()
 	h.deregisterFromRotations()
+	h.drainingUntil = time.Time{}
+	wasOnline := h.online
+	h.online = false
+	h.updateRotationMembership(wasOnline)
+	h.probation = false
+	h.consecutiveSuccesses = 0
+	h.backoffFailures = 0
+	h.paused = true
+	h.publishInfo()
 	log.Debugf("%v paused", h)
+	heartbeatTicker := time.NewTicker(heartbeatInterval)
+	defer heartbeatTicker.Stop()
 	for {
+		h.heartbeat()
 		select {
 		case newName := <-h.resetCh:
 			log.Debugf("Unpausing checks for %v", h)
+			h.paused = false
 			h.doReset(newName)
+			h.publishInfo()
 			return
 		case <-h.unregisterCh:
 			log.Tracef("Ignoring unregister while paused")
+		case <-heartbeatTicker.C:
 		}
 	}
 }
@@ -357,9 +696,9 @@ func (h *host) doReset(newName string) {
 	if newName != h.name {
 		log.Debugf("Hostname for %v changed to %v", h, newName)
 		var cflErr, dspErr error
-		if h.cflRecord != nil {
-			log.Debugf("Deregistering old Cloudflare hostname %v", h.name)
-			cflErr = h.doDeregisterCflHost()
+		if h.cflRecord != nil || h.cflRecordId != "" {
+			log.Debugf("Migrating Cloudflare records of %v to %v", h.name, newName)
+			cflErr = h.doMigrateCflHost(newName)
 			if cflErr != nil {
 				log.Error(cflErr.Error())
 			}
@@ -377,11 +716,72 @@ func (h *host) doReset(newName string) {
 			return
 		}
 		h.name = newName
+		h.publishInfo()
 	}
 	h.lastSuccess = time.Now()
 	h.lastTest = time.Time{}
 }
 
+// doResync forgets the cached DNS records so that the next check registers
+// them from scratch.
+func (h *host) doResync() {
+	log.Debugf("Resyncing DNS records for %v", h)
+	h.cflRecord = nil
+	h.cflRecordId = ""
+	h.isProxying = false
+	h.srvRegistered = false
+	h.healthCheckRegistered = false
+	for _, g := range h.cflGroups {
+		g.existing = nil
+		g.isProxying = false
+	}
+	h.lastTest = time.Time{}
+}
+
+// publishInfo makes a snapshot of this host's current state available to
+// getInfo. It must only be called from the run loop.
+func (h *host) publishInfo() {
+	state := StateOffline
+	if h.paused {
+		state = StatePaused
+	} else if h.online {
+		state = StateOnline
+	} else if !h.drainingUntil.IsZero() {
+		state = StateDraining
+	} else if h.probation && h.consecutiveSuccesses > 0 {
+		state = StateProbation
+	}
+	h.infoMutex.Lock()
+	h.info = hostInfo{
+		name:                h.name,
+		ip:                  h.ip,
+		port:                h.port,
+		online:              h.online,
+		state:               state,
+		consecutiveFailures: h.consecutiveFailures,
+		lastTest:            h.lastTest,
+		cflRecordId:         h.cflRecordId,
+		checkDurations:      h.checkDurations,
+		lastCfSync:          h.lastCloudFlareSync,
+		groups:              h.groupNames(),
+	}
+	h.info.timeoutRatio, _ = h.healthHistory.timeoutRatio()
+	if h.lastCloudFlareSyncErr != nil {
+		h.info.lastCfSyncErr = h.lastCloudFlareSyncErr.Error()
+	}
+	h.infoMutex.Unlock()
+}
+
+// groupNames returns the names of this host's cflGroups, sorted.
+func (h *host) groupNames() []GroupName {
+	names := make([]GroupName, 0, len(h.cflGroups))
+	for name := range h.cflGroups {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
+
 /*******************************************************************************
  * Functions for managing DNS
  ******************************************************************************/
@@ -406,6 +806,16 @@ func (h *host) registerCfl() error {
 	if err != nil {
 		return fmt.Errorf("Unable to register Cloudflare host %v: %v", h, err)
 	}
+	err = h.registerSrv()
+	if err != nil {
+		// Clients can do without SRV records, so keep going
+		log.Errorf("Unable to register SRV record for %v: %v", h, err)
+	}
+	err = h.registerHealthCheck()
+	if err != nil {
+		// Like SRV records, these aren't essential
+		log.Errorf("Unable to register CloudFlare health check for %v: %v", h, err)
+	}
 	err = h.registerToCflRotations()
 	if err != nil {
 		return err
@@ -434,7 +844,12 @@ func (h *host) registerCflHost() error {
 	}
 	log.Debugf("Registering Cloudflare record %v", h)
 	var err error
-	h.cflRecord, h.isProxying, err = cflutil.EnsureRegistered(h.name, h.ip, h.cflRecord)
+	h.cflRecord, h.isProxying, err = cflutil.EnsureRegisteredWithTtl(h.name, h.ip, h.cflRecord, h.getRecordTtl())
+	if h.cflRecord != nil {
+		h.cflRecordId = h.cflRecord.Id
+	} else {
+		h.cflRecordId = ""
+	}
 	return err
 }
 
@@ -451,8 +866,47 @@ func (h *host) registerDspHost() error {
 }
 */
 
+// registerToCflRotations registers this host to its rotations. If it fails
+// the smoke test of a rotation, it's removed from it, except from Peers.
+// While it's rolling out (see -rollout), it's only registered to
+// StagingGroup. Rotations that have their -groups-config MaxMembers already
+// don't get new members.
 func (h *host) registerToCflRotations() error {
-	for _, group := range h.cflGroups {
+	smokeTestErrs := make(map[string]error)
+	passes := func(protocol string) bool {
+		err, tested := smokeTestErrs[protocol]
+		if !tested {
+			st := newSmokeTester()
+			st.Protocol = protocol
+			err = st.Test(h)
+			if err != nil {
+				log.Debugf("%v failed its %v smoke test, only keeping it in rotations that don't need it: %v", h, protocol, err)
+			}
+			smokeTestErrs[protocol] = err
+		}
+		return err == nil
+	}
+	_, promoted := h.rolloutWeight()
+	for name, group := range h.cflGroups {
+		protocol := *smokeTestProtocol
+		config, configured := groupConfigFor(name)
+		if configured {
+			protocol = config.checkProtocol()
+		}
+		member := name == Peers || passes(protocol)
+		if name == StagingGroup {
+			member = passes(protocol) && !promoted
+		} else if !promoted {
+			member = false
+		}
+		if !member {
+			group.deregister(h)
+			continue
+		}
+		if configured && config.MaxMembers > 0 && group.existing == nil && membersOf(name).Len() >= config.MaxMembers {
+			log.Debugf("%v already has %d members, not adding %v", name, config.MaxMembers, h)
+			continue
+		}
 		err := group.register(h)
 		if err != nil {
 			return err
@@ -478,9 +932,13 @@ func (h *host) registerToDspRotations() error {
 */
 
 func (h *host) deregisterFromRotations() {
+	var err error
 	for _, group := range h.cflGroups {
-		group.deregister(h)
+		if groupErr := group.deregister(h); groupErr != nil {
+			err = groupErr
+		}
 	}
+	h.cloudFlareSynced(err)
 	/* (CloudFront/DNSimple support temporarily disabled)
 	for _, group := range h.dspGroups {
 		group.deregister(h)
@@ -492,11 +950,12 @@ func (h *host) isFallback() bool {
 	return isFallback(h.name)
 }
 
-func (h *host) isAbleToProxy() (bool, bool, error) {
-	// Check whether or not we can proxy a few times
+func (h *host) isAbleToProxy(ctx context.Context) (bool, bool, error) {
+	// Check whether or not we can proxy a few times, as configured by
+	// backoff.DefaultCheckPolicy
 	var lastErr error
-	for i := 0; i < proxyAttempts; i++ {
-		success, connectionRefused, err := h.doIsAbleToProxy()
+	for attempt := 1; ; attempt++ {
+		success, connectionRefused, err := h.doIsAbleToProxy(ctx)
 		if err != nil {
 			log.Tracef("Error testing %v: %v", h, err.Error())
 		}
@@ -516,23 +975,38 @@ func (h *host) isAbleToProxy() (bool, bool, error) {
 					log.Error(lastErr.Error())
 				}
 			}
+			if success {
+				if err := h.obfs4Ok(ctx); err != nil {
+					log.Debugf("%v failed its obfs4 check: %v", h, err)
+					success = false
+					lastErr = err
+				}
+			}
 
 			return success, connectionRefused, lastErr
 		}
+		wait := backoff.DefaultCheckPolicy.Next(attempt)
+		if wait == backoff.Stop {
+			return false, false, lastErr
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return false, false, lastErr
+		}
 	}
-	return false, false, lastErr
 }
 
-func (h *host) doIsAbleToProxy() (bool, bool, error) {
+func (h *host) doIsAbleToProxy(ctx context.Context) (bool, bool, error) {
 	if h.port == "" {
 		h.resetProxiedClient("80")
-		success, connectionRefused, err := h.reallyDoIsAbleToProxy("80")
+		success, connectionRefused, err := h.reallyDoIsAbleToProxy(ctx, "80")
 		if success {
 			h.port = "80"
 			return success, connectionRefused, err
 		}
 		h.resetProxiedClient("443")
-		success, connectionRefused, err = h.reallyDoIsAbleToProxy("443")
+		success, connectionRefused, err = h.reallyDoIsAbleToProxy(ctx, "443")
 		if success {
 			h.port = "443"
 		}
@@ -540,17 +1014,17 @@ func (h *host) doIsAbleToProxy() (bool, bool, error) {
 	} else if h.proxiedClient == nil {
 		h.resetProxiedClient(h.port)
 	}
-	return h.reallyDoIsAbleToProxy(h.port)
+	return h.reallyDoIsAbleToProxy(ctx, h.port)
 }
 
-func (h *host) reallyDoIsAbleToProxy(port string) (bool, bool, error) {
+func (h *host) reallyDoIsAbleToProxy(ctx context.Context, port string) (bool, bool, error) {
 	// First just try a plain TCP connection. This is useful because the
 	// underlying TCP-level error is consumed in the flashlight layer, and we
 	// need that to be accessible on the client side in the logic for deciding
 	// whether or not to display the port mapping message.
 	//XXX: allow port 80 too
 	addr := h.ip + ":" + port
-	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	conn, err := h.dial(ctx, addr)
 	if err != nil {
 		err2 := fmt.Errorf("Unable to connect to %v: %v", addr, err)
 		return false, strings.Contains(err.Error(), "connection refused"), err2
@@ -561,7 +1035,11 @@ func (h *host) reallyDoIsAbleToProxy(port string) (bool, bool, error) {
 
 	// Now actually try to proxy an http request
 	site := testSites[rand.Intn(len(testSites))]
-	resp, err := h.proxiedClient.Head("http://" + site)
+	req, err := http.NewRequestWithContext(ctx, "HEAD", "http://"+site, nil)
+	if err != nil {
+		return false, false, err
+	}
+	resp, err := h.proxiedClient.Do(req)
 	if err != nil {
 		return false, false, fmt.Errorf("Unable to make proxied HEAD request to %v: %v", site, err)
 	}
@@ -581,21 +1059,39 @@ func (h *host) reallyDoIsAbleToProxy(port string) (bool, bool, error) {
 
 // fallbackCountry returns the country code of a fallback if it follows the
 // usual naming convention.
-func fallbackCountry(name string) string {
+func fallbackCountry(name string) GroupName {
 	sub := fallbackNamePattern.FindSubmatch([]byte(name))
 	if len(sub) == 2 {
-		return string(sub[1]) + ".fallbacks"
+		return countryGroup(string(sub[1]))
 	}
 	return ""
 }
 
 func (h *host) doDeregisterCflHost() error {
-	err := cflutil.DestroyRecord(h.cflRecord)
+	id := h.cflRecordId
+	if id == "" {
+		// We don't know the record id (e.g. after a failed create), so look it
+		// up.
+		rec, err := cflutil.FindRecord(h.name, h.ip)
+		if err != nil {
+			return fmt.Errorf("Unable to find Cloudflare record to deregister %v: %v", h, err)
+		}
+		id = rec.Id
+	}
+	err := cflutil.DestroyRecordById(id)
 	h.cflRecord = nil
+	h.cflRecordId = ""
 	h.isProxying = false
+	h.cloudFlareSynced(err)
 	if err != nil {
 		return fmt.Errorf("Unable to deregister Cloudflare record %v: %v", h, err)
 	}
+	if err := h.deregisterSrv(); err != nil {
+		log.Errorf("Unable to deregister SRV record for %v: %v", h, err)
+	}
+	if err := h.deregisterHealthCheck(); err != nil {
+		log.Errorf("Unable to deregister CloudFlare health check for %v: %v", h, err)
+	}
 	return nil
 }
 
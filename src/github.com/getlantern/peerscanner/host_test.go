@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getlantern/enproxy"
+	"github.com/getlantern/peerscanner/backoff"
+	"github.com/getlantern/testify/assert"
+)
+
+// mockDialer connects every dial to addr, unless err is set, in which case it
+// fails with err.
+type mockDialer struct {
+	sync.Mutex
+	addr string
+	err  error
+}
+
+func (d *mockDialer) Dial(ctx context.Context, network, address string) (net.Conn, error) {
+	d.Lock()
+	addr, err := d.addr, d.err
+	d.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, addr)
+}
+
+func (d *mockDialer) setErr(err error) {
+	d.Lock()
+	d.err = err
+	d.Unlock()
+}
+
+func (d *mockDialer) setAddr(addr string) {
+	d.Lock()
+	d.addr = addr
+	d.Unlock()
+}
+
+// fakeFallback is an enproxy server that behaves like a fallback named name,
+// proxying every request to a local site that always responds with 200.
+type fakeFallback struct {
+	site  *httptest.Server
+	proxy *httptest.Server
+}
+
+func newFakeFallback(name string) *fakeFallback {
+	site := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(200)
+	}))
+	p := &enproxy.Proxy{
+		Dial: func(addr string) (net.Conn, error) {
+			return net.Dial("tcp", site.Listener.Addr().String())
+		},
+		Host: name + "." + *cfldomain,
+	}
+	p.Start()
+	return &fakeFallback{site: site, proxy: httptest.NewServer(p)}
+}
+
+func (f *fakeFallback) addr() string {
+	return f.proxy.Listener.Addr().String()
+}
+
+func (f *fakeFallback) close() {
+	f.proxy.Close()
+	f.site.Close()
+}
+
+// newTestHost creates a fallback host that dials fallback through a
+// mockDialer, without starting its run loop.
+func newTestHost(name string, ip string, fallback *fakeFallback) (*host, *mockDialer) {
+	d := &mockDialer{addr: fallback.addr()}
+	h := mustNewHost(name, ip, "80")
+	h.dialer = d
+	return h, d
+}
+
+// mustNewHost creates a host without DNS records, panicking if name and ip
+// are invalid.
+func mustNewHost(name string, ip string, port string) *host {
+	h, err := newHost(name, ip, port, nil)
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
+
+func TestCheckSuccessKeepsHostOnline(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	name, ip := "fl-us-checkok", "45.63.1.1"
+	f := newFakeFallback(name)
+	defer f.close()
+	h, _ := newTestHost(name, ip, f)
+
+	for i := 0; i < 3; i++ {
+		h.check()
+		assert.True(t, h.getInfo().online, "Host should be online after successful check %d", i)
+	}
+	assert.Len(t, m.FindRecords(name, ip), 1, "Host should be registered")
+	assert.Len(t, m.FindRecords(string(RoundRobin), ip), 1, "Host should be in round robin")
+	assert.Equal(t, 1, m.CountRecordRequests("POST")-len(h.cflGroups), "Host should only have been registered once")
+}
+
+func TestCheckSetsRecordId(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	name, ip := "fl-us-recordid", "45.63.1.10"
+	f := newFakeFallback(name)
+	defer f.close()
+	h, _ := newTestHost(name, ip, f)
+	assert.Equal(t, "", h.getInfo().cflRecordId, "Host shouldn't have a record before its first check")
+
+	h.check()
+	recs := m.FindRecords(name, ip)
+	if assert.Len(t, recs, 1) && assert.NotNil(t, h.cflRecord, "Host should have its record after its first check") {
+		assert.Equal(t, recs[0].Id, h.cflRecord.Id)
+		assert.Equal(t, recs[0].Id, h.getInfo().cflRecordId)
+	}
+}
+
+func TestCheckFailureTakesHostOfflineAndRecovers(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	defer withDrainTime(0)()
+	defer withThresholds(1, 1)()
+	name, ip := "fl-us-checkfail", "45.63.1.2"
+	f := newFakeFallback(name)
+	defer f.close()
+	h, d := newTestHost(name, ip, f)
+
+	h.check()
+	if !assert.True(t, h.getInfo().online, "Host should start out online") {
+		return
+	}
+
+	d.setErr(fmt.Errorf("connection refused"))
+	for i := 0; i < backoff.DefaultCheckPolicy.MaxAttempts; i++ {
+		h.check()
+	}
+	assert.False(t, h.getInfo().online, "Host should be offline after %d failures", backoff.DefaultCheckPolicy.MaxAttempts)
+	assert.Len(t, m.FindRecords(string(RoundRobin), ip), 0, "Offline host should be removed from round robin")
+	assert.Len(t, m.FindRecords(string(Fallbacks), ip), 0, "Offline host should be removed from fallbacks")
+	assert.Len(t, m.FindRecords(name, ip), 1, "Offline host should keep its own record for sticky routing")
+
+	d.setErr(nil)
+	h.check()
+	assert.True(t, h.getInfo().online, "Host should be back online after recovering")
+	assert.Len(t, m.FindRecords(string(RoundRobin), ip), 1, "Recovered host should be back in round robin")
+	assert.Len(t, m.FindRecords("us.fallbacks", ip), 1, "Recovered host should be back in its country rotation")
+}
+
+func TestDrainingStateMachine(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	defer withDrainTime(50 * time.Millisecond)()
+	defer withThresholds(1, 1)()
+
+	name, ip := "fl-us-drain", "45.63.1.3"
+	f := newFakeFallback(name)
+	defer f.close()
+	h, d := newTestHost(name, ip, f)
+
+	h.check()
+	assert.Equal(t, StateOnline, h.getInfo().state)
+
+	// Failing while online starts draining, which keeps the host in DNS
+	d.setErr(fmt.Errorf("connection refused"))
+	h.check()
+	assert.Equal(t, StateDraining, h.getInfo().state, "Failed host should be draining")
+	assert.Len(t, m.FindRecords(string(RoundRobin), ip), 1, "Draining host should stay in round robin")
+
+	// Recovering while draining cancels the drain
+	d.setErr(nil)
+	h.check()
+	assert.Equal(t, StateOnline, h.getInfo().state, "Recovered host should be online again")
+	assert.True(t, h.drainingUntil.IsZero(), "Drain should have been cancelled")
+	assert.Equal(t, 0, m.CountRecordRequests("DELETE"), "Nothing should have been removed while draining")
+
+	// Failing again starts a new drain, after which the host is removed
+	d.setErr(fmt.Errorf("connection refused"))
+	h.check()
+	assert.Equal(t, StateDraining, h.getInfo().state)
+	h.check()
+	assert.Equal(t, StateDraining, h.getInfo().state, "Host should still be draining before drain time passes")
+	assert.Len(t, m.FindRecords(string(RoundRobin), ip), 1, "Host should stay in round robin until drain time passes")
+	time.Sleep(60 * time.Millisecond)
+	h.check()
+	assert.Equal(t, StateOffline, h.getInfo().state, "Host should be offline once drain time passed")
+	assert.Len(t, m.FindRecords(string(RoundRobin), ip), 0, "Drained host should be removed from round robin")
+	assert.Len(t, m.FindRecords(name, ip), 1, "Drained host should keep its own record for sticky routing")
+
+	// Failing while offline doesn't start draining
+	h.check()
+	assert.Equal(t, StateOffline, h.getInfo().state, "Offline host shouldn't start draining")
+}
+
+// withDrainTime sets -drain-time, returning a function that restores the
+// original value.
+func withDrainTime(d time.Duration) func() {
+	orig := *drainTime
+	*drainTime = d
+	return func() {
+		*drainTime = orig
+	}
+}
+
+func withThresholds(fail int, success int) func() {
+	origFail, origSuccess := *failThreshold, *successThreshold
+	*failThreshold, *successThreshold = fail, success
+	return func() {
+		*failThreshold, *successThreshold = origFail, origSuccess
+	}
+}
+
+func TestFailThreshold(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	defer withDrainTime(0)()
+	defer withThresholds(3, 2)()
+	name, ip := "fl-us-failthreshold", "45.63.1.5"
+	f := newFakeFallback(name)
+	defer f.close()
+	h, d := newTestHost(name, ip, f)
+
+	h.check()
+	assert.Equal(t, StateOnline, h.getInfo().state)
+
+	d.setErr(fmt.Errorf("connection refused"))
+	for i := 1; i < 3; i++ {
+		h.check()
+		assert.Equal(t, StateOnline, h.getInfo().state, "Host should stay online after %d failures", i)
+		assert.Len(t, m.FindRecords(string(RoundRobin), ip), 1, "Host should stay in round robin after %d failures", i)
+	}
+	h.check()
+	assert.Equal(t, StateOffline, h.getInfo().state, "Host should be offline after 3 failures")
+	assert.Equal(t, 3, h.getInfo().consecutiveFailures)
+	assert.Len(t, m.FindRecords(string(RoundRobin), ip), 0, "Host should be removed from round robin after 3 failures")
+}
+
+func TestFailThresholdResetsOnSuccess(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	defer withDrainTime(0)()
+	defer withThresholds(3, 2)()
+	name, ip := "fl-us-failreset", "45.63.1.6"
+	f := newFakeFallback(name)
+	defer f.close()
+	h, d := newTestHost(name, ip, f)
+
+	h.check()
+	for i := 0; i < 5; i++ {
+		d.setErr(fmt.Errorf("connection refused"))
+		h.check()
+		h.check()
+		d.setErr(nil)
+		h.check()
+	}
+	assert.Equal(t, StateOnline, h.getInfo().state, "Failures that don't reach the threshold in a row shouldn't take the host offline")
+	assert.Equal(t, 0, m.CountRecordRequests("DELETE"), "Nothing should have been removed")
+}
+
+func TestSuccessThreshold(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	defer withDrainTime(0)()
+	defer withThresholds(1, 2)()
+	name, ip := "fl-us-successthreshold", "45.63.1.7"
+	f := newFakeFallback(name)
+	defer f.close()
+	h, d := newTestHost(name, ip, f)
+
+	h.check()
+	assert.Equal(t, StateOnline, h.getInfo().state, "New hosts shouldn't need to pass more than one check")
+
+	d.setErr(fmt.Errorf("connection refused"))
+	h.check()
+	assert.Equal(t, StateOffline, h.getInfo().state)
+
+	d.setErr(nil)
+	h.check()
+	assert.Equal(t, StateProbation, h.getInfo().state, "Host should be on probation after 1 success")
+	assert.False(t, h.getInfo().online)
+	assert.Len(t, m.FindRecords(string(RoundRobin), ip), 0, "Host on probation shouldn't be back in round robin")
+
+	// Failing on probation starts it over
+	d.setErr(fmt.Errorf("connection refused"))
+	h.check()
+	assert.Equal(t, StateOffline, h.getInfo().state)
+	d.setErr(nil)
+	h.check()
+	assert.Equal(t, StateProbation, h.getInfo().state, "Probation should start over after a failure")
+
+	h.check()
+	assert.Equal(t, StateOnline, h.getInfo().state, "Host should be online after 2 successes")
+	assert.Len(t, m.FindRecords(string(RoundRobin), ip), 1, "Host should be back in round robin after 2 successes")
+}
+
+func TestAlternatingChecksDontFlap(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	defer withDrainTime(0)()
+	defer withThresholds(1, 2)()
+	name, ip := "fl-us-alternating", "45.63.1.9"
+	f := newFakeFallback(name)
+	defer f.close()
+	h, d := newTestHost(name, ip, f)
+
+	h.check()
+	assert.Equal(t, StateOnline, h.getInfo().state)
+	d.setErr(fmt.Errorf("connection refused"))
+	h.check()
+	assert.Equal(t, StateOffline, h.getInfo().state)
+	created := m.CountRecordRequests("POST")
+
+	for i := 0; i < 5; i++ {
+		d.setErr(nil)
+		h.check()
+		assert.Equal(t, StateProbation, h.getInfo().state, "A single success shouldn't add the host back")
+		d.setErr(fmt.Errorf("connection refused"))
+		h.check()
+		assert.Equal(t, StateOffline, h.getInfo().state)
+	}
+	assert.Len(t, m.FindRecords(string(RoundRobin), ip), 0, "Alternating host shouldn't be back in round robin")
+	assert.Equal(t, created, m.CountRecordRequests("POST"), "Alternating host shouldn't churn DNS")
+}
+
+func TestSuccessThresholdAlias(t *testing.T) {
+	defer withThresholds(*failThreshold, *successThreshold)()
+	if assert.NoError(t, flag.Set("check-consecutive-success-before-add", "4")) {
+		assert.Equal(t, 4, *successThreshold)
+	}
+}
+
+func TestCheckBackoff(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	defer withDrainTime(0)()
+	defer withThresholds(1, 1)()
+	name, ip := "fl-us-backoff", "45.63.1.8"
+	f := newFakeFallback(name)
+	defer f.close()
+	h, d := newTestHost(name, ip, f)
+	h.checkBackoff.Max = 8 * testPeriod
+
+	h.check()
+	assert.Equal(t, testPeriod, h.checkInterval(), "Online host should be checked at the base interval")
+
+	d.setErr(fmt.Errorf("connection refused"))
+	for _, expected := range []time.Duration{2, 4, 8, 8} {
+		h.check()
+		assert.Equal(t, expected*testPeriod, h.checkInterval(), "Interval should double after each failure, up to the maximum")
+	}
+
+	d.setErr(nil)
+	h.check()
+	assert.Equal(t, testPeriod, h.checkInterval(), "Success should reset the interval")
+}
+
+func TestCheckBackoffWaitsForRemoval(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	defer withDrainTime(time.Hour)()
+	defer withThresholds(2, 1)()
+	name, ip := "fl-us-backoffdrain", "45.63.1.9"
+	f := newFakeFallback(name)
+	defer f.close()
+	h, d := newTestHost(name, ip, f)
+
+	h.check()
+	d.setErr(fmt.Errorf("connection refused"))
+	for i := 0; i < 3; i++ {
+		h.check()
+		assert.Equal(t, testPeriod, h.checkInterval(), "Hosts in DNS shouldn't back off")
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+const (
+	// maxLabelLength is the longest a single DNS label can be
+	maxLabelLength = 63
+
+	// maxFqdnLength is the longest a DNS name can be, leaving out the
+	// trailing dot
+	maxFqdnLength = 253
+)
+
+var (
+	maxRecordNameLength = flag.Int("max-record-name-length", maxFqdnLength, "Longest that a host's own record name can be including -cfldomain, registrations with longer names are rejected, defaults to 253")
+
+	// hostNamePattern matches names made up of lowercase DNS label characters.
+	// CloudFlare lowercases record names, so names with uppercase letters
+	// would never match their own records.
+	hostNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+)
+
+// hostkey identifies a host by its name and ip
+type hostkey struct {
+	name string
+	ip   string
+}
+
+func (k hostkey) String() string {
+	return fmt.Sprintf("%v@%v", k.name, k.ip)
+}
+
+// validateHostKey checks that key is something we can register in DNS: a peer
+// or fallback name and a valid ip.
+func validateHostKey(key hostkey) error {
+	if key.name == "" {
+		return fmt.Errorf("Host name is empty")
+	}
+	if key.ip == "" {
+		return fmt.Errorf("Host ip for %v is empty", key.name)
+	}
+	if net.ParseIP(key.ip) == nil {
+		return fmt.Errorf("Invalid ip %v for %v", key.ip, key.name)
+	}
+	for _, label := range strings.Split(key.name, ".") {
+		if len(label) > maxLabelLength {
+			return fmt.Errorf("Host name %v has a label that's %d characters long, DNS allows at most %d", key.name, len(label), maxLabelLength)
+		}
+	}
+	if fqdn := key.name + "." + *cfldomain; len(fqdn) > *maxRecordNameLength {
+		return fmt.Errorf("Record name %v is %d characters long, at most %d are allowed", fqdn, len(fqdn), *maxRecordNameLength)
+	}
+	if !isPeer(key.name) && !isFallback(key.name) {
+		return fmt.Errorf("%v is neither a peer nor a fallback", key.name)
+	}
+	if !hostNamePattern.MatchString(key.name) {
+		return fmt.Errorf("Invalid host name %v, only lowercase letters, digits and dashes are allowed", key.name)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestValidateHostKey(t *testing.T) {
+	tests := []struct {
+		desc  string
+		key   hostkey
+		valid bool
+	}{
+		{"valid peer", hostkey{"0123456789abcdef0123456789abcdef", "1.2.3.4"}, true},
+		{"valid named peer", hostkey{"peer-home", "1.2.3.4"}, true},
+		{"valid fallback", hostkey{"fl-us-20150401-001", "45.63.1.1"}, true},
+		{"empty name", hostkey{"", "45.63.1.1"}, false},
+		{"empty ip", hostkey{"fl-us-001", ""}, false},
+		{"invalid ip", hostkey{"fl-us-001", "45.63.1"}, false},
+		{"overly long name", hostkey{"fl-" + strings.Repeat("a", maxFqdnLength), "45.63.1.1"}, false},
+		{"guid with uppercase letters", hostkey{"0123456789ABCDEF0123456789abcdef", "1.2.3.4"}, false},
+		{"neither peer nor fallback", hostkey{"roundrobin", "45.63.1.1"}, false},
+	}
+	for _, test := range tests {
+		err := validateHostKey(test.key)
+		if test.valid {
+			assert.NoError(t, err, test.desc)
+		} else {
+			assert.Error(t, err, test.desc)
+		}
+	}
+}
+
+func TestValidateHostKeyLength(t *testing.T) {
+	origDomain, origLength := *cfldomain, *maxRecordNameLength
+	defer func() {
+		*cfldomain, *maxRecordNameLength = origDomain, origLength
+	}()
+
+	// A domain that leaves 253 - 190 - 1 = 62 characters for the host name
+	longDomain := strings.Repeat("d", 60) + "." + strings.Repeat("d", 60) + "." + strings.Repeat("d", 60) + ".example"
+	tests := []struct {
+		desc      string
+		name      string
+		domain    string
+		maxLength int
+		valid     bool
+	}{
+		{"63 character label", "fl-" + strings.Repeat("a", 60), "getiantem.org", maxFqdnLength, true},
+		{"64 character label", "fl-" + strings.Repeat("a", 61), "getiantem.org", maxFqdnLength, false},
+		{"253 character record name", "fl-" + strings.Repeat("a", 59), longDomain, maxFqdnLength, true},
+		{"254 character record name", "fl-" + strings.Repeat("a", 60), longDomain, maxFqdnLength, false},
+		{"record name at -max-record-name-length", "fl-us-001", "getiantem.org", 23, true},
+		{"record name beyond -max-record-name-length", "fl-us-0001", "getiantem.org", 23, false},
+	}
+	for _, test := range tests {
+		*cfldomain, *maxRecordNameLength = test.domain, test.maxLength
+		err := validateHostKey(hostkey{test.name, "45.63.1.1"})
+		if test.valid {
+			assert.NoError(t, err, test.desc)
+		} else {
+			assert.Error(t, err, test.desc)
+		}
+	}
+}
+
+func TestRegisterRejectsOverlyLongHostName(t *testing.T) {
+	req := newRegisterRequest("fl-"+strings.Repeat("a", maxLabelLength), "45.63.6.2", "443")
+	rec := httptest.NewRecorder()
+	pool := NewHostPool()
+	webFor(pool).register(rec, req)
+	assert.Equal(t, 400, rec.Code, "Registration with overly long name should be rejected")
+	assert.Contains(t, rec.Body.String(), "at most 63", "Response should say why")
+	assert.Nil(t, pool.Get("45.63.6.2"), "Host shouldn't have been created")
+}
+
+func TestRegisterRejectsInvalidHostName(t *testing.T) {
+	req := newRegisterRequest("fl-US-invalid", "45.63.6.1", "443")
+	rec := httptest.NewRecorder()
+	pool := NewHostPool()
+	webFor(pool).register(rec, req)
+	assert.Equal(t, 400, rec.Code, "Registration with invalid name should be rejected")
+	assert.Nil(t, pool.Get("45.63.6.1"), "Host shouldn't have been created")
+}
+
+func TestHostKeyString(t *testing.T) {
+	assert.Equal(t, "fl-us-001@45.63.1.1", hostkey{"fl-us-001", "45.63.1.1"}.String())
+}
+
+func TestHostString(t *testing.T) {
+	h := mustNewHost("fl-us-001", "45.63.1.1", "443")
+	assert.Equal(t, "fl-us-001@45.63.1.1:443", h.String())
+	assert.Equal(t, "fl-us-002@45.63.1.2", mustNewHost("fl-us-002", "45.63.1.2", "").String(), "Host without port should be shown without one")
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sync"
+
+	"github.com/getlantern/cloudflare"
+)
+
+var (
+	maxHosts = flag.Int("max-hosts", 10000, "Maximum number of hosts to check, registrations of new hosts beyond that are rejected, defaults to 10000")
+
+	errHostLimitReached = fmt.Errorf("Reached the limit on the number of hosts")
+)
+
+// HostPool holds the hosts we're checking, keyed by ip.
+type HostPool struct {
+	hosts map[string]*host
+	mutex sync.Mutex
+
+	// limitRejections counts the hosts rejected because of -max-hosts
+	limitRejections int
+}
+
+// NewHostPool creates an empty HostPool. Call Load to fill it with the hosts we
+// already have records for.
+func NewHostPool() *HostPool {
+	return &HostPool{hosts: make(map[string]*host)}
+}
+
+// Get returns the host with the given ip, or nil if there isn't one.
+func (p *HostPool) Get(ip string) *host {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.hosts[ip]
+}
+
+// GetOrCreate returns the host with the given ip, resetting it to the given
+// name and the options it registered with. If there isn't one yet, it creates
+// one with opts' port and starts checking it. Looking up and creating the host happen under the same
+// lock, so concurrent calls for the same ip all get the same host and only
+// one run loop is started. New hosts are rejected with errHostLimitReached
+// once there are -max-hosts of them, and start out with the record that
+// CloudFlare already has for them, if any.
+func (p *HostPool) GetOrCreate(name string, ip string, opts RegisterOpts) (*host, error) {
+	if err := validateHostKey(hostkey{name, ip}); err != nil {
+		return nil, err
+	}
+	p.mutex.Lock()
+	isNew := p.hosts[ip] == nil
+	if isNew && len(p.hosts) >= *maxHosts {
+		// Reject before looking up the record, so that a registration flood
+		// beyond the limit doesn't use up our CloudFlare API budget
+		err := p.rejectLocked(name, ip)
+		p.mutex.Unlock()
+		return nil, err
+	}
+	p.mutex.Unlock()
+
+	var existing *cloudflare.Record
+	if isNew {
+		// Not under the lock, so that other registrations don't wait for
+		// CloudFlare
+		existing = existingRecord(name, ip)
+		if opts.Metadata == nil {
+			opts.Metadata = loadMetadata(name, ip)
+		}
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	h := p.hosts[ip]
+	if h == nil {
+		// Other registrations may have reached the limit in the meantime
+		if len(p.hosts) >= *maxHosts {
+			return nil, p.rejectLocked(name, ip)
+		}
+		// Temporarily disable CloudFront/DNSimple.
+		//h, err := newHost(name, ip, port, existing, nil)
+		h, err := newHost(name, ip, opts.Port, existing)
+		if err != nil {
+			return nil, err
+		}
+		// Before the run loop starts, so that its first check already
+		// includes the obfs4 server
+		h.setOpts(opts)
+		p.hosts[ip] = h
+		go h.run()
+		return h, nil
+	}
+	h.setOpts(opts)
+	h.reset(name)
+	return h, nil
+}
+
+// rejectLocked counts and logs that the new host with the given name and ip
+// is rejected because of -max-hosts, returning errHostLimitReached.
+func (p *HostPool) rejectLocked(name string, ip string) error {
+	hostLimitReached.Add(1)
+	p.limitRejections++
+	// Don't flood the log during a registration flood
+	if p.limitRejections%100 == 1 {
+		log.Errorf("WARNING: Rejecting %v, already checking %d hosts (%d rejected so far)", hostkey{name, ip}, len(p.hosts), p.limitRejections)
+	}
+	return errHostLimitReached
+}
+
+// existingRecord looks up the A record that CloudFlare already has for name
+// and ip, e.g. for a peer registering again after we restarted, so that the
+// new host doesn't try to create it again. It returns nil if there's none or
+// the lookup fails, in which case the host creates its record as usual.
+func existingRecord(name string, ip string) *cloudflare.Record {
+	recs, err := cflutil.ListRecordsByName(name)
+	if err != nil {
+		log.Debugf("Unable to look up existing records for %v: %v", hostkey{name, ip}, err)
+		return nil
+	}
+	var found *cloudflare.Record
+	for i, r := range recs {
+		if r.Type != "A" {
+			continue
+		}
+		if r.Value == ip {
+			found = &recs[i]
+		} else {
+			log.Debugf("%v also has a record for %v", name, r.Value)
+		}
+	}
+	return found
+}
+
+// Add adds h to the pool and starts checking it, unless the pool already has
+// a host with its ip.
+func (p *HostPool) Add(h *host) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.hosts[h.ip] != nil {
+		return fmt.Errorf("Already have a host with ip %v", h.ip)
+	}
+	p.hosts[h.ip] = h
+	go h.run()
+	return nil
+}
+
+// Remove removes the host with the given ip from the pool and returns it, or
+// nil if there wasn't one. The host itself keeps running.
+func (p *HostPool) Remove(ip string) *host {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	h := p.hosts[ip]
+	delete(p.hosts, ip)
+	return h
+}
+
+// Snapshot returns a snapshot of the current state of all hosts.
+func (p *HostPool) Snapshot() []hostInfo {
+	hs := p.all()
+	infos := make([]hostInfo, 0, len(hs))
+	for _, h := range hs {
+		infos = append(infos, h.getInfo())
+	}
+	return infos
+}
+
+// Len returns the number of hosts in the pool.
+func (p *HostPool) Len() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return len(p.hosts)
+}
+
+// all returns all hosts in the pool.
+func (p *HostPool) all() []*host {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	hs := make([]*host, 0, len(p.hosts))
+	for _, h := range p.hosts {
+		hs = append(hs, h)
+	}
+	return hs
+}
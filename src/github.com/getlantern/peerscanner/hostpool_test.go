@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestHostPool(t *testing.T) {
+	a := onlineHost("fl-us-a", "45.63.9.1", "443", true)
+	b := onlineHost("fl-us-b", "45.63.9.2", "443", false)
+	pool := newTestPool(a, b)
+	assert.Equal(t, 2, pool.Len())
+	assert.Equal(t, a, pool.Get("45.63.9.1"))
+	assert.Nil(t, pool.Get("45.63.9.3"), "Unknown ip shouldn't be found")
+	assert.Len(t, pool.Snapshot(), 2)
+
+	assert.Equal(t, b, pool.Remove("45.63.9.2"))
+	assert.Nil(t, pool.Remove("45.63.9.2"), "Removing twice should find nothing")
+	assert.Equal(t, 1, pool.Len())
+	assert.Nil(t, pool.Get("45.63.9.2"), "Removed host shouldn't be found")
+
+	_, err := pool.GetOrCreate("fl-US-invalid", "45.63.9.4", RegisterOpts{Port: "443", Weight: defaultWeight})
+	assert.Error(t, err, "Invalid name should be rejected")
+	assert.Equal(t, 1, pool.Len(), "Invalid host shouldn't have been added")
+}
+
+func TestGetOrCreateHostConcurrent(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	origDialer := defaultDialer
+	defer func() { defaultDialer = origDialer }()
+	defaultDialer = &mockDialer{err: fmt.Errorf("not dialing in tests")}
+
+	pool := NewHostPool()
+	const callers = 50
+	hosts := make([]*host, callers)
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			h, err := pool.GetOrCreate("fl-us-race", "45.63.9.5", RegisterOpts{Port: "443", Weight: defaultWeight})
+			if assert.NoError(t, err) {
+				hosts[i] = h
+			}
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	assert.Equal(t, 1, pool.Len(), "Only one host should have been created")
+	for i, h := range hosts {
+		assert.True(t, h == hosts[0], "Caller %d got a different host", i)
+	}
+	// Stop checking the host
+	hosts[0].unregister()
+}
+
+func TestMaxHosts(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	origDialer, origMax := defaultDialer, *maxHosts
+	defer func() { defaultDialer, *maxHosts = origDialer, origMax }()
+	defaultDialer = &mockDialer{err: fmt.Errorf("not dialing in tests")}
+	*maxHosts = 3
+
+	pool := NewHostPool()
+	for i := 1; i <= *maxHosts; i++ {
+		h, err := pool.GetOrCreate(fmt.Sprintf("fl-us-max%d", i), fmt.Sprintf("45.63.11.%d", i), RegisterOpts{Port: "443", Weight: defaultWeight})
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer h.unregister()
+	}
+	_, err := pool.GetOrCreate("fl-us-max1", "45.63.11.1", RegisterOpts{Port: "443", Weight: defaultWeight})
+	assert.NoError(t, err, "Existing hosts should still be able to re-register")
+
+	before := hostLimitReached.Value()
+	requests := len(m.V4Requests())
+	rec := httptest.NewRecorder()
+	webFor(pool).register(rec, newRegisterRequest("fl-us-max4", "45.63.11.4", "443"))
+	assert.Equal(t, 503, rec.Code, "Host beyond -max-hosts should be rejected")
+	var body map[string]string
+	if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body)) {
+		assert.Equal(t, "host_limit_reached", body["error"])
+	}
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, before+1, hostLimitReached.Value())
+	assert.Equal(t, *maxHosts, pool.Len())
+	assert.Len(t, m.V4Requests(), requests, "Host beyond -max-hosts shouldn't have been looked up in CloudFlare")
+}
+
+func TestExistingRecord(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	name := "fl-us-existing"
+	rec := m.AddRecord("A", name, "45.63.12.1")
+	m.AddRecord("A", name, "45.63.12.2")
+
+	if found := existingRecord(name, "45.63.12.1"); assert.NotNil(t, found, "Existing record should have been found") {
+		assert.Equal(t, rec.Id, found.Id, "Record of the host's own ip should have been picked")
+	}
+	assert.Nil(t, existingRecord(name, "45.63.12.3"), "Records of other ips shouldn't count")
+	assert.Nil(t, existingRecord("fl-us-new", "45.63.12.3"), "Host without records shouldn't have one")
+	for _, r := range m.V4Requests() {
+		assert.Equal(t, "GET", r.Method, "Looking up records shouldn't change them")
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"sync"
+)
+
+var (
+	// cflGroupMembers tracks which ips are currently registered in each
+	// Cloudflare rotation, keyed by the rotation's subdomain.
+	cflGroupMembers      = make(map[GroupName]*HostSet)
+	cflGroupMembersMutex sync.Mutex
+)
+
+// HostSet is a set of host ips that is safe for concurrent use.
+type HostSet struct {
+	sync.RWMutex
+	m map[string]struct{}
+}
+
+func NewHostSet() *HostSet {
+	return &HostSet{m: make(map[string]struct{})}
+}
+
+// Add adds the given ip to the set.
+func (s *HostSet) Add(ip string) {
+	s.Lock()
+	s.m[ip] = struct{}{}
+	s.Unlock()
+}
+
+// Remove removes the given ip from the set, if present.
+func (s *HostSet) Remove(ip string) {
+	s.Lock()
+	delete(s.m, ip)
+	s.Unlock()
+}
+
+// Contains indicates whether or not the given ip is in the set.
+func (s *HostSet) Contains(ip string) bool {
+	s.RLock()
+	_, found := s.m[ip]
+	s.RUnlock()
+	return found
+}
+
+// Len returns the number of ips in the set.
+func (s *HostSet) Len() int {
+	s.RLock()
+	defer s.RUnlock()
+	return len(s.m)
+}
+
+// membersOf returns the HostSet of ips registered in the Cloudflare rotation
+// with the given subdomain, creating it if necessary.
+func membersOf(subdomain GroupName) *HostSet {
+	cflGroupMembersMutex.Lock()
+	defer cflGroupMembersMutex.Unlock()
+	s := cflGroupMembers[subdomain]
+	if s == nil {
+		s = NewHostSet()
+		cflGroupMembers[subdomain] = s
+	}
+	return s
+}
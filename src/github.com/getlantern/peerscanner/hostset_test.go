@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestHostSet(t *testing.T) {
+	s := NewHostSet()
+	assert.False(t, s.Contains("1.1.1.1"), "Empty set shouldn't contain anything")
+
+	s.Add("1.1.1.1")
+	s.Add("1.1.1.1")
+	s.Add("2.2.2.2")
+	assert.True(t, s.Contains("1.1.1.1"), "Set should contain added ip")
+	assert.Equal(t, 2, s.Len(), "Adding the same ip twice should only count once")
+
+	s.Remove("1.1.1.1")
+	s.Remove("3.3.3.3")
+	assert.False(t, s.Contains("1.1.1.1"), "Set shouldn't contain removed ip")
+	assert.Equal(t, 1, s.Len(), "Removing should shrink the set")
+}
+
+// TestHostSetConcurrent is mostly useful when run with -race
+func TestHostSetConcurrent(t *testing.T) {
+	s := NewHostSet()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				ip := fmt.Sprintf("10.0.%d.%d", i, j)
+				s.Add(ip)
+				s.Contains(ip)
+				s.Len()
+				if j%2 == 0 {
+					s.Remove(ip)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, 500, s.Len(), "Half of the added ips should remain")
+}
+
+func BenchmarkHostSetContains10(b *testing.B)    { benchmarkHostSetContains(b, 10) }
+func BenchmarkHostSetContains100(b *testing.B)   { benchmarkHostSetContains(b, 100) }
+func BenchmarkHostSetContains1000(b *testing.B)  { benchmarkHostSetContains(b, 1000) }
+func BenchmarkHostSetContains10000(b *testing.B) { benchmarkHostSetContains(b, 10000) }
+
+func benchmarkHostSetContains(b *testing.B, size int) {
+	s := NewHostSet()
+	for i := 0; i < size; i++ {
+		s.Add(fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Contains("10.0.0.1")
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/getlantern/peerscanner/watchdog"
+)
+
+var (
+	// hostWatchdog restarts the run loops of hosts that haven't gone around
+	// their loop in twice their check interval, or that panicked
+	hostWatchdog = watchdog.New(testPeriod)
+)
+
+// startHostWatchdog starts hostWatchdog and keeps an eye on it, starting it
+// again if it stops sweeping.
+func startHostWatchdog() {
+	hostWatchdog.Start()
+	go func() {
+		ticker := time.NewTicker(2 * testPeriod)
+		defer ticker.Stop()
+		for range ticker.C {
+			if !hostWatchdog.Healthy() {
+				log.Errorf("WARNING: Host watchdog hasn't swept since %v, starting it again", hostWatchdog.LastSweep().Format(time.RFC3339))
+				hostWatchdog.Start()
+			}
+		}
+	}()
+}
+
+// watchRun registers h's run loop with hostWatchdog the first time it's
+// called and returns the generation of the run loop that's starting.
+func (h *host) watchRun() int {
+	if h.runTicker == nil {
+		h.runTicker = hostWatchdog.Register(fmt.Sprintf("Run loop of %v", h), func() {
+			go h.run()
+		})
+	}
+	return h.runTicker.Generation()
+}
+
+// recoverRun keeps a panic in h's run loop from taking down the whole
+// peerscanner. The loop exits and hostWatchdog restarts it on its next sweep.
+func (h *host) recoverRun() {
+	if r := recover(); r != nil {
+		log.Errorf("Run loop of %v panicked: %v\n%s", h, r, debug.Stack())
+		h.runTicker.Expire()
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/getlantern/peerscanner/watchdog"
+	"github.com/getlantern/testify/assert"
+)
+
+func TestRunLoopRestartedAfterPanic(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	name, ip := "fl-us-watchdog", "45.63.20.1"
+	f := newFakeFallback(name)
+	defer f.close()
+	h, _ := newTestHost(name, ip, f)
+
+	// A nil group makes the first check panic, the restart fixes it so that
+	// the restarted loop can carry on
+	broken := GroupName("broken")
+	h.cflGroups[broken] = nil
+	w := watchdog.New(10 * time.Millisecond)
+	w.Start()
+	h.runTicker = w.Register("test loop", func() {
+		delete(h.cflGroups, broken)
+		go h.run()
+	})
+	go h.run()
+
+	assert.True(t, waitUntil(func() bool { return h.getInfo().online }), "Restarted run loop should have brought the host online")
+	assert.Equal(t, 1, h.runTicker.Generation(), "Run loop should have been restarted once")
+
+	// Pause the host so that it stops using the mock CloudFlare
+	h.unregister()
+	waitUntil(func() bool { return h.getInfo().state == StatePaused })
+}
@@ -0,0 +1,122 @@
+//go:build integration
+// +build integration
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getlantern/testify/assert"
+)
+
+// TestPeerLifecycle runs a fallback through registration, going offline and
+// recovering, against an in-process peerscanner web server and a mock
+// CloudFlare. Run it with go test -tags integration.
+func TestPeerLifecycle(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	m := newMockCfl()
+	defer m.close()
+	pool := NewHostPool()
+	defer withDrainTime(0)()
+	origPeriod, origDialer := testPeriod, defaultDialer
+	defer func() {
+		testPeriod, defaultDialer = origPeriod, origDialer
+	}()
+	testPeriod = 50 * time.Millisecond
+
+	name, ip := "fl-us-lifecycle", "45.63.10.1"
+	fallback := newFakeFallback(name)
+	d := &mockDialer{addr: fallback.addr()}
+	defaultDialer = d
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/register", webFor(pool).register)
+	mux.HandleFunc("/v1/peers", webFor(pool).listPeers)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// Register
+	form := url.Values{"name": {name}, "port": {"80"}}
+	req, _ := http.NewRequest("POST", server.URL+"/register", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Peerscanner-Forwarded-For", ip)
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if !assert.NoError(t, err, "Unable to register") {
+		return
+	}
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode, "Registration should succeed")
+	h := pool.Get(ip)
+	if !assert.NotNil(t, h, "Host should have been created") {
+		return
+	}
+	defer func() {
+		// Pause the host so that it stops using the mock CloudFlare
+		h.unregister()
+		waitFor(ctx, t, "host to pause", func() bool { return h.getInfo().state == StatePaused })
+	}()
+
+	waitFor(ctx, t, "host to appear in CloudFlare", func() bool {
+		return len(m.FindRecords(name, ip)) == 1 && len(m.FindRecords(string(RoundRobin), ip)) == 1
+	})
+	assert.True(t, listsFallback(ctx, t, server.URL, name), "Online host should be listed in /v1/peers")
+
+	// Go offline
+	fallback.close()
+	waitFor(ctx, t, "host to leave round robin", func() bool {
+		return len(m.FindRecords(string(RoundRobin), ip)) == 0
+	})
+	assert.Equal(t, StateOffline, h.getInfo().state)
+	assert.Len(t, m.FindRecords(name, ip), 1, "Offline host should keep its own record")
+	assert.False(t, listsFallback(ctx, t, server.URL, name), "Offline host shouldn't be listed in /v1/peers")
+
+	// Recover
+	fallback = newFakeFallback(name)
+	defer fallback.close()
+	d.setAddr(fallback.addr())
+	waitFor(ctx, t, "host to rejoin round robin", func() bool {
+		return len(m.FindRecords(string(RoundRobin), ip)) == 1 && len(m.FindRecords(string(Fallbacks), ip)) == 1
+	})
+	// The host publishes its state once it's done registering
+	waitFor(ctx, t, "host to come back online", func() bool { return h.getInfo().state == StateOnline })
+	assert.True(t, listsFallback(ctx, t, server.URL, name), "Recovered host should be listed in /v1/peers")
+}
+
+// waitFor polls cond until it's true, failing the test if ctx is done first.
+func waitFor(ctx context.Context, t *testing.T, what string, cond func() bool) {
+	for !cond() {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("Timed out waiting for %v", what)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
+
+func listsFallback(ctx context.Context, t *testing.T, serverURL string, name string) bool {
+	req, _ := http.NewRequest("GET", serverURL+"/v1/peers", nil)
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if !assert.NoError(t, err, "Unable to list peers") {
+		return false
+	}
+	defer resp.Body.Close()
+	var result peersResponse
+	if !assert.NoError(t, json.NewDecoder(resp.Body).Decode(&result)) {
+		return false
+	}
+	for _, f := range result.Fallbacks {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getlantern/peerscanner/cfl"
+)
+
+const (
+	reconcileLockKey = "peerscanner:reconcile"
+	reconcileLockTtl = 30 * time.Second
+
+	// releaseScript deletes the lock only if we still hold it
+	releaseScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`
+	// renewScript extends the lock only if we still hold it
+	renewScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) else return 0 end`
+)
+
+var (
+	redisAddr     = flag.String("redis-addr", "", "(optional) Redis server (host:port) used to coordinate replicas, so that only one of them reconciles records at a time, and to keep hosts' metadata across restarts")
+	kvNamespaceId = flag.String("kv-namespace-id", "", "(optional) id of a CloudFlare Workers KV namespace used to coordinate replicas instead of Redis, also locking the zone while syncing groups")
+
+	// reconcileLock, if set, makes sure that only one replica reconciles
+	// CloudFlare records at a time.
+	reconcileLock DistributedLock
+)
+
+// DistributedLock is a lock shared by multiple peerscanner replicas.
+type DistributedLock interface {
+	// TryAcquire tries to acquire the lock for key, which expires after ttl
+	// unless renewed. It returns false if someone else holds the lock.
+	TryAcquire(ctx context.Context, key string, ttl time.Duration) (bool, error)
+
+	// Release releases the lock for key if we hold it.
+	Release(key string) error
+}
+
+// renewableLock is a DistributedLock whose locks can be extended while held.
+type renewableLock interface {
+	Renew(key string, ttl time.Duration) (bool, error)
+}
+
+// withLock runs fn while holding lock for key, renewing the lock until fn
+// returns. It returns false without running fn if someone else holds the
+// lock. If lock is nil, fn is simply run.
+func withLock(lock DistributedLock, key string, ttl time.Duration, fn func()) (bool, error) {
+	if lock == nil {
+		fn()
+		return true, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ttl)
+	acquired, err := lock.TryAcquire(ctx, key, ttl)
+	cancel()
+	if err != nil || !acquired {
+		return false, err
+	}
+	defer func() {
+		if err := lock.Release(key); err != nil {
+			log.Errorf("Unable to release lock %v: %v", key, err)
+		}
+	}()
+
+	done := make(chan interface{})
+	defer close(done)
+	if r, ok := lock.(renewableLock); ok {
+		go func() {
+			ticker := time.NewTicker(ttl / 3)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					held, err := r.Renew(key, ttl)
+					if err != nil {
+						log.Errorf("Unable to renew lock %v: %v", key, err)
+					} else if !held {
+						log.Errorf("Lost lock %v", key)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	fn()
+	return true, nil
+}
+
+// RedisDistributedLock is a DistributedLock backed by Redis, using SET NX PX.
+// Each instance has a random token identifying it as the lock's holder.
+type RedisDistributedLock struct {
+	*redisClient
+	token string
+}
+
+func NewRedisDistributedLock(addr string) *RedisDistributedLock {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("Unable to generate lock token: %v", err))
+	}
+	return &RedisDistributedLock{redisClient: newRedisClient(addr), token: hex.EncodeToString(b)}
+}
+
+func (l *RedisDistributedLock) TryAcquire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	reply, err := l.do(ctx, "SET", key, l.token, "NX", "PX", strconv.FormatInt(int64(ttl/time.Millisecond), 10))
+	if err != nil {
+		return false, err
+	}
+	// Redis replies OK if it set the key and nil if it already existed
+	return reply == "OK", nil
+}
+
+func (l *RedisDistributedLock) Release(key string) error {
+	_, err := l.do(context.Background(), "EVAL", releaseScript, "1", key, l.token)
+	return err
+}
+
+func (l *RedisDistributedLock) Renew(key string, ttl time.Duration) (bool, error) {
+	reply, err := l.do(context.Background(), "EVAL", renewScript, "1", key, l.token, strconv.FormatInt(int64(ttl/time.Millisecond), 10))
+	if err != nil {
+		return false, err
+	}
+	return reply == "1", nil
+}
+
+// redisClient talks to the Redis server at addr.
+type redisClient struct {
+	addr        string
+	dialTimeout time.Duration
+}
+
+func newRedisClient(addr string) *redisClient {
+	return &redisClient{addr: addr, dialTimeout: 5 * time.Second}
+}
+
+// do runs a single Redis command on a new connection, returning the reply as
+// a string ("" for nil replies).
+func (c *redisClient) do(ctx context.Context, args ...string) (string, error) {
+	dialer := &net.Dialer{Timeout: c.dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return "", fmt.Errorf("Unable to connect to Redis at %v: %v", c.addr, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(c.dialTimeout))
+	}
+
+	cmd := fmt.Sprintf("*%d\r\n", len(args))
+	for _, arg := range args {
+		cmd += fmt.Sprintf("$%d\r\n%v\r\n", len(arg), arg)
+	}
+	if _, err := conn.Write([]byte(cmd)); err != nil {
+		return "", fmt.Errorf("Unable to send %v to Redis: %v", args[0], err)
+	}
+	return readRedisReply(bufio.NewReader(conn))
+}
+
+// readRedisReply reads a simple string, error, integer or bulk string reply
+func readRedisReply(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("Unable to read Redis reply: %v", err)
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return "", fmt.Errorf("Empty Redis reply")
+	}
+	switch line[0] {
+	case '+', ':':
+		return line[1:], nil
+	case '-':
+		return "", fmt.Errorf("Redis error: %v", line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", fmt.Errorf("Invalid Redis bulk reply: %v", line)
+		}
+		if n < 0 {
+			return "", nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", fmt.Errorf("Unable to read Redis bulk reply: %v", err)
+		}
+		return string(buf[:n]), nil
+	default:
+		return "", fmt.Errorf("Unsupported Redis reply: %v", line)
+	}
+}
+
+// zoneLock adapts a cfl.ZoneLock, which always locks our whole zone whatever
+// the key, to DistributedLock. The ZoneLock renews itself while held.
+type zoneLock struct {
+	lock *cfl.ZoneLock
+}
+
+func (l zoneLock) TryAcquire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	err := l.lock.TryLock(ctx, ttl)
+	if err == cfl.ErrLockHeld {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (l zoneLock) Release(key string) error {
+	return l.lock.Unlock()
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/getlantern/testify/assert"
+)
+
+// mockRedis is a tiny Redis server that understands just enough (GET, SET,
+// SET NX PX and our release and renew scripts) to back RedisDistributedLock
+// and the metadataStore.
+type mockRedis struct {
+	sync.Mutex
+	l       net.Listener
+	values  map[string]string
+	expires map[string]time.Time
+}
+
+func newMockRedis(t *testing.T) *mockRedis {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	r := &mockRedis{l: l, values: make(map[string]string), expires: make(map[string]time.Time)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+	return r
+}
+
+func (r *mockRedis) addr() string {
+	return r.l.Addr().String()
+}
+
+func (r *mockRedis) close() {
+	r.l.Close()
+}
+
+func (r *mockRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	args, err := readRedisCommand(br)
+	if err != nil {
+		return
+	}
+	io.WriteString(conn, r.handle(args))
+}
+
+func (r *mockRedis) handle(args []string) string {
+	r.Lock()
+	defer r.Unlock()
+	switch strings.ToUpper(args[0]) {
+	case "GET":
+		// GET key
+		key := args[1]
+		r.expire(key)
+		value, found := r.values[key]
+		if !found {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%v\r\n", len(value), value)
+	case "SET":
+		// SET key value [NX PX ms]
+		key := args[1]
+		r.expire(key)
+		if len(args) == 3 {
+			r.values[key] = args[2]
+			delete(r.expires, key)
+			return "+OK\r\n"
+		}
+		if _, found := r.values[key]; found {
+			return "$-1\r\n"
+		}
+		ms, _ := strconv.Atoi(args[5])
+		r.values[key] = args[2]
+		r.expires[key] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		return "+OK\r\n"
+	case "EVAL":
+		// EVAL script 1 key token [ms]
+		script, key, token := args[1], args[3], args[4]
+		r.expire(key)
+		if r.values[key] != token {
+			return ":0\r\n"
+		}
+		switch script {
+		case releaseScript:
+			delete(r.values, key)
+			delete(r.expires, key)
+		case renewScript:
+			ms, _ := strconv.Atoi(args[5])
+			r.expires[key] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		default:
+			return "-ERR unknown script\r\n"
+		}
+		return ":1\r\n"
+	default:
+		return fmt.Sprintf("-ERR unknown command %v\r\n", args[0])
+	}
+}
+
+// expire drops key if its ttl has passed
+func (r *mockRedis) expire(key string) {
+	if exp, found := r.expires[key]; found && time.Now().After(exp) {
+		delete(r.values, key)
+		delete(r.expires, key)
+	}
+}
+
+func (r *mockRedis) holder(key string) string {
+	r.Lock()
+	defer r.Unlock()
+	return r.values[key]
+}
+
+func readRedisCommand(br *bufio.Reader) ([]string, error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line)[1:])
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		arg, err := readRedisReply(br)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+func TestOnlyOneInstanceReconciles(t *testing.T) {
+	r := newMockRedis(t)
+	defer r.close()
+
+	var instances []DistributedLock
+	for i := 0; i < 2; i++ {
+		instances = append(instances, NewRedisDistributedLock(r.addr()))
+	}
+
+	var executed int32
+	var wg sync.WaitGroup
+	acquired := make([]bool, len(instances))
+	for i, lock := range instances {
+		wg.Add(1)
+		go func(i int, lock DistributedLock) {
+			defer wg.Done()
+			var err error
+			acquired[i], err = withLock(lock, reconcileLockKey, time.Second, func() {
+				atomic.AddInt32(&executed, 1)
+				time.Sleep(100 * time.Millisecond)
+			})
+			assert.NoError(t, err)
+		}(i, lock)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&executed), "Only one instance should have reconciled")
+	assert.NotEqual(t, acquired[0], acquired[1], "Exactly one instance should have acquired the lock")
+	assert.Equal(t, "", r.holder(reconcileLockKey), "Lock should have been released")
+
+	// Once released, the other instance gets its turn
+	ok, err := withLock(instances[1], reconcileLockKey, time.Second, func() {})
+	assert.NoError(t, err)
+	assert.True(t, ok, "Lock should be available again after release")
+}
+
+func TestLockIsRenewedWhileHeld(t *testing.T) {
+	r := newMockRedis(t)
+	defer r.close()
+
+	a := NewRedisDistributedLock(r.addr())
+	b := NewRedisDistributedLock(r.addr())
+	ttl := 150 * time.Millisecond
+	ok, err := withLock(a, reconcileLockKey, ttl, func() {
+		// Outlast the ttl, relying on renewal to keep others out
+		time.Sleep(3 * ttl)
+		acquired, err := b.TryAcquire(context.Background(), reconcileLockKey, ttl)
+		assert.NoError(t, err)
+		assert.False(t, acquired, "Renewed lock shouldn't be acquirable by others")
+	})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestReleaseOnlyReleasesOwnLock(t *testing.T) {
+	r := newMockRedis(t)
+	defer r.close()
+
+	a := NewRedisDistributedLock(r.addr())
+	b := NewRedisDistributedLock(r.addr())
+	acquired, err := a.TryAcquire(context.Background(), reconcileLockKey, time.Second)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+
+	assert.NoError(t, b.Release(reconcileLockKey))
+	assert.Equal(t, a.token, r.holder(reconcileLockKey), "Other instance shouldn't be able to release our lock")
+}
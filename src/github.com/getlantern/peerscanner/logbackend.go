@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/getlantern/golog"
+)
+
+const (
+	// slogLevelTrace and slogLevelFatal are the slog levels of golog's trace
+	// and fatal messages, which slog has no levels of its own for. Debug and
+	// error messages get slog.LevelDebug and slog.LevelError, errors that
+	// start with "WARNING: " get slog.LevelWarn.
+	slogLevelTrace = slog.LevelDebug - 4
+	slogLevelFatal = slog.LevelError + 4
+)
+
+var (
+	slogHandler = flag.String("slog-handler", "text", "How to format log records with LOG_BACKEND=slog, text or json, defaults to text")
+
+	// logHandler holds the logHandlerHolder of the slog.Handler that
+	// logOutputs emit records to, if LOG_BACKEND=slog
+	logHandler atomic.Value
+)
+
+// logHandlerHolder lets logHandler hold a nil slog.Handler
+type logHandlerHolder struct {
+	h slog.Handler
+}
+
+// logOutput is what golog writes all loggers' lines to (see
+// setLogOutputs). Lines that the Level excludes are dropped, the rest get
+// written to out as they are, unless there's a slog.Handler from
+// setLogBackend, which gets them as records instead.
+type logOutput struct {
+	out io.Writer
+}
+
+// setLogOutputs makes golog write errors to errorOut and debug and trace
+// messages to debugOut through logOutputs.
+func setLogOutputs(errorOut io.Writer, debugOut io.Writer) {
+	golog.SetOutputs(&logOutput{errorOut}, &logOutput{debugOut})
+}
+
+// Write handles a single line from golog. golog writes each message with a
+// single call, so a message spanning several lines still makes one record.
+func (o *logOutput) Write(p []byte) (int, error) {
+	if severity, _, _ := strings.Cut(string(p), " "); !logsAt(severity) {
+		return len(p), nil
+	}
+	hh, _ := logHandler.Load().(logHandlerHolder)
+	if hh.h == nil {
+		return o.out.Write(p)
+	}
+	return len(p), emitLogLine(hh.h, string(p))
+}
+
+// emitLogLine emits a line the way golog writes it, e.g. "DEBUG peerscanner:
+// host.go:123 Checking fl-us-1\n", to h as a record with the logger's name in
+// a "logger" attribute and the file and line in a "source" one. Lines that
+// aren't like that make error records as they are.
+func emitLogLine(h slog.Handler, line string) error {
+	line = strings.TrimSuffix(line, "\n")
+	var attrs []slog.Attr
+	level, msg := slog.LevelError, line
+	if severity, rest, ok := strings.Cut(line, " "); ok {
+		if logger, rest, ok := strings.Cut(rest, ": "); ok {
+			if source, rest, ok := strings.Cut(rest, " "); ok {
+				level, msg = slogLevelFor(severity, rest), rest
+				attrs = []slog.Attr{slog.String("logger", logger), slog.String(slog.SourceKey, source)}
+			}
+		}
+	}
+	ctx := context.Background()
+	if !h.Enabled(ctx, level) {
+		return nil
+	}
+	r := slog.NewRecord(time.Now(), level, msg, 0)
+	r.AddAttrs(attrs...)
+	return h.Handle(ctx, r)
+}
+
+func slogLevelFor(severity string, msg string) slog.Level {
+	switch severity {
+	case "TRACE":
+		return slogLevelTrace
+	case "DEBUG":
+		return slog.LevelDebug
+	case "FATAL":
+		return slogLevelFatal
+	}
+	if strings.HasPrefix(msg, "WARNING: ") {
+		return slog.LevelWarn
+	}
+	return slog.LevelError
+}
+
+// replaceLevel is a slog.HandlerOptions.ReplaceAttr that names the trace and
+// fatal levels TRACE and FATAL instead of DEBUG-4 and ERROR+4.
+func replaceLevel(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) > 0 || a.Key != slog.LevelKey {
+		return a
+	}
+	switch a.Value.Any() {
+	case slogLevelTrace:
+		a.Value = slog.StringValue("TRACE")
+	case slogLevelFatal:
+		a.Value = slog.StringValue("FATAL")
+	}
+	return a
+}
+
+// newSlogHandler creates the slog.Handler for -slog-handler that writes to
+// out. Records keep golog's trace and fatal levels.
+func newSlogHandler(format string, out io.Writer) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{
+		// logOutputs filter by -log-level already
+		Level:       slogLevelTrace,
+		ReplaceAttr: replaceLevel,
+	}
+	switch format {
+	case "text":
+		return slog.NewTextHandler(out, opts), nil
+	case "json":
+		return slog.NewJSONHandler(out, opts), nil
+	default:
+		return nil, fmt.Errorf("Unknown slog handler %q, must be text or json", format)
+	}
+}
+
+// setLogBackend makes all loggers, ours and our dependencies', write their
+// lines the golog way (LOG_BACKEND unset or golog) or emit records to handler
+// (LOG_BACKEND=slog), once setLogOutputs has been called.
+func setLogBackend(backend string, handler slog.Handler) error {
+	switch backend {
+	case "", "golog":
+		logHandler.Store(logHandlerHolder{})
+	case "slog":
+		logHandler.Store(logHandlerHolder{handler})
+	default:
+		return fmt.Errorf("Unknown log backend %q, must be golog or slog", backend)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/getlantern/golog"
+	"github.com/getlantern/testify/assert"
+)
+
+// recordingHandler is a slog.Handler that keeps the records it handles
+type recordingHandler struct {
+	records []slog.Record
+	mutex   sync.Mutex
+}
+
+func (h *recordingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return true
+}
+
+func (h *recordingHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.mutex.Lock()
+	h.records = append(h.records, r)
+	h.mutex.Unlock()
+	return nil
+}
+
+func (h *recordingHandler) WithAttrs(attrs []slog.Attr) slog.Handler { return h }
+func (h *recordingHandler) WithGroup(name string) slog.Handler       { return h }
+
+func TestSetLogBackend(t *testing.T) {
+	var out bytes.Buffer
+	setLogOutputs(&out, &out)
+	defer golog.ResetOutputs()
+	defer setLogBackend("", nil)
+
+	h := &recordingHandler{}
+	if !assert.NoError(t, setLogBackend("slog", h)) {
+		return
+	}
+	log.Debugf("Checking %v", "fl-us-slog")
+	log.Errorf("WARNING: %v is slow", "fl-us-slog")
+	assert.NotContains(t, out.String(), "fl-us-slog", "Nothing should have been written the golog way")
+	// Hosts of other tests may still be logging in the background
+	var records []slog.Record
+	h.mutex.Lock()
+	for _, r := range h.records {
+		if strings.Contains(r.Message, "fl-us-slog") {
+			records = append(records, r)
+		}
+	}
+	h.mutex.Unlock()
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, slog.LevelDebug, records[0].Level)
+		assert.Equal(t, "Checking fl-us-slog", records[0].Message)
+		assert.Equal(t, slog.LevelWarn, records[1].Level)
+		attrs := make(map[string]string)
+		records[1].Attrs(func(a slog.Attr) bool {
+			attrs[a.Key] = a.Value.String()
+			return true
+		})
+		assert.Equal(t, "peerscanner", attrs["logger"])
+		assert.Regexp(t, `^[a-z_]+\.go:[0-9]+$`, attrs["source"])
+	}
+
+	assert.NoError(t, setLogBackend("golog", h))
+	log.Debug("Back to golog")
+	assert.Contains(t, out.String(), "DEBUG peerscanner: ")
+	assert.Error(t, setLogBackend("logrus", h), "Unknown backend should be rejected")
+}
+
+func TestNewSlogHandler(t *testing.T) {
+	var out bytes.Buffer
+	h, err := newSlogHandler("json", &out)
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.NoError(t, emitLogLine(h, "DEBUG peerscanner: host.go:123 Hello 5\n")) {
+		return
+	}
+	var record map[string]interface{}
+	if assert.NoError(t, json.Unmarshal(out.Bytes(), &record), "Record should be valid JSON") {
+		assert.Equal(t, "DEBUG", record["level"])
+		assert.Equal(t, "Hello 5", record["msg"])
+		assert.Equal(t, "peerscanner", record["logger"])
+		assert.Equal(t, "host.go:123", record["source"])
+	}
+
+	out.Reset()
+	h, err = newSlogHandler("text", &out)
+	if assert.NoError(t, err) {
+		assert.NoError(t, emitLogLine(h, "TRACE peerscanner: host.go:123 Hello 5\n"))
+		assert.NoError(t, emitLogLine(h, "Not from golog\n"))
+		assert.Regexp(t, `level=TRACE msg="Hello 5" logger=peerscanner source=host.go:123\n.*level=ERROR msg="Not from golog"\n`, out.String())
+	}
+
+	_, err = newSlogHandler("xml", &out)
+	assert.Error(t, err, "Unknown handler should be rejected")
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	logLevel = flag.String("log-level", "debug", "Minimum level to log at, one of trace, debug, info, warn or error, can be changed at runtime via PUT /v1/admin/log-level, defaults to debug")
+
+	levelNames = []string{"trace", "debug", "info", "warn", "error"}
+
+	// currentLevel is the Level that logOutputs filter by
+	currentLevel atomic.Int32
+)
+
+// Level is the minimum severity that gets logged, by all loggers that write
+// through logOutputs. Debug and trace lines are filtered by it, while errors
+// always get logged. golog has no separate info or warn messages, so those
+// behave like error. golog only writes trace lines for loggers that TRACE
+// turns on when they're created, so trace lets through the same lines as
+// debug.
+type Level int32
+
+const (
+	LevelTrace Level = iota
+	LevelDebug
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func init() {
+	currentLevel.Store(int32(LevelDebug))
+}
+
+func (l Level) String() string {
+	if l < LevelTrace || l > LevelError {
+		return fmt.Sprintf("Level(%d)", int32(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses one of trace, debug, info, warn or error.
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown log level %q, must be one of %v", s, strings.Join(levelNames, ", "))
+}
+
+// SetLevel atomically sets the level to log at, returning the previous one.
+func SetLevel(l Level) Level {
+	return Level(currentLevel.Swap(int32(l)))
+}
+
+// GetLevel returns the current level to log at.
+func GetLevel() Level {
+	return Level(currentLevel.Load())
+}
+
+// logsAt indicates whether lines golog writes with the given severity get
+// logged at the current level.
+func logsAt(severity string) bool {
+	switch severity {
+	case "DEBUG", "TRACE":
+		return GetLevel() <= LevelDebug
+	}
+	return true
+}
+
+// logLevelRequest is the body of PUT /v1/admin/log-level
+type logLevelRequest struct {
+	Level string `json:"level"`
+}
+
+// logLevelResponse is what PUT /v1/admin/log-level responds with
+type logLevelResponse struct {
+	Level    string `json:"level"`
+	Previous string `json:"previous"`
+}
+
+// setLogLevel is the admin endpoint at PUT /v1/admin/log-level that changes
+// the level we log at, given e.g. {"level": "debug"}, without restarting. It
+// responds with the new level and the previous one, so that it can be set
+// back once done.
+func setLogLevel(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != "PUT" {
+		resp.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintln(resp, "Only PUT is supported")
+		return
+	}
+	var body logLevelRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		resp.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(resp, `Body must be like {"level": "debug"}`)
+		return
+	}
+	level, err := ParseLevel(body.Level)
+	if err != nil {
+		resp.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(resp, err.Error())
+		return
+	}
+	previous := SetLevel(level)
+	log.Errorf("WARNING: Log level changed from %v to %v", previous, level)
+	writeJSON(resp, &logLevelResponse{Level: level.String(), Previous: previous.String()})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/getlantern/golog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetLogLevel(t *testing.T) {
+	var out bytes.Buffer
+	setLogOutputs(&out, &out)
+	defer golog.ResetOutputs()
+	defer SetLevel(SetLevel(LevelError))
+
+	log.Debug("Quiet debug message")
+	assert.NotContains(t, out.String(), "Quiet debug message", "Debug messages shouldn't be logged at error")
+
+	rec := httptest.NewRecorder()
+	setLogLevel(rec, httptest.NewRequest("PUT", "/v1/admin/log-level", strings.NewReader(`{"level": "debug"}`)))
+	assert.Equal(t, 200, rec.Code)
+	var body logLevelResponse
+	if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body)) {
+		assert.Equal(t, logLevelResponse{Level: "debug", Previous: "error"}, body)
+	}
+	assert.Equal(t, LevelDebug, GetLevel())
+
+	log.Debug("Loud debug message")
+	assert.Contains(t, out.String(), "DEBUG peerscanner: ", "Debug messages should be logged at debug")
+	assert.Contains(t, out.String(), "Loud debug message", "Debug messages should be logged at debug")
+}
+
+func TestSetLogLevelBadRequest(t *testing.T) {
+	defer SetLevel(SetLevel(LevelDebug))
+
+	for _, body := range []string{"", "{}", `{"level": "verbose"}`, `{"level": 1}`} {
+		rec := httptest.NewRecorder()
+		setLogLevel(rec, httptest.NewRequest("PUT", "/v1/admin/log-level", strings.NewReader(body)))
+		assert.Equal(t, 400, rec.Code, "Body %q should be rejected", body)
+	}
+	rec := httptest.NewRecorder()
+	setLogLevel(rec, httptest.NewRequest("GET", "/v1/admin/log-level", nil))
+	assert.Equal(t, 405, rec.Code)
+	assert.Equal(t, LevelDebug, GetLevel(), "Bad requests shouldn't change the log level")
+}
+
+func TestParseLevel(t *testing.T) {
+	level, err := ParseLevel("WARN")
+	if assert.NoError(t, err) {
+		assert.Equal(t, LevelWarn, level)
+		assert.Equal(t, "warn", level.String())
+	}
+	_, err = ParseLevel("verbose")
+	assert.Error(t, err)
+}
@@ -36,10 +36,13 @@ package main
 import (
 	"flag"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"runtime"
 	"strings"
 	"sync"
+	"time"
 
 	// Temporarily disable CloudFront/DNSimple.
 	//"github.com/getlantern/aws-sdk-go/gen/cloudfront"
@@ -54,10 +57,18 @@ import (
 	"github.com/getlantern/profiling"
 )
 
-const (
-	RoundRobin = "roundrobin"
-	Peers      = "peers"
-	Fallbacks  = "fallbacks"
+// GroupName is the subdomain of a Cloudflare rotation (e.g. roundrobin)
+type GroupName string
+
+// The names of the rotations. -groups-config can change them and
+// PEERSCANNER_ENV can prefix them (see applyGroupConfigs).
+var (
+	RoundRobin GroupName = "roundrobin"
+	Peers      GroupName = "peers"
+	Fallbacks  GroupName = "fallbacks"
+	// StagingGroup holds the fallbacks that are rolling out (see -rollout).
+	// Clients don't use it.
+	StagingGroup GroupName = "staging"
 )
 
 var (
@@ -67,13 +78,20 @@ var (
 	cfldomain = flag.String("cfldomain", "getiantem.org", "CloudFlare domain, defaults to getiantem.org")
 	// Temporarily disable CloudFront/DNSimple.
 	//dspdomain  = flag.String("dspdomain", "flashlightproxy.org", "DNSimple domain, defaults to flashlightproxy.org")
-	cpuprofile = flag.String("cpuprofile", "", "(optional) specify the name of a file to which to write cpu profiling info")
-	memprofile = flag.String("memprofile", "", "(optional) specify the name of a file to which to write memory profiling info")
+	cpuprofile       = flag.String("cpuprofile", "", "(optional) specify the name of a file to which to write cpu profiling info")
+	memprofile       = flag.String("memprofile", "", "(optional) specify the name of a file to which to write memory profiling info")
+	warnProxiedPeers = flag.Bool("warn-proxied-peers", true, "Warn about peer records proxied by CloudFlare, defaults to true")
+	maxRetryAfter    = flag.Duration("max-retry-after", 60*time.Second, "Longest to wait before retrying a request that CloudFlare rate limited, even if its Retry-After asks for longer, defaults to 60s")
 
 	cflid   = os.Getenv("CFL_ID")
 	cflkey  = os.Getenv("CFL_KEY")
 	cflutil *cfl.Util
 
+	// tags are attached to the records we create, to tell apart records of
+	// different environments sharing a zone (e.g. env=staging)
+	tags        = os.Getenv("PEERSCANNER_TAGS")
+	requireTags = flag.Bool("require-tags", false, "Only load records tagged with PEERSCANNER_TAGS, defaults to false")
+
 	/* Temporarily disable CloudFront/DNSimple.
 	cfrid   = os.Getenv("CFR_ID")
 	cfrkey  = os.Getenv("CFR_KEY")
@@ -83,62 +101,242 @@ var (
 	dspkey  = os.Getenv("DSP_KEY")
 	dsputil *dsp.Util
 	*/
-
-	hosts      map[string]*host
-	hostsMutex sync.Mutex
 )
 
 func main() {
-	numCores := runtime.NumCPU()
-	log.Debugf("Using all %d cores", numCores)
-	runtime.GOMAXPROCS(numCores)
-
 	parseFlags()
 
+	if *autoMaxProcs {
+		log.Debugf("Using GOMAXPROCS=%d", setMaxProcs())
+	} else {
+		numCores := runtime.NumCPU()
+		log.Debugf("Using all %d cores", numCores)
+		runtime.GOMAXPROCS(numCores)
+	}
+
+	if *canary {
+		runCanary()
+		return
+	}
+
 	finishProfiling := profiling.Start(*cpuprofile, *memprofile)
 	defer finishProfiling()
 
+	defaultDialer = dohDialer()
 	connectToCloudFlare()
 	// Temporarily disable CloudFront/DNSimple.
 	//connectToCloudFront()
 	//connectToDnsimple()
 
-	var err error
-	hosts, err = loadHosts()
-	if err != nil {
+	if *redisAddr != "" {
+		reconcileLock = NewRedisDistributedLock(*redisAddr)
+		metadataStore = newRedisClient(*redisAddr)
+	} else if *kvNamespaceId != "" {
+		lock, err := cflutil.NewZoneLock(*kvNamespaceId)
+		if err != nil {
+			log.Fatalf("Unable to set up zone lock: %v", err)
+		}
+		cflutil.Lock = lock
+		reconcileLock = zoneLock{lock}
+	}
+
+	pool := NewHostPool()
+	if err := pool.Load(); err != nil {
 		log.Fatal(err)
 	}
 
-	startHttp()
+	startHostWatchdog()
+	startReaper(pool)
+	startZoneStatsMonitor()
+	startCloudFlareHealthCheck()
+	startRecordWatcher(pool)
+	startCfSyncMonitor(pool)
+	publishCheckTimeoutRatios(pool)
+	startZoneBackup()
+	if *syntheticPeer {
+		runSyntheticPeer(pool)
+	}
+	startHttp(pool)
 }
 
 func parseFlags() {
+	var errs []string
+	if err := applyEnvironment(os.Getenv("PEERSCANNER_ENV")); err != nil {
+		errs = append(errs, fmt.Sprintf("Invalid PEERSCANNER_ENV: %v", err))
+	}
 	flag.Parse()
+	if cflkey == "" && os.Getenv("VAULT_ADDR") != "" {
+		if err := useVaultCredentials(); err != nil {
+			errs = append(errs, fmt.Sprintf("Unable to read CloudFlare credentials from Vault: %v", err))
+		}
+	}
+	if err := loadGroupConfigs(); err != nil {
+		errs = append(errs, fmt.Sprintf("Invalid -groups-config: %v", err))
+	}
+	setLogOutputs(os.Stderr, os.Stdout)
+	if level, err := ParseLevel(*logLevel); err != nil {
+		errs = append(errs, fmt.Sprintf("Invalid -log-level: %v", err))
+	} else {
+		SetLevel(level)
+	}
+	if h, err := newSlogHandler(*slogHandler, os.Stdout); err != nil {
+		errs = append(errs, fmt.Sprintf("Invalid -slog-handler: %v", err))
+	} else if err := setLogBackend(os.Getenv("LOG_BACKEND"), h); err != nil {
+		errs = append(errs, fmt.Sprintf("Invalid LOG_BACKEND: %v", err))
+	}
+	errs = append(errs, validateConfig()...)
+	if len(errs) > 0 {
+		log.Fatalf("Invalid configuration:\n  %v", strings.Join(errs, "\n  "))
+	}
+	trustedProxyNets, _ = parseTrustedProxies(*trustedProxies)
+	if *rollout {
+		rollouts = NewRolloutController(*rolloutSteps, *rolloutInterval)
+	}
+	if *cfApiRate > 0 {
+		cfBudget = cfl.NewBudget(*cfApiRate, *cfApiBurst)
+	}
+}
+
+// validateConfig checks the flags and environment variables, returning all
+// problems with them so that they can be fixed in one go.
+func validateConfig() []string {
+	var errs []string
+	if *canary {
+		// Canaries don't talk to CloudFlare
+		if u, err := url.Parse(*canarySourceUrl); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Sprintf("Invalid -canary-source-url %q, -canary needs the URL of a peerscanner's /v1/peers", *canarySourceUrl))
+		}
+		return errs
+	}
 	if cflid == "" {
-		log.Fatal("Please specify a CFL_ID environment variable")
+		errs = append(errs, "Please specify a CFL_ID environment variable")
 	}
 	if cflkey == "" {
-		log.Fatal("Please specify a CFL_KEY environment variable")
+		errs = append(errs, "Please specify a CFL_KEY environment variable")
+	}
+	if _, err := cfl.ParseTags(tags); err != nil {
+		errs = append(errs, fmt.Sprintf("Invalid PEERSCANNER_TAGS: %v", err))
+	}
+	if *requireTags && tags == "" {
+		errs = append(errs, "-require-tags needs PEERSCANNER_TAGS")
+	}
+	if err := validateSmokeTestProtocol(*smokeTestProtocol); err != nil {
+		errs = append(errs, fmt.Sprintf("Invalid -smoke-test-protocol: %v", err))
+	}
+	if *port < 1 || *port > 65535 {
+		errs = append(errs, fmt.Sprintf("Invalid -port %d, must be between 1 and 65535", *port))
+	}
+	if *bindAddr != "" {
+		if _, err := net.ResolveTCPAddr("tcp", *bindAddr); err != nil {
+			errs = append(errs, fmt.Sprintf("Invalid -bind-addr %v, must be host:port or :port: %v", *bindAddr, err))
+		}
+	}
+	if *adminClientCA != "" {
+		if _, err := loadAdminClientCAs(*adminClientCA); err != nil {
+			errs = append(errs, fmt.Sprintf("Invalid -admin-client-ca: %v", err))
+		}
+	}
+	if !isHostname(*cfldomain) || !strings.Contains(*cfldomain, ".") {
+		errs = append(errs, fmt.Sprintf("Invalid -cfldomain %v, must be a domain name", *cfldomain))
+	}
+	if *acmeDNS01 {
+		if _, err := acmeChallengeName(*cfldomain, *tlsDomain); err != nil {
+			errs = append(errs, fmt.Sprintf("Invalid -tlsdomain %v, -acme-dns01 needs a domain in -cfldomain: %v", *tlsDomain, err))
+		}
+		if *acmeDNSPropagationWait < 0 {
+			errs = append(errs, fmt.Sprintf("Invalid -acme-dns-propagation-wait %v, must not be negative", *acmeDNSPropagationWait))
+		}
+	}
+	if *maxRecordNameLength < 1 || *maxRecordNameLength > maxFqdnLength {
+		errs = append(errs, fmt.Sprintf("Invalid -max-record-name-length %d, must be between 1 and %d", *maxRecordNameLength, maxFqdnLength))
+	}
+	if *backupCfdomain != "" && (!isHostname(*backupCfdomain) || *backupCfdomain == *cfldomain) {
+		errs = append(errs, fmt.Sprintf("Invalid -backup-cfdomain %v, must be a domain name other than -cfldomain", *backupCfdomain))
+	}
+	if *redisAddr != "" {
+		if _, _, err := net.SplitHostPort(*redisAddr); err != nil {
+			errs = append(errs, fmt.Sprintf("Invalid -redis-addr %v, must be host:port", *redisAddr))
+		}
+		if *kvNamespaceId != "" {
+			errs = append(errs, "Only one of -redis-addr and -kv-namespace-id may be given")
+		}
+	}
+	if *rollout {
+		if !*cfSrv {
+			errs = append(errs, "-rollout needs -cf-srv, since rollout weights are SRV record weights")
+		}
+		if *rolloutSteps < 1 {
+			errs = append(errs, fmt.Sprintf("Invalid -rollout-steps %d, must be at least 1", *rolloutSteps))
+		}
+		if *rolloutInterval <= 0 {
+			errs = append(errs, fmt.Sprintf("Invalid -rollout-interval %v, must be positive", *rolloutInterval))
+		}
+	}
+	if *cfApiRate < 0 {
+		errs = append(errs, fmt.Sprintf("Invalid -cf-api-rate %v, must not be negative", *cfApiRate))
+	}
+	if *cfApiRate > 0 && *cfApiBurst < 1 {
+		errs = append(errs, fmt.Sprintf("Invalid -cf-api-burst %d, must be at least 1", *cfApiBurst))
+	}
+	if *maxRetryAfter <= 0 {
+		errs = append(errs, fmt.Sprintf("Invalid -max-retry-after %v, must be positive", *maxRetryAfter))
+	}
+	if *maxBackoff < testPeriod {
+		errs = append(errs, fmt.Sprintf("Invalid -max-backoff %v, must be at least %v", *maxBackoff, testPeriod))
+	}
+	if *failThreshold < 1 {
+		errs = append(errs, fmt.Sprintf("Invalid -fail-threshold %d, must be at least 1", *failThreshold))
+	}
+	if *timeoutRatioThreshold < 0 || *timeoutRatioThreshold > 1 {
+		errs = append(errs, fmt.Sprintf("Invalid -timeout-ratio-threshold %v, must be between 0 and 1", *timeoutRatioThreshold))
+	}
+	if *successThreshold < 1 {
+		errs = append(errs, fmt.Sprintf("Invalid -success-threshold %d, must be at least 1", *successThreshold))
+	}
+	if *healthTtl && (*minTtl < 120 || *maxTtl < *minTtl) {
+		errs = append(errs, fmt.Sprintf("Invalid -min-ttl %d and -max-ttl %d, -health-ttl needs 120 <= -min-ttl <= -max-ttl", *minTtl, *maxTtl))
+	}
+	if _, err := parseTrustedProxies(*trustedProxies); err != nil {
+		errs = append(errs, fmt.Sprintf("Invalid -trusted-proxies: %v", err))
+	}
+	if *dohURL != "" {
+		if u, err := url.Parse(*dohURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			errs = append(errs, fmt.Sprintf("Invalid -doh-url %v, must be an https URL", *dohURL))
+		}
 	}
 	/* Temporarily disable CloudFront/DNSimple.
 	if cfrid == "" {
-		log.Fatal("Please specify a CFR_ID environment variable")
+		errs = append(errs, "Please specify a CFR_ID environment variable")
 	}
 	if cfrkey == "" {
-		log.Fatal("Please specify a CFR_KEY environment variable")
+		errs = append(errs, "Please specify a CFR_KEY environment variable")
 	}
 	if dspid == "" {
-		log.Fatal("Please specify a DSP_ID environment variable")
+		errs = append(errs, "Please specify a DSP_ID environment variable")
 	}
 	if dspkey == "" {
-		log.Fatal("Please specify a DSP_KEY environment variable")
+		errs = append(errs, "Please specify a DSP_KEY environment variable")
 	}
 	*/
+	return errs
 }
 
 func connectToCloudFlare() {
 	log.Debug("Connecting to CloudFlare ...")
-	cflutil = cfl.New(*cfldomain, cflid, cflkey)
+	parsedTags, _ := cfl.ParseTags(tags)
+	var err error
+	credentials := cfl.WithAPIKey(cflid, cflkey)
+	if vaultCreds != nil {
+		credentials = cfl.WithCredentialSource(readCFCredsFromVault)
+	}
+	opts := []cfl.Option{credentials, cfl.WithTags(parsedTags), cfl.WithRecordComment(expandRecordComment(*cfRecordComment)), cfl.WithMaxRetryAfter(*maxRetryAfter), cfl.WithTelemetry()}
+	if cfBudget != nil {
+		opts = append(opts, cfl.WithBudget(cfBudget, *cfApiWait))
+	}
+	cflutil, err = cfl.New(*cfldomain, opts...)
+	if err != nil {
+		log.Fatalf("Unable to connect to CloudFlare: %v", err)
+	}
 }
 
 /* Temporarily disable CloudFront/DNSimple.
@@ -157,43 +355,45 @@ func connectToDnsimple() {
  * Functions for managing map of hosts
  ******************************************************************************/
 
-// loadHosts loads the initial list of hosts based on the existing entries in
-// the CDN and DNS services we manage
-func loadHosts() (map[string]*host, error) {
+// Load loads the initial list of hosts based on the existing entries in the
+// CDN and DNS services we manage, replacing whatever hosts the pool had, and
+// starts checking them.
+func (p *HostPool) Load() error {
 
-	log.Debug("Loading existing CloudFlare records ...")
-	cflRecs, err := cflutil.GetAllRecords()
+	// Fallbacks and groups are all A records, so don't bother fetching the
+	// rest of the zone
+	log.Debug("Loading existing CloudFlare A records ...")
+	cflRecs, err := cflutil.ListRecordsByType("A")
 	if err != nil {
-		return nil, fmt.Errorf("Unable to load Cloudflare records: %v", err)
+		return fmt.Errorf("Unable to load Cloudflare records: %v", err)
+	}
+	log.Debugf("Loaded %d existing Cloudflare A records", len(cflRecs))
+	if *requireTags {
+		cflRecs, err = cflutil.FilterTagged(cflRecs)
+		if err != nil {
+			return fmt.Errorf("Unable to filter Cloudflare records by tags: %v", err)
+		}
+		log.Debugf("%d Cloudflare records tagged with %v", len(cflRecs), cfl.FormatTags(cflutil.Tags))
 	}
-	log.Debugf("Loaded %d existing Cloudflare records", len(cflRecs))
+	cflRecs = removeDuplicateRecords(cflRecs)
 
 	/* Disable CloudFront/DNSimple
 	log.Debug("Loading existing DNSimple records ...")
 	dspRecs, err := dsputil.GetAllRecords()
 	if err != nil {
-		return nil, fmt.Errorf("Unable to load DNSimple records: %v", err)
+		return fmt.Errorf("Unable to load DNSimple records: %v", err)
 	}
 	log.Debugf("Loaded %d existing DNSimple records", len(dspRecs))
 
 	dists, err := cfr.ListDistributions(cfrutil)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to load cloudfront distributions: %v", err)
+		return fmt.Errorf("Unable to load cloudfront distributions: %v", err)
 	}
 	log.Debugf("Loaded %d existing distributions", len(dists))
 	*/
 
 	// Collect round-robin entries in Cloudflare
-	cflGroups := make(map[string]map[string]*cloudflare.Record, 0)
-	addToCflGroup := func(name string, r cloudflare.Record) {
-		log.Debugf("Adding to %v: %v", name, r.Value)
-		g := cflGroups[name]
-		if g == nil {
-			g = make(map[string]*cloudflare.Record, 1)
-			cflGroups[name] = g
-		}
-		g[r.Value] = &r
-	}
+	cflGroups := newCflGroupRecords()
 
 	/* Temporarily disable CloudFront/DNSimple
 	// Collect round-robin entries in DNSimple
@@ -230,22 +430,24 @@ func loadHosts() (map[string]*host, error) {
 	}
 
 	// Look through Cloudflare records to find peers, fallbacks and groups
+	var peerRecs []cloudflare.Record
 	for _, r := range cflRecs {
+		if r.Type != "A" {
+			// Only A records represent hosts and rotations, not e.g. CNAME
+			// aliases
+			continue
+		}
 		if isFallback(r.Name) {
-			log.Debugf("Adding fallback: %v", r.Name)
+			log.Debugf("Adding fallback: %v", hostkey{r.Name, r.Value})
 			// Temporarily disable CloudFront/DNSimple.
 			//addHost(r.Name, r.Value, &r, nil)
 			addHost(r.Name, r.Value, &r)
 		} else if isPeer(r.Name) {
-			log.Debugf("Not adding peer: %v", r.Name)
-		} else if r.Name == RoundRobin {
-			addToCflGroup(RoundRobin, r)
-		} else if r.Name == Fallbacks {
-			addToCflGroup(Fallbacks, r)
-		} else if r.Name == Peers {
-			addToCflGroup(Peers, r)
-		} else if strings.HasSuffix(r.Name, ".fallbacks") {
-			addToCflGroup(r.Name, r)
+			warnIfProxiedPeer(&r)
+			log.Debugf("Not adding peer: %v", hostkey{r.Name, r.Value})
+			peerRecs = append(peerRecs, r)
+		} else if g, ok := groupNameFor(r.Name); ok {
+			addToCflGroup(cflGroups, g, r)
 		} else {
 			log.Tracef("Unrecognized Cloudflare record: %v", r.FullName)
 		}
@@ -277,8 +479,13 @@ func loadHosts() (map[string]*host, error) {
 	hostsByIp := make(map[string]*host)
 	for _, pre := range preHosts {
 		// Temporarily disable CloudFront/DNSimple.
-		//h := newHost(pre.name, pre.ip, "", pre.cflRecord, pre.dspRecord)
-		h := newHost(pre.name, pre.ip, "", pre.cflRecord)
+		//h, err := newHost(pre.name, pre.ip, "", pre.cflRecord, pre.dspRecord)
+		h, err := newHost(pre.name, pre.ip, "", pre.cflRecord)
+		if err != nil {
+			log.Errorf("Not adding host from DNS: %v", err)
+			continue
+		}
+		h.setMetadata(loadMetadata(h.name, h.ip))
 		hostsByName[h.name] = h
 		hostsByIp[h.ip] = h
 	}
@@ -299,6 +506,13 @@ func loadHosts() (map[string]*host, error) {
 			if found {
 				hg.existing = g[h.ip]
 				delete(g, h.ip)
+				if hg.existing != nil {
+					membersOf(hg.subdomain).Add(h.ip)
+					if rollouts != nil && hg.subdomain != StagingGroup && hg.subdomain != Peers {
+						// Already in rotation, no need to roll it out again
+						rollouts.promote(h.ip)
+					}
+				}
 			}
 		}
 		/* Temporarily disable CloudFront/DNSimple.
@@ -316,37 +530,76 @@ func loadHosts() (map[string]*host, error) {
 		*/
 	}
 
-	var wg sync.WaitGroup
-
-	// Remove items from rotation that don't have a corresponding host
-	for k, g := range cflGroups {
-		for _, r := range g {
-			wg.Add(1)
-			go removeCflRecord(&wg, k, r)
+	// Remove items from rotation that don't have a corresponding host. Only
+	// one replica does this at a time, the others leave it until next time.
+	acquired, err := withLock(reconcileLock, reconcileLockKey, reconcileLockTtl, func() {
+		var wg sync.WaitGroup
+		for k, g := range cflGroups {
+			for _, r := range g {
+				wg.Add(1)
+				go removeCflRecord(&wg, k, r)
+			}
 		}
-	}
-	/* Temporarily disable CloudFront/DNSimple.
-	for k, g := range dspGroups {
-		for _, r := range g {
-			wg.Add(1)
-			go removeDspRecord(&wg, k, r)
+		if *maxRecordAge > 0 {
+			now := time.Now()
+			for _, r := range peerRecs {
+				wg.Add(1)
+				go removeStalePeerRecord(&wg, r, hostsByIp, now)
+			}
 		}
-	}
-	*/
+		/* Temporarily disable CloudFront/DNSimple.
+		for k, g := range dspGroups {
+			for _, r := range g {
+				wg.Add(1)
+				go removeDspRecord(&wg, k, r)
+			}
+		}
+		*/
+		wg.Wait()
 
-	wg.Wait()
+		if *cfCreateCnameAliases {
+			groups := make([]GroupName, 0, len(cflGroups))
+			for g := range cflGroups {
+				groups = append(groups, g)
+			}
+			createCnameAliases(groups)
+		}
+	})
+	if err != nil {
+		log.Errorf("Unable to acquire lock %v, not removing orphaned records: %v", reconcileLockKey, err)
+	} else if !acquired {
+		log.Debugf("Another replica holds %v, not removing orphaned records", reconcileLockKey)
+	}
 
 	// Start hosts
 	for _, h := range hostsByIp {
 		go h.run()
 	}
 
-	return hostsByIp, nil
+	p.mutex.Lock()
+	p.hosts = hostsByIp
+	p.mutex.Unlock()
+	return nil
+}
+
+// warnIfProxiedPeer warns if the given peer record is proxied by CloudFlare.
+// DNS for such a record resolves to CloudFlare's edge, which happily answers
+// even when the peer is down, so peers must only ever be checked at the
+// record's content (the real ip) as reported by the API.
+func warnIfProxiedPeer(r *cloudflare.Record) {
+	if *warnProxiedPeers && cfl.IsProxied(r) {
+		proxiedPeerRecords.Add(1)
+		log.Errorf("WARNING: Peer record %v is proxied by CloudFlare, checking its content %v instead of resolving it", r.FullName, r.Value)
+	}
 }
 
-func removeCflRecord(wg *sync.WaitGroup, k string, r *cloudflare.Record) {
+func removeCflRecord(wg *sync.WaitGroup, k GroupName, r *cloudflare.Record) {
 	log.Debugf("%v in %v is missing Cloudflare record, removing", r.Value, k)
 	err := cflutil.DestroyRecord(r)
+	if skippedForBudget(err, fmt.Sprintf("removing %v from %v", r.Value, k)) {
+		wg.Done()
+		return
+	}
 	if err != nil {
 		log.Debugf("Unable to remove %v from Cloudflare's %v: %v", r.Value, k, err)
 	}
@@ -364,29 +617,6 @@ func removeDspRecord(wg *sync.WaitGroup, k string, r *dnsimple.Record) {
 }
 */
 
-func getOrCreateHost(name string, ip string, port string) *host {
-	hostsMutex.Lock()
-	defer hostsMutex.Unlock()
-
-	h := hosts[ip]
-	if h == nil {
-		// Temporarily disable CloudFront/DNSimple.
-		//h := newHost(name, ip, port, nil, nil)
-		h := newHost(name, ip, port, nil)
-		hosts[ip] = h
-		go h.run()
-		return h
-	}
-	h.reset(name)
-	return h
-}
-
-func getHostByIp(ip string) *host {
-	hostsMutex.Lock()
-	defer hostsMutex.Unlock()
-	return hosts[ip]
-}
-
 func isPeer(name string) bool {
 	// We just check the length of the subdomain here, which is the unique
 	// peer GUID. While it's possible something else could have a subdomain
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getlantern/golog"
+	"github.com/getlantern/peerscanner/cfl/cfltest"
+	"github.com/getlantern/testify/assert"
+)
+
+func TestLoadHostsWarnsAboutProxiedPeers(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+
+	proxied := m.AddRecord("A", "peer-proxied", "1.2.3.4")
+	m.SetProxied(proxied.Id, true)
+	m.AddRecord("A", "peer-dnsonly", "1.2.3.5")
+
+	before := proxiedPeerRecords.Value()
+	pool := NewHostPool()
+	if assert.NoError(t, pool.Load()) {
+		assert.Equal(t, 0, pool.Len(), "Peers shouldn't be loaded as hosts")
+	}
+	assert.Equal(t, before+1, proxiedPeerRecords.Value(), "Only the proxied peer should have been warned about")
+
+	*warnProxiedPeers = false
+	defer func() {
+		*warnProxiedPeers = true
+	}()
+	assert.NoError(t, pool.Load())
+	assert.Equal(t, before+1, proxiedPeerRecords.Value(), "Shouldn't warn with -warn-proxied-peers=false")
+}
+
+func TestLoadHostsRequireTags(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	cflutil.Tags = map[string]string{"env": "staging"}
+	*requireTags = true
+	defer func() {
+		*requireTags = false
+	}()
+
+	// Records in rotations without a corresponding host get removed, but only
+	// if they were loaded at all
+	tagged := m.AddRecord("A", string(RoundRobin), "45.63.8.1")
+	m.SetComment(tagged.Id, "tags: env=staging,team=ops")
+	untagged := m.AddRecord("A", string(RoundRobin), "45.63.8.2")
+	otherEnv := m.AddRecord("A", string(RoundRobin), "45.63.8.3")
+	m.SetComment(otherEnv.Id, "tags: env=production")
+
+	if !assert.NoError(t, NewHostPool().Load()) {
+		return
+	}
+	assert.Len(t, m.FindRecords(string(RoundRobin), tagged.Value), 0, "Tagged record should have been loaded")
+	assert.Len(t, m.FindRecords(string(RoundRobin), untagged.Value), 1, "Untagged record should have been skipped")
+	assert.Len(t, m.FindRecords(string(RoundRobin), otherEnv.Value), 1, "Record of other environment should have been skipped")
+}
+
+func TestCreatedRecordsAreTagged(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	cflutil.Tags = map[string]string{"env": "staging", "team": "ops"}
+
+	rec, _, err := cflutil.EnsureRegistered("fl-us-tagged", "45.63.8.4", nil)
+	if assert.NoError(t, err) {
+		comment := m.Comment(rec.Id)
+		assert.True(t, strings.HasPrefix(comment, "peerscanner created at "), "Comment should say when the record was created")
+		assert.True(t, strings.HasSuffix(comment, "; tags: env=staging,team=ops"), "Record should have been tagged")
+	}
+}
+
+func TestCreatedRecordsHaveRecordComment(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	origVersion := version
+	defer func() {
+		version = origVersion
+	}()
+	version = "1.2.3"
+	hostname, _ := os.Hostname()
+	cflutil.RecordComment = expandRecordComment(*cfRecordComment)
+	cflutil.Tags = map[string]string{"env": "staging"}
+
+	rec, _, err := cflutil.EnsureRegistered("fl-us-commented", "45.63.8.5", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	comment := m.Comment(rec.Id)
+	assert.Contains(t, comment, "peerscanner/1.2.3@"+hostname, "Comment should include our version and hostname")
+	assert.Contains(t, comment, "tags: env=staging", "Comment should still include the tags")
+	var sent bool
+	for _, r := range m.V4Requests() {
+		if r.Method == "POST" && r.Body["comment"] == comment {
+			sent = true
+		}
+	}
+	assert.True(t, sent, "Comment should have been sent in the comment field")
+}
+
+func TestValidateConfigReportsAllErrors(t *testing.T) {
+	origId, origKey, origPort, origDomain, origNameLength, origRedis, origKV := cflid, cflkey, *port, *cfldomain, *maxRecordNameLength, *redisAddr, *kvNamespaceId
+	defer func() {
+		cflid, cflkey, *port, *cfldomain, *maxRecordNameLength, *redisAddr, *kvNamespaceId = origId, origKey, origPort, origDomain, origNameLength, origRedis, origKV
+	}()
+
+	cflid, cflkey = "user@example.com", "key"
+	assert.Len(t, validateConfig(), 0, "Default configuration should be valid")
+
+	cflid, cflkey = "", ""
+	*port = 70000
+	*cfldomain = "not a domain"
+	*maxRecordNameLength = 254
+	*redisAddr = "localhost"
+	*kvNamespaceId = "namespace"
+	errs := validateConfig()
+	if assert.Len(t, errs, 7, "Every problem should be reported: %v", errs) {
+		assert.Contains(t, errs[0], "CFL_ID")
+		assert.Contains(t, errs[1], "CFL_KEY")
+		assert.Contains(t, errs[2], "-port 70000")
+		assert.Contains(t, errs[3], "-cfldomain")
+		assert.Contains(t, errs[4], "-max-record-name-length 254")
+		assert.Contains(t, errs[5], "-redis-addr")
+		assert.Contains(t, errs[6], "-kv-namespace-id")
+	}
+}
+
+func TestLoadHostsRemovesStalePeerRecords(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+
+	stale := m.AddRecord("A", "peer-stale", "1.2.4.1")
+	m.SetCreatedOn(stale.Id, time.Now().Add(-8*24*time.Hour))
+	recent := m.AddRecord("A", "peer-recent", "1.2.4.2")
+	m.SetCreatedOn(recent.Id, time.Now().Add(-6*24*time.Hour))
+	unknown := m.AddRecord("A", "peer-unknown", "1.2.4.3")
+	fallback := m.AddRecord("A", "fl-us-old", "1.2.4.4")
+	m.SetCreatedOn(fallback.Id, time.Now().Add(-30*24*time.Hour))
+
+	if !assert.NoError(t, NewHostPool().Load()) {
+		return
+	}
+	assert.Len(t, m.FindRecords(stale.Name, ""), 0, "Stale peer record should have been removed")
+	assert.Len(t, m.FindRecords(recent.Name, ""), 1, "Recent peer record should have been kept")
+	assert.Len(t, m.FindRecords(unknown.Name, ""), 1, "Peer record of unknown age should have been kept")
+	assert.Len(t, m.FindRecords(fallback.Name, ""), 1, "Old fallback record has a host and should have been kept")
+
+	*maxRecordAge = 0
+	defer func() {
+		*maxRecordAge = 7 * 24 * time.Hour
+	}()
+	m.SetCreatedOn(recent.Id, time.Now().Add(-8*24*time.Hour))
+	assert.NoError(t, NewHostPool().Load())
+	assert.Len(t, m.FindRecords(recent.Name, ""), 1, "Nothing should be removed with -max-record-age 0")
+}
+
+func TestLoadHostsPartialCFFailure(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	var debug bytes.Buffer
+	golog.SetOutputs(&bytes.Buffer{}, &debug)
+	defer golog.ResetOutputs()
+
+	// Rotation members without a host get removed when loading, the first 5
+	// of those removals succeed and the next 5 fail
+	var deletes int
+	var mutex sync.Mutex
+	m.SetV4Fail(func(req cfltest.V4Request) bool {
+		if req.Method != "DELETE" {
+			return false
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		deletes++
+		return deletes > 5 && deletes <= 10
+	})
+	for i := 1; i <= 3; i++ {
+		m.AddRecord("A", fmt.Sprintf("fl-us-partial%d", i), fmt.Sprintf("10.1.0.%d", i))
+	}
+	for i := 1; i <= 10; i++ {
+		m.AddRecord("A", string(RoundRobin), fmt.Sprintf("10.2.0.%d", i))
+	}
+
+	p := NewHostPool()
+	if !assert.NoError(t, p.Load(), "Failing to remove some records shouldn't fail loading") {
+		return
+	}
+	p.mutex.Lock()
+	assert.Len(t, p.hosts, 3, "All hosts should have been loaded")
+	for i := 1; i <= 3; i++ {
+		assert.NotNil(t, p.hosts[fmt.Sprintf("10.1.0.%d", i)], "Host for 10.1.0.%d should have been loaded", i)
+	}
+	p.mutex.Unlock()
+	assert.Equal(t, 10, m.CountRecordRequests("DELETE"), "Every rotation member without a host should have been removed")
+	assert.Len(t, m.FindRecords(string(RoundRobin), ""), 5, "Members whose removal failed should remain")
+	assert.Equal(t, 5, strings.Count(debug.String(), "Unable to remove"), "Failed removals should be logged")
+	assert.Contains(t, debug.String(), "DEBUG peerscanner: ", "Failed removals should be logged at DEBUG")
+}
+
+// TestConcurrentLoadHosts checks that loading a zone of
+// loadBenchmarkRecords records, as at startup, stays within 10 seconds.
+func TestConcurrentLoadHosts(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	defer withoutDialing()()
+	addLoadBenchmarkRecords(m)
+
+	pool := NewHostPool()
+	start := time.Now()
+	if !assert.NoError(t, pool.Load()) {
+		return
+	}
+	elapsed := time.Since(start)
+	defer func() {
+		assert.True(t, pauseAll(pool), "Hosts should have been paused")
+	}()
+	assert.True(t, elapsed < 10*time.Second, "Loading %d records took %v, should take less than 10 seconds", loadBenchmarkRecords, elapsed)
+	assert.Equal(t, loadBenchmarkFallbacks, pool.Len(), "Every fallback should have been loaded")
+	assert.Len(t, m.FindRecords("peer-bench0", ""), 0, "Stale peer record should have been removed")
+	assert.Len(t, m.FindRecords(fmt.Sprintf("peer-bench%d", loadBenchmarkStalePeers), ""), 1, "Recent peer record should have been kept")
+	assert.Len(t, m.FindRecords(string(RoundRobin), "192.0.3.0"), 0, "Orphaned rotation entry should have been removed")
+}
+
+// BenchmarkLoadHosts5000 measures HostPool.Load with a zone of
+// loadBenchmarkRecords records, reporting the CloudFlare API calls it makes
+// and the host run loops it starts.
+func BenchmarkLoadHosts5000(b *testing.B) {
+	defer withoutDialing()()
+	b.ReportAllocs()
+	var calls, hosts int
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		m := newMockCfl()
+		addLoadBenchmarkRecords(m)
+		before := len(m.GetRequests())
+		pool := NewHostPool()
+		b.StartTimer()
+
+		if err := pool.Load(); err != nil {
+			b.Fatal(err)
+		}
+
+		b.StopTimer()
+		calls += len(m.GetRequests()) - before
+		hosts += pool.Len()
+		if !pauseAll(pool) {
+			b.Fatal("Hosts weren't paused")
+		}
+		m.close()
+	}
+	b.ReportMetric(float64(calls)/float64(b.N), "cf-calls/op")
+	b.ReportMetric(float64(hosts)/float64(b.N), "run-loops/op")
+}
+
+const (
+	loadBenchmarkRecords    = 5000
+	loadBenchmarkPeers      = 2000
+	loadBenchmarkStalePeers = 500
+	loadBenchmarkFallbacks  = 100
+	loadBenchmarkOrphans    = 10
+)
+
+// addLoadBenchmarkRecords fills m with loadBenchmarkRecords A records:
+// loadBenchmarkPeers peers, the first loadBenchmarkStalePeers of them older
+// than -max-record-age, loadBenchmarkFallbacks fallbacks in roundrobin and
+// fallbacks, loadBenchmarkOrphans rotation entries without a host and, for
+// the rest, records that peerscanner doesn't manage.
+func addLoadBenchmarkRecords(m *mockCfl) {
+	stale := time.Now().Add(-8 * 24 * time.Hour)
+	added := 0
+	add := func(name string, ip string) {
+		m.AddRecord("A", name, ip)
+		added++
+	}
+	for i := 0; i < loadBenchmarkPeers; i++ {
+		r := m.AddRecord("A", fmt.Sprintf("peer-bench%d", i), fmt.Sprintf("10.1.%d.%d", i/256, i%256))
+		added++
+		if i < loadBenchmarkStalePeers {
+			m.SetCreatedOn(r.Id, stale)
+		}
+	}
+	for i := 0; i < loadBenchmarkFallbacks; i++ {
+		ip := fmt.Sprintf("45.64.0.%d", i)
+		add(fmt.Sprintf("fl-us-bench%d", i), ip)
+		add(string(RoundRobin), ip)
+		add(string(Fallbacks), ip)
+	}
+	for i := 0; i < loadBenchmarkOrphans; i++ {
+		add(string(RoundRobin), fmt.Sprintf("192.0.3.%d", i))
+	}
+	for i := 0; added < loadBenchmarkRecords; i++ {
+		add(fmt.Sprintf("www%d", i), fmt.Sprintf("192.0.2.%d", i%256))
+	}
+}
+
+// pauseAll pauses pool's hosts and waits for them to be paused, so that they
+// stop using CloudFlare before the mock goes away. It returns false if they
+// weren't all paused in time.
+func pauseAll(pool *HostPool) bool {
+	hosts := pool.all()
+	for _, h := range hosts {
+		h.unregister()
+	}
+	return waitUntil(func() bool {
+		for _, h := range hosts {
+			if h.getInfo().state != StatePaused {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// withoutDialing has hosts created from now on fail their checks without
+// touching the network, returning a function that restores the default
+// dialer.
+func withoutDialing() func() {
+	orig := defaultDialer
+	defaultDialer = &mockDialer{err: fmt.Errorf("not dialing in tests")}
+	return func() { defaultDialer = orig }
+}
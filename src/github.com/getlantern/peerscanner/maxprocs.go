@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+var (
+	autoMaxProcs = flag.Bool("auto-maxprocs", true, "Set GOMAXPROCS from the container's cgroup CPU quota rather than the number of CPUs on the machine, defaults to true")
+
+	// cgroupRoot is where the cgroup filesystem is mounted. Within a
+	// container's cgroup namespace, its own cgroup is at the root.
+	cgroupRoot = "/sys/fs/cgroup"
+)
+
+// setMaxProcs sets GOMAXPROCS to the number of CPUs that our cgroup's quota
+// allows (rounded down, but at least 1), or to the number of CPUs if there's
+// no quota, and returns it. Otherwise Go would use every CPU on the node and
+// get throttled by the quota.
+func setMaxProcs() int {
+	procs := runtime.NumCPU()
+	quota, err := cgroupCPUQuota(cgroupRoot)
+	if err != nil {
+		log.Debugf("Unable to read cgroup CPU quota, using all %d cores: %v", procs, err)
+	} else if quota > 0 {
+		procs = int(quota)
+		if procs < 1 {
+			procs = 1
+		}
+	}
+	runtime.GOMAXPROCS(procs)
+	return procs
+}
+
+// cgroupCPUQuota returns the number of CPUs that the cgroup under root may
+// use, or 0 if it's unlimited. It supports both cgroup v2 (cpu.max) and v1
+// (cpu.cfs_quota_us and cpu.cfs_period_us).
+func cgroupCPUQuota(root string) (float64, error) {
+	if data, err := ioutil.ReadFile(filepath.Join(root, "cpu.max")); err == nil {
+		fields := strings.Fields(string(data))
+		if len(fields) != 2 {
+			return 0, fmt.Errorf("Unexpected cpu.max: %q", string(data))
+		}
+		if fields[0] == "max" {
+			return 0, nil
+		}
+		return quotaFrom(fields[0], fields[1])
+	}
+
+	for _, dir := range []string{"cpu", "cpu,cpuacct"} {
+		quota, err := ioutil.ReadFile(filepath.Join(root, dir, "cpu.cfs_quota_us"))
+		if err != nil {
+			continue
+		}
+		period, err := ioutil.ReadFile(filepath.Join(root, dir, "cpu.cfs_period_us"))
+		if err != nil {
+			return 0, err
+		}
+		if strings.TrimSpace(string(quota)) == "-1" {
+			return 0, nil
+		}
+		return quotaFrom(strings.TrimSpace(string(quota)), strings.TrimSpace(string(period)))
+	}
+	return 0, fmt.Errorf("No cgroup CPU controller found under %v", root)
+}
+
+func quotaFrom(quota string, period string) (float64, error) {
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid CPU quota %q: %v", quota, err)
+	}
+	p, err := strconv.ParseFloat(period, 64)
+	if err != nil || p <= 0 {
+		return 0, fmt.Errorf("Invalid CPU period %q", period)
+	}
+	return q / p, nil
+}
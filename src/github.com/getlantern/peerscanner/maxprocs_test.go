@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+// withCgroup creates a fake cgroup filesystem with the given files and points
+// cgroupRoot at it.
+func withCgroup(t *testing.T, files map[string]string) func() {
+	dir, err := ioutil.TempDir("", "cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	orig := cgroupRoot
+	cgroupRoot = dir
+	origProcs := runtime.GOMAXPROCS(0)
+	return func() {
+		cgroupRoot = orig
+		runtime.GOMAXPROCS(origProcs)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSetMaxProcsCgroupV2(t *testing.T) {
+	defer withCgroup(t, map[string]string{"cpu.max": "200000 100000\n"})()
+	assert.Equal(t, 2, setMaxProcs())
+	assert.Equal(t, 2, runtime.GOMAXPROCS(0))
+}
+
+func TestSetMaxProcsCgroupV1(t *testing.T) {
+	defer withCgroup(t, map[string]string{
+		"cpu,cpuacct/cpu.cfs_quota_us":  "300000\n",
+		"cpu,cpuacct/cpu.cfs_period_us": "100000\n",
+	})()
+	assert.Equal(t, 3, setMaxProcs())
+	assert.Equal(t, 3, runtime.GOMAXPROCS(0))
+}
+
+func TestSetMaxProcsFractionalQuota(t *testing.T) {
+	defer withCgroup(t, map[string]string{"cpu.max": "25000 100000\n"})()
+	assert.Equal(t, 1, setMaxProcs(), "A quarter of a core should still get one proc")
+}
+
+func TestSetMaxProcsUnlimited(t *testing.T) {
+	defer withCgroup(t, map[string]string{"cpu.max": "max 100000\n"})()
+	assert.Equal(t, runtime.NumCPU(), setMaxProcs())
+
+	defer withCgroup(t, map[string]string{})()
+	assert.Equal(t, runtime.NumCPU(), setMaxProcs(), "Without cgroups, all cores should be used")
+}
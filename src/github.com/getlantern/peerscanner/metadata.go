@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	maxMetadataKeys  = 10
+	maxMetadataBytes = 64
+
+	metadataKeyPrefix = "peerscanner:metadata:"
+)
+
+var (
+	// metadataStore, if set, is the Redis server at -redis-addr, where hosts'
+	// metadata is kept so that it survives restarts.
+	metadataStore *redisClient
+)
+
+// parseMetadata parses the JSON metadata that hosts can include with their
+// registration, like their datacenter or operator contact.
+func parseMetadata(data []byte) (map[string]string, error) {
+	var metadata map[string]string
+	err := json.Unmarshal(data, &metadata)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid metadata: %v", err)
+	}
+	if len(metadata) > maxMetadataKeys {
+		return nil, fmt.Errorf("Metadata has %d keys, at most %d are allowed", len(metadata), maxMetadataKeys)
+	}
+	for k, v := range metadata {
+		if len(k) > maxMetadataBytes {
+			return nil, fmt.Errorf("Metadata key %v is longer than %d bytes", k, maxMetadataBytes)
+		}
+		if len(v) > maxMetadataBytes {
+			return nil, fmt.Errorf("Metadata value for %v is longer than %d bytes", k, maxMetadataBytes)
+		}
+	}
+	return metadata, nil
+}
+
+// setMetadata replaces this host's metadata. Unlike the rest of the host's
+// state, metadata doesn't belong to the run loop, so it can be set from
+// anywhere.
+func (h *host) setMetadata(metadata map[string]string) {
+	h.metadataMutex.Lock()
+	h.metadata = metadata
+	h.metadataMutex.Unlock()
+}
+
+// getMetadata returns this host's metadata, which callers must not modify.
+func (h *host) getMetadata() map[string]string {
+	h.metadataMutex.RLock()
+	defer h.metadataMutex.RUnlock()
+	return h.metadata
+}
+
+// metadataKey is the Redis key that the metadata of the host with the given
+// name and ip is stored under.
+func metadataKey(name string, ip string) string {
+	return metadataKeyPrefix + name + ":" + ip
+}
+
+// storeMetadata stores the metadata of the host with the given name and ip in
+// the metadataStore, if there is one.
+func storeMetadata(name string, ip string, metadata map[string]string) {
+	if metadataStore == nil {
+		return
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		log.Errorf("Unable to encode metadata for %v: %v", hostkey{name, ip}, err)
+		return
+	}
+	if _, err := metadataStore.do(context.Background(), "SET", metadataKey(name, ip), string(data)); err != nil {
+		log.Errorf("Unable to store metadata for %v: %v", hostkey{name, ip}, err)
+	}
+}
+
+// loadMetadata returns the metadata that the metadataStore has for the host
+// with the given name and ip, or nil if there's no store or it has none.
+func loadMetadata(name string, ip string) map[string]string {
+	if metadataStore == nil {
+		return nil
+	}
+	data, err := metadataStore.do(context.Background(), "GET", metadataKey(name, ip))
+	if err != nil {
+		log.Errorf("Unable to load metadata for %v: %v", hostkey{name, ip}, err)
+		return nil
+	}
+	if data == "" {
+		return nil
+	}
+	var metadata map[string]string
+	if err := json.Unmarshal([]byte(data), &metadata); err != nil {
+		log.Errorf("Invalid metadata stored for %v: %v", hostkey{name, ip}, err)
+		return nil
+	}
+	return metadata
+}
+
+type hostReport struct {
+	Name                  string            `json:"name"`
+	Ip                    string            `json:"ip"`
+	Port                  string            `json:"port"`
+	State                 string            `json:"state"`
+	Metadata              map[string]string `json:"metadata,omitempty"`
+	LastCloudFlareSync    *time.Time        `json:"lastCloudFlareSync,omitempty"`
+	LastCloudFlareSyncErr string            `json:"lastCloudFlareSyncErr,omitempty"`
+	CheckTimeoutRatio     float64           `json:"checkTimeoutRatio"`
+}
+
+// listHosts is the debug endpoint that lists all hosts along with their
+// metadata.
+func (p *HostPool) listHosts(resp http.ResponseWriter, req *http.Request) {
+	infos := p.Snapshot()
+	sort.Sort(byName(infos))
+	reports := make([]hostReport, 0, len(infos))
+	for _, info := range infos {
+		report := hostReport{
+			Name:                  info.name,
+			Ip:                    info.ip,
+			Port:                  info.port,
+			State:                 info.state,
+			Metadata:              info.metadata,
+			LastCloudFlareSyncErr: info.lastCfSyncErr,
+			CheckTimeoutRatio:     info.timeoutRatio,
+		}
+		if !info.lastCfSync.IsZero() {
+			synced := info.lastCfSync
+			report.LastCloudFlareSync = &synced
+		}
+		reports = append(reports, report)
+	}
+	writeJSON(resp, reports)
+}
+
+// updateHostMetadata is the admin endpoint at
+// POST /v1/admin/hosts/{name}/{ip}/metadata that replaces a host's metadata
+// without it having to re-register.
+func (p *HostPool) updateHostMetadata(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		resp.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintln(resp, "Only POST is supported")
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/v1/admin/hosts/"), "/")
+	if len(parts) != 3 || parts[2] != "metadata" {
+		resp.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(resp, "Not found")
+		return
+	}
+	name, ip := parts[0], parts[1]
+	h := p.Get(ip)
+	if h == nil || h.getInfo().name != name {
+		resp.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(resp, "Host %v (%v) not found\n", name, ip)
+		return
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		resp.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(resp, "Unable to read body: %v\n", err)
+		return
+	}
+	metadata, err := parseMetadata(body)
+	if err != nil {
+		resp.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(resp, err.Error())
+		return
+	}
+	h.setMetadata(metadata)
+	storeMetadata(name, ip, metadata)
+	log.Debugf("Updated metadata for %v", h)
+	resp.WriteHeader(200)
+	fmt.Fprintln(resp, "Metadata updated")
+}
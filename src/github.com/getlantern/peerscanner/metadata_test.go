@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestParseMetadataLimits(t *testing.T) {
+	md, err := parseMetadata([]byte(`{"datacenter": "ams3", "contact": "ops@example.com"}`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]string{"datacenter": "ams3", "contact": "ops@example.com"}, md)
+	}
+
+	tooMany := make(map[string]string)
+	for i := 0; i <= maxMetadataKeys; i++ {
+		tooMany[fmt.Sprintf("key%d", i)] = "value"
+	}
+	data, _ := json.Marshal(tooMany)
+	_, err = parseMetadata(data)
+	assert.Error(t, err, "More than %d keys should be rejected", maxMetadataKeys)
+
+	long := strings.Repeat("x", maxMetadataBytes+1)
+	_, err = parseMetadata([]byte(`{"` + long + `": "value"}`))
+	assert.Error(t, err, "Long key should be rejected")
+	_, err = parseMetadata([]byte(`{"key": "` + long + `"}`))
+	assert.Error(t, err, "Long value should be rejected")
+	_, err = parseMetadata([]byte(`{"key": "` + long[1:] + `"}`))
+	assert.NoError(t, err, "Value of exactly %d bytes should be accepted", maxMetadataBytes)
+	_, err = parseMetadata([]byte(`["not", "an", "object"]`))
+	assert.Error(t, err, "Non-object should be rejected")
+}
+
+func TestRegisterRejectsInvalidMetadata(t *testing.T) {
+	form := url.Values{"name": {"fl-us-metadata"}, "port": {"443"}, "metadata": {`{"key": "` + strings.Repeat("x", 100) + `"}`}}
+	req := httptest.NewRequest("POST", "/register", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = "45.63.4.1:40000"
+	rec := httptest.NewRecorder()
+	pool := NewHostPool()
+	webFor(pool).register(rec, req)
+	assert.Equal(t, 400, rec.Code, "Registration with oversized metadata should be rejected")
+	assert.Nil(t, pool.Get("45.63.4.1"), "Host shouldn't have been created")
+}
+
+func TestUpdateHostMetadata(t *testing.T) {
+	h := onlineHost("fl-us-md", "45.63.4.2", "443", true)
+	pool := newTestPool(h)
+
+	update := func(path string, body string) int {
+		rec := httptest.NewRecorder()
+		pool.updateHostMetadata(rec, httptest.NewRequest("POST", path, strings.NewReader(body)))
+		return rec.Code
+	}
+	assert.Equal(t, 404, update("/v1/admin/hosts/fl-us-other/45.63.4.2/metadata", `{}`), "Wrong name should be rejected")
+	assert.Equal(t, 404, update("/v1/admin/hosts/fl-us-md/45.63.4.3/metadata", `{}`), "Unknown ip should be rejected")
+	assert.Equal(t, 400, update("/v1/admin/hosts/fl-us-md/45.63.4.2/metadata", `{"key": "`+strings.Repeat("x", 65)+`"}`), "Oversized metadata should be rejected")
+	assert.Equal(t, 200, update("/v1/admin/hosts/fl-us-md/45.63.4.2/metadata", `{"datacenter": "ams3"}`))
+
+	rec := httptest.NewRecorder()
+	pool.listHosts(rec, httptest.NewRequest("GET", "/debug/hosts", nil))
+	var reports []map[string]interface{}
+	if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &reports)) && assert.Len(t, reports, 1) {
+		assert.Equal(t, "fl-us-md", reports[0]["name"])
+		assert.Equal(t, map[string]interface{}{"datacenter": "ams3"}, reports[0]["metadata"])
+	}
+}
+
+func TestMetadataSurvivesRestart(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	r := newMockRedis(t)
+	defer r.close()
+	origDialer, origStore := defaultDialer, metadataStore
+	defer func() { defaultDialer, metadataStore = origDialer, origStore }()
+	defaultDialer = &mockDialer{err: fmt.Errorf("not dialing in tests")}
+	metadataStore = newRedisClient(r.addr())
+	name, ip := "fl-us-mdrestart", "45.63.4.4"
+
+	pool := NewHostPool()
+	NewCloudFlareHostRegistry(pool).Register(name, ip, RegisterOpts{Port: "443", Weight: defaultWeight, Metadata: map[string]string{"datacenter": "ams3"}})
+	h := pool.Get(ip)
+	if !assert.NotNil(t, h, "Registration should have created host") {
+		return
+	}
+	h.unregister()
+	rec := httptest.NewRecorder()
+	pool.updateHostMetadata(rec, httptest.NewRequest("POST", "/v1/admin/hosts/"+name+"/"+ip+"/metadata", strings.NewReader(`{"datacenter": "sfo2"}`)))
+	assert.Equal(t, 200, rec.Code)
+
+	// After a restart, the host gets its metadata back when it re-registers
+	// without any
+	restarted := NewHostPool()
+	NewCloudFlareHostRegistry(restarted).Register(name, ip, RegisterOpts{Port: "443", Weight: defaultWeight})
+	h = restarted.Get(ip)
+	if !assert.NotNil(t, h, "Registration should have created host") {
+		return
+	}
+	defer h.unregister()
+	assert.Equal(t, map[string]string{"datacenter": "sfo2"}, h.getMetadata(), "Metadata should have survived the restart")
+	assert.Nil(t, loadMetadata("fl-us-other", ip), "Host with a different name shouldn't get the metadata")
+}
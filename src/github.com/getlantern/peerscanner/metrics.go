@@ -0,0 +1,52 @@
+package main
+
+import (
+	"expvar"
+	"time"
+)
+
+// Counters, gauges and histograms are published through expvar, which serves
+// them as JSON at /debug/vars.
+var (
+	dedupHits          = expvar.NewInt("dedup_hits_total")
+	proxiedPeerRecords = expvar.NewInt("proxied_peer_records_total")
+	externalChanges    = expvar.NewInt("cf_external_changes_detected_total")
+	zoneRecordUsage    = expvar.NewFloat("cf_zone_record_usage_percent")
+	cfSloBreaches      = expvar.NewInt("cf_slo_breach_total")
+	hostLimitReached   = expvar.NewInt("hosts_limit_reached_total")
+	canaryWarnings     = expvar.NewInt("canary_peer_warnings_total")
+
+	// registrations counts the outcomes of registrations by result, one of
+	// accepted, rejected_ratelimit, rejected_invalid, rejected_signature or
+	// deduplicated
+	registrations = expvar.NewMap("peer_registration_total")
+
+	checkDuration = newHistogram("peer_check_duration_seconds",
+		[]float64{0.01, 0.05, 0.1, 0.25, 0.5, 1.0, 2.5, 5.0, 10.0},
+		"host_type", "result")
+	cfProbeDuration = newHistogram("cf_api_probe_duration_seconds",
+		[]float64{0.05, 0.1, 0.25, 0.5, 1.0, 2.5, 5.0, 10.0},
+		"result")
+	canaryProbeDuration = newHistogram("canary_probe_duration_seconds",
+		[]float64{0.01, 0.05, 0.1, 0.25, 0.5, 1.0, 2.5},
+		"result")
+)
+
+// observeCheckDuration records how long a check of a fallback or peer took in
+// peer_check_duration_seconds.
+func observeCheckDuration(fallback bool, online bool, d time.Duration) {
+	hostType, result := "peer", "failure"
+	if fallback {
+		hostType = "fallback"
+	}
+	if online {
+		result = "success"
+	}
+	checkDuration.observe(d.Seconds(), hostType, result)
+}
+
+// countRegistration counts a registration with the given result in
+// peer_registration_total.
+func countRegistration(result string) {
+	registrations.Add(result, 1)
+}
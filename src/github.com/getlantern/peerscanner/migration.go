@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	migrationLog = flag.String("migration-log", "", "(optional) File to which every migration of a host's records to a new name is appended")
+
+	migrationLogMutex sync.Mutex
+)
+
+// doMigrateCflHost moves h's Cloudflare records to newName, which it
+// reported in with while keeping its ip (e.g. after a reinstall). Unlike
+// deregistering and registering again, this keeps the host resolvable
+// throughout. The records are found again by the next check.
+func (h *host) doMigrateCflHost(newName string) error {
+	if err := h.deregisterSrv(); err != nil {
+		log.Errorf("Unable to deregister SRV record for %v: %v", h, err)
+	}
+	if err := h.deregisterHealthCheck(); err != nil {
+		log.Errorf("Unable to deregister CloudFlare health check for %v: %v", h, err)
+	}
+	err := cflutil.MigrateRecords(h.name, newName)
+	logMigration(time.Now(), h.name, newName, h.ip, err)
+	h.cloudFlareSynced(err)
+	if err != nil {
+		return fmt.Errorf("Unable to migrate Cloudflare records of %v to %v: %v", h, newName, err)
+	}
+	h.cflRecord = nil
+	h.cflRecordId = ""
+	h.isProxying = false
+	return nil
+}
+
+// logMigration appends a line about the migration of the records of the host
+// at ip from oldName to newName to -migration-log, if set.
+func logMigration(at time.Time, oldName string, newName string, ip string, err error) {
+	if *migrationLog == "" {
+		return
+	}
+	result := "ok"
+	if err != nil {
+		result = "failed: " + err.Error()
+	}
+	migrationLogMutex.Lock()
+	defer migrationLogMutex.Unlock()
+	f, err := os.OpenFile(*migrationLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		log.Errorf("Unable to open migration log %v: %v", *migrationLog, err)
+		return
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintf(f, "%v %v -> %v (%v) %v\n", at.UTC().Format(time.RFC3339), oldName, newName, ip, result); err != nil {
+		log.Errorf("Unable to write to migration log %v: %v", *migrationLog, err)
+	}
+}
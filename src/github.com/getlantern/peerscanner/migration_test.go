@@ -0,0 +1,43 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestRenameMigratesRecords(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	logFile, err := ioutil.TempFile("", "migrations")
+	if !assert.NoError(t, err) {
+		return
+	}
+	logFile.Close()
+	defer os.Remove(logFile.Name())
+	orig := *migrationLog
+	*migrationLog = logFile.Name()
+	defer func() {
+		*migrationLog = orig
+	}()
+
+	name, ip := "fl-us-migrate", "45.63.11.1"
+	f := newFakeFallback(name)
+	defer f.close()
+	h, _ := newTestHost(name, ip, f)
+	h.check()
+	if !assert.Len(t, m.FindRecords(name, ip), 1, "Host should have been registered") {
+		return
+	}
+
+	h.doReset("fl-us-migrated")
+	assert.Equal(t, "fl-us-migrated", h.name)
+	assert.Len(t, m.FindRecords("fl-us-migrated", ip), 1, "Record should have been migrated to the new name")
+	assert.Len(t, m.FindRecords(name, ip), 0, "Record for the old name should have been destroyed")
+	assert.Equal(t, 1, m.CountRecordRequests("DELETE"), "Only the record for the old name should have been destroyed, the host shouldn't have been deregistered")
+
+	logged, _ := ioutil.ReadFile(logFile.Name())
+	assert.Regexp(t, "^[^ ]+ fl-us-migrate -> fl-us-migrated \\(45.63.11.1\\) ok\n$", string(logged))
+}
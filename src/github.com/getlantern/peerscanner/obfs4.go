@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Lengths from the obfs4 spec. The client's padding is chosen so that its
+// handshake is at least as long as the server's, including the inline seed
+// frame that follows it.
+const (
+	obfs4NodeIdLength             = 20
+	obfs4PublicKeyLength          = 32
+	obfs4RepresentativeLength     = 32
+	obfs4AuthLength               = 32
+	obfs4MarkLength               = sha256.Size / 2
+	obfs4MacLength                = sha256.Size / 2
+	obfs4MaxHandshakeLength       = 8192
+	obfs4ClientMinHandshakeLength = obfs4RepresentativeLength + obfs4MarkLength + obfs4MacLength
+	obfs4ServerMinHandshakeLength = obfs4RepresentativeLength + obfs4AuthLength + obfs4MarkLength + obfs4MacLength
+	obfs4InlineSeedFrameLength    = 45
+	obfs4ClientMinPadLength       = obfs4ServerMinHandshakeLength + obfs4InlineSeedFrameLength - obfs4ClientMinHandshakeLength
+	obfs4ClientMaxPadLength       = obfs4MaxHandshakeLength - obfs4ClientMinHandshakeLength
+
+	obfs4Timeout = 10 * time.Second
+)
+
+var (
+	checkObfs4 = flag.Bool("check-obfs4", false, "Only consider hosts that registered an obfs4cert online if they complete an obfs4 handshake at their obfs4port, defaults to false")
+)
+
+// obfs4Config is how a host's obfs4 server can be reached: the port it
+// listens at and the node id and public key from its bridge line's cert.
+type obfs4Config struct {
+	port      string
+	nodeId    []byte
+	publicKey []byte
+}
+
+// parseObfs4Config parses the obfs4 cert and port that a host registered
+// with. The cert is the base64 encoded node id and public key, like in the
+// cert= argument of an obfs4 bridge line. Hosts without a cert don't speak
+// obfs4.
+func parseObfs4Config(cert string, port string) (*obfs4Config, error) {
+	if cert == "" && port == "" {
+		return nil, nil
+	}
+	if cert == "" || port == "" {
+		return nil, fmt.Errorf("obfs4cert and obfs4port must be given together")
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return nil, fmt.Errorf("Invalid obfs4port %v, must be between 1 and 65535", port)
+	}
+	b, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(cert, "="))
+	if err != nil || len(b) != obfs4NodeIdLength+obfs4PublicKeyLength {
+		return nil, fmt.Errorf("Invalid obfs4cert %v, must be the base64 encoded node id and public key", cert)
+	}
+	return &obfs4Config{port: port, nodeId: b[:obfs4NodeIdLength], publicKey: b[obfs4NodeIdLength:]}, nil
+}
+
+// macKey is the key of the HMACs that mark and authenticate handshakes.
+func (c *obfs4Config) macKey() []byte {
+	return append(append([]byte{}, c.publicKey...), c.nodeId...)
+}
+
+func (c *obfs4Config) mac(data ...[]byte) []byte {
+	h := hmac.New(sha256.New, c.macKey())
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)[:obfs4MacLength]
+}
+
+// probeObfs4 makes the client side of an obfs4 handshake with the server at
+// addr and checks that the server answers with a correctly marked and
+// authenticated handshake of its own. That takes a server that knows the node
+// id and public key in c, whereas an open port alone doesn't prove anything.
+//
+// The representative of our ephemeral key is random bytes, since every
+// representative maps to some key and we don't go on to derive session keys,
+// so the server's auth value (which needs our private key to check) is
+// skipped.
+func probeObfs4(ctx context.Context, dialer Dialer, addr string, c *obfs4Config, now time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, obfs4Timeout)
+	defer cancel()
+	conn, err := dialer.Dial(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("Unable to dial obfs4 server at %v: %v", addr, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(obfs4Timeout))
+
+	epochHour := []byte(strconv.FormatInt(now.Unix()/3600, 10))
+	hello, err := obfs4ClientHandshake(c, epochHour)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(hello); err != nil {
+		return fmt.Errorf("Unable to send obfs4 handshake to %v: %v", addr, err)
+	}
+
+	resp := make([]byte, 0, obfs4MaxHandshakeLength)
+	buf := make([]byte, obfs4MaxHandshakeLength)
+	for {
+		n, err := conn.Read(buf[:obfs4MaxHandshakeLength-len(resp)])
+		resp = append(resp, buf[:n]...)
+		if pos := findObfs4Mark(c, resp, obfs4RepresentativeLength+obfs4AuthLength); pos >= 0 {
+			if !hmac.Equal(c.mac(resp[:pos+obfs4MarkLength], epochHour), resp[pos+obfs4MarkLength:pos+obfs4MarkLength+obfs4MacLength]) {
+				return fmt.Errorf("Invalid MAC in obfs4 handshake from %v", addr)
+			}
+			return nil
+		}
+		if len(resp) >= obfs4MaxHandshakeLength {
+			return fmt.Errorf("No mark in obfs4 handshake from %v", addr)
+		}
+		if err == io.EOF {
+			return fmt.Errorf("%v closed the connection without completing the obfs4 handshake", addr)
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to read obfs4 handshake from %v: %v", addr, err)
+		}
+	}
+}
+
+// obfs4ClientHandshake builds X | P_C | M_C | MAC(X | P_C | M_C | E), with a
+// random representative X and random padding P_C.
+func obfs4ClientHandshake(c *obfs4Config, epochHour []byte) ([]byte, error) {
+	padLength, err := rand.Int(rand.Reader, big.NewInt(obfs4ClientMaxPadLength-obfs4ClientMinPadLength+1))
+	if err != nil {
+		return nil, err
+	}
+	representative := make([]byte, obfs4RepresentativeLength)
+	pad := make([]byte, obfs4ClientMinPadLength+int(padLength.Int64()))
+	if _, err := rand.Read(representative); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(pad); err != nil {
+		return nil, err
+	}
+	var hello bytes.Buffer
+	hello.Write(representative)
+	hello.Write(pad)
+	hello.Write(c.mac(representative))
+	hello.Write(c.mac(hello.Bytes(), epochHour))
+	return hello.Bytes(), nil
+}
+
+// findObfs4Mark finds the mark of the handshake in buf, which is the MAC of
+// its representative, at or after start. It returns -1 if the mark, and the
+// MAC that follows it, haven't been received yet.
+func findObfs4Mark(c *obfs4Config, buf []byte, start int) int {
+	if len(buf) < start+obfs4MarkLength+obfs4MacLength {
+		return -1
+	}
+	pos := bytes.Index(buf[start:], c.mac(buf[:obfs4RepresentativeLength]))
+	if pos < 0 {
+		return -1
+	}
+	pos += start
+	if len(buf) < pos+obfs4MarkLength+obfs4MacLength {
+		return -1
+	}
+	return pos
+}
+
+// obfs4Ok checks h's obfs4 server, if it has one and with -check-obfs4.
+func (h *host) obfs4Ok(ctx context.Context) error {
+	c := h.getObfs4()
+	if !*checkObfs4 || c == nil {
+		return nil
+	}
+	return probeObfs4(ctx, h.dialer, net.JoinHostPort(h.ip, c.port), c, time.Now())
+}
+
+// setObfs4 sets how this host's obfs4 server can be reached. Like metadata,
+// it doesn't belong to the run loop.
+func (h *host) setObfs4(c *obfs4Config) {
+	h.obfs4Mutex.Lock()
+	h.obfs4 = c
+	h.obfs4Mutex.Unlock()
+}
+
+func (h *host) getObfs4() *obfs4Config {
+	h.obfs4Mutex.RLock()
+	defer h.obfs4Mutex.RUnlock()
+	return h.obfs4
+}
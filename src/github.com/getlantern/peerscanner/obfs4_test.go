@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getlantern/testify/assert"
+)
+
+// mockObfs4Server speaks the server side of the obfs4 handshake for the node
+// id and public key in config. Like a real obfs4 server, it doesn't answer
+// handshakes that aren't marked and authenticated for them, and closes the
+// connection after a while instead.
+type mockObfs4Server struct {
+	l      net.Listener
+	config *obfs4Config
+}
+
+func newMockObfs4Server(t *testing.T) *mockObfs4Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &mockObfs4Server{l: l, config: newObfs4Config(t, "")}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+	return s
+}
+
+func (s *mockObfs4Server) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(250 * time.Millisecond))
+	hello := make([]byte, 0, obfs4MaxHandshakeLength)
+	buf := make([]byte, obfs4MaxHandshakeLength)
+	for {
+		n, err := conn.Read(buf[:obfs4MaxHandshakeLength-len(hello)])
+		hello = append(hello, buf[:n]...)
+		if pos := findObfs4Mark(s.config, hello, obfs4RepresentativeLength); pos >= 0 {
+			mac := hello[pos+obfs4MarkLength : pos+obfs4MarkLength+obfs4MacLength]
+			epochHour := []byte(strconv.FormatInt(time.Now().Unix()/3600, 10))
+			if bytes.Equal(mac, s.config.mac(hello[:pos+obfs4MarkLength], epochHour)) {
+				conn.Write(s.serverHandshake(epochHour))
+			}
+			io.Copy(ioutil.Discard, conn)
+			return
+		}
+		if err != nil || len(hello) >= obfs4MaxHandshakeLength {
+			return
+		}
+	}
+}
+
+// serverHandshake builds Y | AUTH | P_S | M_S | MAC(Y | AUTH | P_S | M_S | E)
+// with random Y, AUTH and padding.
+func (s *mockObfs4Server) serverHandshake(epochHour []byte) []byte {
+	var resp bytes.Buffer
+	random := make([]byte, obfs4RepresentativeLength+obfs4AuthLength+100)
+	rand.Read(random)
+	resp.Write(random)
+	resp.Write(s.config.mac(random[:obfs4RepresentativeLength]))
+	resp.Write(s.config.mac(resp.Bytes(), epochHour))
+	return resp.Bytes()
+}
+
+func (s *mockObfs4Server) close() {
+	s.l.Close()
+}
+
+// newObfs4Config creates a config with a random node id and public key for
+// the given port.
+func newObfs4Config(t *testing.T, port string) *obfs4Config {
+	b := make([]byte, obfs4NodeIdLength+obfs4PublicKeyLength)
+	rand.Read(b)
+	c, err := parseObfs4Config(base64.RawStdEncoding.EncodeToString(b), "443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.port = port
+	return c
+}
+
+var tcpDialer = dialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+	return (&net.Dialer{}).DialContext(ctx, network, address)
+})
+
+func TestParseObfs4Config(t *testing.T) {
+	cert := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, obfs4NodeIdLength+obfs4PublicKeyLength))
+	c, err := parseObfs4Config(strings.TrimRight(cert, "="), "9443")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "9443", c.port)
+		assert.Len(t, c.nodeId, obfs4NodeIdLength)
+		assert.Len(t, c.publicKey, obfs4PublicKeyLength)
+	}
+	_, err = parseObfs4Config(cert, "9443")
+	assert.NoError(t, err, "Padded cert should be accepted too")
+	c, err = parseObfs4Config("", "")
+	assert.NoError(t, err)
+	assert.Nil(t, c, "Hosts without obfs4 shouldn't have a config")
+
+	_, err = parseObfs4Config(cert, "")
+	assert.Error(t, err, "Cert without port should be rejected")
+	_, err = parseObfs4Config(cert, "99999")
+	assert.Error(t, err, "Invalid port should be rejected")
+	_, err = parseObfs4Config(cert[:20], "9443")
+	assert.Error(t, err, "Short cert should be rejected")
+}
+
+func TestProbeObfs4(t *testing.T) {
+	s := newMockObfs4Server(t)
+	defer s.close()
+	addr := s.l.Addr().String()
+
+	assert.NoError(t, probeObfs4(context.Background(), tcpDialer, addr, s.config, time.Now()), "Handshake with the right cert should succeed")
+	assert.Error(t, probeObfs4(context.Background(), tcpDialer, addr, newObfs4Config(t, ""), time.Now()), "Server shouldn't answer handshake for a different cert")
+	assert.Error(t, probeObfs4(context.Background(), tcpDialer, addr, s.config, time.Now().Add(-3*time.Hour)), "Server shouldn't answer handshake from another epoch")
+
+	// An open port that doesn't speak obfs4
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+			conn.Close()
+		}
+	}()
+	assert.Error(t, probeObfs4(context.Background(), tcpDialer, l.Addr().String(), s.config, time.Now()), "Non-obfs4 server should fail the check")
+}
+
+func TestRegisterRejectsInvalidObfs4(t *testing.T) {
+	form := url.Values{"name": {"fl-us-obfs4"}, "port": {"443"}, "obfs4cert": {"notacert"}, "obfs4port": {"9443"}}
+	req := httptest.NewRequest("POST", "/register", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = "45.63.12.1:40000"
+	rec := httptest.NewRecorder()
+	pool := NewHostPool()
+	webFor(pool).register(rec, req)
+	assert.Equal(t, 400, rec.Code, "Registration with invalid obfs4cert should be rejected")
+	assert.Nil(t, pool.Get("45.63.12.1"), "Host shouldn't have been created")
+}
+
+func TestRegisterNewHostWithFailingObfs4(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	name, ip := "fl-us-obfs4fail", "45.63.12.2"
+	f := newFakeFallback(name)
+	defer f.close()
+	origDialer, origCheck := defaultDialer, *checkObfs4
+	defer func() { defaultDialer, *checkObfs4 = origDialer, origCheck }()
+	// The obfs4 handshake goes to the fallback too, which doesn't answer it
+	defaultDialer = &mockDialer{addr: f.addr()}
+	*checkObfs4 = true
+
+	pool := NewHostPool()
+	r := NewCloudFlareHostRegistry(pool)
+	err := r.Register(name, ip, RegisterOpts{Port: "80", Weight: defaultWeight, Obfs4: newObfs4Config(t, "9443")})
+	h := pool.Get(ip)
+	if !assert.NotNil(t, h, "Host should have been created") {
+		return
+	}
+	defer func() {
+		h.unregister()
+		waitUntil(func() bool { return h.getInfo().state == StatePaused })
+	}()
+	assert.Error(t, err, "Host failing its obfs4 check shouldn't register")
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if !assert.NotEqual(t, StateOnline, h.getInfo().state, "Host failing its obfs4 check shouldn't go online") {
+			return
+		}
+	}
+	assert.Empty(t, m.FindRecords(name, ip), "Host failing its obfs4 check shouldn't have been registered in CloudFlare")
+}
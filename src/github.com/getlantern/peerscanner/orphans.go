@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// orphansReport is how many orphaned records there are and, for DELETE, how
+// many of them were destroyed.
+type orphansReport struct {
+	OrphanedCount int      `json:"orphaned_count"`
+	Orphans       []string `json:"orphans"`
+	Deleted       int      `json:"deleted"`
+	Errors        []string `json:"errors,omitempty"`
+}
+
+// findOrphanedRecords finds the records of peers and fallbacks whose ips
+// aren't in the pool, so that nothing is checking them or would ever remove
+// them.
+func (p *HostPool) findOrphanedRecords() (orphansReport, []string, error) {
+	orphans, err := cflutil.FindOrphanedRecords(func(name string) bool {
+		return isPeer(name) || isFallback(name)
+	}, func(ip string) bool {
+		return p.Get(ip) != nil
+	})
+	if err != nil {
+		return orphansReport{}, nil, err
+	}
+	report := orphansReport{OrphanedCount: len(orphans), Orphans: make([]string, 0, len(orphans))}
+	ids := make([]string, 0, len(orphans))
+	for _, r := range orphans {
+		report.Orphans = append(report.Orphans, hostkey{r.Name, r.Value}.String())
+		ids = append(ids, r.Id)
+	}
+	return report, ids, nil
+}
+
+// orphans is the admin endpoint that lists the orphaned records on GET and
+// destroys them on DELETE. Peers aren't loaded as hosts on startup, so the
+// records of peers that haven't registered since count as orphaned too.
+func (p *HostPool) orphans(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" && req.Method != "DELETE" {
+		resp.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintln(resp, "Only GET and DELETE are supported")
+		return
+	}
+	report, ids, err := p.findOrphanedRecords()
+	if err != nil {
+		resp.WriteHeader(http.StatusBadGateway)
+		fmt.Fprintln(resp, err.Error())
+		return
+	}
+	if req.Method == "DELETE" {
+		for i, id := range ids {
+			if err := cflutil.DestroyRecordById(id); err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("Unable to destroy record of %v: %v", report.Orphans[i], err))
+				continue
+			}
+			report.Deleted++
+		}
+		log.Debugf("Destroyed %d of %d orphaned records", report.Deleted, report.OrphanedCount)
+	}
+	writeJSON(resp, report)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestOrphans(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	peer := "peer-" + "0123456789abcdef0123456789abcdef"
+	m.AddRecord("A", "fl-us-active", "45.63.13.1")
+	m.AddRecord("A", "fl-us-orphan", "45.63.13.2")
+	m.AddRecord("A", peer, "45.63.13.3")
+	m.AddRecord("A", string(RoundRobin), "45.63.13.2")
+	m.AddRecord("A", "www", "45.63.13.4")
+	pool := NewHostPool()
+	pool.hosts["45.63.13.1"] = mustNewHost("fl-us-active", "45.63.13.1", "443")
+
+	rec := httptest.NewRecorder()
+	pool.orphans(rec, httptest.NewRequest("GET", "/v1/admin/orphans", nil))
+	assert.Equal(t, 200, rec.Code)
+	var report orphansReport
+	if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report)) {
+		assert.Equal(t, 2, report.OrphanedCount)
+		sort.Strings(report.Orphans)
+		assert.Equal(t, []string{"fl-us-orphan@45.63.13.2", peer + "@45.63.13.3"}, report.Orphans)
+		assert.Equal(t, 0, report.Deleted, "GET shouldn't destroy anything")
+	}
+	assert.Len(t, m.FindRecords("fl-us-orphan", "45.63.13.2"), 1)
+
+	rec = httptest.NewRecorder()
+	pool.orphans(rec, httptest.NewRequest("DELETE", "/v1/admin/orphans", nil))
+	assert.Equal(t, 200, rec.Code)
+	if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report)) {
+		assert.Equal(t, 2, report.Deleted)
+		assert.Empty(t, report.Errors)
+	}
+	assert.Len(t, m.FindRecords("fl-us-orphan", "45.63.13.2"), 0, "Orphan should have been destroyed")
+	assert.Len(t, m.FindRecords(peer, "45.63.13.3"), 0, "Orphaned peer should have been destroyed")
+	assert.Len(t, m.FindRecords("fl-us-active", "45.63.13.1"), 1, "Active host's record should be kept")
+	assert.Len(t, m.FindRecords(string(RoundRobin), "45.63.13.2"), 1, "Group records aren't orphans")
+	assert.Len(t, m.FindRecords("www", "45.63.13.4"), 1, "Other records aren't orphans")
+
+	rec = httptest.NewRecorder()
+	pool.orphans(rec, httptest.NewRequest("POST", "/v1/admin/orphans", nil))
+	assert.Equal(t, 405, rec.Code)
+}
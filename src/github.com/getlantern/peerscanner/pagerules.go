@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/getlantern/peerscanner/cfl"
+)
+
+// pageRulesReport is what /debug/cf-page-rules responds with
+type pageRulesReport struct {
+	LastUpdated time.Time      `json:"last_updated"`
+	Rules       []cfl.PageRule `json:"rules"`
+	Warnings    []string       `json:"warnings,omitempty"`
+}
+
+// listPageRules is the debug endpoint at /debug/cf-page-rules that lists the
+// page rules of our zone as CloudFlare has them right now. It warns about
+// rules that target a peer that isn't registered, which were probably left
+// behind when the peer went away.
+func (p *HostPool) listPageRules(resp http.ResponseWriter, req *http.Request) {
+	rules, err := cflutil.GetPageRules()
+	if err != nil {
+		resp.WriteHeader(http.StatusBadGateway)
+		fmt.Fprintln(resp, err.Error())
+		return
+	}
+	report := pageRulesReport{LastUpdated: time.Now(), Rules: rules}
+	names := make(map[string]bool)
+	for _, info := range p.Snapshot() {
+		names[info.name] = true
+	}
+	for _, r := range rules {
+		for _, target := range r.Targets {
+			name := pageRuleTargetName(target)
+			if isPeer(name) && !names[name] {
+				warning := fmt.Sprintf("Page rule %v targets %v, which isn't registered", r.Id, name)
+				log.Errorf("WARNING: %v", warning)
+				report.Warnings = append(report.Warnings, warning)
+			}
+		}
+	}
+	writeJSON(resp, report)
+}
+
+// pageRuleTargetName returns the name of the host in our domain that a page
+// rule target like *.peer-abc.getiantem.org/* matches, or "" if it doesn't
+// match a single one.
+func pageRuleTargetName(target string) string {
+	hostname := target
+	if i := strings.Index(hostname, "://"); i >= 0 {
+		hostname = hostname[i+3:]
+	}
+	if i := strings.IndexAny(hostname, "/:"); i >= 0 {
+		hostname = hostname[:i]
+	}
+	hostname = strings.TrimPrefix(hostname, "*.")
+	name := strings.TrimSuffix(hostname, "."+*cfldomain)
+	if name == hostname || strings.ContainsAny(name, ".*") {
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/getlantern/peerscanner/cfl"
+	"github.com/getlantern/testify/assert"
+)
+
+func TestListPageRules(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	peer := "peer-" + "0123456789abcdef0123456789abcdef"
+	zombie := "peer-" + "fedcba9876543210fedcba9876543210"
+	m.SetPageRules(
+		cfl.PageRule{Id: "live", Targets: []string{"*." + peer + ".getiantem.org/*"}, Actions: []cfl.PageRuleAction{{Id: "always_use_https"}}, Priority: 3, Status: "active"},
+		cfl.PageRule{Id: "zombie", Targets: []string{"http://" + zombie + ".getiantem.org/*"}, Actions: []cfl.PageRuleAction{{Id: "always_use_https"}}, Priority: 2, Status: "active"},
+		cfl.PageRule{Id: "site", Targets: []string{"*.getiantem.org/*", "fl-us-001.getiantem.org/*"}, Actions: []cfl.PageRuleAction{{Id: "cache_level", Value: json.RawMessage(`"bypass"`)}}, Priority: 1, Status: "disabled"},
+	)
+	pool := NewHostPool()
+	pool.hosts["45.63.7.1"] = mustNewHost(peer, "45.63.7.1", "80")
+
+	rec := httptest.NewRecorder()
+	pool.listPageRules(rec, httptest.NewRequest("GET", "/debug/cf-page-rules", nil))
+	assert.Equal(t, 200, rec.Code)
+	var report pageRulesReport
+	if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report)) {
+		assert.False(t, report.LastUpdated.IsZero(), "Report should say when the rules were fetched")
+		if assert.Len(t, report.Rules, 3) {
+			assert.Equal(t, "live", report.Rules[0].Id)
+			assert.Equal(t, []string{"*.getiantem.org/*", "fl-us-001.getiantem.org/*"}, report.Rules[2].Targets)
+			assert.Equal(t, `"bypass"`, string(report.Rules[2].Actions[0].Value))
+			assert.Equal(t, "disabled", report.Rules[2].Status)
+		}
+		assert.Equal(t, []string{"Page rule zombie targets " + zombie + ", which isn't registered"}, report.Warnings)
+	}
+}
+
+func TestListPageRulesFails(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	// Leave cflutil pointed at the mock but stop serving
+	m.Close()
+
+	rec := httptest.NewRecorder()
+	NewHostPool().listPageRules(rec, httptest.NewRequest("GET", "/debug/cf-page-rules", nil))
+	assert.Equal(t, 502, rec.Code, "Failing to get page rules should be reported")
+}
+
+func TestPageRuleTargetName(t *testing.T) {
+	for target, expected := range map[string]string{
+		"fl-us-001.getiantem.org/*":            "fl-us-001",
+		"*.fl-us-001.getiantem.org/*":          "fl-us-001",
+		"https://fl-us-001.getiantem.org:443/": "fl-us-001",
+		"fl-us-001.getiantem.org":              "fl-us-001",
+		"*.getiantem.org/*":                    "",
+		"a.fl-us-001.getiantem.org/*":          "",
+		"fl-us-001.example.com/*":              "",
+	} {
+		assert.Equal(t, expected, pageRuleTargetName(target), "Name targeted by %v", target)
+	}
+}
@@ -0,0 +1,108 @@
+// peerscanner-cli runs one-off operations against the CloudFlare zone that
+// peerscanner manages. It authenticates with the same CFL_ID and CFL_KEY
+// environment variables as peerscanner.
+//
+// Usage:
+//
+//	peerscanner-cli export-csv [-domain getiantem.org] [-output peers.csv]
+//	peerscanner-cli sync-ttl [-domain getiantem.org] -ttl 300
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/getlantern/peerscanner/cfl"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "export-csv":
+		exportCSV(os.Args[2:])
+	case "sync-ttl":
+		syncTTL(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: peerscanner-cli export-csv [-domain getiantem.org] [-output peers.csv]")
+	fmt.Fprintln(os.Stderr, "       peerscanner-cli sync-ttl [-domain getiantem.org] -ttl 300")
+	os.Exit(2)
+}
+
+func exportCSV(args []string) {
+	fs := flag.NewFlagSet("export-csv", flag.ExitOnError)
+	domain := fs.String("domain", "getiantem.org", "The CloudFlare zone to export")
+	output := fs.String("output", "", "File to write the CSV to, defaults to stdout")
+	fs.Parse(args)
+
+	u, err := cfl.New(*domain, cfl.WithAPIKey(os.Getenv("CFL_ID"), os.Getenv("CFL_KEY")))
+	if err != nil {
+		log.Fatalf("Unable to connect to CloudFlare: %v", err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Fatalf("Unable to create %v: %v", *output, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := u.ExportRecordsToCSV(w); err != nil {
+		log.Fatalf("Unable to export records: %v", err)
+	}
+}
+
+// syncTTL sets the TTL of the records of all peers and fallbacks, e.g. after
+// changing the TTL that peerscanner registers them with.
+func syncTTL(args []string) {
+	fs := flag.NewFlagSet("sync-ttl", flag.ExitOnError)
+	domain := fs.String("domain", "getiantem.org", "The CloudFlare zone whose records to update")
+	ttl := fs.Int("ttl", 0, "The TTL to set, in seconds, 1 for automatic")
+	fs.Parse(args)
+	if !cfl.IsValidTtl(*ttl) {
+		log.Fatalf("Unsupported ttl %d, try %d", *ttl, cfl.NearestValidTtl(*ttl))
+	}
+
+	u, err := cfl.New(*domain, cfl.WithAPIKey(os.Getenv("CFL_ID"), os.Getenv("CFL_KEY")))
+	if err != nil {
+		log.Fatalf("Unable to connect to CloudFlare: %v", err)
+	}
+	recs, err := u.ListRecordsByType("A")
+	if err != nil {
+		log.Fatalf("Unable to list records: %v", err)
+	}
+	unique := make(map[string]bool)
+	for _, r := range recs {
+		if isPeerOrFallback(r.Name) {
+			unique[r.Name] = true
+		}
+	}
+	names := make([]string, 0, len(unique))
+	for name := range unique {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if err := u.BulkUpdateTTL(names, *ttl); err != nil {
+		log.Fatalf("Unable to update ttls: %v", err)
+	}
+	fmt.Printf("Set ttl of records of %d peers and fallbacks to %d\n", len(names), *ttl)
+}
+
+// isPeerOrFallback tells peers and fallbacks apart from the rotations and
+// other records the same way peerscanner does.
+func isPeerOrFallback(name string) bool {
+	return len(name) == 32 || strings.HasPrefix(name, "peer-") || strings.HasPrefix(name, "fl-")
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru"
+)
+
+const (
+	rateLimitCacheSize = 10000
+
+	// cfBlockPeriod is the period in seconds over which the CloudFlare rule
+	// for a blocked ip counts requests.
+	cfBlockPeriod = 60
+
+	// firewallAfter is how long we reject an ip's registrations before it gets
+	// a CloudFlare firewall rule that blocks it outright
+	firewallAfter = 1 * time.Hour
+
+	// rejectionStreakGap is how long an ip has to go without being rejected
+	// for us to consider it no longer blocked
+	rejectionStreakGap = 5 * time.Minute
+)
+
+var (
+	registerRate     = flag.Float64("register-rate", 1, "Registrations per second allowed from a single ip, defaults to 1")
+	registerBurst    = flag.Int("register-burst", 10, "Registrations allowed in a burst from a single ip, defaults to 10")
+	cfBlockThreshold = flag.Int("cf-block-threshold", 10, "Block an ip at the CloudFlare edge once it exceeds its registration rate limit more than this many times within an hour, defaults to 10")
+	cfBlockDuration  = flag.Duration("cf-block-duration", 1*time.Hour, "How long to block abusive ips at the CloudFlare edge, defaults to 1 hour")
+
+	registrationLimiter = newRateLimiter()
+	blocker             = newEdgeBlocker(
+		func(ip string) error {
+			return cflutil.CreateRateLimitRule(ip, int(*registerRate*cfBlockPeriod), cfBlockPeriod)
+		},
+		func(ip string) error {
+			return cflutil.DeleteRateLimitRule(ip)
+		}).withFirewall(
+		func(ip string) error {
+			return cflutil.CreateFirewallRule(ip, "block", fmt.Sprintf("peerscanner: registrations from %v rejected for %v", ip, firewallAfter))
+		},
+		deleteFirewallRules)
+)
+
+// tokenBucket tracks the registrations allowed for a single ip
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter limits the rate of registrations from each ip using a token
+// bucket per ip.
+type rateLimiter struct {
+	buckets *lru.Cache
+	mutex   sync.Mutex
+}
+
+func newRateLimiter() *rateLimiter {
+	c, err := lru.New(rateLimitCacheSize)
+	if err != nil {
+		panic(err)
+	}
+	return &rateLimiter{buckets: c}
+}
+
+// allow takes a token from ip's bucket, returning false if it's exhausted
+func (l *rateLimiter) allow(ip string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	var b *tokenBucket
+	_b, found := l.buckets.Get(ip)
+	if found {
+		b = _b.(*tokenBucket)
+		b.tokens += now.Sub(b.last).Seconds() * *registerRate
+		if b.tokens > float64(*registerBurst) {
+			b.tokens = float64(*registerBurst)
+		}
+		b.last = now
+	} else {
+		b = &tokenBucket{float64(*registerBurst), now}
+		l.buckets.Add(ip, b)
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// allowRegistration applies the per-ip rate limit, reporting ips that keep
+// exceeding it to the blocker.
+func allowRegistration(ip string) bool {
+	if registrationLimiter.allow(ip) {
+		return true
+	}
+	blocker.exhausted(ip)
+	return false
+}
+
+// edgeBlocker blocks ips at the CloudFlare edge once they've exhausted their
+// rate limit too often, and unblocks them again after -cf-block-duration.
+// Blocking means a rate limiting rule, ips that we keep rejecting for
+// firewallAfter anyway also get a firewall rule that blocks them outright.
+type edgeBlocker struct {
+	block      func(ip string) error
+	unblock    func(ip string) error
+	firewall   func(ip string) error
+	unfirewall func(ip string) error
+
+	exhaustions map[string][]time.Time
+	blocked     map[string]time.Time
+	// rejectingSince is when the current streak of rejections of each ip
+	// started, lastRejected when its last rejection was
+	rejectingSince map[string]time.Time
+	lastRejected   map[string]time.Time
+	// firewalled is until when each ip has a firewall rule
+	firewalled map[string]time.Time
+	mutex      sync.Mutex
+	now        func() time.Time
+}
+
+func newEdgeBlocker(block func(ip string) error, unblock func(ip string) error) *edgeBlocker {
+	return &edgeBlocker{
+		block:          block,
+		unblock:        unblock,
+		exhaustions:    make(map[string][]time.Time),
+		blocked:        make(map[string]time.Time),
+		rejectingSince: make(map[string]time.Time),
+		lastRejected:   make(map[string]time.Time),
+		firewalled:     make(map[string]time.Time),
+		now:            time.Now,
+	}
+}
+
+// withFirewall makes b add a firewall rule for ips whose registrations it has
+// been rejecting for firewallAfter, and remove it again after
+// -cf-block-duration.
+func (b *edgeBlocker) withFirewall(firewall func(ip string) error, unfirewall func(ip string) error) *edgeBlocker {
+	b.firewall = firewall
+	b.unfirewall = unfirewall
+	return b
+}
+
+// exhausted records that ip exhausted its rate limit, blocking it if that
+// happened more than -cf-block-threshold times within the past hour.
+func (b *edgeBlocker) exhausted(ip string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	now := b.now()
+	b.rejected(ip, now)
+	if _, found := b.blocked[ip]; found {
+		return
+	}
+	cutoff := now.Add(-1 * time.Hour)
+	recent := b.exhaustions[ip][:0]
+	for _, t := range b.exhaustions[ip] {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+	if len(recent) <= *cfBlockThreshold {
+		b.exhaustions[ip] = recent
+		return
+	}
+
+	delete(b.exhaustions, ip)
+	b.blocked[ip] = now.Add(*cfBlockDuration)
+	go b.doBlock("Blocking", ip, *cfBlockDuration, b.block, b.unblock, b.blocked)
+}
+
+// rejected keeps track of how long we've been rejecting ip's registrations,
+// adding a firewall rule for it once that's been firewallAfter. It needs
+// b.mutex to be held.
+func (b *edgeBlocker) rejected(ip string, now time.Time) {
+	if last, found := b.lastRejected[ip]; !found || now.Sub(last) > rejectionStreakGap {
+		b.rejectingSince[ip] = now
+	}
+	b.lastRejected[ip] = now
+	if b.firewall == nil || now.Sub(b.rejectingSince[ip]) < firewallAfter {
+		return
+	}
+	if _, found := b.firewalled[ip]; found {
+		return
+	}
+	delete(b.rejectingSince, ip)
+	delete(b.lastRejected, ip)
+	b.firewalled[ip] = now.Add(*cfBlockDuration)
+	go b.doBlock("Adding firewall rule for", ip, *cfBlockDuration, b.firewall, b.unfirewall, b.firewalled)
+}
+
+// doBlock adds a block for ip with add and removes it with remove after
+// duration, keeping track of it in blocks.
+func (b *edgeBlocker) doBlock(what string, ip string, duration time.Duration, add func(ip string) error, remove func(ip string) error, blocks map[string]time.Time) {
+	log.Debugf("%v %v at CloudFlare edge for %v", what, ip, duration)
+	err := add(ip)
+	if err != nil {
+		log.Errorf("Unable to block %v at CloudFlare edge: %v", ip, err)
+		b.forget(ip, blocks)
+		return
+	}
+	time.AfterFunc(duration, func() {
+		log.Debugf("Unblocking %v at CloudFlare edge", ip)
+		err := remove(ip)
+		if err != nil {
+			log.Errorf("Unable to unblock %v at CloudFlare edge: %v", ip, err)
+		}
+		b.forget(ip, blocks)
+	})
+}
+
+func (b *edgeBlocker) forget(ip string, blocks map[string]time.Time) {
+	b.mutex.Lock()
+	delete(blocks, ip)
+	b.mutex.Unlock()
+}
+
+// deleteFirewallRules removes the firewall rules for ip from CloudFlare
+func deleteFirewallRules(ip string) error {
+	rules, err := cflutil.ListFirewallRules()
+	if err != nil {
+		return err
+	}
+	for _, r := range rules {
+		if r.Ip != ip {
+			continue
+		}
+		if err := cflutil.DeleteFirewallRule(r.Id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type blockedIp struct {
+	Ip    string    `json:"ip"`
+	Until time.Time `json:"until"`
+	// Rule is rate_limit for ips with a rate limiting rule, firewall for ips
+	// with a firewall rule
+	Rule string `json:"rule"`
+}
+
+func (b *edgeBlocker) blockedIps() []blockedIp {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	ips := make([]blockedIp, 0, len(b.blocked)+len(b.firewalled))
+	for ip, until := range b.blocked {
+		ips = append(ips, blockedIp{ip, until, "rate_limit"})
+	}
+	for ip, until := range b.firewalled {
+		ips = append(ips, blockedIp{ip, until, "firewall"})
+	}
+	sort.Sort(byIp(ips))
+	return ips
+}
+
+type byIp []blockedIp
+
+func (a byIp) Len() int      { return len(a) }
+func (a byIp) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byIp) Less(i, j int) bool {
+	if a[i].Ip != a[j].Ip {
+		return a[i].Ip < a[j].Ip
+	}
+	return a[i].Rule > a[j].Rule
+}
+
+// listBlockedIps is the debug endpoint that lists the ips currently blocked at
+// the CloudFlare edge.
+func listBlockedIps(resp http.ResponseWriter, req *http.Request) {
+	writeJSON(resp, blocker.blockedIps())
+}
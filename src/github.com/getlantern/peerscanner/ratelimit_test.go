@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter()
+	for i := 0; i < *registerBurst; i++ {
+		assert.True(t, l.allow("1.1.1.1"), "Registration %d should be allowed within burst", i)
+	}
+	assert.False(t, l.allow("1.1.1.1"), "Registration beyond burst should be rejected")
+	assert.True(t, l.allow("1.1.1.2"), "Other ips shouldn't be affected")
+}
+
+func TestEdgeBlockerBlocksAndExpires(t *testing.T) {
+	origThreshold, origDuration := *cfBlockThreshold, *cfBlockDuration
+	*cfBlockThreshold, *cfBlockDuration = 3, 50*time.Millisecond
+	defer func() {
+		*cfBlockThreshold, *cfBlockDuration = origThreshold, origDuration
+	}()
+
+	var mutex sync.Mutex
+	blocked := make(map[string]bool)
+	b := newEdgeBlocker(func(ip string) error {
+		mutex.Lock()
+		blocked[ip] = true
+		mutex.Unlock()
+		return nil
+	}, func(ip string) error {
+		mutex.Lock()
+		delete(blocked, ip)
+		mutex.Unlock()
+		return nil
+	})
+	isBlocked := func(ip string) bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return blocked[ip]
+	}
+
+	for i := 0; i < 3; i++ {
+		b.exhausted("2.2.2.2")
+	}
+	time.Sleep(10 * time.Millisecond)
+	assert.False(t, isBlocked("2.2.2.2"), "IP shouldn't be blocked at threshold")
+	assert.Len(t, b.blockedIps(), 0)
+
+	b.exhausted("2.2.2.2")
+	time.Sleep(10 * time.Millisecond)
+	assert.True(t, isBlocked("2.2.2.2"), "IP should be blocked beyond threshold")
+	ips := b.blockedIps()
+	if assert.Len(t, ips, 1) {
+		assert.Equal(t, "2.2.2.2", ips[0].Ip)
+		assert.WithinDuration(t, time.Now().Add(40*time.Millisecond), ips[0].Until, 40*time.Millisecond)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	assert.False(t, isBlocked("2.2.2.2"), "Block should have expired")
+	assert.Len(t, b.blockedIps(), 0, "Expired block shouldn't be listed")
+}
+
+func TestEdgeBlockerForgetsFailedBlock(t *testing.T) {
+	origThreshold := *cfBlockThreshold
+	*cfBlockThreshold = 0
+	defer func() {
+		*cfBlockThreshold = origThreshold
+	}()
+
+	b := newEdgeBlocker(func(ip string) error {
+		return fmt.Errorf("API unavailable")
+	}, func(ip string) error {
+		return nil
+	})
+	b.exhausted("3.3.3.3")
+	time.Sleep(10 * time.Millisecond)
+	assert.Len(t, b.blockedIps(), 0, "IP whose block failed shouldn't be listed")
+}
+
+func TestEdgeBlockerFirewallsPersistentAbuse(t *testing.T) {
+	origThreshold, origDuration := *cfBlockThreshold, *cfBlockDuration
+	*cfBlockThreshold, *cfBlockDuration = 1000, 50*time.Millisecond
+	defer func() {
+		*cfBlockThreshold, *cfBlockDuration = origThreshold, origDuration
+	}()
+
+	var mutex sync.Mutex
+	firewalled := make(map[string]bool)
+	noop := func(ip string) error { return nil }
+	b := newEdgeBlocker(noop, noop).withFirewall(func(ip string) error {
+		mutex.Lock()
+		firewalled[ip] = true
+		mutex.Unlock()
+		return nil
+	}, func(ip string) error {
+		mutex.Lock()
+		delete(firewalled, ip)
+		mutex.Unlock()
+		return nil
+	})
+	isFirewalled := func(ip string) bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return firewalled[ip]
+	}
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	// Rejected every minute for just under an hour
+	for i := 0; i < 60; i++ {
+		b.exhausted("4.4.4.4")
+		b.exhausted("5.5.5.5")
+		now = now.Add(time.Minute)
+	}
+	time.Sleep(10 * time.Millisecond)
+	assert.False(t, isFirewalled("4.4.4.4"), "IP shouldn't be firewalled before being rejected for an hour")
+
+	b.exhausted("4.4.4.4")
+	time.Sleep(10 * time.Millisecond)
+	assert.True(t, isFirewalled("4.4.4.4"), "IP rejected for an hour should be firewalled")
+
+	// 5.5.5.5 takes a break that ends its streak
+	now = now.Add(rejectionStreakGap + time.Minute)
+	b.exhausted("5.5.5.5")
+	time.Sleep(10 * time.Millisecond)
+	assert.False(t, isFirewalled("5.5.5.5"), "IP whose rejections had a gap shouldn't be firewalled")
+	ips := b.blockedIps()
+	if assert.Len(t, ips, 1) {
+		assert.Equal(t, "4.4.4.4", ips[0].Ip)
+		assert.Equal(t, "firewall", ips[0].Rule)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	assert.False(t, isFirewalled("4.4.4.4"), "Firewall rule should have expired")
+	assert.Len(t, b.blockedIps(), 0, "Expired firewall rule shouldn't be listed")
+}
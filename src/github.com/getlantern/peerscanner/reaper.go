@@ -0,0 +1,53 @@
+package main
+
+import (
+	"time"
+)
+
+const (
+	// reaperInterval is how often the reaper looks for hosts whose run loops
+	// are gone
+	reaperInterval = 1 * time.Minute
+)
+
+var (
+	// heartbeatInterval is how often run loops let the reaper know that
+	// they're alive, even while they're waiting for something to do
+	heartbeatInterval = reaperInterval / 3
+)
+
+// heartbeat tells the reaper that h's run loop is alive.
+func (h *host) heartbeat() {
+	h.goroutineAlive.Store(true)
+}
+
+// startReaper removes the hosts from pool whose run loops haven't had a
+// heartbeat in a reaperInterval, which means that they exited and the
+// watchdog didn't restart them, or that they're stuck for good.
+func startReaper(pool *HostPool) {
+	go func() {
+		for range time.Tick(reaperInterval) {
+			pool.reap()
+		}
+	}()
+}
+
+// reap removes the hosts that didn't have a heartbeat since the last time and
+// returns them. Their records stay in CloudFlare, so that they're loaded
+// again on the next start.
+func (p *HostPool) reap() []*host {
+	var reaped []*host
+	for _, h := range p.all() {
+		if h.goroutineAlive.Swap(false) {
+			continue
+		}
+		log.Errorf("Run loop of %v had no heartbeat in %v, removing it", h, reaperInterval)
+		p.Remove(h.ip)
+		if h.runTicker != nil {
+			// Don't let the watchdog bring it back
+			hostWatchdog.Unregister(h.runTicker)
+		}
+		reaped = append(reaped, h)
+	}
+	return reaped
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/getlantern/peerscanner/watchdog"
+	"github.com/getlantern/testify/assert"
+)
+
+func TestReaperRemovesHostsWithDeadRunLoops(t *testing.T) {
+	oldHeartbeatInterval := heartbeatInterval
+	heartbeatInterval = 10 * time.Millisecond
+	defer func() { heartbeatInterval = oldHeartbeatInterval }()
+	m := newMockCfl()
+	defer m.close()
+
+	f := newFakeFallback("fl-us-alive")
+	defer f.close()
+	alive, _ := newTestHost("fl-us-alive", "45.63.21.1", f)
+	dead, _ := newTestHost("fl-us-dead", "45.63.21.2", f)
+	pool := newTestPool(alive, dead)
+
+	// The dead host's loop panics on its first check and the watchdog doesn't
+	// restart it
+	broken := GroupName("broken")
+	dead.cflGroups[broken] = nil
+	w := watchdog.New(10 * time.Millisecond)
+	w.Start()
+	dead.runTicker = w.Register("dead loop", func() {})
+	go dead.run()
+	go alive.run()
+	assert.True(t, waitUntil(func() bool { return dead.runTicker.Generation() == 1 }), "Dead host's run loop should have panicked and not been restarted")
+	assert.True(t, waitUntil(func() bool { return alive.getInfo().online }))
+
+	// The first cycle only resets the heartbeat that the dead loop left before
+	// it died
+	assert.Empty(t, pool.reap())
+	time.Sleep(5 * heartbeatInterval)
+	assert.Equal(t, []*host{dead}, pool.reap(), "Host without a heartbeat should be reaped")
+	assert.Nil(t, pool.hosts[dead.ip])
+	assert.Equal(t, alive, pool.hosts[alive.ip], "Host with a live run loop should be kept")
+
+	// Paused hosts have a heartbeat too
+	alive.unregister()
+	waitUntil(func() bool { return alive.getInfo().state == StatePaused })
+	pool.reap()
+	time.Sleep(5 * heartbeatInterval)
+	assert.Empty(t, pool.reap(), "Paused host should be kept")
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/getlantern/cloudflare"
+)
+
+var (
+	maxRecordAge = flag.Duration("max-record-age", 7*24*time.Hour, "Remove peer records older than this that don't belong to an active host when loading records, 0 means never, defaults to 7 days")
+)
+
+// removeStalePeerRecord removes the given peer record if it has been in
+// CloudFlare for more than -max-record-age and there's no host for its ip.
+// Peers aren't loaded as hosts, so nothing else would ever remove it.
+func removeStalePeerRecord(wg *sync.WaitGroup, r cloudflare.Record, hostsByIp map[string]*host, now time.Time) {
+	defer wg.Done()
+	if hostsByIp[r.Value] != nil {
+		return
+	}
+	created, err := cflutil.GetRecordCreationTime(r.Id)
+	if skippedForBudget(err, fmt.Sprintf("checking age of peer record %v", hostkey{r.Name, r.Value})) {
+		return
+	}
+	if err != nil {
+		log.Debugf("Unable to get age of peer record %v: %v", hostkey{r.Name, r.Value}, err)
+		return
+	}
+	age := now.Sub(created)
+	if age <= *maxRecordAge {
+		return
+	}
+	log.Debugf("Peer record %v is %v old and has no host, removing", hostkey{r.Name, r.Value}, age)
+	err = cflutil.DestroyRecord(&r)
+	if skippedForBudget(err, fmt.Sprintf("removing stale peer record %v", hostkey{r.Name, r.Value})) {
+		return
+	}
+	if err != nil {
+		log.Debugf("Unable to remove stale peer record %v: %v", hostkey{r.Name, r.Value}, err)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+)
+
+var (
+	// version is set at build time with -ldflags "-X main.version=<version>"
+	version = "development"
+
+	cfRecordComment = flag.String("cf-record-comment", "peerscanner/{version}@{hostname}", "Comment to put on every CloudFlare record we create, so that operators can tell which instance created it. {version} and {hostname} are replaced with ours, empty means no comment")
+)
+
+// expandRecordComment replaces {version} and {hostname} in the given
+// -cf-record-comment.
+func expandRecordComment(comment string) string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Debugf("Unable to get hostname for record comment: %v", err)
+		hostname = "unknown"
+	}
+	return strings.NewReplacer("{version}", version, "{hostname}", hostname).Replace(comment)
+}
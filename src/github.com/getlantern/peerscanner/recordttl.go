@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/getlantern/peerscanner/cfl"
+)
+
+// parseRecordTtl parses the TTL that a host requested for its records, which
+// must be one that CloudFlare accepts. If none was requested, it's up to
+// CloudFlare.
+func parseRecordTtl(s string) (int, error) {
+	if s == "" {
+		return cfl.AutoTtl, nil
+	}
+	ttl, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid ttl %v", s)
+	}
+	if !cfl.IsValidTtl(ttl) {
+		return 0, fmt.Errorf("Unsupported ttl %d, must be 1 (auto) or one of 120, 300, 600, 900, 1800, 3600, 7200, 18000, 43200 or 86400", ttl)
+	}
+	return ttl, nil
+}
+
+// setRecordTtl sets the TTL with which this host's records get created. Like
+// metadata, it doesn't belong to the run loop. Records that already exist
+// keep their TTL.
+func (h *host) setRecordTtl(ttl int) {
+	h.recordTtlMutex.Lock()
+	h.recordTtl = ttl
+	h.recordTtlMutex.Unlock()
+}
+
+func (h *host) getRecordTtl() int {
+	h.recordTtlMutex.RLock()
+	defer h.recordTtlMutex.RUnlock()
+	return h.recordTtl
+}
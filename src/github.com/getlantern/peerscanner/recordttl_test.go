@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/getlantern/peerscanner/cfl"
+	"github.com/getlantern/testify/assert"
+)
+
+func TestParseRecordTtl(t *testing.T) {
+	for s, expected := range map[string]int{
+		"":      cfl.AutoTtl,
+		"1":     1,
+		"120":   120,
+		"300":   300,
+		"86400": 86400,
+	} {
+		ttl, err := parseRecordTtl(s)
+		if assert.NoError(t, err, "ttl '%v' should be accepted", s) {
+			assert.Equal(t, expected, ttl)
+		}
+	}
+	for _, s := range []string{"0", "2", "119", "121", "301", "86399", "86401", "-1", "abc"} {
+		_, err := parseRecordTtl(s)
+		assert.Error(t, err, "ttl '%v' should be rejected", s)
+	}
+}
+
+func TestRegisteredTtlIsUsedForRecords(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+
+	fallback := newFakeFallback("fl-us-ttl")
+	defer fallback.close()
+	h, _ := newTestHost("fl-us-ttl", "45.63.7.1", fallback)
+	h.setRecordTtl(120)
+	h.check()
+	recs := m.FindRecords("fl-us-ttl", "45.63.7.1")
+	if assert.Len(t, recs, 1) {
+		assert.Equal(t, "120", recs[0].Ttl, "Record should have been created with requested ttl")
+	}
+	if assert.Len(t, m.FindRecords(string(RoundRobin), "45.63.7.1"), 1) {
+		assert.Equal(t, "120", m.FindRecords(string(RoundRobin), "45.63.7.1")[0].Ttl, "Rotation record should have been created with requested ttl")
+	}
+}
+
+func TestRegisterRejectsInvalidTtl(t *testing.T) {
+	req := newRegisterRequest("fl-us-badttl", "45.63.7.2", "443")
+	req.URL.RawQuery = "ttl=121"
+	rec := httptest.NewRecorder()
+	pool := NewHostPool()
+	webFor(pool).register(rec, req)
+	assert.Equal(t, 400, rec.Code, "Registration with invalid ttl should be rejected")
+	assert.Nil(t, pool.Get("45.63.7.2"), "Host shouldn't have been created")
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var (
+	// errHostNotRegistered is what Deregister returns for an unknown host
+	errHostNotRegistered = fmt.Errorf("Host not registered")
+
+	// These are what Register returns for hosts that it registered but that
+	// failed their check
+	errStatusTimedOut    = fmt.Errorf("Timed out waiting for status")
+	errConnectionRefused = fmt.Errorf("No connectivity to proxy - connection refused")
+	errNoConnectivity    = fmt.Errorf("No connectivity to proxy - test request timed out")
+)
+
+// HostRegistry is what the public HTTP endpoints need to register, deregister
+// and list hosts, so that they can be tested without CloudFlare.
+type HostRegistry interface {
+	// Register starts checking the host with the given name and ip, or resets
+	// it if we're already checking it, and waits for the result of its next
+	// check. Hosts that are registered but fail their check get
+	// errStatusTimedOut, errConnectionRefused or errNoConnectivity. Hosts that
+	// can't be registered at all get errHostLimitReached,
+	// errAPIBudgetExhausted or another error.
+	Register(name string, ip string, opts RegisterOpts) error
+
+	// Deregister takes the host with the given ip out of DNS. Hosts are
+	// deregistered by ip, name is only used for logging. Unknown hosts get
+	// errHostNotRegistered.
+	Deregister(name string, ip string) error
+
+	// List returns all registered hosts, ordered by name.
+	List() []HostInfo
+
+	// Healthy indicates whether the host with the given name and ip passed its
+	// last check.
+	Healthy(name string, ip string) bool
+}
+
+// RegisterOpts are the settings that hosts can register with, besides their
+// name and ip.
+type RegisterOpts struct {
+	Port      string
+	RecordTtl int
+	Sni       string
+	Weight    int
+	Metadata  map[string]string
+	Obfs4     *obfs4Config
+}
+
+// HostInfo describes a registered host.
+type HostInfo struct {
+	Name   string
+	Ip     string
+	Port   string
+	Online bool
+	Weight int
+	Sni    string
+}
+
+// CloudFlareHostRegistry is the HostRegistry that checks hosts and registers
+// the ones that pass in CloudFlare.
+type CloudFlareHostRegistry struct {
+	pool *HostPool
+}
+
+func NewCloudFlareHostRegistry(pool *HostPool) *CloudFlareHostRegistry {
+	return &CloudFlareHostRegistry{pool}
+}
+
+func (r *CloudFlareHostRegistry) Register(name string, ip string, opts RegisterOpts) error {
+	if r.pool.Get(ip) == nil && !cfBudget.Available(*registerCfWait) {
+		// New hosts will need their records created, reject them rather than
+		// queueing up their calls when there's no budget
+		return errAPIBudgetExhausted
+	}
+	h, err := r.pool.GetOrCreate(name, ip, opts)
+	if err != nil {
+		return err
+	}
+	online, connectionRefused, timedOut := h.status()
+	if opts.Metadata != nil {
+		// Only once we have the status, so that waiting for Redis doesn't
+		// make us miss the first check's
+		storeMetadata(name, ip, opts.Metadata)
+	}
+	switch {
+	case online:
+		return nil
+	case timedOut:
+		log.Debugf("%v timed out waiting for status", h)
+		return errStatusTimedOut
+	case connectionRefused:
+		return errConnectionRefused
+	default:
+		return errNoConnectivity
+	}
+}
+
+// setOpts sets the options that h registered with, other than its port, which
+// only new hosts take.
+func (h *host) setOpts(opts RegisterOpts) {
+	h.setRecordTtl(opts.RecordTtl)
+	h.setSni(opts.Sni)
+	if opts.Metadata != nil {
+		h.setMetadata(opts.Metadata)
+	}
+	h.setWeight(opts.Weight)
+	h.setObfs4(opts.Obfs4)
+}
+
+func (r *CloudFlareHostRegistry) Deregister(name string, ip string) error {
+	h := r.pool.Get(ip)
+	if h == nil {
+		return errHostNotRegistered
+	}
+	h.unregister()
+	return nil
+}
+
+func (r *CloudFlareHostRegistry) List() []HostInfo {
+	infos := r.pool.Snapshot()
+	sort.Sort(byName(infos))
+	result := make([]HostInfo, 0, len(infos))
+	for _, info := range infos {
+		result = append(result, info.export())
+	}
+	return result
+}
+
+func (r *CloudFlareHostRegistry) Healthy(name string, ip string) bool {
+	h := r.pool.Get(ip)
+	if h == nil {
+		return false
+	}
+	info := h.getInfo()
+	return info.name == name && info.online
+}
+
+// export converts info to a HostInfo
+func (info hostInfo) export() HostInfo {
+	return HostInfo{
+		Name:   info.name,
+		Ip:     info.ip,
+		Port:   info.port,
+		Online: info.online,
+		Weight: info.weight,
+		Sni:    info.sni,
+	}
+}
+
+// MemoryHostRegistry is a HostRegistry that keeps hosts in memory without
+// checking them or touching DNS, for tests. It is safe for concurrent use.
+type MemoryHostRegistry struct {
+	// Check, if set, is what Register lets a host's check return. Without it,
+	// all hosts pass.
+	Check func(name string, ip string) error
+
+	hosts map[string]HostInfo
+	mutex sync.Mutex
+}
+
+func NewMemoryHostRegistry() *MemoryHostRegistry {
+	return &MemoryHostRegistry{hosts: make(map[string]HostInfo)}
+}
+
+func (r *MemoryHostRegistry) Register(name string, ip string, opts RegisterOpts) error {
+	if err := validateHostKey(hostkey{name, ip}); err != nil {
+		return err
+	}
+	var checkErr error
+	if r.Check != nil {
+		checkErr = r.Check(name, ip)
+	}
+	r.mutex.Lock()
+	r.hosts[ip] = HostInfo{
+		Name:   name,
+		Ip:     ip,
+		Port:   opts.Port,
+		Online: checkErr == nil,
+		Weight: opts.Weight,
+		Sni:    opts.Sni,
+	}
+	r.mutex.Unlock()
+	return checkErr
+}
+
+func (r *MemoryHostRegistry) Deregister(name string, ip string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	info, found := r.hosts[ip]
+	if !found {
+		return errHostNotRegistered
+	}
+	info.Online = false
+	r.hosts[ip] = info
+	return nil
+}
+
+func (r *MemoryHostRegistry) List() []HostInfo {
+	r.mutex.Lock()
+	result := make([]HostInfo, 0, len(r.hosts))
+	for _, info := range r.hosts {
+		result = append(result, info)
+	}
+	r.mutex.Unlock()
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+func (r *MemoryHostRegistry) Healthy(name string, ip string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	info, found := r.hosts[ip]
+	return found && info.Name == name && info.Online
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestMemoryHostRegistry(t *testing.T) {
+	r := NewMemoryHostRegistry()
+	assert.Error(t, r.Register("fl-us-bad name", "45.63.30.1", RegisterOpts{}), "Invalid name should be rejected")
+
+	assert.NoError(t, r.Register("fl-us-memb", "45.63.30.2", RegisterOpts{Port: "443", Weight: 10}))
+	assert.NoError(t, r.Register("fl-us-mema", "45.63.30.3", RegisterOpts{Port: "80", Sni: "example.com"}))
+	r.Check = func(name string, ip string) error { return errConnectionRefused }
+	assert.Equal(t, errConnectionRefused, r.Register("fl-us-memc", "45.63.30.4", RegisterOpts{Port: "443"}))
+
+	assert.Equal(t, []HostInfo{
+		{Name: "fl-us-mema", Ip: "45.63.30.3", Port: "80", Online: true, Sni: "example.com"},
+		{Name: "fl-us-memb", Ip: "45.63.30.2", Port: "443", Online: true, Weight: 10},
+		{Name: "fl-us-memc", Ip: "45.63.30.4", Port: "443"},
+	}, r.List())
+	assert.True(t, r.Healthy("fl-us-memb", "45.63.30.2"))
+	assert.False(t, r.Healthy("fl-us-other", "45.63.30.2"), "Host with a different name isn't healthy")
+	assert.False(t, r.Healthy("fl-us-memc", "45.63.30.4"), "Host that failed its check isn't healthy")
+
+	assert.NoError(t, r.Deregister("fl-us-memb", "45.63.30.2"))
+	assert.False(t, r.Healthy("fl-us-memb", "45.63.30.2"), "Deregistered host isn't healthy")
+	assert.Equal(t, errHostNotRegistered, r.Deregister("fl-us-unknown", "45.63.30.5"))
+}
+
+func TestRegisterWithoutCloudFlare(t *testing.T) {
+	seenCache.Purge()
+	r := NewMemoryHostRegistry()
+	web := newWebHandlers(r)
+
+	rec := httptest.NewRecorder()
+	web.register(rec, newRegisterRequest("fl-us-nocf", "45.63.30.6", "443"))
+	assert.Equal(t, 200, rec.Code, "Registration should succeed")
+	assert.True(t, r.Healthy("fl-us-nocf", "45.63.30.6"), "Host should have been registered")
+
+	rec = httptest.NewRecorder()
+	web.register(rec, newRegisterRequest("fl-us-nocf", "45.63.30.6", "8080"))
+	assert.Equal(t, 400, rec.Code, "Unsupported port should be rejected")
+
+	results := map[error]int{
+		errStatusTimedOut:    500,
+		errConnectionRefused: 417,
+		errNoConnectivity:    408,
+		errHostLimitReached:  503,
+		fmt.Errorf("other"):  400,
+	}
+	i := 0
+	for err, code := range results {
+		err := err
+		r.Check = func(name string, ip string) error { return err }
+		rec = httptest.NewRecorder()
+		web.register(rec, newRegisterRequest(fmt.Sprintf("fl-us-nocf%d", i), fmt.Sprintf("45.63.31.%d", i+1), "443"))
+		assert.Equal(t, code, rec.Code, "Wrong response code for %v", err)
+		i++
+	}
+
+	rec = httptest.NewRecorder()
+	web.unregister(rec, newRegisterRequest("fl-us-nocf", "45.63.30.6", "443"))
+	assert.Equal(t, "Host unregistered\n", rec.Body.String())
+	assert.False(t, r.Healthy("fl-us-nocf", "45.63.30.6"), "Host should have been deregistered")
+	rec = httptest.NewRecorder()
+	web.unregister(rec, newRegisterRequest("fl-us-unknown", "45.63.30.7", "443"))
+	assert.Equal(t, "Host not registered\n", rec.Body.String())
+}
+
+func TestCloudFlareHostRegistry(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	name, ip := "fl-us-cfregistry", "45.63.30.8"
+	f := newFakeFallback(name)
+	defer f.close()
+	origDialer := defaultDialer
+	defer func() { defaultDialer = origDialer }()
+	d := &mockDialer{addr: f.addr()}
+	defaultDialer = d
+
+	pool := NewHostPool()
+	r := NewCloudFlareHostRegistry(pool)
+	assert.Error(t, r.Register("fl-us-bad name", ip, RegisterOpts{Port: "80"}), "Invalid name should be rejected")
+	if !assert.NoError(t, r.Register(name, ip, RegisterOpts{Port: "80", Weight: 50, Sni: "example.com"})) {
+		return
+	}
+	h := pool.Get(ip)
+	defer func() {
+		// Pause the host so that it stops using the mock CloudFlare
+		h.unregister()
+		waitUntil(func() bool { return h.getInfo().state == StatePaused })
+	}()
+	assert.True(t, waitUntil(func() bool { return r.Healthy(name, ip) }), "Host should become healthy")
+	assert.Len(t, m.FindRecords(name, ip), 1, "Host should have been registered in CloudFlare")
+	assert.False(t, r.Healthy("fl-us-other", ip), "Host with a different name isn't healthy")
+	assert.Equal(t, []HostInfo{{Name: name, Ip: ip, Port: "80", Online: true, Weight: 50, Sni: "example.com"}}, r.List())
+
+	assert.Equal(t, errHostNotRegistered, r.Deregister("fl-us-unknown", "45.63.30.9"))
+}
+
+// waitUntil waits up to 10 seconds for cond to become true and returns whether
+// it did.
+func waitUntil(cond func() bool) bool {
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
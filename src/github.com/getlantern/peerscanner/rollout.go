@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"sync"
+	"time"
+)
+
+var (
+	rollout         = flag.Bool("rollout", false, "Roll new fallbacks out gradually, keeping them in the staging group and raising the weight of their SRV records step by step before adding them to the rotations, needs -cf-srv, defaults to false")
+	rolloutSteps    = flag.Int("rollout-steps", 3, "Number of steps in which -rollout raises a new fallback's weight, defaults to 3")
+	rolloutInterval = flag.Duration("rollout-interval", time.Hour, "How long each -rollout step lasts, defaults to 1h")
+
+	// rollouts, if set, rolls out new fallbacks gradually (see -rollout)
+	rollouts *RolloutController
+)
+
+// RolloutController keeps track of how far along the rollout of each new
+// fallback is. A rollout starts when the fallback first passes its check, in
+// the staging group and without an SRV record, so without traffic. After
+// every interval, its SRV record's weight goes up by 1/steps of srvWeight,
+// and once it reaches srvWeight the fallback is promoted to its rotations. It
+// is safe for concurrent use.
+type RolloutController struct {
+	steps    int
+	interval time.Duration
+	// started is when the rollout of each ip started
+	started map[string]time.Time
+	mutex   sync.Mutex
+	now     func() time.Time
+}
+
+func NewRolloutController(steps int, interval time.Duration) *RolloutController {
+	return &RolloutController{steps: steps, interval: interval, started: make(map[string]time.Time), now: time.Now}
+}
+
+// step returns how far along the rollout of the host with the given ip is,
+// from 0 (staging) to c.steps (promoted). The rollout starts the first time
+// step is called for ip.
+func (c *RolloutController) step(ip string) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	step, found := c.currentStep(ip)
+	if !found {
+		log.Debugf("Starting rollout of %v in %d steps of %v", ip, c.steps, c.interval)
+		c.started[ip] = c.now()
+	}
+	return step
+}
+
+// scale scales weight down to how far along the rollout of the host with the
+// given ip is, so that /v1/peers doesn't send staging fallbacks traffic
+// either. Hosts whose rollout hasn't started are left alone.
+func (c *RolloutController) scale(ip string, weight int) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	step, found := c.currentStep(ip)
+	if !found {
+		return weight
+	}
+	return weight * step / c.steps
+}
+
+// currentStep returns the step that the rollout of ip is at, and whether it
+// has started at all. It needs c.mutex to be held.
+func (c *RolloutController) currentStep(ip string) (int, bool) {
+	started, found := c.started[ip]
+	if !found {
+		return 0, false
+	}
+	step := int(c.now().Sub(started) / c.interval)
+	if step > c.steps {
+		step = c.steps
+	}
+	return step, true
+}
+
+// promote treats the host with the given ip as fully rolled out, e.g.
+// because it was already in its rotations when we started.
+func (c *RolloutController) promote(ip string) {
+	c.mutex.Lock()
+	c.started[ip] = c.now().Add(-time.Duration(c.steps) * c.interval)
+	c.mutex.Unlock()
+}
+
+// rolloutWeight returns the weight that h's SRV record should have at this
+// point of its rollout, and whether it's been promoted to its rotations.
+// Without -rollout, hosts are promoted right away.
+func (h *host) rolloutWeight() (weight int, promoted bool) {
+	if rollouts == nil || !h.isFallback() {
+		return srvWeight, true
+	}
+	step := rollouts.step(h.ip)
+	return srvWeight * step / rollouts.steps, step >= rollouts.steps
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestRollout(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	defer withCfSrv(true)()
+	now := time.Now()
+	rollouts = NewRolloutController(3, time.Hour)
+	rollouts.now = func() time.Time { return now }
+	defer func() { rollouts = nil }()
+
+	name, ip := "fl-us-rollout", "45.63.9.1"
+	f := newFakeFallback(name)
+	defer f.close()
+	h, _ := newTestHost(name, ip, f)
+
+	// First check starts the rollout in staging, without traffic
+	h.check()
+	assert.Len(t, m.FindRecords(string(StagingGroup), ip), 1, "New fallback should be staging")
+	assert.Len(t, m.FindRecords(string(RoundRobin), ip), 0, "New fallback shouldn't be in round robin yet")
+	assert.Len(t, m.FindRecords("_lantern._tcp."+name, ""), 0, "Staging fallback shouldn't have an SRV record")
+	assert.False(t, listsRolloutHost(h), "Staging fallback shouldn't be listed in /v1/peers")
+
+	var weights []string
+	for step := 1; step <= 3; step++ {
+		now = now.Add(time.Hour)
+		h.check()
+		srvs := m.FindRecords("_lantern._tcp."+name, "")
+		if assert.Len(t, srvs, 1, "There should be one SRV record at step %d", step) {
+			weights = append(weights, srvs[0].Value)
+		}
+		// Checks within the same step don't touch the record
+		h.check()
+	}
+	assert.Equal(t, []string{
+		"10 3 80 fl-us-rollout.getiantem.org",
+		"10 6 80 fl-us-rollout.getiantem.org",
+		"10 10 80 fl-us-rollout.getiantem.org",
+	}, weights, "SRV record should have been recreated with increasing weights")
+	assert.Equal(t, 3, countSrvCreations(m), "SRV record should be created once per step")
+
+	assert.Len(t, m.FindRecords(string(RoundRobin), ip), 1, "Promoted fallback should be in round robin")
+	assert.Len(t, m.FindRecords(string(StagingGroup), ip), 0, "Promoted fallback should have left staging")
+	assert.True(t, listsRolloutHost(h), "Promoted fallback should be listed in /v1/peers")
+}
+
+func listsRolloutHost(h *host) bool {
+	rec := httptest.NewRecorder()
+	webFor(newTestPool(h)).listPeers(rec, httptest.NewRequest("GET", "/v1/peers", nil))
+	var result peersResponse
+	json.Unmarshal(rec.Body.Bytes(), &result)
+	return len(result.Fallbacks) == 1
+}
+
+func TestRolloutSkipsHostsAlreadyInRotation(t *testing.T) {
+	rollouts = NewRolloutController(3, time.Hour)
+	defer func() { rollouts = nil }()
+	rollouts.promote("45.63.9.2")
+	assert.Equal(t, 3, rollouts.step("45.63.9.2"))
+	assert.Equal(t, 0, rollouts.step("45.63.9.3"), "Unknown host should start rolling out")
+}
+
+func countSrvCreations(m *mockCfl) int {
+	n := 0
+	for _, r := range m.V4Requests() {
+		if r.Method == "POST" && r.Body["type"] == "SRV" {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+var (
+	sigSkew = flag.Duration("sig-skew", 60*time.Second, "How far the timestamp of a signed registration may be from now, defaults to 60s")
+
+	// peerSecret is the pre-shared secret with which peers sign their
+	// registrations. If it's not set, registrations don't need to be signed.
+	peerSecret = os.Getenv("PEERSCANNER_PEER_SECRET")
+)
+
+// signRegistration computes the signature for a registration of name at ip
+// made at the given unix timestamp.
+func signRegistration(secret string, name string, ip string, ts string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(name + ":" + ip + ":" + ts))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifySignature checks that sig is a valid signature for a registration of
+// name at ip made at ts, and that ts is within -sig-skew of now.
+func verifySignature(name string, ip string, sig string, ts string, now time.Time) error {
+	if peerSecret == "" {
+		return nil
+	}
+	if sig == "" || ts == "" {
+		return fmt.Errorf("Registration must be signed")
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("Invalid timestamp %v", ts)
+	}
+	skew := now.Sub(time.Unix(unix, 0))
+	if skew > *sigSkew || skew < -*sigSkew {
+		return fmt.Errorf("Timestamp %v is more than %v from now", ts, *sigSkew)
+	}
+	expected := signRegistration(peerSecret, name, ip, ts)
+	if !hmac.Equal([]byte(sig), []byte(expected)) {
+		return fmt.Errorf("Invalid signature")
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestVerifySignature(t *testing.T) {
+	defer withPeerSecret("psk")()
+	name, ip := "fl-us-signed", "45.63.5.1"
+	now := time.Now()
+	ts := strconv.FormatInt(now.Unix(), 10)
+
+	assert.NoError(t, verifySignature(name, ip, signRegistration("psk", name, ip, ts), ts, now), "Valid signature should be accepted")
+
+	old := strconv.FormatInt(now.Add(-*sigSkew-time.Second).Unix(), 10)
+	assert.Error(t, verifySignature(name, ip, signRegistration("psk", name, ip, old), old, now), "Expired timestamp should be rejected")
+	future := strconv.FormatInt(now.Add(*sigSkew+time.Second).Unix(), 10)
+	assert.Error(t, verifySignature(name, ip, signRegistration("psk", name, ip, future), future, now), "Future timestamp should be rejected")
+
+	assert.Error(t, verifySignature(name, ip, signRegistration("wrong", name, ip, ts), ts, now), "Signature with wrong secret should be rejected")
+	assert.Error(t, verifySignature(name, "45.63.5.2", signRegistration("psk", name, ip, ts), ts, now), "Signature for other ip should be rejected")
+	assert.Error(t, verifySignature(name, ip, "", "", now), "Missing signature should be rejected")
+
+	peerSecret = ""
+	assert.NoError(t, verifySignature(name, ip, "", "", now), "Signatures shouldn't be required without a secret")
+}
+
+func TestRegisterRejectsBadSignature(t *testing.T) {
+	defer withPeerSecret("psk")()
+	req := newRegisterRequest("fl-us-badsig", "45.63.5.3", "443")
+	req.URL.RawQuery = "sig=abcd&ts=" + strconv.FormatInt(time.Now().Unix(), 10)
+	rec := httptest.NewRecorder()
+	pool := NewHostPool()
+	webFor(pool).register(rec, req)
+	assert.Equal(t, 401, rec.Code, "Registration with wrong signature should be rejected")
+	assert.Nil(t, pool.Get("45.63.5.3"), "Host shouldn't have been created")
+}
+
+// withPeerSecret sets the peer secret, returning a function that restores the
+// original one.
+func withPeerSecret(secret string) func() {
+	orig := peerSecret
+	peerSecret = secret
+	return func() {
+		peerSecret = orig
+	}
+}
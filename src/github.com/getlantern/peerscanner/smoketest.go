@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	SmokeTestTCP         = "tcp"
+	SmokeTestHTTPConnect = "http-connect"
+	SmokeTestLantern     = "lantern-proxy"
+
+	// smokeTestTarget is where http-connect and lantern-proxy smoke tests ask
+	// the host to CONNECT to. The host doesn't actually need to reach it for
+	// the smoke test to pass, it just needs to accept the CONNECT.
+	smokeTestTarget = "www.google.com:443"
+)
+
+var (
+	smokeTestProtocol = flag.String("smoke-test-protocol", SmokeTestTCP, "How to smoke test hosts before adding them to rotations: tcp, http-connect or lantern-proxy, defaults to tcp")
+	smokeTestTimeout  = flag.Duration("smoke-test-timeout", 10*time.Second, "How long smoke tests may take, defaults to 10 seconds")
+)
+
+// SmokeTester checks that a host actually speaks the protocol that clients use
+// before it gets into rotations that serve traffic. Hosts that fail the smoke
+// test only stay in the Peers rotation, which makes them known without
+// sending traffic their way.
+type SmokeTester struct {
+	// Protocol is one of SmokeTestTCP, SmokeTestHTTPConnect and
+	// SmokeTestLantern
+	Protocol string
+	Timeout  time.Duration
+}
+
+// newSmokeTester creates a SmokeTester based on -smoke-test-protocol and
+// -smoke-test-timeout.
+func newSmokeTester() *SmokeTester {
+	return &SmokeTester{Protocol: *smokeTestProtocol, Timeout: *smokeTestTimeout}
+}
+
+// validateSmokeTestProtocol checks that protocol is one that SmokeTester
+// supports.
+func validateSmokeTestProtocol(protocol string) error {
+	switch protocol {
+	case SmokeTestTCP, SmokeTestHTTPConnect, SmokeTestLantern:
+		return nil
+	default:
+		return fmt.Errorf("Unsupported smoke test protocol %v, expected %v, %v or %v", protocol, SmokeTestTCP, SmokeTestHTTPConnect, SmokeTestLantern)
+	}
+}
+
+// Test smoke tests h at the port that it was found listening on.
+//
+//   - tcp just connects
+//   - http-connect sends an HTTP CONNECT and expects a 200
+//   - lantern-proxy does the same the way Lantern clients do, over TLS if the
+//     host listens on 443 and identifying itself with X-Lantern-Device-Id
+func (st *SmokeTester) Test(h *host) error {
+	if err := validateSmokeTestProtocol(st.Protocol); err != nil {
+		return err
+	}
+	port := h.port
+	if port == "" {
+		port = "80"
+	}
+	addr := h.ip + ":" + port
+	var conn net.Conn
+	var err error
+	if st.Protocol == SmokeTestLantern && port == "443" {
+		conn, err = h.dialTLS(context.Background(), addr, &tls.Config{ServerName: h.getSni(), InsecureSkipVerify: true})
+	} else {
+		conn, err = h.dial(context.Background(), addr)
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to connect to %v: %v", addr, err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			log.Debugf("Unable to close smoke test connection: %v", err)
+		}
+	}()
+	if st.Protocol == SmokeTestTCP {
+		return nil
+	}
+
+	if err := conn.SetDeadline(time.Now().Add(st.Timeout)); err != nil {
+		log.Debugf("Unable to set smoke test deadline: %v", err)
+	}
+	req, err := http.NewRequest("CONNECT", "http://"+smokeTestTarget, nil)
+	if err != nil {
+		return fmt.Errorf("Unable to create CONNECT request: %v", err)
+	}
+	req.Host = smokeTestTarget
+	if st.Protocol == SmokeTestLantern {
+		req.Header.Set("X-Lantern-Device-Id", "peerscanner")
+	}
+	if err := req.Write(conn); err != nil {
+		return fmt.Errorf("Unable to send CONNECT to %v: %v", addr, err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return fmt.Errorf("Unable to read CONNECT response from %v: %v", addr, err)
+	}
+	if err := resp.Body.Close(); err != nil {
+		log.Debugf("Unable to close CONNECT response body: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("CONNECT via %v returned unexpected status %d", addr, resp.StatusCode)
+	}
+	return nil
+}
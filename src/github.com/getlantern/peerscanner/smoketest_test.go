@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/getlantern/testify/assert"
+)
+
+// mockConnectProxy speaks just enough of the HTTP CONNECT protocol that
+// Lantern proxies use to answer smoke tests. It accepts CONNECTs that
+// identify a Lantern device and rejects everything else.
+type mockConnectProxy struct {
+	l net.Listener
+}
+
+func newMockConnectProxy(t *testing.T) *mockConnectProxy {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	p := &mockConnectProxy{l: l}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(conn)
+		}
+	}()
+	return p
+}
+
+func (p *mockConnectProxy) serve(conn net.Conn) {
+	defer conn.Close()
+	req, err := http.ReadRequest(bufio.NewReader(conn))
+	if err != nil {
+		return
+	}
+	status := http.StatusOK
+	if req.Method != "CONNECT" {
+		status = http.StatusMethodNotAllowed
+	} else if req.Header.Get("X-Lantern-Device-Id") == "" {
+		status = http.StatusForbidden
+	}
+	resp := &http.Response{StatusCode: status, ProtoMajor: 1, ProtoMinor: 1}
+	resp.Write(conn)
+}
+
+func (p *mockConnectProxy) addr() string {
+	return p.l.Addr().String()
+}
+
+func (p *mockConnectProxy) close() {
+	p.l.Close()
+}
+
+func TestSmokeTester(t *testing.T) {
+	p := newMockConnectProxy(t)
+	defer p.close()
+	h := mustNewHost("fl-us-smoke", "45.63.9.1", "80")
+	d := &mockDialer{addr: p.addr()}
+	h.dialer = d
+
+	test := func(protocol string) error {
+		return (&SmokeTester{Protocol: protocol, Timeout: time.Second}).Test(h)
+	}
+	assert.NoError(t, test(SmokeTestTCP), "Accepting connections should pass tcp smoke test")
+	assert.Error(t, test(SmokeTestHTTPConnect), "CONNECT that the proxy rejects should fail")
+	assert.NoError(t, test(SmokeTestLantern), "Lantern CONNECT should pass")
+	assert.Error(t, test("bogus"), "Unknown protocol should fail")
+
+	p.close()
+	assert.Error(t, test(SmokeTestTCP), "Host that doesn't accept connections should fail")
+}
+
+func TestFailedSmokeTestDemotesToPeers(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	defer withSmokeTestProtocol(SmokeTestLantern)()
+
+	name, ip := "fl-us-demoted", "45.63.9.2"
+	f := newFakeFallback(name)
+	defer f.close()
+	h, _ := newTestHost(name, ip, f)
+
+	// The fake fallback only speaks enproxy, so it passes its check but not a
+	// lantern-proxy smoke test
+	h.check()
+	assert.True(t, h.getInfo().online, "Host should pass its check")
+	assert.Len(t, m.FindRecords(string(Peers), ip), 1, "Host should be known in peers")
+	assert.Len(t, m.FindRecords(string(RoundRobin), ip), 0, "Host shouldn't be in round robin")
+	assert.Len(t, m.FindRecords(string(Fallbacks), ip), 0, "Host shouldn't be in fallbacks")
+
+	*smokeTestProtocol = SmokeTestTCP
+	h.check()
+	assert.Len(t, m.FindRecords(string(RoundRobin), ip), 1, "Host passing its smoke test should be promoted to round robin")
+
+	*smokeTestProtocol = SmokeTestLantern
+	h.check()
+	assert.Len(t, m.FindRecords(string(RoundRobin), ip), 0, "Host failing its smoke test should be demoted")
+	assert.Len(t, m.FindRecords(string(Peers), ip), 1, "Demoted host should stay in peers")
+}
+
+// withSmokeTestProtocol sets -smoke-test-protocol, returning a function that
+// restores the original value.
+func withSmokeTestProtocol(protocol string) func() {
+	orig := *smokeTestProtocol
+	*smokeTestProtocol = protocol
+	return func() {
+		*smokeTestProtocol = orig
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+)
+
+var (
+	sniPattern = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.)*[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+)
+
+// parseSni validates the SNI hostname that a host registered with, which
+// clients expect it to present during the TLS handshake. It must be a
+// hostname, not an IP.
+func parseSni(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	if !isHostname(s) {
+		return "", fmt.Errorf("Invalid sni %v, must be a hostname", s)
+	}
+	return s, nil
+}
+
+// isHostname indicates whether s is a valid hostname (and not an IP).
+func isHostname(s string) bool {
+	return len(s) <= 253 && net.ParseIP(s) == nil && sniPattern.MatchString(s)
+}
+
+// setSni sets the hostname that this host's TLS checks use as their
+// ServerName. Like metadata, it doesn't belong to the run loop.
+func (h *host) setSni(sni string) {
+	h.sniMutex.Lock()
+	h.sni = sni
+	h.sniMutex.Unlock()
+}
+
+func (h *host) getSni() string {
+	h.sniMutex.RLock()
+	defer h.sniMutex.RUnlock()
+	return h.sni
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestParseSni(t *testing.T) {
+	for _, sni := range []string{"", "cdn.example.com", "a-b.example.com", "localhost"} {
+		_, err := parseSni(sni)
+		assert.NoError(t, err, "%v should be accepted", sni)
+	}
+	for _, sni := range []string{"45.63.1.1", "-bad.example.com", "bad..example.com", "under_score.com", strings.Repeat("a.", 127) + "com"} {
+		_, err := parseSni(sni)
+		assert.Error(t, err, "%v should be rejected", sni)
+	}
+}
+
+func TestTLSCheckPresentsRegisteredSni(t *testing.T) {
+	serverNames := make(chan string, 10)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(200)
+	}))
+	server.TLS = &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverNames <- hello.ServerName
+			return nil, nil
+		},
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	h := mustNewHost("fl-us-sni", "45.63.5.1", "443")
+	h.dialer = &mockDialer{addr: server.Listener.Addr().String()}
+	h.setSni("cdn.example.com")
+	// The check itself fails because the server isn't a real fallback, but
+	// it gets as far as the TLS handshake.
+	h.doIsAbleToProxy(context.Background())
+
+	select {
+	case sni := <-serverNames:
+		assert.Equal(t, "cdn.example.com", sni, "TLS check should present the registered SNI")
+	default:
+		t.Fatal("TLS check never reached the server")
+	}
+}
+
+func TestListPeersIncludesSni(t *testing.T) {
+	h := onlineHost("fl-us-sni", "45.63.5.2", "443", true)
+	h.setSni("cdn.example.com")
+	pool := newTestPool(h, onlineHost("fl-us-nosni", "45.63.5.3", "443", true))
+
+	rec := httptest.NewRecorder()
+	webFor(pool).listPeers(rec, httptest.NewRequest("GET", "/v1/peers", nil))
+	var result map[string][]map[string]interface{}
+	if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result)) && assert.Len(t, result["fallbacks"], 2) {
+		fallbacks := peersByName(result["fallbacks"])
+		assert.Nil(t, fallbacks["fl-us-nosni"]["sni"], "Host without SNI shouldn't include one")
+		assert.Equal(t, "cdn.example.com", fallbacks["fl-us-sni"]["sni"])
+	}
+}
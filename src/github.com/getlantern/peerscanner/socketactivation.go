@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+var (
+	systemdSocketActivation = flag.Bool("systemd-socket-activation", os.Getenv("LISTEN_FDS") != "", "Serve on the socket that systemd passes us instead of listening on -port, so that systemd can hold it open while we restart, defaults to true if LISTEN_FDS is set")
+
+	// listenFdsStart is the first file descriptor that systemd passes
+	// (SD_LISTEN_FDS_START)
+	listenFdsStart = 3
+)
+
+// listen listens at laddr, or takes over the socket that systemd opened for
+// us if -systemd-socket-activation is set.
+func listen(laddr string) (net.Listener, error) {
+	if !*systemdSocketActivation {
+		return net.Listen("tcp", laddr)
+	}
+	l, err := systemdListener()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to use socket from systemd: %v", err)
+	}
+	log.Debugf("Using socket from systemd at %v instead of %v", l.Addr(), laddr)
+	return l, nil
+}
+
+// systemdListener returns a listener for the first socket that systemd passed
+// us, as described in sd_listen_fds(3). It unsets the LISTEN_* variables so
+// that processes we start don't think the sockets are for them.
+func systemdListener() (net.Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	if pid := os.Getenv("LISTEN_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return nil, fmt.Errorf("Sockets are for pid %v, not us", pid)
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, fmt.Errorf("No sockets passed, LISTEN_FDS is %q", os.Getenv("LISTEN_FDS"))
+	}
+	if fds > 1 {
+		log.Debugf("systemd passed %d sockets, only using the first", fds)
+	}
+	f := os.NewFile(uintptr(listenFdsStart), "LISTEN_FD_"+strconv.Itoa(listenFdsStart))
+	// FileListener dups the descriptor, so we can close ours
+	defer f.Close()
+	return net.FileListener(f)
+}
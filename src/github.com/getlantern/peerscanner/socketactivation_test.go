@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestSystemdSocketActivation(t *testing.T) {
+	// Stand in for systemd by opening a socket and passing on its descriptor
+	orig, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	addr := orig.Addr().String()
+	f, err := orig.(*net.TCPListener).File()
+	if !assert.NoError(t, err) {
+		return
+	}
+	orig.Close()
+	// listen closes the descriptor it's passed, so pass it a copy, or closing
+	// f later (or its finalizer) would close whatever reused the descriptor
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	origStart, origActivation := listenFdsStart, *systemdSocketActivation
+	defer func() {
+		listenFdsStart, *systemdSocketActivation = origStart, origActivation
+	}()
+	listenFdsStart = fd
+	*systemdSocketActivation = true
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	os.Setenv("LISTEN_FDS", "1")
+
+	l, err := listen(":62443")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer l.Close()
+	assert.Equal(t, addr, l.Addr().String(), "Should serve on the passed socket rather than -port")
+	assert.Empty(t, os.Getenv("LISTEN_FDS"), "LISTEN_FDS should be unset")
+
+	go http.Serve(l, http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(resp, "hello")
+	}))
+	resp, err := http.Get("http://" + addr)
+	if assert.NoError(t, err) {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		assert.Equal(t, "hello", string(body))
+	}
+}
+
+func TestSystemdSocketActivationWrongPid(t *testing.T) {
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	os.Setenv("LISTEN_FDS", "1")
+	_, err := systemdListener()
+	assert.Error(t, err, "Sockets meant for another process shouldn't be used")
+
+	_, err = systemdListener()
+	assert.Error(t, err, "Sockets shouldn't be used without LISTEN_FDS")
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+)
+
+const (
+	srvPriority = 10
+	srvWeight   = 10
+)
+
+var (
+	cfSrv = flag.Bool("cf-srv", false, "Also register a _lantern._tcp SRV record for every host, advertising the port it listens on, defaults to false")
+)
+
+// registerSrv creates this host's SRV record if -cf-srv is set and it doesn't
+// exist yet. While the host is rolling out (see -rollout), the record is
+// recreated whenever its weight goes up.
+func (h *host) registerSrv() error {
+	if !*cfSrv {
+		return nil
+	}
+	weight, _ := h.rolloutWeight()
+	if weight == 0 {
+		// Still staging, so no traffic
+		return h.deregisterSrv()
+	}
+	if h.srvRegistered && h.srvWeight == weight {
+		return nil
+	}
+	port, err := strconv.Atoi(h.port)
+	if err != nil {
+		log.Tracef("Port of %v not known yet, not registering SRV record", h)
+		return nil
+	}
+	if err := h.deregisterSrv(); err != nil {
+		return err
+	}
+	err = cflutil.CreateSRVRecord(h.name, h.name+"."+*cfldomain, port, srvPriority, weight)
+	if err != nil {
+		return err
+	}
+	h.srvRegistered = true
+	h.srvWeight = weight
+	return nil
+}
+
+// deregisterSrv destroys this host's SRV record, if we created it.
+func (h *host) deregisterSrv() error {
+	if !h.srvRegistered {
+		return nil
+	}
+	h.srvRegistered = false
+	return cflutil.DestroySRVRecord(h.name)
+}
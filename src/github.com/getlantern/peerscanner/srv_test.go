@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestCheckRegistersSrvRecord(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	defer withCfSrv(true)()
+
+	name, ip := "fl-us-srv", "45.63.8.1"
+	f := newFakeFallback(name)
+	defer f.close()
+	h, _ := newTestHost(name, ip, f)
+
+	h.check()
+	h.check()
+	srvs := m.FindRecords("_lantern._tcp."+name, "")
+	if assert.Len(t, srvs, 1, "Online host should have exactly one SRV record") {
+		assert.Equal(t, "10 10 80 fl-us-srv.getiantem.org", srvs[0].Value)
+	}
+
+	// Renaming the host removes the old SRV record
+	h.doReset("fl-us-srv2")
+	assert.Len(t, m.FindRecords("_lantern._tcp."+name, ""), 0, "Old SRV record should have been removed")
+}
+
+func TestCheckSkipsSrvRecordByDefault(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+
+	name, ip := "fl-us-nosrv", "45.63.8.2"
+	f := newFakeFallback(name)
+	defer f.close()
+	h, _ := newTestHost(name, ip, f)
+
+	h.check()
+	assert.Len(t, m.FindRecords("_lantern._tcp."+name, ""), 0, "SRV record shouldn't be created without -cf-srv")
+}
+
+// withCfSrv sets -cf-srv, returning a function that restores the original
+// value.
+func withCfSrv(enabled bool) func() {
+	orig := *cfSrv
+	*cfSrv = enabled
+	return func() {
+		*cfSrv = orig
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"expvar"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/getlantern/enproxy"
+)
+
+const (
+	// syntheticPeerChecks is how many checks the synthetic peer has to pass
+	// before it's deregistered again
+	syntheticPeerChecks = 5
+
+	// syntheticPeerIp is the ip that the synthetic peer is registered with.
+	// peerscanner reaches it on localhost no matter what, so this only ends up
+	// in DNS, and it's from TEST-NET-1 so that clients that happen to resolve
+	// it while it's there don't connect anywhere.
+	syntheticPeerIp = "192.0.2.1"
+
+	// syntheticPeerTimeout is how long the synthetic peer has to make it
+	// through its whole lifecycle
+	syntheticPeerTimeout = 5 * time.Minute
+)
+
+var (
+	syntheticPeer = flag.Bool("synthetic-peer", false, "Once started, smoke test the whole lifecycle of a host with a synthetic fallback served from a local HTTP server: register it, wait for it to appear in the round robin, deregister it after it passed 5 checks and report the result in the log and synthetic_peer_status, defaults to false")
+
+	// syntheticPeerStatus is one of running, passed or failed
+	syntheticPeerStatus = expvar.NewString("synthetic_peer_status")
+)
+
+// SyntheticPeer is a fallback that peerscanner registers with itself to smoke
+// test a deployment. It proxies to a local site through an enproxy proxy, both
+// listening on random localhost ports, and peerscanner's checks reach it there
+// whatever its ip. Its name is a generated GUID, prefixed with fl-synthetic-
+// so that it's treated like a fallback and added to the rotations, since
+// peers aren't.
+type SyntheticPeer struct {
+	name      string
+	site      net.Listener
+	proxy     net.Listener
+	proxyAddr string
+}
+
+// NewSyntheticPeer starts serving a new SyntheticPeer. Close it once done.
+func NewSyntheticPeer() (*SyntheticPeer, error) {
+	guid := make([]byte, 16)
+	if _, err := rand.Read(guid); err != nil {
+		return nil, fmt.Errorf("Unable to generate GUID: %v", err)
+	}
+	name := "fl-synthetic-" + hex.EncodeToString(guid)
+
+	site, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to listen for synthetic site: %v", err)
+	}
+	proxy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		site.Close()
+		return nil, fmt.Errorf("Unable to listen for synthetic proxy: %v", err)
+	}
+	p := &enproxy.Proxy{
+		Dial: func(addr string) (net.Conn, error) {
+			// Whatever site the check asks for, it gets ours
+			return net.Dial("tcp", site.Addr().String())
+		},
+		Host: name + "." + *cfldomain,
+	}
+	p.Start()
+	go http.Serve(site, http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(200)
+	}))
+	go http.Serve(proxy, p)
+	return &SyntheticPeer{name: name, site: site, proxy: proxy, proxyAddr: proxy.Addr().String()}, nil
+}
+
+// Close stops serving the synthetic peer.
+func (s *SyntheticPeer) Close() {
+	s.proxy.Close()
+	s.site.Close()
+}
+
+// Run registers the synthetic peer in pool, waits for it to be in the round
+// robin in CloudFlare, deregisters it once it passed syntheticPeerChecks
+// checks and waits for its records to be gone. It gives up after timeout.
+func (s *SyntheticPeer) Run(pool *HostPool, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	h, err := newHost(s.name, syntheticPeerIp, "80", nil)
+	if err != nil {
+		return err
+	}
+	h.dialer = dialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, network, s.proxyAddr)
+	})
+	if err := pool.Add(h); err != nil {
+		return err
+	}
+	log.Debugf("Registered synthetic peer %v, serving at %v", h, s.proxyAddr)
+
+	inRoundRobin := func() bool {
+		_, err := cflutil.FindRecord(string(RoundRobin), h.ip)
+		return err == nil
+	}
+	if !waitUntilDeadline(deadline, inRoundRobin) {
+		return fmt.Errorf("%v didn't appear in %v", h, RoundRobin)
+	}
+	log.Debugf("Synthetic peer %v is in %v", h, RoundRobin)
+	if !waitUntilDeadline(deadline, func() bool { return successfulChecks(h) >= syntheticPeerChecks }) {
+		return fmt.Errorf("%v passed only %d of %d checks", h, successfulChecks(h), syntheticPeerChecks)
+	}
+
+	h.unregister()
+	if !waitUntilDeadline(deadline, func() bool { return h.getInfo().state == StatePaused }) {
+		return fmt.Errorf("%v didn't pause after being unregistered", h)
+	}
+	pool.Remove(h.ip)
+	// Pausing leaves a host's own record in place for sticky routing, the
+	// synthetic peer doesn't need it
+	rec, err := cflutil.FindRecord(h.name, h.ip)
+	if err != nil {
+		return fmt.Errorf("Unable to find record of %v: %v", h, err)
+	}
+	if err := cflutil.DestroyRecordById(rec.Id); err != nil {
+		return fmt.Errorf("Unable to remove record of %v: %v", h, err)
+	}
+	if inRoundRobin() {
+		return fmt.Errorf("%v is still in %v after being unregistered", h, RoundRobin)
+	}
+	return nil
+}
+
+// successfulChecks counts the checks that h passed
+func successfulChecks(h *host) int {
+	passed := 0
+	for _, r := range h.healthHistory.snapshot() {
+		if r.success {
+			passed++
+		}
+	}
+	return passed
+}
+
+// waitUntilDeadline polls cond until it's true or deadline passes, returning
+// whether it became true.
+func waitUntilDeadline(deadline time.Time, cond func() bool) bool {
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(testPeriod / 10)
+	}
+	return true
+}
+
+// runSyntheticPeer runs a SyntheticPeer against pool in the background and
+// reports how it went.
+func runSyntheticPeer(pool *HostPool) {
+	syntheticPeerStatus.Set("running")
+	go func() {
+		s, err := NewSyntheticPeer()
+		if err == nil {
+			defer s.Close()
+			err = s.Run(pool, syntheticPeerTimeout)
+		}
+		if err != nil {
+			syntheticPeerStatus.Set("failed")
+			log.Errorf("Synthetic peer failed: %v", err)
+			return
+		}
+		syntheticPeerStatus.Set("passed")
+		log.Debugf("Synthetic peer passed")
+	}()
+}
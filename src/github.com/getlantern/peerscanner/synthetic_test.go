@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestSyntheticPeer(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	defer withDrainTime(0)()
+	origPeriod := testPeriod
+	defer func() { testPeriod = origPeriod }()
+	testPeriod = 50 * time.Millisecond
+
+	s, err := NewSyntheticPeer()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer s.Close()
+	pool := NewHostPool()
+	assert.NoError(t, s.Run(pool, 30*time.Second), "Synthetic peer should make it through its lifecycle")
+	assert.Len(t, m.FindRecords(string(RoundRobin), syntheticPeerIp), 0, "Synthetic peer should have left round robin")
+	assert.Len(t, m.FindRecords(s.name, syntheticPeerIp), 0, "Synthetic peer's own record should have been removed")
+	assert.Nil(t, pool.Get(syntheticPeerIp), "Synthetic peer should have been removed from the pool")
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var (
+	trustedProxies = flag.String("trusted-proxies", "", "(optional) comma separated CIDR ranges of reverse proxies in front of peerscanner, whose X-Forwarded-For headers are used to find the ips of registering hosts")
+
+	// trustedProxyNets are the parsed -trusted-proxies
+	trustedProxyNets []*net.IPNet
+)
+
+// parseTrustedProxies parses a comma separated list of CIDR ranges. Single
+// ips are treated as ranges of one.
+func parseTrustedProxies(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range strings.Split(s, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid CIDR range %v: %v", cidr, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// isTrustedProxy indicates whether ip falls within one of the
+// trustedProxyNets.
+func isTrustedProxy(ip net.IP) bool {
+	for _, n := range trustedProxyNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxiedClientIp finds the ip of the client behind the trusted proxy that
+// sent req. Since each proxy appends the address it got the request from to
+// X-Forwarded-For, the client is the rightmost entry that isn't itself a
+// trusted proxy; anything to the left of that could have been made up by the
+// client. fromProxy is false if req didn't come from a trusted proxy, in
+// which case the caller should determine the ip itself. It fails if the
+// header is missing or malformed.
+func proxiedClientIp(req *http.Request) (ip string, fromProxy bool, err error) {
+	if len(trustedProxyNets) == 0 {
+		return "", false, nil
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	source := net.ParseIP(host)
+	if source == nil || !isTrustedProxy(source) {
+		return "", false, nil
+	}
+
+	header := req.Header.Get("X-Forwarded-For")
+	if header == "" {
+		return "", true, fmt.Errorf("Request from proxy %v is missing X-Forwarded-For", host)
+	}
+	entries := strings.Split(header, ",")
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := strings.TrimSpace(entries[i])
+		parsed := net.ParseIP(entry)
+		if parsed == nil {
+			return "", true, fmt.Errorf("Invalid ip %q in X-Forwarded-For", entry)
+		}
+		if !isTrustedProxy(parsed) {
+			return parsed.String(), true, nil
+		}
+	}
+	return "", true, fmt.Errorf("X-Forwarded-For only contains trusted proxies: %v", header)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func withTrustedProxies(t *testing.T, cidrs string) func() {
+	orig := trustedProxyNets
+	var err error
+	trustedProxyNets, err = parseTrustedProxies(cidrs)
+	assert.NoError(t, err)
+	return func() { trustedProxyNets = orig }
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	nets, err := parseTrustedProxies("10.0.0.0/8, 192.168.1.1,fd00::/8")
+	if assert.NoError(t, err) && assert.Len(t, nets, 3) {
+		assert.Equal(t, "10.0.0.0/8", nets[0].String())
+		assert.Equal(t, "192.168.1.1/32", nets[1].String())
+		assert.Equal(t, "fd00::/8", nets[2].String())
+	}
+	_, err = parseTrustedProxies("10.0.0.0/33")
+	assert.Error(t, err)
+	nets, err = parseTrustedProxies("")
+	assert.NoError(t, err)
+	assert.Len(t, nets, 0)
+}
+
+func TestClientIpDirectConnection(t *testing.T) {
+	defer withTrustedProxies(t, "10.0.0.0/8")()
+	req := newRegisterRequest("fl-us-direct", "45.63.5.1", "443")
+	ip, err := clientIpFor(req, "fl-us-direct")
+	assert.NoError(t, err)
+	assert.Equal(t, "45.63.5.1", ip, "Direct connections should use the remote address")
+}
+
+func TestClientIpSingleHopProxy(t *testing.T) {
+	defer withTrustedProxies(t, "10.0.0.0/8")()
+	req := newRegisterRequest("fl-us-proxied", "10.0.0.2", "443")
+	req.Header.Set("X-Forwarded-For", "45.63.5.2")
+	ip, err := clientIpFor(req, "fl-us-proxied")
+	assert.NoError(t, err)
+	assert.Equal(t, "45.63.5.2", ip)
+}
+
+func TestClientIpMultiHopProxy(t *testing.T) {
+	defer withTrustedProxies(t, "10.0.0.0/8,192.168.0.0/16")()
+	req := newRegisterRequest("fl-us-multihop", "10.0.0.2", "443")
+	// The leftmost entry is spoofed by the client, the rightmost entries were
+	// added by our own proxies.
+	req.Header.Set("X-Forwarded-For", "45.63.5.99, 45.63.5.3, 192.168.0.7, 10.1.1.1")
+	ip, err := clientIpFor(req, "fl-us-multihop")
+	assert.NoError(t, err)
+	assert.Equal(t, "45.63.5.3", ip, "Rightmost untrusted entry should be used")
+}
+
+func TestRegisterThroughProxyRejectsBadForwardedFor(t *testing.T) {
+	defer withTrustedProxies(t, "10.0.0.0/8")()
+	pool := NewHostPool()
+	for _, header := range []string{"", "not-an-ip", "45.63.5.4, bogus", "10.0.0.3"} {
+		req := newRegisterRequest("fl-us-badxff", "10.0.0.2", "443")
+		if header != "" {
+			req.Header.Set("X-Forwarded-For", header)
+		}
+		rec := httptest.NewRecorder()
+		webFor(pool).register(rec, req)
+		assert.Equal(t, 400, rec.Code, "X-Forwarded-For '%v' should be rejected", header)
+	}
+	assert.Equal(t, 0, pool.Len(), "No hosts should have been created")
+}
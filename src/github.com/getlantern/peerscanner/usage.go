@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// envVar documents an environment variable that peerscanner reads
+type envVar struct {
+	name        string
+	description string
+	required    bool
+}
+
+var (
+	envVars = []envVar{
+		{"CFL_ID", "CloudFlare account email", true},
+		{"CFL_KEY", "CloudFlare API key, unless both are read from Vault", true},
+		{"VAULT_ADDR", "Address of a Vault to read CFL_ID and CFL_KEY from when CFL_KEY isn't set", false},
+		{"VAULT_TOKEN", "Token with which to read from VAULT_ADDR", false},
+		{"VAULT_SECRET_PATH", "Path of the Vault secret with CFL_ID and CFL_KEY in its data, e.g. secret/peerscanner", false},
+		{"PEERSCANNER_ENV", "Environment whose bundled defaults to use: production (the default), staging or test", false},
+		{"PEERSCANNER_TAGS", "Comma-separated key=value tags attached to the records we create (see -require-tags)", false},
+		{"PEERSCANNER_ADMIN_KEY", "Key that admin endpoints expect in the X-Admin-Key header, admin endpoints are disabled without it", false},
+		{"PEERSCANNER_PEER_SECRET", "Secret with which hosts sign their registrations, signatures aren't checked without it", false},
+	}
+)
+
+func init() {
+	flag.Usage = usage
+}
+
+// usage prints the flags and the environment variables that peerscanner reads.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage of %v:\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprintln(out, "\nEnvironment variables:")
+	for _, v := range envVars {
+		required := "optional"
+		if v.required {
+			required = "required"
+		}
+		fmt.Fprintf(out, "  %v (%v)\n    \t%v\n", v.name, required, v.description)
+	}
+}
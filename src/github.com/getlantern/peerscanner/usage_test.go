@@ -0,0 +1,25 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestUsageListsEnvVars(t *testing.T) {
+	var buf bytes.Buffer
+	flag.CommandLine.SetOutput(&buf)
+	defer flag.CommandLine.SetOutput(os.Stderr)
+
+	flag.Usage()
+	out := buf.String()
+	assert.Contains(t, out, "-cfldomain", "Usage should list flags")
+	for _, v := range envVars {
+		assert.Contains(t, out, v.name, "Usage should document %v", v.name)
+	}
+	assert.Contains(t, out, "CFL_KEY (required)")
+	assert.Contains(t, out, "PEERSCANNER_TAGS (optional)")
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// vaultRefreshAt is how far into its lease we read a secret again
+	vaultRefreshAt = 0.8
+	// vaultDefaultRefresh is how often we read secrets without a lease, e.g.
+	// from a KV version 2 engine, so that rotated credentials get picked up
+	vaultDefaultRefresh = 1 * time.Hour
+)
+
+var (
+	// vaultCreds reads CFL_ID and CFL_KEY from Vault when CFL_KEY isn't set
+	// but VAULT_ADDR is (see parseFlags), nil otherwise
+	vaultCreds *vaultCredentials
+)
+
+// vaultCredentials reads the CloudFlare credentials from the secret at path
+// in the Vault at addr, caching them until their lease nears its end. The
+// secret's data holds the email in CFL_ID and the key in CFL_KEY, like the
+// environment variables. It is safe for concurrent use.
+type vaultCredentials struct {
+	addr   string
+	token  string
+	path   string
+	client *http.Client
+	now    func() time.Time
+
+	user      string
+	key       string
+	refreshAt time.Time
+	mutex     sync.Mutex
+}
+
+func newVaultCredentials(addr string, token string, path string) (*vaultCredentials, error) {
+	if token == "" || path == "" {
+		return nil, fmt.Errorf("VAULT_ADDR needs VAULT_TOKEN and VAULT_SECRET_PATH")
+	}
+	return &vaultCredentials{
+		addr:   strings.TrimSuffix(addr, "/"),
+		token:  token,
+		path:   strings.Trim(path, "/"),
+		client: &http.Client{Timeout: 30 * time.Second},
+		now:    time.Now,
+	}, nil
+}
+
+// useVaultCredentials has peerscanner read the CloudFlare credentials from
+// the secret at VAULT_SECRET_PATH in the Vault at VAULT_ADDR, using
+// VAULT_TOKEN, instead of CFL_ID and CFL_KEY.
+func useVaultCredentials() error {
+	v, err := newVaultCredentials(os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN"), os.Getenv("VAULT_SECRET_PATH"))
+	if err != nil {
+		return err
+	}
+	vaultCreds = v
+	cflid, cflkey, err = readCFCredsFromVault()
+	return err
+}
+
+// readCFCredsFromVault returns the CloudFlare email and key from vaultCreds.
+// It's the cfl.CredentialSource of cflutil when they're read from Vault.
+func readCFCredsFromVault() (user string, key string, err error) {
+	return vaultCreds.get()
+}
+
+// get returns the cached credentials, reading them again if their lease is
+// nearly up. If that fails while there are cached ones, it keeps using them
+// and tries again next time.
+func (v *vaultCredentials) get() (string, string, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	now := v.now()
+	if v.key != "" && now.Before(v.refreshAt) {
+		return v.user, v.key, nil
+	}
+	user, key, lease, err := v.read()
+	if err != nil {
+		if v.key != "" {
+			log.Errorf("WARNING: Unable to refresh CloudFlare credentials from Vault, using the cached ones: %v", err)
+			return v.user, v.key, nil
+		}
+		return "", "", err
+	}
+	refresh := vaultDefaultRefresh
+	if lease > 0 {
+		refresh = time.Duration(float64(lease) * vaultRefreshAt)
+	}
+	v.user, v.key, v.refreshAt = user, key, now.Add(refresh)
+	log.Debugf("Read CloudFlare credentials from Vault, reading them again in %v", refresh)
+	return user, key, nil
+}
+
+// vaultSecret is a response from Vault's secret API
+type vaultSecret struct {
+	LeaseDuration int                    `json:"lease_duration"`
+	Data          map[string]interface{} `json:"data"`
+	Errors        []string               `json:"errors"`
+}
+
+// read reads the credentials and their lease from Vault.
+func (v *vaultCredentials) read() (string, string, time.Duration, error) {
+	req, err := http.NewRequest("GET", v.addr+"/v1/"+v.path, nil)
+	if err != nil {
+		return "", "", 0, err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("Unable to read %v from Vault: %v", v.path, err)
+	}
+	defer resp.Body.Close()
+	var secret vaultSecret
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil && resp.StatusCode == http.StatusOK {
+		return "", "", 0, fmt.Errorf("Unable to decode %v from Vault: %v", v.path, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", "", 0, fmt.Errorf("Unable to read %v from Vault: %v %v", v.path, resp.Status, strings.Join(secret.Errors, ", "))
+	}
+	data := secret.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		// KV version 2 nests the secret in data
+		data = nested
+	}
+	user, _ := data["CFL_ID"].(string)
+	key, _ := data["CFL_KEY"].(string)
+	if user == "" || key == "" {
+		return "", "", 0, fmt.Errorf("Vault secret %v needs CFL_ID and CFL_KEY", v.path)
+	}
+	return user, key, time.Duration(secret.LeaseDuration) * time.Second, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getlantern/testify/assert"
+)
+
+// mockVault serves a single secret at /v1/secret/peerscanner
+type mockVault struct {
+	*httptest.Server
+	status int
+	secret map[string]interface{}
+	reads  int
+	mutex  sync.Mutex
+}
+
+func newMockVault(lease int, data map[string]interface{}) *mockVault {
+	v := &mockVault{status: http.StatusOK}
+	v.set(lease, data)
+	v.Server = httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		v.mutex.Lock()
+		defer v.mutex.Unlock()
+		v.reads++
+		if req.Header.Get("X-Vault-Token") != "s.token" {
+			resp.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(resp).Encode(map[string]interface{}{"errors": []string{"permission denied"}})
+			return
+		}
+		if req.URL.Path != "/v1/secret/peerscanner" {
+			resp.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(resp).Encode(map[string]interface{}{"errors": []string{}})
+			return
+		}
+		resp.WriteHeader(v.status)
+		json.NewEncoder(resp).Encode(v.secret)
+	}))
+	return v
+}
+
+func (v *mockVault) set(lease int, data map[string]interface{}) {
+	v.mutex.Lock()
+	v.secret = map[string]interface{}{"lease_duration": lease, "data": data}
+	v.mutex.Unlock()
+}
+
+func (v *mockVault) setStatus(status int) {
+	v.mutex.Lock()
+	v.status = status
+	v.mutex.Unlock()
+}
+
+func (v *mockVault) readCount() int {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.reads
+}
+
+func TestVaultCredentialsRefreshNearLeaseEnd(t *testing.T) {
+	v := newMockVault(100, map[string]interface{}{"CFL_ID": "ops@example.com", "CFL_KEY": "key1"})
+	defer v.Close()
+	creds, err := newVaultCredentials(v.URL+"/", "s.token", "/secret/peerscanner")
+	if !assert.NoError(t, err) {
+		return
+	}
+	now := time.Now()
+	creds.now = func() time.Time { return now }
+
+	user, key, err := creds.get()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "ops@example.com", user)
+		assert.Equal(t, "key1", key)
+	}
+
+	v.set(100, map[string]interface{}{"CFL_ID": "ops@example.com", "CFL_KEY": "key2"})
+	now = now.Add(79 * time.Second)
+	_, key, _ = creds.get()
+	assert.Equal(t, "key1", key, "Credentials should be cached early in their lease")
+	assert.Equal(t, 1, v.readCount())
+
+	now = now.Add(2 * time.Second)
+	_, key, _ = creds.get()
+	assert.Equal(t, "key2", key, "Credentials should be read again near the end of their lease")
+	assert.Equal(t, 2, v.readCount())
+
+	v.setStatus(http.StatusServiceUnavailable)
+	now = now.Add(time.Hour)
+	_, key, err = creds.get()
+	assert.NoError(t, err, "Failed refresh should fall back to cached credentials")
+	assert.Equal(t, "key2", key)
+	assert.Equal(t, 3, v.readCount())
+}
+
+func TestVaultCredentialsKVv2(t *testing.T) {
+	v := newMockVault(0, map[string]interface{}{
+		"data":     map[string]interface{}{"CFL_ID": "ops@example.com", "CFL_KEY": "key1"},
+		"metadata": map[string]interface{}{"version": 3},
+	})
+	defer v.Close()
+	creds, _ := newVaultCredentials(v.URL, "s.token", "secret/peerscanner")
+	now := time.Now()
+	creds.now = func() time.Time { return now }
+	_, key, err := creds.get()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "key1", key)
+	}
+	now = now.Add(vaultDefaultRefresh - time.Second)
+	creds.get()
+	assert.Equal(t, 1, v.readCount(), "Secret without a lease should be cached for vaultDefaultRefresh")
+}
+
+func TestVaultCredentialsErrors(t *testing.T) {
+	_, err := newVaultCredentials("http://127.0.0.1:8200", "", "secret/peerscanner")
+	assert.Error(t, err, "Missing token should be rejected")
+
+	v := newMockVault(100, map[string]interface{}{"CFL_ID": "ops@example.com"})
+	defer v.Close()
+	creds, _ := newVaultCredentials(v.URL, "s.token", "secret/peerscanner")
+	_, _, err = creds.get()
+	assert.Error(t, err, "Secret without CFL_KEY should be rejected")
+
+	creds, _ = newVaultCredentials(v.URL, "s.wrong", "secret/peerscanner")
+	_, _, err = creds.get()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "permission denied")
+	}
+}
+
+func TestUseVaultCredentials(t *testing.T) {
+	v := newMockVault(100, map[string]interface{}{"CFL_ID": "ops@example.com", "CFL_KEY": "key1"})
+	defer v.Close()
+	origId, origKey, origCreds := cflid, cflkey, vaultCreds
+	defer func() { cflid, cflkey, vaultCreds = origId, origKey, origCreds }()
+	for k, val := range map[string]string{"VAULT_ADDR": v.URL, "VAULT_TOKEN": "s.token", "VAULT_SECRET_PATH": "secret/peerscanner"} {
+		os.Setenv(k, val)
+		defer os.Unsetenv(k)
+	}
+
+	if assert.NoError(t, useVaultCredentials()) {
+		assert.Equal(t, "ops@example.com", cflid)
+		assert.Equal(t, "key1", cflkey)
+		user, key, err := readCFCredsFromVault()
+		if assert.NoError(t, err) {
+			assert.Equal(t, "ops@example.com", user)
+			assert.Equal(t, "key1", key)
+		}
+		assert.Equal(t, 1, v.readCount(), "Credentials should have been cached")
+	}
+}
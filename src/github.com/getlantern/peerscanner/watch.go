@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"time"
+)
+
+var (
+	cfWatchInterval = flag.Duration("cf-watch-interval", 2*time.Minute, "How often to check CloudFlare's audit logs for records changed outside of peerscanner, 0 disables checking, defaults to 2 minutes")
+)
+
+// startRecordWatcher starts watching for records that were changed outside of
+// peerscanner, unless that's disabled.
+func startRecordWatcher(pool *HostPool) {
+	if *cfWatchInterval <= 0 {
+		log.Debug("Not watching for external record changes")
+		return
+	}
+	cflutil.NewRecordWatcher(*cfWatchInterval, pool.onExternalChange).Start()
+}
+
+// onExternalChange reconciles the records with the given name after they were
+// changed outside of peerscanner, by resyncing the hosts they belong to.
+func (p *HostPool) onExternalChange(name string) {
+	externalChanges.Add(1)
+	g, isGroup := groupNameFor(name)
+	var affected []*host
+	for _, h := range p.all() {
+		info := h.getInfo()
+		if info.name == name || (isGroup && isFallback(info.name) && inGroup(info.name, g)) {
+			affected = append(affected, h)
+		}
+	}
+
+	if len(affected) == 0 {
+		log.Debugf("No hosts affected by external change to %v", name)
+		return
+	}
+	log.Debugf("%v was changed externally, resyncing %d hosts", name, len(affected))
+	for _, h := range affected {
+		h.resync()
+	}
+}
+
+// inGroup indicates whether the fallback with the given name belongs to the
+// rotation g.
+func inGroup(name string, g GroupName) bool {
+	for _, valid := range ValidGroupNames() {
+		if g == valid {
+			return true
+		}
+	}
+	return fallbackCountry(name) == g
+}
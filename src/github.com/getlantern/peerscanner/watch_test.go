@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestOnExternalChangeResyncsAffectedHosts(t *testing.T) {
+	us := onlineHost("fl-us-watch", "45.63.7.1", "443", true)
+	de := onlineHost("fl-de-watch", "45.63.7.2", "443", true)
+	pool := newTestPool(us, de)
+
+	resynced := func(h *host) bool {
+		select {
+		case <-h.resyncCh:
+			return true
+		default:
+			return false
+		}
+	}
+
+	orig := externalChanges.Value()
+	pool.onExternalChange("fl-us-watch")
+	assert.True(t, resynced(us), "Changed host should be resynced")
+	assert.False(t, resynced(de), "Other host shouldn't be resynced")
+
+	pool.onExternalChange("de.fallbacks")
+	assert.False(t, resynced(us), "Host outside of the changed rotation shouldn't be resynced")
+	assert.True(t, resynced(de), "Host in the changed rotation should be resynced")
+
+	pool.onExternalChange(string(RoundRobin))
+	assert.True(t, resynced(us), "All fallbacks are in round robin")
+	assert.True(t, resynced(de), "All fallbacks are in round robin")
+
+	pool.onExternalChange("unrelated")
+	assert.Equal(t, orig+4, externalChanges.Value(), "Every external change should be counted")
+}
@@ -0,0 +1,190 @@
+// Package watchdog restarts goroutines that have stopped making progress.
+//
+// A goroutine registers with a Watchdog and is handed a Ticker, which it
+// resets every time it goes around its loop. If a Ticker isn't reset before
+// its timeout runs out, the Watchdog calls its restart function and bumps its
+// generation, so that the stuck goroutine knows to exit should it ever get
+// unstuck.
+package watchdog
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/getlantern/golog"
+)
+
+var (
+	log = golog.LoggerFor("watchdog")
+)
+
+// Watchdog watches a set of Tickers, sweeping them once per interval. It is
+// safe for concurrent use.
+type Watchdog struct {
+	interval time.Duration
+	tickers  map[*Ticker]bool
+	mutex    sync.Mutex
+	// lastSweep is when the last sweep finished, in unix nanos
+	lastSweep int64
+	now       func() time.Time
+}
+
+// New creates a Watchdog that checks its Tickers every interval. It doesn't
+// do anything until it's started.
+func New(interval time.Duration) *Watchdog {
+	w := &Watchdog{
+		interval: interval,
+		tickers:  make(map[*Ticker]bool),
+		now:      time.Now,
+	}
+	w.markSwept()
+	return w
+}
+
+// Register starts watching a new Ticker identified by name, which calls
+// restart whenever it runs out. The Ticker isn't watched until its first
+// Reset.
+func (w *Watchdog) Register(name string, restart func()) *Ticker {
+	t := &Ticker{name: name, restart: restart, now: w.now}
+	w.mutex.Lock()
+	w.tickers[t] = true
+	w.mutex.Unlock()
+	return t
+}
+
+// Unregister stops watching t.
+func (w *Watchdog) Unregister(t *Ticker) {
+	w.mutex.Lock()
+	delete(w.tickers, t)
+	w.mutex.Unlock()
+}
+
+// Start starts sweeping in the background. Calling it again, e.g. because
+// the watchdog isn't Healthy, starts another sweeper.
+func (w *Watchdog) Start() {
+	go w.run()
+}
+
+// Healthy indicates whether the watchdog has swept within the last two
+// intervals.
+func (w *Watchdog) Healthy() bool {
+	return w.now().Sub(w.LastSweep()) < 2*w.interval
+}
+
+// LastSweep returns when the watchdog last finished going through its
+// Tickers.
+func (w *Watchdog) LastSweep() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&w.lastSweep))
+}
+
+func (w *Watchdog) run() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		w.sweep()
+	}
+}
+
+// sweep restarts all Tickers that have run out.
+func (w *Watchdog) sweep() {
+	now := w.now()
+	w.mutex.Lock()
+	expired := make(map[*Ticker]string)
+	for t := range w.tickers {
+		if reason, ok := t.expire(now); ok {
+			expired[t] = reason
+		}
+	}
+	w.mutex.Unlock()
+
+	for t, reason := range expired {
+		log.Errorf("WARNING: %v", reason)
+		if err := t.doRestart(); err != nil {
+			log.Errorf("Unable to restart %v: %v", t.name, err)
+		}
+	}
+	w.markSwept()
+}
+
+func (w *Watchdog) markSwept() {
+	atomic.StoreInt64(&w.lastSweep, w.now().UnixNano())
+}
+
+// Ticker tracks the progress of a single goroutine. Its zero deadline means
+// that it isn't being watched.
+type Ticker struct {
+	name    string
+	restart func()
+	now     func() time.Time
+
+	mutex      sync.Mutex
+	deadline   time.Time
+	timeout    time.Duration
+	generation int
+}
+
+// Reset gives the goroutine another timeout to go around its loop.
+func (t *Ticker) Reset(timeout time.Duration) {
+	t.mutex.Lock()
+	t.timeout = timeout
+	t.deadline = t.now().Add(timeout)
+	t.mutex.Unlock()
+}
+
+// Stop stops watching the goroutine until its next Reset, e.g. while it's
+// legitimately blocked waiting for something to happen.
+func (t *Ticker) Stop() {
+	t.mutex.Lock()
+	t.deadline = time.Time{}
+	t.mutex.Unlock()
+}
+
+// Expire makes the Ticker run out right away, so that the goroutine is
+// restarted on the next sweep, e.g. because it's about to exit after a
+// panic.
+func (t *Ticker) Expire() {
+	t.mutex.Lock()
+	t.deadline = t.now()
+	t.mutex.Unlock()
+}
+
+// Generation returns how many times the goroutine has been restarted.
+func (t *Ticker) Generation() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.generation
+}
+
+// Current indicates whether a goroutine that started at the given generation
+// is still the one that's supposed to be running.
+func (t *Ticker) Current(generation int) bool {
+	return t.Generation() == generation
+}
+
+// expire checks whether the Ticker has run out at now and if so, moves it to
+// its next generation and stops watching it until the restarted goroutine
+// resets it. It returns why the goroutine is being restarted.
+func (t *Ticker) expire(now time.Time) (string, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.deadline.IsZero() || now.Before(t.deadline) {
+		return "", false
+	}
+	reason := fmt.Sprintf("%v wasn't reset within %v, restarting it", t.name, t.timeout)
+	t.deadline = time.Time{}
+	t.generation++
+	return reason, true
+}
+
+func (t *Ticker) doRestart() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("restart panicked: %v\n%s", r, debug.Stack())
+		}
+	}()
+	t.restart()
+	return nil
+}
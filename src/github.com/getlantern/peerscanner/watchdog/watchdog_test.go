@@ -0,0 +1,107 @@
+package watchdog
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/getlantern/testify/assert"
+)
+
+// newTestWatchdog creates a Watchdog whose clock only moves when advanced
+func newTestWatchdog(interval time.Duration) (*Watchdog, func(time.Duration)) {
+	now := time.Now()
+	w := New(interval)
+	w.now = func() time.Time { return now }
+	w.markSwept()
+	return w, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestRestartAfterTimeout(t *testing.T) {
+	w, advance := newTestWatchdog(time.Second)
+	var restarts int32
+	ticker := w.Register("loop", func() { atomic.AddInt32(&restarts, 1) })
+
+	w.sweep()
+	assert.Equal(t, int32(0), atomic.LoadInt32(&restarts), "A ticker that was never reset shouldn't be restarted")
+
+	ticker.Reset(10 * time.Second)
+	gen := ticker.Generation()
+	advance(9 * time.Second)
+	w.sweep()
+	assert.Equal(t, int32(0), atomic.LoadInt32(&restarts), "Ticker shouldn't be restarted before its timeout")
+	assert.True(t, ticker.Current(gen))
+
+	ticker.Reset(10 * time.Second)
+	advance(9 * time.Second)
+	w.sweep()
+	assert.Equal(t, int32(0), atomic.LoadInt32(&restarts), "Resetting should have bought the ticker more time")
+
+	advance(2 * time.Second)
+	w.sweep()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&restarts), "Ticker should have been restarted after its timeout")
+	assert.False(t, ticker.Current(gen), "Stuck goroutine should no longer be current")
+
+	advance(time.Hour)
+	w.sweep()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&restarts), "Ticker shouldn't be restarted again until the new goroutine resets it")
+}
+
+func TestStop(t *testing.T) {
+	w, advance := newTestWatchdog(time.Second)
+	var restarts int32
+	ticker := w.Register("loop", func() { atomic.AddInt32(&restarts, 1) })
+	ticker.Reset(time.Second)
+	ticker.Stop()
+	advance(time.Hour)
+	w.sweep()
+	assert.Equal(t, int32(0), atomic.LoadInt32(&restarts), "Stopped ticker shouldn't be restarted")
+
+	ticker.Reset(time.Second)
+	w.Unregister(ticker)
+	advance(time.Hour)
+	w.sweep()
+	assert.Equal(t, int32(0), atomic.LoadInt32(&restarts), "Unregistered ticker shouldn't be restarted")
+}
+
+func TestExpire(t *testing.T) {
+	w, _ := newTestWatchdog(time.Second)
+	var restarts int32
+	ticker := w.Register("loop", func() { atomic.AddInt32(&restarts, 1) })
+	ticker.Reset(time.Hour)
+	ticker.Expire()
+	w.sweep()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&restarts), "Expired ticker should be restarted on the next sweep")
+}
+
+func TestPanickingRestart(t *testing.T) {
+	w, advance := newTestWatchdog(time.Second)
+	var restarts int32
+	w.Register("bad", func() { panic("boom") }).Reset(time.Second)
+	w.Register("good", func() { atomic.AddInt32(&restarts, 1) }).Reset(time.Second)
+	advance(2 * time.Second)
+	assert.NotPanics(t, w.sweep)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&restarts), "A panicking restart shouldn't keep others from being restarted")
+	assert.True(t, w.Healthy(), "Watchdog should still have swept")
+}
+
+func TestHealthy(t *testing.T) {
+	w, advance := newTestWatchdog(time.Second)
+	assert.True(t, w.Healthy())
+	advance(3 * time.Second)
+	assert.False(t, w.Healthy(), "Watchdog that hasn't swept in a while shouldn't be healthy")
+	w.sweep()
+	assert.True(t, w.Healthy())
+}
+
+func TestStart(t *testing.T) {
+	w := New(10 * time.Millisecond)
+	restarted := make(chan bool, 1)
+	w.Register("loop", func() { restarted <- true }).Expire()
+	w.Start()
+	select {
+	case <-restarted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Started watchdog should have restarted the expired ticker")
+	}
+}
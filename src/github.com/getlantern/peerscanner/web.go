@@ -5,10 +5,14 @@ package main
 
 import (
 	"crypto/tls"
+	"flag"
 	"fmt"
+	"math/rand"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/getlantern/keyman"
 	"github.com/getlantern/tlsdefaults"
@@ -24,60 +28,208 @@ const (
 	cloudfrontBit = 1 << iota
 )
 
-func startHttp() {
-	http.HandleFunc("/register", register)
-	http.HandleFunc("/unregister", unregister)
-	laddr := fmt.Sprintf(":%d", *port)
+var (
+	maxResponsePeers = flag.Int("max-response-peers", 20, "Maximum number of peers and of fallbacks returned by /v1/peers, defaults to 20")
+	bindAddr         = flag.String("bind-addr", "", "host:port or :port to listen at instead of -port on all interfaces, e.g. the address of the public VPC interface, defaults to none")
+)
+
+// webHandlers are the public HTTP endpoints. They only know about hosts
+// through their HostRegistry.
+type webHandlers struct {
+	registry HostRegistry
+}
+
+func newWebHandlers(registry HostRegistry) *webHandlers {
+	return &webHandlers{registry}
+}
+
+func startHttp(pool *HostPool) {
+	web := newWebHandlers(NewCloudFlareHostRegistry(pool))
+	http.HandleFunc("/register", web.register)
+	http.HandleFunc("/unregister", web.unregister)
+	http.HandleFunc("/v1/peers", web.listPeers)
+	http.HandleFunc("/v1/peers.txt", web.listPeersTxt)
+	http.HandleFunc("/v1/admin/fallbacks/health", requireAdmin(pool.fallbacksHealth))
+	http.HandleFunc("/v1/admin/hosts/", requireAdmin(pool.updateHostMetadata))
+	http.HandleFunc("/v1/admin/groups/", requireAdmin(pool.groupStatus))
+	http.HandleFunc("/v1/admin/dnssec", requireAdmin(setDNSSEC))
+	http.HandleFunc("/v1/admin/analytics", requireAdmin(getAnalytics))
+	http.HandleFunc("/v1/admin/orphans", requireAdmin(pool.orphans))
+	http.HandleFunc("/v1/admin/log-level", requireAdmin(setLogLevel))
+	http.HandleFunc("/debug/blocked-ips", requireAdmin(listBlockedIps))
+	http.HandleFunc("/debug/cf-page-rules", requireAdmin(pool.listPageRules))
+	http.HandleFunc("/debug/cf-telemetry", requireAdmin(listCfTelemetry))
+	http.HandleFunc("/debug/hosts", requireAdmin(pool.listHosts))
+	http.HandleFunc("/debug/hosts/", requireAdmin(pool.hostEndpoints))
+	http.HandleFunc("/debug/dashboards/", requireAdmin(serveDashboard))
+	laddr := listenAddr()
 
 	tlsConfig := tlsdefaults.Server()
-	_, _, err := keyman.StoredPKAndCert(PKFile, CertFile, "Lantern", "localhost")
-	if err != nil {
-		log.Fatalf("Unable to initialize private key and certificate: %v", err)
+	if *acmeDNS01 {
+		solver := &cflDNS01Solver{util: cflutil, zone: *cfldomain, propagationWait: *acmeDNSPropagationWait}
+		certs, err := newAcmeCertManager(*acmeDirectory, solver, *tlsDomain, *autocertDir)
+		if err != nil {
+			log.Fatalf("Unable to set up ACME: %v", err)
+		}
+		if err := certs.ensureCertificate(); err != nil {
+			log.Fatalf("Unable to initialize certificate: %v", err)
+		}
+		go certs.keepRenewed()
+		tlsConfig.GetCertificate = certs.getCertificate
+	} else {
+		_, _, err := keyman.StoredPKAndCert(PKFile, CertFile, "Lantern", "localhost")
+		if err != nil {
+			log.Fatalf("Unable to initialize private key and certificate: %v", err)
+		}
+		cert, err := tls.LoadX509KeyPair(CertFile, PKFile)
+		if err != nil {
+			log.Fatalf("Unable to load certificate and key from %s and %s: %s", CertFile, PKFile, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
 	}
-	cert, err := tls.LoadX509KeyPair(CertFile, PKFile)
-	if err != nil {
-		log.Fatalf("Unable to load certificate and key from %s and %s: %s", CertFile, PKFile, err)
+	if err := configureAdminClientAuth(tlsConfig); err != nil {
+		log.Fatalf("Unable to load -admin-client-ca %v: %v", *adminClientCA, err)
 	}
-	tlsConfig.Certificates = []tls.Certificate{cert}
 
 	log.Debugf("About to listen at %v", laddr)
-	l, err := tls.Listen("tcp", laddr, tlsConfig)
+	tcpListener, err := listen(laddr)
 	if err != nil {
 		log.Fatalf("Unable to listen for tls connections at %s: %s", laddr, err)
 	}
+	l := tls.NewListener(tcpListener, tlsConfig)
 
 	log.Debug("About to serve")
-	err = http.Serve(l, nil)
+	server := &http.Server{Addr: laddr}
+	err = server.Serve(l)
 	if err != nil {
 		log.Fatalf("Unable to serve: %s", err)
 	}
 }
 
+// listenAddr is the address that startHttp listens at, -bind-addr if given
+// and otherwise -port on all interfaces.
+func listenAddr() string {
+	if *bindAddr != "" {
+		return *bindAddr
+	}
+	return fmt.Sprintf(":%d", *port)
+}
+
 // register is the entry point for peers registering themselves with the service.
 // If peers are successfully vetted, they'll be added to the DNS round robin.
-func register(resp http.ResponseWriter, req *http.Request) {
+func (w *webHandlers) register(resp http.ResponseWriter, req *http.Request) {
 	name, ip, port, supportedFronts, err := getHostInfo(req)
 	if err == nil && !(port == "80" || port == "443") {
 		err = fmt.Errorf("Port %s not supported, only ports 80 and 443 are supported", port)
 	}
 	if err != nil {
+		countRegistration("rejected_invalid")
+		resp.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(resp, err.Error())
+		return
+	}
+	err = verifySignature(name, ip, getSingleFormValue(req, "sig"), getSingleFormValue(req, "ts"), time.Now())
+	if err != nil {
+		log.Debugf("Rejecting registration of %v: %v", hostkey{name, ip}, err)
+		countRegistration("rejected_signature")
+		resp.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintln(resp, err.Error())
+		return
+	}
+	recordTtl, err := parseRecordTtl(getSingleFormValue(req, "ttl"))
+	if err != nil {
+		countRegistration("rejected_invalid")
 		resp.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintln(resp, err.Error())
 		return
 	}
+	sni, err := parseSni(getSingleFormValue(req, "sni"))
+	if err != nil {
+		countRegistration("rejected_invalid")
+		resp.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(resp, err.Error())
+		return
+	}
+	weight, err := parseWeight(getSingleFormValue(req, "weight"))
+	if err != nil {
+		countRegistration("rejected_invalid")
+		resp.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(resp, err.Error())
+		return
+	}
+	obfs4, err := parseObfs4Config(getSingleFormValue(req, "obfs4cert"), getSingleFormValue(req, "obfs4port"))
+	if err != nil {
+		countRegistration("rejected_invalid")
+		resp.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(resp, err.Error())
+		return
+	}
+	var metadata map[string]string
+	if md := getSingleFormValue(req, "metadata"); md != "" {
+		metadata, err = parseMetadata([]byte(md))
+		if err != nil {
+			countRegistration("rejected_invalid")
+			resp.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(resp, err.Error())
+			return
+		}
+	}
+	if !allowRegistration(ip) {
+		log.Debugf("Too many registrations from %v, rejecting %v", ip, name)
+		countRegistration("rejected_ratelimit")
+		resp.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprintln(resp, "Too many registrations")
+		return
+	}
 	if isPeer(name) {
 		log.Debugf("Not adding peer %v because we're not using peers at the moment", name)
 		resp.WriteHeader(200)
 		fmt.Fprintln(resp, "Peers disabled at the moment")
 		return
 	}
-	online := true
-	connectionRefused := false
-	timedOut := false
+	if isDuplicateRegistration(name, ip) {
+		countRegistration("deduplicated")
+		log.Tracef("Already processed registration for %v within %v, ignoring", hostkey{name, ip}, *dedupWindow)
+		resp.WriteHeader(200)
+		fmt.Fprintln(resp, "Registration already received")
+		return
+	}
+	err = w.registry.Register(name, ip, RegisterOpts{
+		Port:      port,
+		RecordTtl: recordTtl,
+		Sni:       sni,
+		Weight:    weight,
+		Metadata:  metadata,
+		Obfs4:     obfs4,
+	})
+	switch err {
+	case errHostLimitReached:
+		countRegistration("rejected_host_limit")
+		forgetRegistration(name, ip)
+		resp.Header().Set("Content-Type", "application/json")
+		resp.WriteHeader(http.StatusServiceUnavailable)
+		writeJSON(resp, map[string]string{"error": "host_limit_reached"})
+		return
+	case errAPIBudgetExhausted:
+		countRegistration("rejected_cf_budget")
+		forgetRegistration(name, ip)
+		resp.Header().Set("Content-Type", "application/json")
+		resp.Header().Set("Retry-After", "1")
+		resp.WriteHeader(http.StatusServiceUnavailable)
+		writeJSON(resp, map[string]string{"error": "cf_api_budget_exhausted"})
+		return
+	case nil, errStatusTimedOut, errConnectionRefused, errNoConnectivity:
+		countRegistration("accepted")
+	default:
+		countRegistration("rejected_invalid")
+		forgetRegistration(name, ip)
+		resp.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(resp, err.Error())
+		return
+	}
 
-	h := getOrCreateHost(name, ip, port)
-	online, connectionRefused, timedOut = h.status()
-	if online {
+	switch err {
+	case nil:
 		resp.WriteHeader(200)
 		fmt.Fprintln(resp, "Connectivity to proxy confirmed")
 		if (supportedFronts & cloudfrontBit) == cloudfrontBit {
@@ -93,51 +245,156 @@ func register(resp http.ResponseWriter, req *http.Request) {
 		*/
 		fstr += "}"
 		fmt.Fprintln(resp, fstr)
-
-		return
-	}
-	if timedOut {
-		log.Debugf("%v timed out waiting for status, returning 500 error", h)
+	case errStatusTimedOut:
+		log.Debugf("%v timed out waiting for status, returning 500 error", hostkey{name, ip})
 		resp.WriteHeader(500)
 		fmt.Fprintf(resp, "Timed out waiting for status")
-		return
-	}
-
 	// Note this may not work across platforms, but the intent
 	// is to tell the client if the connection was flat out
 	// refused as opposed to timed out in order to allow them
 	// to configure their router if possible.
-	if connectionRefused {
+	case errConnectionRefused:
 		// 417 response code.
 		resp.WriteHeader(http.StatusExpectationFailed)
-		fmt.Fprintln(resp, "No connectivity to proxy - connection refused")
-	} else {
+		fmt.Fprintln(resp, err.Error())
+	default:
 		// 408 response code.
 		resp.WriteHeader(http.StatusRequestTimeout)
-		fmt.Fprintln(resp, "No connectivity to proxy - test request timed out")
+		fmt.Fprintln(resp, err.Error())
 	}
 }
 
 // unregister is the HTTP endpoint for removing peers from DNS. Peers are
 // unregistered based on their ip (not their name).
-func unregister(resp http.ResponseWriter, req *http.Request) {
-	_, ip, _, _, err := getHostInfo(req)
+func (w *webHandlers) unregister(resp http.ResponseWriter, req *http.Request) {
+	name, ip, _, _, err := getHostInfo(req)
 	if err != nil {
 		resp.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintln(resp, err.Error())
 		return
 	}
 
-	h := getHostByIp(ip)
-	msg := "Host not registered"
-	if h != nil {
-		h.unregister()
-		msg = "Host unregistered"
+	msg := "Host unregistered"
+	if err := w.registry.Deregister(name, ip); err != nil {
+		msg = err.Error()
 	}
 	resp.WriteHeader(200)
 	fmt.Fprintln(resp, msg)
 }
 
+// peerInfo is how a host is represented in the /v1/peers response
+type peerInfo struct {
+	Name string `json:"name"`
+	Ip   string `json:"ip"`
+	Port int    `json:"port"`
+	// Sni is the hostname clients should use for TLS, if any
+	Sni string `json:"sni,omitempty"`
+}
+
+type peersResponse struct {
+	Peers     []peerInfo `json:"peers"`
+	Fallbacks []peerInfo `json:"fallbacks"`
+}
+
+// listPeers is the public HTTP endpoint that lists the peers and fallbacks
+// that are currently online, for clients that can't or don't want to rely on
+// DNS.
+func (w *webHandlers) listPeers(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		resp.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintln(resp, "Only GET is supported")
+		return
+	}
+
+	// Order peers and fallbacks by a weighted random draw, so that clients
+	// that use the first ones get them in proportion to their weights.
+	infosByIp := make(map[string]HostInfo)
+	var peers, fallbacks []WeightedIp
+	for _, info := range w.registry.List() {
+		if !info.Online {
+			continue
+		}
+		infosByIp[info.Ip] = info
+		entry := WeightedIp{info.Ip, info.Weight}
+		if isFallback(info.Name) {
+			if rollouts != nil {
+				entry.Weight = rollouts.scale(info.Ip, entry.Weight)
+			}
+			fallbacks = append(fallbacks, entry)
+		} else {
+			peers = append(peers, entry)
+		}
+	}
+	result := peersResponse{
+		Peers:     peerInfosFor(NewWeightedSelector(peers).Select(*maxResponsePeers), infosByIp),
+		Fallbacks: peerInfosFor(NewWeightedSelector(fallbacks).Select(*maxResponsePeers), infosByIp),
+	}
+
+	resp.Header().Set("Cache-Control", "max-age=30")
+	writeJSON(resp, result)
+}
+
+// listPeersTxt is the public HTTP endpoint that lists the online peers and
+// fallbacks in the format of the peers.txt that Lantern clients can be
+// configured with: a "# generated: <timestamp>" header followed by one
+// ip:port per line, in random order. With ?type=peers or ?type=fallbacks,
+// only peers or fallbacks are listed.
+func (w *webHandlers) listPeersTxt(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		resp.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintln(resp, "Only GET is supported")
+		return
+	}
+	typ := req.URL.Query().Get("type")
+	if typ != "" && typ != "peers" && typ != "fallbacks" {
+		resp.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(resp, "Unknown type %v, must be peers or fallbacks\n", typ)
+		return
+	}
+
+	var addrs []string
+	for _, info := range w.registry.List() {
+		if !info.Online || info.Port == "" {
+			continue
+		}
+		fallback := isFallback(info.Name)
+		if (typ == "peers" && fallback) || (typ == "fallbacks" && !fallback) {
+			continue
+		}
+		if fallback && rollouts != nil && rollouts.scale(info.Ip, info.Weight) <= 0 {
+			// Still staging
+			continue
+		}
+		addrs = append(addrs, net.JoinHostPort(info.Ip, info.Port))
+	}
+	for i, j := range rand.Perm(len(addrs)) {
+		addrs[i], addrs[j] = addrs[j], addrs[i]
+	}
+
+	resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	resp.Header().Set("Cache-Control", "no-store")
+	fmt.Fprintf(resp, "# generated: %v\n", time.Now().UTC().Format(time.RFC3339))
+	for _, addr := range addrs {
+		fmt.Fprintln(resp, addr)
+	}
+}
+
+func peerInfosFor(ips []string, infosByIp map[string]HostInfo) []peerInfo {
+	pis := make([]peerInfo, 0, len(ips))
+	for _, ip := range ips {
+		info := infosByIp[ip]
+		port, _ := strconv.Atoi(info.Port)
+		pis = append(pis, peerInfo{Name: info.Name, Ip: info.Ip, Port: port, Sni: info.Sni})
+	}
+	return pis
+}
+
+type byName []hostInfo
+
+func (a byName) Len() int           { return len(a) }
+func (a byName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byName) Less(i, j int) bool { return a[i].name < a[j].name }
+
 func getHostInfo(req *http.Request) (name string, ip string, port string, supportedFronts int, err error) {
 	err = req.ParseForm()
 	if err != nil {
@@ -149,7 +406,10 @@ func getHostInfo(req *http.Request) (name string, ip string, port string, suppor
 		err = fmt.Errorf("Please specify a name")
 		return
 	}
-	ip = clientIpFor(req, name)
+	ip, err = clientIpFor(req, name)
+	if err != nil {
+		return
+	}
 	if ip == "" {
 		err = fmt.Errorf("Unable to determine IP address")
 		return
@@ -181,30 +441,39 @@ func getHostInfo(req *http.Request) (name string, ip string, port string, suppor
 	return
 }
 
-func clientIpFor(req *http.Request, name string) string {
-	// Client requested their info
-	clientIp := req.Header.Get("X-Peerscanner-Forwarded-For")
-	if clientIp == "" {
-		clientIp = req.Header.Get("X-Forwarded-For")
+// clientIpFor determines the ip of the host registering with req, returning
+// "" if it can't. It fails if req came through a trusted proxy (see
+// -trusted-proxies) without a valid X-Forwarded-For.
+func clientIpFor(req *http.Request, name string) (string, error) {
+	ip, fromProxy, err := proxiedClientIp(req)
+	if err != nil {
+		return "", err
 	}
-	if clientIp == "" && isFallback(name) {
-		// Use direct IP for fallbacks
-		clientIp = strings.Split(req.RemoteAddr, ":")[0]
+	if !fromProxy {
+		// Client requested their info
+		clientIp := req.Header.Get("X-Peerscanner-Forwarded-For")
+		if clientIp == "" {
+			clientIp = req.Header.Get("X-Forwarded-For")
+		}
+		if clientIp == "" && isFallback(name) {
+			// Use direct IP for fallbacks
+			clientIp = strings.Split(req.RemoteAddr, ":")[0]
+		}
+		// clientIp may contain multiple ips, use the first
+		ips := strings.Split(clientIp, ",")
+		ip = strings.TrimSpace(ips[0])
 	}
-	// clientIp may contain multiple ips, use the first
-	ips := strings.Split(clientIp, ",")
-	ip := strings.TrimSpace(ips[0])
 	// TODO: need a more robust way to determine when a non-fallback host looks
 	// like a fallback.
 	hasFallbackIp := isFallbackIp(ip)
 	if !isFallback(name) && hasFallbackIp {
 		log.Errorf("Found fallback ip %v for non-fallback host %v", ip, name)
-		return ""
+		return "", nil
 	} else if isFallback(name) && !hasFallbackIp {
 		log.Errorf("Found non-fallback ip %v for fallback host %v", ip, name)
-		return ""
+		return "", nil
 	}
-	return ip
+	return ip, nil
 }
 
 func isFallbackIp(ip string) bool {
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestDuplicateRegistrationSkipsHostPool(t *testing.T) {
+	name, ip := "fl-us-dedup", "45.63.0.1"
+	seenCache.Purge()
+	isDuplicateRegistration(name, ip)
+	hitsBefore := dedupHits.Value()
+
+	// Hold the pool's mutex so that anything that tries to acquire it blocks
+	pool := NewHostPool()
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		rec := httptest.NewRecorder()
+		webFor(pool).register(rec, newRegisterRequest(name, ip, "443"))
+		done <- rec
+	}()
+
+	select {
+	case rec := <-done:
+		assert.Equal(t, 200, rec.Code, "Duplicate registration should succeed")
+		assert.Equal(t, hitsBefore+1, dedupHits.Value(), "Duplicate registration should be counted")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Duplicate registration blocked on the host pool")
+	}
+}
+
+func TestDuplicateRegistrationWindow(t *testing.T) {
+	name, ip := "fl-us-window", "45.63.0.2"
+	seenCache.Purge()
+	oldWindow := *dedupWindow
+	defer func() { *dedupWindow = oldWindow }()
+
+	*dedupWindow = time.Hour
+	assert.False(t, isDuplicateRegistration(name, ip), "First registration isn't a duplicate")
+	assert.True(t, isDuplicateRegistration(name, ip), "Second registration within window is a duplicate")
+	assert.False(t, isDuplicateRegistration(name, "45.63.0.3"), "Registration from different ip isn't a duplicate")
+
+	*dedupWindow = 0
+	assert.False(t, isDuplicateRegistration(name, ip), "Registration outside of window isn't a duplicate")
+}
+
+func TestRetryAfterRejectionIsntDeduplicated(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	defer withoutDialing()()
+	name, ip := "fl-us-retryafter", "45.63.0.4"
+	seenCache.Purge()
+	oldWindow := *dedupWindow
+	defer func() { *dedupWindow = oldWindow }()
+	*dedupWindow = time.Hour
+	pool := NewHostPool()
+
+	restore := exhaustCfBudget()
+	rec := httptest.NewRecorder()
+	webFor(pool).register(rec, newRegisterRequest(name, ip, "443"))
+	restore()
+	if !assert.Equal(t, 503, rec.Code) {
+		return
+	}
+
+	rec = httptest.NewRecorder()
+	webFor(pool).register(rec, newRegisterRequest(name, ip, "443"))
+	assert.NotContains(t, rec.Body.String(), "Registration already received", "Retry after a 503 shouldn't be deduplicated")
+	assert.NotNil(t, pool.Get(ip), "Retry after a 503 should have created host")
+	assert.True(t, pauseAll(pool))
+}
+
+func TestRegistrationCounters(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	defer withPeerSecret("")()
+	origDialer := defaultDialer
+	defer func() { defaultDialer = origDialer }()
+	defaultDialer = &mockDialer{err: fmt.Errorf("not dialing in tests")}
+	seenCache.Purge()
+	pool := NewHostPool()
+
+	register := func(result string, req *http.Request) {
+		before := registrationCount(result)
+		webFor(pool).register(httptest.NewRecorder(), req)
+		assert.Equal(t, before+1, registrationCount(result), "Registration should be counted as %v", result)
+	}
+
+	register("rejected_invalid", newRegisterRequest("fl-us-counters", "45.63.6.1", "8080"))
+
+	func() {
+		defer withPeerSecret("psk")()
+		req := newRegisterRequest("fl-us-counters", "45.63.6.1", "443")
+		req.URL.RawQuery = "sig=abcd&ts=" + strconv.FormatInt(time.Now().Unix(), 10)
+		register("rejected_signature", req)
+	}()
+
+	for i := 0; i < *registerBurst; i++ {
+		registrationLimiter.allow("45.63.6.2")
+	}
+	register("rejected_ratelimit", newRegisterRequest("fl-us-counters", "45.63.6.2", "443"))
+
+	isDuplicateRegistration("fl-us-counters", "45.63.6.3")
+	register("deduplicated", newRegisterRequest("fl-us-counters", "45.63.6.3", "443"))
+
+	register("accepted", newRegisterRequest("fl-us-counters", "45.63.6.4", "443"))
+	if h := pool.Get("45.63.6.4"); assert.NotNil(t, h, "Accepted registration should have created host") {
+		defer h.unregister()
+	}
+
+	origMax := *maxHosts
+	defer func() { *maxHosts = origMax }()
+	*maxHosts = pool.Len()
+	register("rejected_host_limit", newRegisterRequest("fl-us-counters", "45.63.6.5", "443"))
+}
+
+// registrationCount returns the number of registrations with the given result
+// in peer_registration_total.
+func registrationCount(result string) int64 {
+	if v, ok := registrations.Get(result).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestListPeers(t *testing.T) {
+	pool := newTestPool(
+		onlineHost("fl-us-b", "45.63.0.2", "443", true),
+		onlineHost("fl-us-a", "45.63.0.1", "80", true),
+		onlineHost("fl-us-offline", "45.63.0.3", "443", false),
+	)
+
+	rec := httptest.NewRecorder()
+	webFor(pool).listPeers(rec, httptest.NewRequest("GET", "/v1/peers", nil))
+	assert.Equal(t, 200, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "max-age=30", rec.Header().Get("Cache-Control"))
+
+	var result map[string][]map[string]interface{}
+	if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result), "Response should be valid JSON") {
+		assert.Len(t, result["peers"], 0, "There should be no peers")
+		fallbacks := peersByName(result["fallbacks"])
+		if assert.Equal(t, 2, len(fallbacks), "Offline fallback should be excluded") {
+			assert.Equal(t, map[string]interface{}{"name": "fl-us-a", "ip": "45.63.0.1", "port": float64(80)}, fallbacks["fl-us-a"])
+			assert.NotNil(t, fallbacks["fl-us-b"])
+		}
+	}
+
+	old := *maxResponsePeers
+	*maxResponsePeers = 1
+	defer func() { *maxResponsePeers = old }()
+	rec = httptest.NewRecorder()
+	webFor(pool).listPeers(rec, httptest.NewRequest("GET", "/v1/peers", nil))
+	if assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result)) {
+		assert.Len(t, result["fallbacks"], 1, "Response should be limited to max-response-peers")
+	}
+}
+
+func TestListPeersIsWeighted(t *testing.T) {
+	heavy := onlineHost("fl-us-heavy", "45.63.0.4", "443", true)
+	heavy.setWeight(90)
+	light := onlineHost("fl-us-light", "45.63.0.5", "443", true)
+	light.setWeight(10)
+	pool := newTestPool(heavy, light)
+
+	heavyFirst := 0
+	for i := 0; i < 1000; i++ {
+		rec := httptest.NewRecorder()
+		webFor(pool).listPeers(rec, httptest.NewRequest("GET", "/v1/peers", nil))
+		var result map[string][]map[string]interface{}
+		if !assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result)) || !assert.Len(t, result["fallbacks"], 2) {
+			return
+		}
+		if result["fallbacks"][0]["name"] == "fl-us-heavy" {
+			heavyFirst++
+		}
+	}
+	assert.True(t, heavyFirst > 850 && heavyFirst < 950, "Heavy fallback should be listed first about 90%% of the time, was %d out of 1000", heavyFirst)
+}
+
+// peersTxtPattern is the format of peers.txt: a generated header and then one
+// ip:port per line
+var peersTxtPattern = regexp.MustCompile(`^# generated: \d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}Z\n((\d{1,3}\.){3}\d{1,3}:\d{1,5}\n)*$`)
+
+func TestListPeersTxt(t *testing.T) {
+	pool := newTestPool(
+		onlineHost("fl-us-txt", "45.63.0.6", "443", true),
+		onlineHost("fl-us-txtoffline", "45.63.0.7", "443", false),
+		onlineHost("peer-txt", "1.2.3.4", "80", true),
+	)
+
+	listed := func(query string) []string {
+		rec := httptest.NewRecorder()
+		webFor(pool).listPeersTxt(rec, httptest.NewRequest("GET", "/v1/peers.txt"+query, nil))
+		assert.Equal(t, 200, rec.Code)
+		assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+		body := rec.Body.String()
+		assert.True(t, peersTxtPattern.MatchString(body), "Response should be in the peers.txt format: %q", body)
+		lines := strings.Split(strings.TrimSpace(body), "\n")
+		generated, err := time.Parse(time.RFC3339, strings.TrimPrefix(lines[0], "# generated: "))
+		if assert.NoError(t, err) {
+			assert.WithinDuration(t, time.Now(), generated, 2*time.Second)
+		}
+		addrs := lines[1:]
+		sort.Strings(addrs)
+		return addrs
+	}
+
+	assert.Equal(t, []string{"1.2.3.4:80", "45.63.0.6:443"}, listed(""), "Only online hosts should be listed")
+	assert.Equal(t, []string{"45.63.0.6:443"}, listed("?type=fallbacks"))
+	assert.Equal(t, []string{"1.2.3.4:80"}, listed("?type=peers"))
+
+	rec := httptest.NewRecorder()
+	webFor(pool).listPeersTxt(rec, httptest.NewRequest("GET", "/v1/peers.txt?type=other", nil))
+	assert.Equal(t, 400, rec.Code, "Unknown type should be rejected")
+}
+
+func TestListPeersTxtIsShuffled(t *testing.T) {
+	var hs []*host
+	for i := 1; i <= 10; i++ {
+		hs = append(hs, onlineHost(fmt.Sprintf("fl-us-shuffle%d", i), fmt.Sprintf("45.63.1.%d", i), "443", true))
+	}
+	pool := newTestPool(hs...)
+
+	orders := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		rec := httptest.NewRecorder()
+		webFor(pool).listPeersTxt(rec, httptest.NewRequest("GET", "/v1/peers.txt", nil))
+		body := rec.Body.String()
+		orders[body[strings.Index(body, "\n"):]] = true
+	}
+	assert.True(t, len(orders) > 1, "Hosts should be listed in random order")
+}
+
+func TestRegisterRejectsInvalidWeight(t *testing.T) {
+	req := newRegisterRequest("fl-us-badweight", "45.63.0.6", "443")
+	req.URL.RawQuery = "weight=0"
+	rec := httptest.NewRecorder()
+	pool := NewHostPool()
+	webFor(pool).register(rec, req)
+	assert.Equal(t, 400, rec.Code, "Registration with invalid weight should be rejected")
+	assert.Nil(t, pool.Get("45.63.0.6"), "Host shouldn't have been created")
+}
+
+// peersByName indexes the peers or fallbacks in a /v1/peers response by name.
+func peersByName(peers []map[string]interface{}) map[string]map[string]interface{} {
+	byName := make(map[string]map[string]interface{}, len(peers))
+	for _, p := range peers {
+		byName[p["name"].(string)] = p
+	}
+	return byName
+}
+
+// onlineHost creates a host (without starting its run loop) whose published
+// state is online or not.
+func onlineHost(name string, ip string, port string, online bool) *host {
+	h := mustNewHost(name, ip, port)
+	h.online = online
+	h.publishInfo()
+	return h
+}
+
+// newTestPool creates a HostPool holding the given hosts, without starting
+// them.
+func newTestPool(hs ...*host) *HostPool {
+	pool := NewHostPool()
+	for _, h := range hs {
+		pool.hosts[h.ip] = h
+	}
+	return pool
+}
+
+// webFor returns the web handlers for pool's hosts
+func webFor(pool *HostPool) *webHandlers {
+	return newWebHandlers(NewCloudFlareHostRegistry(pool))
+}
+
+func newRegisterRequest(name string, ip string, port string) *http.Request {
+	form := url.Values{"name": {name}, "port": {port}}
+	req := httptest.NewRequest("POST", "/register", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = ip + ":40000"
+	return req
+}
+
+func TestBindAddr(t *testing.T) {
+	orig := *bindAddr
+	defer func() { *bindAddr = orig }()
+	*bindAddr = ""
+	assert.Equal(t, fmt.Sprintf(":%d", *port), listenAddr(), "Should listen on all interfaces by default")
+
+	// Find a free port
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, freePort, _ := net.SplitHostPort(l.Addr().String())
+	l.Close()
+
+	*bindAddr = "127.0.0.1:" + freePort
+	l, err = listen(listenAddr())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:"+freePort, time.Second)
+	if assert.NoError(t, err, "Should accept connections at the bound address") {
+		conn.Close()
+	}
+	_, err = net.DialTimeout("tcp", "127.0.0.2:"+freePort, time.Second)
+	assert.Error(t, err, "Shouldn't accept connections at other addresses")
+}
+
+func TestValidateBindAddr(t *testing.T) {
+	origId, origKey, orig := cflid, cflkey, *bindAddr
+	defer func() { cflid, cflkey, *bindAddr = origId, origKey, orig }()
+	cflid, cflkey = "user@example.com", "key"
+	for addr, valid := range map[string]bool{
+		"":                true,
+		":62443":          true,
+		"127.0.0.1:62443": true,
+		"127.0.0.1":       false,
+		"127.0.0.1:port":  false,
+	} {
+		*bindAddr = addr
+		assert.Equal(t, valid, len(validateConfig()) == 0, "Validity of -bind-addr %v", addr)
+	}
+}
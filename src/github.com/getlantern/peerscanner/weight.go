@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const (
+	defaultWeight = 10
+	maxWeight     = 100
+)
+
+// parseWeight parses the weight that a host registered with, which determines
+// how often /v1/peers lists it first relative to other hosts. Hosts that don't
+// specify one get defaultWeight.
+func parseWeight(s string) (int, error) {
+	if s == "" {
+		return defaultWeight, nil
+	}
+	weight, err := strconv.Atoi(s)
+	if err != nil || weight < 1 || weight > maxWeight {
+		return 0, fmt.Errorf("Invalid weight %v, must be between 1 and %d", s, maxWeight)
+	}
+	return weight, nil
+}
+
+// setWeight sets this host's weight. Like metadata, it doesn't belong to the
+// run loop.
+func (h *host) setWeight(weight int) {
+	h.weightMutex.Lock()
+	h.weight = weight
+	h.weightMutex.Unlock()
+}
+
+func (h *host) getWeight() int {
+	h.weightMutex.RLock()
+	defer h.weightMutex.RUnlock()
+	return h.weight
+}
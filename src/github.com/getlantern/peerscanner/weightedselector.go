@@ -0,0 +1,132 @@
+package main
+
+import (
+	"math/rand"
+)
+
+// WeightedIp is an ip that a WeightedSelector picks in proportion to its
+// weight.
+type WeightedIp struct {
+	Ip     string
+	Weight int
+}
+
+// WeightedSelector draws ips at random in proportion to their weights, which
+// DNS round robin can't do. It uses the alias method, so each draw takes
+// constant time. A WeightedSelector is not safe for concurrent use.
+type WeightedSelector struct {
+	entries []WeightedIp
+}
+
+// NewWeightedSelector creates a WeightedSelector for the given ips. Ips with a
+// weight of 0 or less are never selected.
+func NewWeightedSelector(entries []WeightedIp) *WeightedSelector {
+	s := &WeightedSelector{}
+	for _, e := range entries {
+		if e.Weight > 0 {
+			s.entries = append(s.entries, e)
+		}
+	}
+	return s
+}
+
+// Select draws n distinct ips (or all of them if there are fewer than n),
+// in the order they were drawn. Each draw picks among the ips not drawn yet
+// in proportion to their weights.
+func (s *WeightedSelector) Select(n int) []string {
+	if n > len(s.entries) {
+		n = len(s.entries)
+	}
+	result := make([]string, 0, n)
+	taken := make([]bool, len(s.entries))
+
+	// Drawing from a table that still includes taken entries and redrawing
+	// when we hit one keeps each draw O(1). Once half the table's weight is
+	// taken, we rebuild it so that we don't keep redrawing.
+	var table *aliasTable
+	var indexes []int
+	var tableWeight, takenWeight int
+	for len(result) < n {
+		if table == nil || 2*takenWeight >= tableWeight {
+			table, indexes, tableWeight = s.buildTable(taken)
+			takenWeight = 0
+		}
+		i := indexes[table.draw()]
+		if taken[i] {
+			continue
+		}
+		taken[i] = true
+		takenWeight += s.entries[i].Weight
+		result = append(result, s.entries[i].Ip)
+	}
+	return result
+}
+
+// buildTable builds an alias table over the entries that haven't been taken,
+// returning it along with the entries' indexes and their total weight.
+func (s *WeightedSelector) buildTable(taken []bool) (*aliasTable, []int, int) {
+	var indexes []int
+	var weights []int
+	total := 0
+	for i, e := range s.entries {
+		if !taken[i] {
+			indexes = append(indexes, i)
+			weights = append(weights, e.Weight)
+			total += e.Weight
+		}
+	}
+	return newAliasTable(weights), indexes, total
+}
+
+// aliasTable is Vose's alias method for sampling from a discrete
+// distribution: draw a slot uniformly, then either keep it (with the slot's
+// probability) or take its alias.
+type aliasTable struct {
+	prob  []float64
+	alias []int
+}
+
+func newAliasTable(weights []int) *aliasTable {
+	n := len(weights)
+	t := &aliasTable{prob: make([]float64, n), alias: make([]int, n)}
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+	scaled := make([]float64, n)
+	var small, large []int
+	for i, w := range weights {
+		scaled[i] = float64(w) * float64(n) / float64(total)
+		if scaled[i] < 1 {
+			small = append(small, i)
+		} else {
+			large = append(large, i)
+		}
+	}
+	for len(small) > 0 && len(large) > 0 {
+		s, l := small[len(small)-1], large[len(large)-1]
+		small = small[:len(small)-1]
+		t.prob[s] = scaled[s]
+		t.alias[s] = l
+		scaled[l] = scaled[l] + scaled[s] - 1
+		if scaled[l] < 1 {
+			large = large[:len(large)-1]
+			small = append(small, l)
+		}
+	}
+	// Whatever is left is (up to rounding) exactly 1
+	for _, i := range append(small, large...) {
+		t.prob[i] = 1
+		t.alias[i] = i
+	}
+	return t
+}
+
+// draw returns the index of a randomly drawn weight.
+func (t *aliasTable) draw() int {
+	i := rand.Intn(len(t.prob))
+	if rand.Float64() < t.prob[i] {
+		return i
+	}
+	return t.alias[i]
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestWeightedSelectorDistribution(t *testing.T) {
+	entries := []WeightedIp{{"1.1.1.1", 1}, {"2.2.2.2", 2}, {"3.3.3.3", 3}, {"4.4.4.4", 4}}
+	s := NewWeightedSelector(entries)
+
+	const draws = 100000
+	counts := make(map[string]int)
+	for i := 0; i < draws; i++ {
+		counts[s.Select(1)[0]]++
+	}
+	// Chi-squared test with 3 degrees of freedom, 16.27 is the critical value
+	// at p = 0.001
+	chiSquared := 0.0
+	for _, e := range entries {
+		expected := float64(draws) * float64(e.Weight) / 10
+		d := float64(counts[e.Ip]) - expected
+		chiSquared += d * d / expected
+	}
+	assert.True(t, chiSquared < 16.27, "Draws should be distributed according to weights, got %v (chi-squared %v)", counts, chiSquared)
+}
+
+func TestWeightedSelectorWithoutReplacement(t *testing.T) {
+	entries := []WeightedIp{{"1.1.1.1", 1}, {"2.2.2.2", 1000}, {"3.3.3.3", 1}, {"4.4.4.4", 0}}
+	s := NewWeightedSelector(entries)
+	for i := 0; i < 100; i++ {
+		ips := s.Select(10)
+		if !assert.Len(t, ips, 3, "Should select every ip with a positive weight once") {
+			return
+		}
+		assert.NotContains(t, ips, "4.4.4.4", "Ip with 0 weight shouldn't be selected")
+		seen := make(map[string]bool)
+		for _, ip := range ips {
+			assert.False(t, seen[ip], "%v selected twice", ip)
+			seen[ip] = true
+		}
+	}
+	assert.Len(t, NewWeightedSelector(nil).Select(5), 0)
+}
+
+func TestWeightedSelectorSecondDraw(t *testing.T) {
+	// After drawing a, the second draw should pick among b and c by their
+	// weights alone.
+	entries := []WeightedIp{{"a", 1000000}, {"b", 1}, {"c", 3}}
+	s := NewWeightedSelector(entries)
+	const draws = 20000
+	bSecond := 0
+	for i := 0; i < draws; i++ {
+		ips := s.Select(2)
+		if ips[0] == "a" && ips[1] == "b" {
+			bSecond++
+		}
+	}
+	expected := float64(draws) / 4
+	sd := math.Sqrt(float64(draws) * 0.25 * 0.75)
+	assert.True(t, math.Abs(float64(bSecond)-expected) < 5*sd, "b should be second about %v times, was %d", expected, bSecond)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"time"
+)
+
+const (
+	zoneStatsInterval = 10 * time.Minute
+
+	// zoneUsageWarnPercent is how full our zone can get before we start
+	// warning that it's nearing its record limit.
+	zoneUsageWarnPercent = 80
+)
+
+// startZoneStatsMonitor checks how close our zone is to its record limit now
+// and every zoneStatsInterval from now on.
+func startZoneStatsMonitor() {
+	checkZoneStats()
+	go func() {
+		for range time.Tick(zoneStatsInterval) {
+			checkZoneStats()
+		}
+	}()
+}
+
+// checkZoneStats updates cf_zone_record_usage_percent and warns if we're about
+// to run out of records.
+func checkZoneStats() {
+	stats, err := cflutil.GetZoneStats()
+	if err != nil {
+		log.Errorf("Unable to get zone stats: %v", err)
+		return
+	}
+	zoneRecordUsage.Set(stats.UsagePercent)
+	if stats.UsagePercent > zoneUsageWarnPercent {
+		log.Errorf("WARNING: Zone is using %d of its %d records (%.1f%%)", stats.RecordCount, stats.RecordLimit, stats.UsagePercent)
+		return
+	}
+	log.Debugf("Zone is using %d of its %d records (%.1f%%)", stats.RecordCount, stats.RecordLimit, stats.UsagePercent)
+}
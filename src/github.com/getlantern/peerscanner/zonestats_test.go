@@ -0,0 +1,19 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/getlantern/testify/assert"
+)
+
+func TestCheckZoneStats(t *testing.T) {
+	m := newMockCfl()
+	defer m.close()
+	for i := 0; i < 50; i++ {
+		m.AddRecord("A", fmt.Sprintf("peer-%d", i), fmt.Sprintf("10.0.0.%d", i))
+	}
+
+	checkZoneStats()
+	assert.InDelta(t, 5.0, zoneRecordUsage.Value(), 0.001, "50 of a free zone's 1000 records should be 5%")
+}